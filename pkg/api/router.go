@@ -0,0 +1,636 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	"gomodules.xyz/jsonpatch/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/config"
+	"github.com/bigkevmcd/peanut-engine/pkg/engine"
+	"github.com/bigkevmcd/peanut-engine/pkg/plan"
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+	"github.com/bigkevmcd/peanut-engine/pkg/window"
+)
+
+const (
+	planApplicationName = "plan"
+	tableOutput         = "table"
+	diffOutput          = "diff"
+
+	// previousSHA rolls back to the previously synchronised commit.
+	previousSHA = "previous"
+
+	// defaultOverrideDuration is how long the sync windows are overridden
+	// for if no duration is requested.
+	defaultOverrideDuration = time.Hour
+
+	// emptyPathValue identifies the core group, or no namespace in resource
+	// paths.
+	emptyPathValue = "_"
+)
+
+// scpURLPattern matches the scp-like form of SSH URLs e.g.
+// git@github.com:org/repo.git
+var scpURLPattern = regexp.MustCompile(`^[\w.-]+@[\w.-]+:`)
+
+// Applications provides access to the running applications.
+//
+// This is implemented by engine.Manager.
+type Applications interface {
+	Applications() []*engine.Application
+}
+
+// Planner plans the synchronisation of an application without applying it.
+type Planner func(config.Application) (*plan.Plan, error)
+
+// Deployer deploys specific commits of the running applications.
+//
+// This is implemented by engine.Manager.
+type Deployer interface {
+	Deploy(context.Context, *engine.Application, plumbing.Hash) (recent.Synchronisation, error)
+	Rollback(context.Context, *engine.Application, plumbing.Hash) (recent.Synchronisation, error)
+}
+
+// APIRouter is an HTTP API for controlling the synchronisation.
+type APIRouter struct {
+	*httprouter.Router
+	applications Applications
+	planner      Planner
+	deployer     Deployer
+}
+
+// NewRouter creates and returns a new APIRouter.
+func NewRouter(apps Applications, planner Planner, deployer Deployer) *APIRouter {
+	api := &APIRouter{Router: httprouter.New(), applications: apps, planner: planner, deployer: deployer}
+	api.HandlerFunc(http.MethodGet, "/api/v1/sync", api.Sync)
+	api.HandlerFunc(http.MethodPost, "/api/v1/sync", api.Sync)
+	api.HandlerFunc(http.MethodPost, "/api/v1/plan", api.Plan)
+	api.HandlerFunc(http.MethodGet, "/api/v1/resources/:group/:kind/:namespace/:name/diff", api.Diff)
+	api.HandlerFunc(http.MethodGet, "/api/v1/health", api.Health)
+	api.HandlerFunc(http.MethodPost, "/api/v1/deploy", api.Deploy)
+	api.HandlerFunc(http.MethodPost, "/api/v1/rollback", api.Rollback)
+	api.HandlerFunc(http.MethodPost, "/api/v1/suspend", api.Suspend)
+	api.HandlerFunc(http.MethodPost, "/api/v1/resume", api.Resume)
+	api.HandlerFunc(http.MethodGet, "/api/v1/status", api.Status)
+	api.HandlerFunc(http.MethodPost, "/api/v1/windows/override", api.OverrideSyncWindows)
+	api.HandlerFunc(http.MethodDelete, "/api/v1/windows/override", api.OverrideSyncWindows)
+	return api
+}
+
+// Sync triggers an immediate synchronisation of the running applications, or
+// the application named in the "application" query parameter.
+//
+// This doesn't wait for the synchronisation, and if a synchronisation is
+// already pending, no other synchronisation is queued.
+func (a *APIRouter) Sync(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("application")
+	log.Println("Synchronization triggered by API call")
+	triggered := 0
+	for _, app := range a.applications.Applications() {
+		if name != "" && app.Name != name {
+			continue
+		}
+		app.TriggerSync()
+		triggered++
+	}
+	if name != "" && triggered == 0 {
+		http.Error(w, "application not found", http.StatusNotFound)
+	}
+}
+
+// Plan reports the changes that synchronising the application in the request
+// body would make, without applying them.
+//
+// The response is JSON unless the "output" query parameter is "table".
+func (a *APIRouter) Plan(w http.ResponseWriter, r *http.Request) {
+	var req planRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode request: %s", err), http.StatusBadRequest)
+		return
+	}
+	cfg, err := req.application()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := a.planner(cfg)
+	if err != nil {
+		log.Errorf("Failed to plan %s: %s", cfg.Name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("output") == tableOutput {
+		w.Header().Set("Content-Type", "text/plain")
+		if err := p.WriteTable(w); err != nil {
+			log.Printf("ERROR: failed to write plan: %s", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Printf("ERROR: failed to marshal plan: %s", err)
+	}
+}
+
+// planRequest is the configuration that can be planned through the API.
+//
+// The plan runs in the engine, so only the fields that are safe for any
+// caller to set are accepted, no credentials or decryption keys are used, and
+// commands can't be run. Only https and ssh repositories can be planned, and
+// the parsers are confined to the repository, symlinks out of the repository,
+// and Jsonnet imports from outside the path are rejected.
+//
+// Kustomizations can still reference remote resources.
+type planRequest struct {
+	RepoURL      string                  `json:"repoURL"`
+	Branch       string                  `json:"branch,omitempty"`
+	Revision     string                  `json:"revision,omitempty"`
+	Path         string                  `json:"path,omitempty"`
+	Parser       string                  `json:"parser,omitempty"`
+	Prune        bool                    `json:"prune,omitempty"`
+	Namespace    string                  `json:"namespace,omitempty"`
+	Helm         config.HelmOptions      `json:"helm,omitempty"`
+	Jsonnet      config.JsonnetOptions   `json:"jsonnet,omitempty"`
+	Transform    config.TransformOptions `json:"transform,omitempty"`
+	Depth        int                     `json:"depth,omitempty"`
+	SingleBranch bool                    `json:"singleBranch,omitempty"`
+	Sparse       bool                    `json:"sparse,omitempty"`
+}
+
+// application validates the request, and returns the configuration to plan.
+func (p planRequest) application() (config.Application, error) {
+	if p.Parser == config.PluginParser {
+		return config.Application{}, fmt.Errorf("the %s parser can't be used to plan", config.PluginParser)
+	}
+	if !isRemoteURL(p.RepoURL) {
+		return config.Application{}, fmt.Errorf("invalid repoURL %q, only https and ssh repositories can be planned", p.RepoURL)
+	}
+	return config.Application{
+		Name:         planApplicationName,
+		RepoURL:      p.RepoURL,
+		Branch:       p.Branch,
+		Revision:     p.Revision,
+		Path:         p.Path,
+		Parser:       p.Parser,
+		Prune:        p.Prune,
+		Namespace:    p.Namespace,
+		Resync:       metav1.Duration{Duration: config.DefaultResync},
+		Helm:         p.Helm,
+		Jsonnet:      p.Jsonnet,
+		Transform:    p.Transform,
+		Depth:        p.Depth,
+		SingleBranch: p.SingleBranch,
+		Sparse:       p.Sparse,
+		Confined:     true,
+	}, nil
+}
+
+// isRemoteURL returns true for https and ssh URLs, including the scp-like form
+// of SSH URLs.
+func isRemoteURL(s string) bool {
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		return u.Scheme == "https" || u.Scheme == "ssh"
+	}
+	return scpURLPattern.MatchString(s)
+}
+
+// Diff returns the difference between the manifest and the live state of a
+// resource, as recorded by the most recent synchronisation.
+//
+// Use "_" in the path for the core group, or for resources without a
+// namespace.
+//
+// The resource is looked up in all the running applications, unless the
+// "application" query parameter names one.
+//
+// The response is JSON unless the "output" query parameter is "diff", when
+// only the unified diff is returned.
+func (a *APIRouter) Diff(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	key := kube.NewResourceKey(
+		pathValue(params.ByName("group")), params.ByName("kind"),
+		pathValue(params.ByName("namespace")), params.ByName("name"))
+	name := r.URL.Query().Get("application")
+	for _, app := range a.applications.Applications() {
+		if name != "" && app.Name != name {
+			continue
+		}
+		latest, ok := app.Synchronisations.Latest()
+		if !ok {
+			continue
+		}
+		d, outOfSync := latest.Diff(key)
+		if !outOfSync && !isSynchronised(latest, key) {
+			continue
+		}
+		if r.URL.Query().Get("output") == diffOutput {
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, d.Diff)
+			return
+		}
+		res := responseDiff{
+			Application: app.Name,
+			SHA:         latest.SHA,
+			Group:       key.Group,
+			Kind:        key.Kind,
+			Namespace:   key.Namespace,
+			Name:        key.Name,
+			OutOfSync:   outOfSync,
+			Diff:        d.Diff,
+			Patch:       d.Patch,
+		}
+		if res.Patch == nil {
+			res.Patch = []jsonpatch.Operation{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Printf("ERROR: failed to marshal diff: %s", err)
+		}
+		return
+	}
+	http.Error(w, "resource not found", http.StatusNotFound)
+}
+
+// Health returns the health of the resources of the running applications, or
+// the application named in the "application" query parameter, as assessed by
+// their most recent synchronisations.
+//
+// Applications that have not been assessed have the health Unknown.
+func (a *APIRouter) Health(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("application")
+	res := responseHealth{Applications: []responseApplicationHealth{}}
+	for _, app := range a.applications.Applications() {
+		if name != "" && app.Name != name {
+			continue
+		}
+		h := responseApplicationHealth{
+			Name:      app.Name,
+			Health:    health.HealthStatusUnknown,
+			Resources: []recent.ResourceHealth{},
+		}
+		if latest, ok := app.Synchronisations.Latest(); ok {
+			h.SHA = latest.SHA
+			if latest.Health != "" {
+				h.Health = latest.Health
+				h.Resources = append(h.Resources, latest.ResourceHealth...)
+			}
+		}
+		res.Applications = append(res.Applications, h)
+	}
+	if name != "" && len(res.Applications) == 0 {
+		http.Error(w, "application not found", http.StatusNotFound)
+		return
+	}
+	sort.Slice(res.Applications, func(i, j int) bool {
+		return res.Applications[i].Name < res.Applications[j].Name
+	})
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("ERROR: failed to marshal health: %s", err)
+	}
+}
+
+type responseHealth struct {
+	Applications []responseApplicationHealth `json:"applications"`
+}
+
+type responseApplicationHealth struct {
+	Name      string                  `json:"name"`
+	SHA       string                  `json:"sha,omitempty"`
+	Health    health.HealthStatusCode `json:"health"`
+	Resources []recent.ResourceHealth `json:"resources"`
+}
+
+// Deploy applies the commit in the "sha" query parameter to the application,
+// and pins the application to it until it's resumed.
+//
+// The response is sent when the resources are healthy, or when the request
+// is cancelled, with the health at that time.
+//
+// The application is named in the "application" query parameter, which is
+// optional if only one application is running.
+func (a *APIRouter) Deploy(w http.ResponseWriter, r *http.Request) {
+	sha := r.URL.Query().Get("sha")
+	if !plumbing.IsHash(sha) {
+		http.Error(w, "sha must be a full commit SHA", http.StatusBadRequest)
+		return
+	}
+	app := a.selectApplication(w, r)
+	if app == nil {
+		return
+	}
+	log.Printf("Deployment of %s to %s triggered by API call", sha, app.Name)
+	s, err := a.deployer.Deploy(r.Context(), app, plumbing.NewHash(sha))
+	a.writeDeployment(w, app, s, err)
+}
+
+// Rollback applies the commit in the "sha" query parameter to the
+// application, or the previously synchronised commit if it's "previous" or
+// omitted, and pins the application to it until it's resumed.
+//
+// The application is named in the "application" query parameter, which is
+// optional if only one application is running.
+func (a *APIRouter) Rollback(w http.ResponseWriter, r *http.Request) {
+	sha := r.URL.Query().Get("sha")
+	h := plumbing.ZeroHash
+	switch {
+	case sha == "" || sha == previousSHA:
+	case plumbing.IsHash(sha):
+		h = plumbing.NewHash(sha)
+	default:
+		http.Error(w, `sha must be a full commit SHA or "previous"`, http.StatusBadRequest)
+		return
+	}
+	app := a.selectApplication(w, r)
+	if app == nil {
+		return
+	}
+	log.Printf("Rollback of %s triggered by API call", app.Name)
+	s, err := a.deployer.Rollback(r.Context(), app, h)
+	a.writeDeployment(w, app, s, err)
+}
+
+// Suspend stops the synchronisation of all applications, or the application
+// named in the "application" query parameter, until they are resumed.
+func (a *APIRouter) Suspend(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("application")
+	found := false
+	for _, app := range a.applications.Applications() {
+		if name != "" && app.Name != name {
+			continue
+		}
+		found = true
+		suspended, err := app.Suspend()
+		if err != nil {
+			log.Errorf("Failed to suspend %s: %s", app.Name, err)
+			http.Error(w, fmt.Sprintf("failed to suspend %s: %s", app.Name, err), http.StatusInternalServerError)
+			return
+		}
+		if suspended {
+			log.Printf("Synchronisation of %s suspended by API call", app.Name)
+		}
+	}
+	if name != "" && !found {
+		http.Error(w, "application not found", http.StatusNotFound)
+	}
+}
+
+// Resume resumes the synchronisation of the pinned and suspended
+// applications, or the application named in the "application" query
+// parameter.
+func (a *APIRouter) Resume(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("application")
+	found := false
+	for _, app := range a.applications.Applications() {
+		if name != "" && app.Name != name {
+			continue
+		}
+		found = true
+		resumed, err := app.Resume()
+		if err != nil {
+			log.Errorf("Failed to resume %s: %s", app.Name, err)
+			http.Error(w, fmt.Sprintf("failed to resume %s: %s", app.Name, err), http.StatusInternalServerError)
+			return
+		}
+		if resumed {
+			log.Printf("Synchronisation of %s resumed by API call", app.Name)
+		}
+	}
+	if name != "" && !found {
+		http.Error(w, "application not found", http.StatusNotFound)
+	}
+}
+
+// OverrideSyncWindows allows all applications, or the application named in
+// the "application" query parameter, to be synchronised outside their sync
+// windows, for the "duration" query parameter, which defaults to an hour.
+//
+// DELETE requests remove the override.
+func (a *APIRouter) OverrideSyncWindows(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("application")
+	var until time.Time
+	if r.Method != http.MethodDelete {
+		d := defaultOverrideDuration
+		if v := r.URL.Query().Get("duration"); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed <= 0 {
+				http.Error(w, fmt.Sprintf("invalid duration %q", v), http.StatusBadRequest)
+				return
+			}
+			d = parsed
+		}
+		until = time.Now().Add(d)
+	}
+	found := false
+	for _, app := range a.applications.Applications() {
+		if name != "" && app.Name != name {
+			continue
+		}
+		found = true
+		app.OverrideSyncWindows(until)
+		if until.IsZero() {
+			log.Printf("Sync window override of %s removed by API call", app.Name)
+		} else {
+			log.Printf("Sync windows of %s overridden until %s by API call", app.Name, until.Format(time.RFC3339))
+		}
+	}
+	if name != "" && !found {
+		http.Error(w, "application not found", http.StatusNotFound)
+	}
+}
+
+// selectApplication returns the application named in the "application" query
+// parameter, or the only running application, if there is no application,
+// the error is written and this returns nil.
+func (a *APIRouter) selectApplication(w http.ResponseWriter, r *http.Request) *engine.Application {
+	name := r.URL.Query().Get("application")
+	apps := a.applications.Applications()
+	if name == "" {
+		if len(apps) != 1 {
+			http.Error(w, "application is required when running multiple applications", http.StatusBadRequest)
+			return nil
+		}
+		return apps[0]
+	}
+	for _, app := range apps {
+		if app.Name == name {
+			return app
+		}
+	}
+	http.Error(w, "application not found", http.StatusNotFound)
+	return nil
+}
+
+func (a *APIRouter) writeDeployment(w http.ResponseWriter, app *engine.Application, s recent.Synchronisation, err error) {
+	if errors.Is(err, engine.ErrNoPreviousCommit) || errors.Is(err, engine.ErrMonitoring) || errors.Is(err, engine.ErrSyncWindowClosed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, engine.ErrUntrustedCommit) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to deploy %s: %s", app.Name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := responseDeployment{
+		Application:  app.Name,
+		ID:           s.ID,
+		SHA:          s.SHA,
+		RollbackFrom: s.RollbackFrom,
+		Pinned:       s.Pinned,
+		Health:       s.Health,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("ERROR: failed to marshal deployment: %s", err)
+	}
+}
+
+// Status reports whether the applications are synchronised automatically.
+func (a *APIRouter) Status(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("application")
+	res := responseStatus{Applications: []responseApplicationStatus{}}
+	for _, app := range a.applications.Applications() {
+		if name != "" && app.Name != name {
+			continue
+		}
+		mode := app.Config.Mode
+		if mode == "" {
+			mode = engine.SyncMode
+		}
+		res.Applications = append(res.Applications, responseApplicationStatus{
+			Name:        app.Name,
+			Mode:        mode,
+			Suspended:   app.Suspended(),
+			Pinned:      app.Pinned(),
+			SyncWindows: makeSyncWindowsResponse(app, time.Now()),
+		})
+	}
+	if name != "" && len(res.Applications) == 0 {
+		http.Error(w, "application not found", http.StatusNotFound)
+		return
+	}
+	sort.Slice(res.Applications, func(i, j int) bool {
+		return res.Applications[i].Name < res.Applications[j].Name
+	})
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("ERROR: failed to marshal status: %s", err)
+	}
+}
+
+type responseStatus struct {
+	Applications []responseApplicationStatus `json:"applications"`
+}
+
+type responseApplicationStatus struct {
+	Name      string `json:"name"`
+	Mode      string `json:"mode"`
+	Suspended bool   `json:"suspended"`
+	// Pinned is the SHA that the application is held on.
+	Pinned string `json:"pinned,omitempty"`
+	// SyncWindows is omitted if the application has no sync windows, and
+	// they are not overridden.
+	SyncWindows *responseSyncWindows `json:"syncWindows,omitempty"`
+}
+
+type responseSyncWindows struct {
+	// Open is true if the resources can be applied.
+	Open   bool                 `json:"open"`
+	Active []responseSyncWindow `json:"active"`
+	// OverrideUntil is when the override of the sync windows expires.
+	OverrideUntil string `json:"overrideUntil,omitempty"`
+}
+
+type responseSyncWindow struct {
+	Kind     window.Kind `json:"kind"`
+	Schedule string      `json:"schedule"`
+	Duration string      `json:"duration"`
+	TimeZone string      `json:"timeZone"`
+}
+
+func makeSyncWindowsResponse(app *engine.Application, now time.Time) *responseSyncWindows {
+	override := app.SyncWindowOverride()
+	if !now.Before(override) {
+		override = time.Time{}
+	}
+	if len(app.Config.SyncWindows) == 0 && override.IsZero() {
+		return nil
+	}
+	res := &responseSyncWindows{
+		Open:   app.SyncWindowOpen(now),
+		Active: []responseSyncWindow{},
+	}
+	if !override.IsZero() {
+		res.OverrideUntil = override.Format(time.RFC3339)
+	}
+	for _, v := range app.Config.SyncWindows.Active(now) {
+		res.Active = append(res.Active, responseSyncWindow{
+			Kind:     v.Kind,
+			Schedule: v.Schedule,
+			Duration: v.Duration.String(),
+			TimeZone: v.Location.String(),
+		})
+	}
+	return res
+}
+
+type responseDeployment struct {
+	Application  string                  `json:"application"`
+	ID           int64                   `json:"id"`
+	SHA          string                  `json:"sha"`
+	RollbackFrom string                  `json:"rollbackFrom,omitempty"`
+	Pinned       bool                    `json:"pinned"`
+	Health       health.HealthStatusCode `json:"health,omitempty"`
+}
+
+type responseDiff struct {
+	Application string                `json:"application"`
+	SHA         string                `json:"sha"`
+	Group       string                `json:"group"`
+	Kind        string                `json:"kind"`
+	Namespace   string                `json:"namespace"`
+	Name        string                `json:"name"`
+	OutOfSync   bool                  `json:"outOfSync"`
+	Diff        string                `json:"diff"`
+	Patch       []jsonpatch.Operation `json:"patch"`
+}
+
+func isSynchronised(s recent.Synchronisation, key kube.ResourceKey) bool {
+	for _, v := range s.Results {
+		if v.ResourceKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+func pathValue(s string) string {
+	if s == emptyPathValue {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,737 @@
+package api
+
+import (
+	"container/ring"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/argoproj/gitops-engine/pkg/sync/common"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-cmp/cmp"
+	"gomodules.xyz/jsonpatch/v2"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/config"
+	"github.com/bigkevmcd/peanut-engine/pkg/diff"
+	"github.com/bigkevmcd/peanut-engine/pkg/engine"
+	"github.com/bigkevmcd/peanut-engine/pkg/plan"
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+	"github.com/bigkevmcd/peanut-engine/pkg/window"
+)
+
+var _ Applications = (*engine.Manager)(nil)
+
+var _ Deployer = (*engine.Manager)(nil)
+
+func TestSync(t *testing.T) {
+	app := &engine.Application{Name: "test-app", Resync: make(chan bool, 1)}
+	ts := makeServer(t, fakeApplications{app}, nil)
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/sync", "")
+
+	assertStatus(t, res, http.StatusOK)
+	if l := len(app.Resync); l != 1 {
+		t.Fatalf("got %d resyncs, want 1", l)
+	}
+}
+
+func TestSyncWithNamedApplication(t *testing.T) {
+	app1 := &engine.Application{Name: "app-1", Resync: make(chan bool, 1)}
+	app2 := &engine.Application{Name: "app-2", Resync: make(chan bool, 1)}
+	ts := makeServer(t, fakeApplications{app1, app2}, nil)
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/sync?application=app-2", "")
+
+	assertStatus(t, res, http.StatusOK)
+	if l := len(app1.Resync); l != 0 {
+		t.Fatalf("got %d resyncs, want 0", l)
+	}
+	if l := len(app2.Resync); l != 1 {
+		t.Fatalf("got %d resyncs, want 1", l)
+	}
+}
+
+func TestSyncWithPendingSynchronisation(t *testing.T) {
+	app := &engine.Application{Name: "test-app", Resync: make(chan bool, 1)}
+	app.Resync <- true
+	ts := makeServer(t, fakeApplications{app}, nil)
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/sync", "")
+
+	assertStatus(t, res, http.StatusOK)
+	if l := len(app.Resync); l != 1 {
+		t.Fatalf("got %d resyncs, want 1", l)
+	}
+}
+
+func TestSyncWithUnknownApplication(t *testing.T) {
+	ts := makeServer(t, fakeApplications{}, nil)
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/sync?application=unknown", "")
+
+	assertStatus(t, res, http.StatusNotFound)
+}
+
+func TestPlan(t *testing.T) {
+	var planned config.Application
+	ts := makeServer(t, fakeApplications{}, func(cfg config.Application) (*plan.Plan, error) {
+		planned = cfg
+		return testPlan(), nil
+	})
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/plan",
+		`{"repoURL": "https://github.com/bigkevmcd/peanut-engine.git", "branch": "testing", "path": "pkg/testdata", "prune": true}`)
+
+	assertStatus(t, res, http.StatusOK)
+	got := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"sha": "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+		"resources": []interface{}{
+			map[string]interface{}{
+				"group":     "apps",
+				"kind":      "Deployment",
+				"namespace": "test-ns",
+				"name":      "taxi",
+				"action":    "Update",
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("plan response:\n%s", diff)
+	}
+	if planned.Name != planApplicationName || planned.Branch != "testing" || !planned.Prune || !planned.Confined {
+		t.Fatalf("incorrect application planned: %#v", planned)
+	}
+}
+
+func TestPlanWithTableOutput(t *testing.T) {
+	ts := makeServer(t, fakeApplications{}, func(cfg config.Application) (*plan.Plan, error) {
+		return testPlan(), nil
+	})
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/plan?output=table",
+		`{"repoURL": "https://github.com/bigkevmcd/peanut-engine.git", "branch": "main", "path": "pkg/testdata"}`)
+
+	assertStatus(t, res, http.StatusOK)
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "0 to create, 1 to update, 0 to prune, 0 unchanged.") {
+		t.Fatalf("incorrect table output: %s", b)
+	}
+}
+
+func TestPlanErrors(t *testing.T) {
+	planTests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{"invalid JSON", `{`, http.StatusBadRequest, "failed to decode request"},
+		{"invalid application", `{"repoURL": "https://example.com"}`, http.StatusBadRequest, `application "plan" has no branch`},
+		{"failed plan", `{"repoURL": "https://example.com", "branch": "main", "path": "deploy"}`, http.StatusInternalServerError, "failed to clone"},
+		{"plugin parser", `{"repoURL": "https://example.com", "branch": "main", "parser": "plugin"}`, http.StatusBadRequest, "the plugin parser can't be used to plan"},
+		{"plugin options", `{"repoURL": "https://example.com", "branch": "main", "plugin": {"command": ["sh"]}}`, http.StatusBadRequest, `unknown field "plugin"`},
+		{"unknown field", `{"repoURL": "https://example.com", "branch": "main", "sources": []}`, http.StatusBadRequest, `unknown field "sources"`},
+		{"auth token file", `{"repoURL": "https://example.com", "branch": "main", "authTokenFile": "/var/run/secrets/kubernetes.io/serviceaccount/token"}`, http.StatusBadRequest, `unknown field "authTokenFile"`},
+		{"auth token env", `{"repoURL": "https://example.com", "branch": "main", "authTokenEnv": "GITHUB_TOKEN"}`, http.StatusBadRequest, `unknown field "authTokenEnv"`},
+		{"auth token secret", `{"repoURL": "https://example.com", "branch": "main", "authTokenSecret": "git-token"}`, http.StatusBadRequest, `unknown field "authTokenSecret"`},
+		{"ssh private key", `{"repoURL": "git@example.com:example.git", "branch": "main", "sshPrivateKeyFile": "/root/.ssh/id_rsa"}`, http.StatusBadRequest, `unknown field "sshPrivateKeyFile"`},
+		{"sops key", `{"repoURL": "https://example.com", "branch": "main", "sopsAgeKeyFile": "/etc/sops/age.txt"}`, http.StatusBadRequest, `unknown field "sopsAgeKeyFile"`},
+		{"absolute value file", `{"repoURL": "https://example.com", "branch": "main", "path": "deploy", "helm": {"valueFiles": ["/etc/passwd"]}}`, http.StatusBadRequest, `invalid path "/etc/passwd", must be within the repository`},
+		{"file repository", `{"repoURL": "file:///var/lib/peanut/repo", "branch": "main"}`, http.StatusBadRequest, `invalid repoURL "file:///var/lib/peanut/repo", only https and ssh repositories can be planned`},
+		{"local repository", `{"repoURL": "/var/lib/peanut/repo", "branch": "main"}`, http.StatusBadRequest, `invalid repoURL "/var/lib/peanut/repo", only https and ssh repositories can be planned`},
+		{"http repository", `{"repoURL": "http://gitea.internal/org/repo.git", "branch": "main"}`, http.StatusBadRequest, `invalid repoURL "http://gitea.internal/org/repo.git", only https and ssh repositories can be planned`},
+		{"parent lib path", `{"repoURL": "https://example.com", "branch": "main", "path": "deploy", "jsonnet": {"libPaths": ["../lib"]}}`, http.StatusBadRequest, `invalid path "../lib", must be within the repository`},
+	}
+
+	for _, tt := range planTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := makeServer(t, fakeApplications{}, func(cfg config.Application) (*plan.Plan, error) {
+				return nil, errors.New("failed to clone")
+			})
+
+			res := doRequest(t, ts, http.MethodPost, "/api/v1/plan", tt.body)
+
+			assertStatus(t, res, tt.status)
+			b, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), tt.want) {
+				t.Fatalf("got error %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRemoteURL(t *testing.T) {
+	urlTests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://github.com/org/repo.git", true},
+		{"ssh://git@github.com/org/repo.git", true},
+		{"git@github.com:org/repo.git", true},
+		{"http://github.com/org/repo.git", false},
+		{"file:///tmp/repo", false},
+		{"/tmp/repo", false},
+		{"repo", false},
+	}
+
+	for _, tt := range urlTests {
+		if got := isRemoteURL(tt.url); got != tt.want {
+			t.Errorf("isRemoteURL(%q) got %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+type failingStateStore struct{}
+
+func (failingStateStore) LoadState(string) (recent.State, error) {
+	return recent.State{}, nil
+}
+
+func (failingStateStore) SaveState(string, recent.State) error {
+	return errors.New("failed to update ConfigMap")
+}
+
+type fakeApplications []*engine.Application
+
+func (f fakeApplications) Applications() []*engine.Application {
+	return f
+}
+
+func testPlan() *plan.Plan {
+	return &plan.Plan{
+		SHA: "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+		Resources: []plan.ResourceChange{
+			{Group: "apps", Kind: "Deployment", Namespace: "test-ns", Name: "taxi", Action: plan.ActionUpdate},
+		},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ts := makeServer(t, fakeApplications{testDiffApplication()}, nil)
+
+	res := doRequest(t, ts, http.MethodGet, "/api/v1/resources/_/ConfigMap/test-ns/test-cfg/diff", "")
+
+	assertStatus(t, res, http.StatusOK)
+	got := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"application": "test-app",
+		"sha":         "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+		"group":       "",
+		"kind":        "ConfigMap",
+		"namespace":   "test-ns",
+		"name":        "test-cfg",
+		"outOfSync":   true,
+		"diff":        testUnifiedDiff,
+		"patch": []interface{}{
+			map[string]interface{}{"op": "replace", "path": "/data/key", "value": "new"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("diff response:\n%s", diff)
+	}
+}
+
+func TestDiffWithDiffOutput(t *testing.T) {
+	ts := makeServer(t, fakeApplications{testDiffApplication()}, nil)
+
+	res := doRequest(t, ts, http.MethodGet, "/api/v1/resources/_/ConfigMap/test-ns/test-cfg/diff?output=diff", "")
+
+	assertStatus(t, res, http.StatusOK)
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(testUnifiedDiff, string(b)); diff != "" {
+		t.Fatalf("diff response:\n%s", diff)
+	}
+}
+
+func TestDiffWithSynchronisedResource(t *testing.T) {
+	ts := makeServer(t, fakeApplications{testDiffApplication()}, nil)
+
+	res := doRequest(t, ts, http.MethodGet, "/api/v1/resources/apps/Deployment/test-ns/taxi/diff", "")
+
+	assertStatus(t, res, http.StatusOK)
+	got := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got["outOfSync"] != false {
+		t.Fatalf("got outOfSync %v, want false", got["outOfSync"])
+	}
+	if diff := cmp.Diff([]interface{}{}, got["patch"]); diff != "" {
+		t.Fatalf("patch:\n%s", diff)
+	}
+}
+
+func TestDiffWithUnknownResource(t *testing.T) {
+	ts := makeServer(t, fakeApplications{testDiffApplication()}, nil)
+
+	res := doRequest(t, ts, http.MethodGet, "/api/v1/resources/_/ConfigMap/test-ns/unknown/diff", "")
+
+	assertStatus(t, res, http.StatusNotFound)
+}
+
+func TestDiffWithOtherApplication(t *testing.T) {
+	ts := makeServer(t, fakeApplications{testDiffApplication()}, nil)
+
+	res := doRequest(t, ts, http.MethodGet, "/api/v1/resources/_/ConfigMap/test-ns/test-cfg/diff?application=other-app", "")
+
+	assertStatus(t, res, http.StatusNotFound)
+}
+
+const testUnifiedDiff = `--- live
++++ desired
+@@ -1,3 +1,3 @@
+ data:
+-  key: old
++  key: new
+`
+
+func testDiffApplication() *engine.Application {
+	syncs := recent.NewRecentSynchronisations(ring.New(1))
+	syncs.Record(recent.Synchronisation{
+		SHA: "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+		Results: []common.ResourceSyncResult{
+			{ResourceKey: kube.NewResourceKey("", "ConfigMap", "test-ns", "test-cfg"), Status: common.ResultCodeSynced},
+			{ResourceKey: kube.NewResourceKey("apps", "Deployment", "test-ns", "taxi"), Status: common.ResultCodeSynced},
+		},
+		Diffs: []diff.ResourceDiff{
+			{
+				Kind:      "ConfigMap",
+				Namespace: "test-ns",
+				Name:      "test-cfg",
+				Diff:      testUnifiedDiff,
+				Patch:     []jsonpatch.Operation{{Operation: "replace", Path: "/data/key", Value: "new"}},
+			},
+		},
+	})
+	return &engine.Application{Name: "test-app", Synchronisations: syncs}
+}
+
+func TestHealth(t *testing.T) {
+	syncs := recent.NewRecentSynchronisations(ring.New(1))
+	syncs.Record(recent.Synchronisation{
+		SHA:    "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+		Health: health.HealthStatusDegraded,
+		ResourceHealth: []recent.ResourceHealth{
+			{Group: "apps", Kind: "Deployment", Namespace: "test-ns", Name: "taxi", Status: health.HealthStatusDegraded, Message: "Deployment exceeded its progress deadline"},
+		},
+	})
+	ts := makeServer(t, fakeApplications{
+		&engine.Application{Name: "test-app", Synchronisations: syncs},
+		&engine.Application{Name: "new-app", Synchronisations: recent.NewRecentSynchronisations(ring.New(1))},
+	}, nil)
+
+	res := doRequest(t, ts, http.MethodGet, "/api/v1/health", "")
+
+	assertStatus(t, res, http.StatusOK)
+	got := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"applications": []interface{}{
+			map[string]interface{}{
+				"name":      "new-app",
+				"health":    "Unknown",
+				"resources": []interface{}{},
+			},
+			map[string]interface{}{
+				"name":   "test-app",
+				"sha":    "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+				"health": "Degraded",
+				"resources": []interface{}{
+					map[string]interface{}{
+						"group":     "apps",
+						"kind":      "Deployment",
+						"namespace": "test-ns",
+						"name":      "taxi",
+						"status":    "Degraded",
+						"message":   "Deployment exceeded its progress deadline",
+					},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("health response:\n%s", diff)
+	}
+}
+
+func TestHealthWithUnknownApplication(t *testing.T) {
+	ts := makeServer(t, fakeApplications{testDiffApplication()}, nil)
+
+	res := doRequest(t, ts, http.MethodGet, "/api/v1/health?application=unknown", "")
+
+	assertStatus(t, res, http.StatusNotFound)
+}
+
+const deploySHA = "9b2e1ea1e5c1e2d3cc5d4b1a0e7b1f2c3d4e5f60"
+
+func TestDeploy(t *testing.T) {
+	deployer := &fakeDeployer{}
+	ts := makeDeployServer(t, fakeApplications{{Name: "test-app"}}, deployer)
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/deploy?sha="+deploySHA, "")
+
+	assertStatus(t, res, http.StatusOK)
+	if deployer.deployed != plumbing.NewHash(deploySHA) {
+		t.Fatalf("got deployed %s, want %s", deployer.deployed, deploySHA)
+	}
+	got := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"application": "test-app",
+		"id":          float64(1),
+		"sha":         deploySHA,
+		"pinned":      true,
+		"health":      "Healthy",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("deploy response:\n%s", diff)
+	}
+}
+
+func TestDeployErrors(t *testing.T) {
+	errorTests := []struct {
+		name     string
+		apps     fakeApplications
+		path     string
+		deployer *fakeDeployer
+		want     int
+	}{
+		{"missing sha", fakeApplications{{Name: "test-app"}}, "/api/v1/deploy", &fakeDeployer{}, http.StatusBadRequest},
+		{"invalid sha", fakeApplications{{Name: "test-app"}}, "/api/v1/deploy?sha=main", &fakeDeployer{}, http.StatusBadRequest},
+		{"multiple applications", fakeApplications{{Name: "app-1"}, {Name: "app-2"}}, "/api/v1/deploy?sha=" + deploySHA, &fakeDeployer{}, http.StatusBadRequest},
+		{"unknown application", fakeApplications{{Name: "test-app"}}, "/api/v1/deploy?application=unknown&sha=" + deploySHA, &fakeDeployer{}, http.StatusNotFound},
+		{"failed deployment", fakeApplications{{Name: "test-app"}}, "/api/v1/deploy?sha=" + deploySHA, &fakeDeployer{err: errors.New("unknown commit")}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := makeDeployServer(t, tt.apps, tt.deployer)
+
+			res := doRequest(t, ts, http.MethodPost, tt.path, "")
+
+			assertStatus(t, res, tt.want)
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	rollbackTests := []struct {
+		path string
+		want plumbing.Hash
+	}{
+		{"/api/v1/rollback", plumbing.ZeroHash},
+		{"/api/v1/rollback?sha=previous", plumbing.ZeroHash},
+		{"/api/v1/rollback?application=app-2&sha=" + deploySHA, plumbing.NewHash(deploySHA)},
+	}
+
+	for _, tt := range rollbackTests {
+		t.Run(tt.path, func(t *testing.T) {
+			deployer := &fakeDeployer{rolledBack: plumbing.NewHash(deploySHA)}
+			apps := fakeApplications{{Name: "app-2"}}
+			ts := makeDeployServer(t, apps, deployer)
+
+			res := doRequest(t, ts, http.MethodPost, tt.path, "")
+
+			assertStatus(t, res, http.StatusOK)
+			if deployer.rolledBack != tt.want {
+				t.Fatalf("got rolled back to %s, want %s", deployer.rolledBack, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollbackErrors(t *testing.T) {
+	errorTests := []struct {
+		name     string
+		path     string
+		deployer *fakeDeployer
+		want     int
+	}{
+		{"invalid sha", "/api/v1/rollback?sha=main", &fakeDeployer{}, http.StatusBadRequest},
+		{"no previous commit", "/api/v1/rollback", &fakeDeployer{err: engine.ErrNoPreviousCommit}, http.StatusConflict},
+		{"monitor mode", "/api/v1/rollback", &fakeDeployer{err: engine.ErrMonitoring}, http.StatusConflict},
+		{"outside the sync windows", "/api/v1/rollback", &fakeDeployer{err: engine.ErrSyncWindowClosed}, http.StatusConflict},
+		{"untrusted commit", "/api/v1/deploy?sha=7f193461f0b44fc5e397a63f2ddba8d9453e7a3f", &fakeDeployer{err: fmt.Errorf("%w: not signed", engine.ErrUntrustedCommit)}, http.StatusForbidden},
+		{"failed rollback", "/api/v1/rollback", &fakeDeployer{err: errors.New("failed")}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := makeDeployServer(t, fakeApplications{{Name: "test-app"}}, tt.deployer)
+
+			res := doRequest(t, ts, http.MethodPost, tt.path, "")
+
+			assertStatus(t, res, tt.want)
+		})
+	}
+}
+
+func TestSuspendAndResume(t *testing.T) {
+	app1 := &engine.Application{Name: "app-1", Resync: make(chan bool, 1)}
+	app2 := &engine.Application{Name: "app-2", Resync: make(chan bool, 1)}
+	ts := makeServer(t, fakeApplications{app1, app2}, nil)
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/suspend?application=app-2", "")
+
+	assertStatus(t, res, http.StatusOK)
+	if app1.Suspended() || !app2.Suspended() {
+		t.Fatalf("got suspended %v and %v, want only app-2 suspended", app1.Suspended(), app2.Suspended())
+	}
+
+	res = doRequest(t, ts, http.MethodPost, "/api/v1/resume", "")
+
+	assertStatus(t, res, http.StatusOK)
+	if app2.Suspended() {
+		t.Fatal("app-2 was not resumed")
+	}
+	if l1, l2 := len(app1.Resync), len(app2.Resync); l1 != 0 || l2 != 1 {
+		t.Fatalf("got %d and %d resyncs, want 0 and 1", l1, l2)
+	}
+}
+
+func TestSuspendWhenTheStateCantBeSaved(t *testing.T) {
+	app := &engine.Application{Name: "test-app", Resync: make(chan bool, 1), StateStore: failingStateStore{}}
+	ts := makeServer(t, fakeApplications{app}, nil)
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/suspend", "")
+
+	assertStatus(t, res, http.StatusInternalServerError)
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "failed to suspend test-app: failed to save the state: failed to update ConfigMap\n"; string(b) != want {
+		t.Fatalf("got error %q, want %q", b, want)
+	}
+	if app.Suspended() {
+		t.Fatal("test-app was suspended")
+	}
+}
+
+func TestSuspendWithUnknownApplication(t *testing.T) {
+	ts := makeServer(t, fakeApplications{{Name: "test-app"}}, nil)
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/suspend?application=unknown", "")
+
+	assertStatus(t, res, http.StatusNotFound)
+}
+
+func TestStatus(t *testing.T) {
+	suspended := &engine.Application{Name: "suspended-app", Resync: make(chan bool, 1)}
+	if _, err := suspended.Suspend(); err != nil {
+		t.Fatal(err)
+	}
+	monitored := &engine.Application{Name: "monitored-app", Config: engine.PeanutConfig{Mode: engine.MonitorMode}}
+	ts := makeServer(t, fakeApplications{suspended, monitored}, nil)
+
+	res := doRequest(t, ts, http.MethodGet, "/api/v1/status", "")
+
+	assertStatus(t, res, http.StatusOK)
+	got := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"applications": []interface{}{
+			map[string]interface{}{"name": "monitored-app", "mode": "monitor", "suspended": false},
+			map[string]interface{}{"name": "suspended-app", "mode": "sync", "suspended": true},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("status response:\n%s", diff)
+	}
+}
+
+func TestStatusWithSyncWindows(t *testing.T) {
+	deny, err := window.New(window.Deny, "* * * * *", time.Hour, "Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow, err := window.New(window.Allow, "0 0 1 1 *", time.Minute, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &engine.Application{Name: "test-app", Config: engine.PeanutConfig{SyncWindows: window.Windows{allow, deny}}}
+	ts := makeServer(t, fakeApplications{app}, nil)
+
+	res := doRequest(t, ts, http.MethodGet, "/api/v1/status", "")
+
+	assertStatus(t, res, http.StatusOK)
+	got := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"applications": []interface{}{
+			map[string]interface{}{
+				"name":      "test-app",
+				"mode":      "sync",
+				"suspended": false,
+				"syncWindows": map[string]interface{}{
+					"open": false,
+					"active": []interface{}{
+						map[string]interface{}{"kind": "deny", "schedule": "* * * * *", "duration": "1h0m0s", "timeZone": "Europe/London"},
+					},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("status response:\n%s", diff)
+	}
+}
+
+func TestOverrideSyncWindows(t *testing.T) {
+	deny, err := window.New(window.Deny, "* * * * *", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &engine.Application{Name: "test-app", Resync: make(chan bool, 1), Config: engine.PeanutConfig{SyncWindows: window.Windows{deny}}}
+	ts := makeServer(t, fakeApplications{app}, nil)
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/windows/override?duration=2h", "")
+
+	assertStatus(t, res, http.StatusOK)
+	if until := time.Until(app.SyncWindowOverride()); until <= time.Hour || until > 2*time.Hour {
+		t.Fatalf("got override for %s, want 2h", until)
+	}
+	if !app.SyncWindowOpen(time.Now()) {
+		t.Fatal("sync windows are not open with the override")
+	}
+	if l := len(app.Resync); l != 1 {
+		t.Fatalf("got %d resyncs, want 1", l)
+	}
+
+	res = doRequest(t, ts, http.MethodDelete, "/api/v1/windows/override?application=test-app", "")
+
+	assertStatus(t, res, http.StatusOK)
+	if app.SyncWindowOpen(time.Now()) {
+		t.Fatal("sync windows are open after removing the override")
+	}
+}
+
+func TestOverrideSyncWindowsErrors(t *testing.T) {
+	errorTests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"invalid duration", "/api/v1/windows/override?duration=soon", http.StatusBadRequest},
+		{"negative duration", "/api/v1/windows/override?duration=-1h", http.StatusBadRequest},
+		{"unknown application", "/api/v1/windows/override?application=unknown", http.StatusNotFound},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := makeServer(t, fakeApplications{{Name: "test-app", Resync: make(chan bool, 1)}}, nil)
+
+			res := doRequest(t, ts, http.MethodPost, tt.path, "")
+
+			assertStatus(t, res, tt.want)
+		})
+	}
+}
+
+func TestStatusWithUnknownApplication(t *testing.T) {
+	ts := makeServer(t, fakeApplications{{Name: "test-app"}}, nil)
+
+	res := doRequest(t, ts, http.MethodGet, "/api/v1/status?application=unknown", "")
+
+	assertStatus(t, res, http.StatusNotFound)
+}
+
+func TestResumeWithUnknownApplication(t *testing.T) {
+	ts := makeServer(t, fakeApplications{{Name: "test-app"}}, nil)
+
+	res := doRequest(t, ts, http.MethodPost, "/api/v1/resume?application=unknown", "")
+
+	assertStatus(t, res, http.StatusNotFound)
+}
+
+type fakeDeployer struct {
+	deployed   plumbing.Hash
+	rolledBack plumbing.Hash
+	err        error
+}
+
+func (f *fakeDeployer) Deploy(ctx context.Context, app *engine.Application, sha plumbing.Hash) (recent.Synchronisation, error) {
+	f.deployed = sha
+	return f.synchronisation(sha.String(), "")
+}
+
+func (f *fakeDeployer) Rollback(ctx context.Context, app *engine.Application, sha plumbing.Hash) (recent.Synchronisation, error) {
+	from := f.rolledBack
+	f.rolledBack = sha
+	return f.synchronisation(from.String(), "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f")
+}
+
+func (f *fakeDeployer) synchronisation(sha, rollbackFrom string) (recent.Synchronisation, error) {
+	if f.err != nil {
+		return recent.Synchronisation{}, f.err
+	}
+	return recent.Synchronisation{ID: 1, SHA: sha, RollbackFrom: rollbackFrom, Pinned: true, Health: health.HealthStatusHealthy}, nil
+}
+
+func makeServer(t *testing.T, apps Applications, planner Planner) *httptest.Server {
+	ts := httptest.NewTLSServer(NewRouter(apps, planner, nil))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func makeDeployServer(t *testing.T, apps Applications, deployer Deployer) *httptest.Server {
+	ts := httptest.NewTLSServer(NewRouter(apps, nil, deployer))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func doRequest(t *testing.T, ts *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", ts.URL, path), strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		res.Body.Close()
+	})
+	return res
+}
+
+func assertStatus(t *testing.T, res *http.Response, want int) {
+	t.Helper()
+	if res.StatusCode != want {
+		t.Fatalf("status code got %v, want %v", res.StatusCode, want)
+	}
+}
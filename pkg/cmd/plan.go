@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/argoproj/pkg/kube/cli"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/config"
+	"github.com/bigkevmcd/peanut-engine/pkg/engine"
+	"github.com/bigkevmcd/peanut-engine/pkg/metrics"
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+const (
+	outputFlag = "output"
+
+	jsonOutput  = "json"
+	tableOutput = "table"
+)
+
+func makePlanCmd() *cobra.Command {
+	var (
+		clientConfig     clientcmd.ClientConfig
+		appCfg           config.Application
+		defaultNamespace string
+		output           string
+	)
+	cmd := cobra.Command{
+		Use:   "plan",
+		Short: "Report the changes that synchronising would make, without applying them",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != jsonOutput && output != tableOutput {
+				return fmt.Errorf("unknown output format %q", output)
+			}
+			appCfg.Name = defaultApplicationName
+			appCfg.Resync.Duration = config.DefaultResync
+			if err := appCfg.Validate(); err != nil {
+				return err
+			}
+			restConfig, err := clientConfig.ClientConfig()
+			if err != nil {
+				return err
+			}
+			if defaultNamespace == "" {
+				defaultNamespace, _, err = clientConfig.Namespace()
+				if err != nil {
+					return err
+				}
+			}
+
+			kubeClient, err := kubernetes.NewForConfig(restConfig)
+			if err != nil {
+				return err
+			}
+			app, cleanup, err := makeApplication(appCfg, applicationOptions{
+				defaultNamespace: defaultNamespace,
+				metrics:          metrics.New("peanut", prometheus.NewRegistry()),
+				historyDepth:     1,
+				historyStore:     recent.NewMemoryStore(),
+				kubeClient:       kubeClient,
+			})
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			manager := engine.NewManager(restConfig, []string{})
+			stop, err := manager.Run()
+			if err != nil {
+				return err
+			}
+			defer stop()
+
+			p, err := manager.Plan(app)
+			if err != nil {
+				return err
+			}
+			if output == jsonOutput {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				return e.Encode(p)
+			}
+			return p.WriteTable(os.Stdout)
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(&cmd)
+
+	addApplicationFlags(&cmd, &appCfg)
+	addDefaultNamespaceFlag(&cmd, &defaultNamespace)
+	cmd.Flags().StringVar(&output, outputFlag, tableOutput, "Output format, table or json")
+	return &cmd
+}
@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
-	"time"
+	"path/filepath"
+	"strings"
 
 	"container/ring"
 
@@ -13,28 +17,93 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"knative.dev/pkg/signals"
 
+	"github.com/bigkevmcd/peanut-engine/pkg/api"
+	"github.com/bigkevmcd/peanut-engine/pkg/config"
+	"github.com/bigkevmcd/peanut-engine/pkg/controller"
+	"github.com/bigkevmcd/peanut-engine/pkg/credentials"
 	"github.com/bigkevmcd/peanut-engine/pkg/engine"
 	"github.com/bigkevmcd/peanut-engine/pkg/metrics"
-	"github.com/bigkevmcd/peanut-engine/pkg/parser"
-	"github.com/bigkevmcd/peanut-engine/pkg/parser/kustomize"
-	"github.com/bigkevmcd/peanut-engine/pkg/parser/manifest"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/plugin"
+	"github.com/bigkevmcd/peanut-engine/pkg/plan"
 	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+	"github.com/bigkevmcd/peanut-engine/pkg/transform"
+	"github.com/bigkevmcd/peanut-engine/pkg/webhook"
 )
 
 const (
-	repoURLFlag          = "repo-url"
-	branchFlag           = "branch"
-	pathFlag             = "path"
-	portFlag             = "port"
-	resyncFlag           = "resync"
-	pruneFlag            = "prune"
-	namespacedFlag       = "namespaced"
-	defaultNamespaceFlag = "default-namespace"
-	parserFlag           = "parser"
-	authTokenFlag        = "auth-token"
+	repoURLFlag            = "repo-url"
+	branchFlag             = "branch"
+	revisionFlag           = "revision"
+	pathFlag               = "path"
+	portFlag               = "port"
+	resyncFlag             = "resync"
+	healthTimeoutFlag      = "health-timeout"
+	autoRollbackFlag       = "auto-rollback"
+	pruneFlag              = "prune"
+	namespacedFlag         = "namespaced"
+	defaultNamespaceFlag   = "default-namespace"
+	parserFlag             = "parser"
+	authTokenFlag          = "auth-token"
+	authTokenFileFlag      = "auth-token-file"
+	authTokenEnvFlag       = "auth-token-env"
+	authTokenSecretFlag    = "auth-token-secret"
+	authTokenSecretKeyFlag = "auth-token-secret-key"
+	authUsernameFlag       = "auth-username"
+	configFlag             = "config"
+	watchApplicationsFlag  = "watch-applications"
+	targetNamespacesFlag   = "application-target-namespaces"
+	applicationSOPSFlag    = "application-sops-keys"
+	historyDepthFlag       = "history-depth"
+
+	sshPrivateKeyFileFlag           = "ssh-private-key-file"
+	sshPrivateKeyPassphraseFileFlag = "ssh-private-key-passphrase-file"
+	sshKnownHostsFileFlag           = "ssh-known-hosts-file"
+	sshInsecureIgnoreHostKeyFlag    = "ssh-insecure-ignore-host-key"
+	historyStoreFlag                = "history-store"
+	historyDirFlag                  = "history-dir"
+	webhookSecretFileFlag           = "webhook-secret-file"
+	depthFlag                       = "depth"
+	singleBranchFlag                = "single-branch"
+	sparseFlag                      = "sparse"
+	cloneDirFlag                    = "clone-dir"
+	verifyKeyringFlag               = "verify-keyring"
+	helmReleaseNameFlag             = "helm-release-name"
+	helmValuesFlag                  = "helm-values"
+	helmSetFlag                     = "helm-set"
+	jsonnetMainFlag                 = "jsonnet-main"
+	jsonnetExtVarFlag               = "jsonnet-ext-var"
+	jsonnetTLAFlag                  = "jsonnet-tla"
+	jsonnetLibFlag                  = "jsonnet-lib"
+	verifyAllowedSignersFlag        = "verify-allowed-signers"
+	sopsAgeKeyFileFlag              = "sops-age-key-file"
+	sopsGnuPGHomeFlag               = "sops-gnupg-home"
+	pluginCommandFlag               = "plugin-command"
+	pluginEnvFlag                   = "plugin-env"
+	pluginTimeoutFlag               = "plugin-timeout"
+	pluginMaxOutputFlag             = "plugin-max-output"
+	transformNamespaceFlag          = "transform-namespace"
+	transformLabelFlag              = "transform-label"
+	transformAnnotationFlag         = "transform-annotation"
+	transformImageFlag              = "transform-image"
+	transformStripFieldFlag         = "transform-strip-field"
+	modeFlag                        = "mode"
+)
+
+// defaultApplicationName is the name of the application configured from the
+// command-line flags.
+const defaultApplicationName = "default"
+
+const defaultHistoryDepth = 20
+
+const (
+	memoryHistoryStore    = "memory"
+	fileHistoryStore      = "file"
+	configMapHistoryStore = "configmap"
 )
 
 func init() {
@@ -43,88 +112,429 @@ func init() {
 
 func makeRootCmd() *cobra.Command {
 	var (
-		clientConfig clientcmd.ClientConfig
-		cfg          engine.PeanutConfig
-		gitCfg       engine.GitConfig
-		port         int
-		parserName   string
+		clientConfig      clientcmd.ClientConfig
+		appCfg            config.Application
+		port              int
+		namespaced        bool
+		defaultNamespace  string
+		configFile        string
+		watchApplications bool
+		targetNamespaces  []string
+		applicationSOPS   bool
+		historyDepth      int
+		historyStore      string
+		historyDir        string
+		webhookSecretFile string
+		cloneDir          string
+		mode              string
 	)
 	cmd := cobra.Command{
 		Use: "peanut-engine",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			resync := make(chan bool)
-			config, err := clientConfig.ClientConfig()
+			apps, err := loadApplications(configFile, appCfg, watchApplications)
 			if err != nil {
 				return err
 			}
-			if cfg.Namespace == "" {
-				cfg.Namespace, _, err = clientConfig.Namespace()
+			restConfig, err := clientConfig.ClientConfig()
+			if err != nil {
+				return err
+			}
+			if defaultNamespace == "" {
+				defaultNamespace, _, err = clientConfig.Namespace()
 				if err != nil {
 					return err
 				}
 			}
-			recentSyncs := recent.NewRecentSynchronisations(ring.New(1))
 
-			http.Handle("/", recent.NewRouter(recentSyncs))
+			if historyDepth < 1 {
+				return fmt.Errorf("--%s must be at least 1", historyDepthFlag)
+			}
+			if mode != engine.SyncMode && mode != engine.MonitorMode {
+				return fmt.Errorf("--%s must be %s or %s", modeFlag, engine.SyncMode, engine.MonitorMode)
+			}
+
+			kubeClient, err := kubernetes.NewForConfig(restConfig)
+			if err != nil {
+				return err
+			}
+			store, err := makeHistoryStore(historyStore, historyDir, kubeClient, defaultNamespace)
+			if err != nil {
+				return err
+			}
+
+			opts := applicationOptions{
+				defaultNamespace: defaultNamespace,
+				metrics:          metrics.New("peanut", nil),
+				historyDepth:     historyDepth,
+				historyStore:     store,
+				kubeClient:       kubeClient,
+				cloneDir:         cloneDir,
+				sopsAgeKeyFile:   appCfg.SOPSAgeKeyFile,
+				sopsGnuPGHome:    appCfg.SOPSGnuPGHome,
+				mode:             mode,
+			}
+			namespaces := []string{}
+			peanutApps := []*engine.Application{}
+			for _, v := range apps {
+				app, cleanup, err := makeApplication(v, opts)
+				if err != nil {
+					return err
+				}
+				defer cleanup()
+				namespaces = append(namespaces, app.Config.Namespace)
+				peanutApps = append(peanutApps, app)
+			}
+			// The first application is served at /latest for compatibility
+			// with single application mode.
+			var latest *recent.RecentSynchronisations
+			if len(peanutApps) > 0 {
+				latest = peanutApps[0].Synchronisations
+			}
+			router := recent.NewRouter(latest)
+			for _, app := range peanutApps {
+				router.AddApplication(app.Name, app.Synchronisations)
+			}
+
+			if !namespaced {
+				namespaces = []string{}
+			} else if watchApplications {
+				namespaces = append(namespaces, defaultNamespace)
+			}
+			manager := engine.NewManager(restConfig, namespaces)
+
+			// Plans are for arbitrary repositories, so they are not kept,
+			// and the operator's keys are not used to decrypt them.
+			planOpts := opts
+			planOpts.cloneDir = ""
+			planOpts.sopsAgeKeyFile, planOpts.sopsGnuPGHome = "", ""
+			planner := func(cfg config.Application) (*plan.Plan, error) {
+				app, cleanup, err := makeApplication(cfg, planOpts)
+				if err != nil {
+					return nil, err
+				}
+				defer cleanup()
+				return manager.Plan(app)
+			}
+
+			http.Handle("/", router)
+			http.Handle("/api/", api.NewRouter(manager, planner, manager))
 			http.Handle("/metrics", promhttp.Handler())
-			http.HandleFunc("/api/v1/sync", func(writer http.ResponseWriter, request *http.Request) {
-				log.Println("Synchronization triggered by API call")
-				resync <- true
-			})
+			if webhookSecretFile != "" {
+				secret, err := os.ReadFile(webhookSecretFile)
+				if err != nil {
+					return fmt.Errorf("failed to read the webhook secret: %w", err)
+				}
+				http.Handle("/api/v1/webhooks/", webhook.NewHandler(manager, bytes.TrimSpace(secret)))
+			}
 
 			go func() {
 				logIfError(http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", viper.GetInt(portFlag)), nil))
 			}()
 
-			var parser parser.ManifestParser = kustomize.New()
-			if parserName == "manifest" {
-				parser = manifest.New()
-			}
-
-			peanutRepo := engine.NewRepository(gitCfg, parser)
-			dir, err := os.MkdirTemp("", "peanut")
+			stop, err := manager.Run()
 			if err != nil {
 				return err
 			}
-			defer os.RemoveAll(dir)
-			log.Printf("Cloning to %s", dir)
-			err = peanutRepo.Clone(dir)
-			if err != nil {
-				return fmt.Errorf("failed to clone repository: %w", err)
-			}
+			defer stop()
 
-			return engine.StartPeanutSync(
-				config, cfg, peanutRepo, metrics.New("peanut", nil),
-				recentSyncs, resync, signals.SetupSignalHandler())
+			done := signals.SetupSignalHandler()
+			for _, app := range peanutApps {
+				manager.Start(app, done)
+			}
+			if watchApplications {
+				client, err := dynamic.NewForConfig(restConfig)
+				if err != nil {
+					return err
+				}
+				watchNamespace := ""
+				if namespaced {
+					watchNamespace = defaultNamespace
+				}
+				// Anyone who can create PeanutApplications could read the
+				// Secrets decrypted with the operator's keys.
+				applicationOpts := opts
+				if !applicationSOPS {
+					applicationOpts.sopsAgeKeyFile, applicationOpts.sopsGnuPGHome = "", ""
+				}
+				factory := func(cfg config.Application) (*engine.Application, func(), error) {
+					app, cleanup, err := makeApplication(cfg, applicationOpts)
+					if err != nil {
+						return nil, nil, err
+					}
+					router.AddApplication(app.Name, app.Synchronisations)
+					return app, func() {
+						router.RemoveApplication(app.Name)
+						cleanup()
+					}, nil
+				}
+				controller.New(client, watchNamespace, targetNamespaces, manager, factory).Run(done)
+			}
+			manager.Wait()
+			return nil
 		},
 	}
 	clientConfig = cli.AddKubectlFlagsToCmd(&cmd)
 
-	cmd.Flags().StringVar(&gitCfg.RepoURL, repoURLFlag, "", "Repository to deploy e.g. https://github.com/example/example.git")
-	logIfError(cmd.MarkFlagRequired(repoURLFlag))
+	cmd.Flags().BoolVar(&watchApplications, watchApplicationsFlag, false, "Synchronise the applications defined by PeanutApplication resources in the cluster")
+	cmd.Flags().StringSliceVar(&targetNamespaces, targetNamespacesFlag, nil, "Namespaces that PeanutApplication resources can deploy to, as well as their own namespace, * allows any namespace")
+	cmd.Flags().BoolVar(&applicationSOPS, applicationSOPSFlag, false, "Decrypt the files of PeanutApplication resources with the --sops-age-key-file and --sops-gnupg-home keys")
+
+	cmd.Flags().StringVar(&configFile, configFlag, "", "Configuration file listing the applications to synchronise, replaces the repository flags")
 
-	cmd.Flags().StringVar(&gitCfg.Branch, branchFlag, "", "Branch to checkout e.g. production")
-	logIfError(cmd.MarkFlagRequired(branchFlag))
+	addApplicationFlags(&cmd, &appCfg)
+	cmd.Flags().DurationVar(&appCfg.Resync.Duration, resyncFlag, config.DefaultResync, "Resync frequency")
+	cmd.Flags().DurationVar(&appCfg.HealthTimeout.Duration, healthTimeoutFlag, 0, "How long to wait for the resources to become healthy after synchronising, by default the health is assessed once")
+	cmd.Flags().BoolVar(&appCfg.AutoRollback, autoRollbackFlag, false, "Applies the resources from the last healthy commit if the resources from a commit are degraded, the degraded commit is not synchronised again until there is a newer commit")
+	cmd.Flags().IntVar(&historyDepth, historyDepthFlag, defaultHistoryDepth, "The number of synchronisations to keep in the history of each application")
+	cmd.Flags().StringVar(&historyStore, historyStoreFlag, memoryHistoryStore, "Where to store the synchronisation history, memory, file or configmap, file and configmap are retained across restarts")
+	cmd.Flags().StringVar(&historyDir, historyDirFlag, "", "The directory to store the synchronisation history in when using the file history store")
 
-	cmd.Flags().StringVar(&gitCfg.Path, pathFlag, "", "Path within the Repository to deploy e.g. deploy")
-	logIfError(cmd.MarkFlagRequired(pathFlag))
+	cmd.Flags().StringVar(&mode, modeFlag, engine.SyncMode, "How the applications are synchronised, sync applies the resources, monitor only compares them with the cluster and records the drift")
 
-	cmd.Flags().StringVar(&parserName, parserFlag, "kustomize", "Which parser to use kustomize, or manifest, manifest will parse non-Kustomize configurations")
+	cmd.Flags().StringVar(&cloneDir, cloneDirFlag, "", "Directory to keep the clones of the repositories in, e.g. on a volume, so that restarts only fetch the changes, by default repositories are cloned to a temporary directory")
 
-	cmd.Flags().DurationVar(&cfg.Resync, resyncFlag, time.Minute*5, "Resync frequency")
-	cmd.Flags().BoolVar(&cfg.Prune, pruneFlag, false, "Enables resource pruning - i.e. resources not in the set will be removed")
+	cmd.Flags().StringVar(&webhookSecretFile, webhookSecretFileFlag, "", "File containing the secret that webhooks are signed with, enables the webhook receivers")
 
 	cmd.Flags().IntVar(&port, portFlag, 8080, "Port number")
 	logIfError(viper.BindPFlag(portFlag, cmd.Flags().Lookup(portFlag)))
 
-	cmd.Flags().BoolVar(&cfg.Namespaced, namespacedFlag, false, "Switches agent into namespaced mode")
+	cmd.Flags().BoolVar(&namespaced, namespacedFlag, false, "Switches agent into namespaced mode")
 
-	cmd.Flags().StringVar(&cfg.Namespace, defaultNamespaceFlag, "",
+	addDefaultNamespaceFlag(&cmd, &defaultNamespace)
+
+	cmd.AddCommand(makePlanCmd())
+	return &cmd
+}
+
+// addApplicationFlags adds the flags that configure an application's
+// repository and synchronisation.
+func addApplicationFlags(cmd *cobra.Command, appCfg *config.Application) {
+	cmd.Flags().StringVar(&appCfg.RepoURL, repoURLFlag, "", "Repository to deploy e.g. https://github.com/example/example.git")
+	cmd.Flags().StringVar(&appCfg.Branch, branchFlag, "", "Branch to checkout e.g. production")
+	cmd.Flags().StringVar(&appCfg.Revision, revisionFlag, "", "Commit SHA, tag or semver constraint e.g. v1.4.x to checkout instead of a branch")
+	cmd.Flags().StringVar(&appCfg.Path, pathFlag, "", "Path within the Repository to deploy e.g. deploy")
+
+	cmd.Flags().StringVar(&appCfg.Parser, parserFlag, config.KustomizeParser, "Which parser to use kustomize, manifest, helm, jsonnet, plugin or auto, manifest will parse non-Kustomize configurations, and auto chooses the parser for the path")
+	cmd.Flags().StringVar(&appCfg.Helm.ReleaseName, helmReleaseNameFlag, "", "The release name to render the Helm chart with, defaults to the application name")
+	cmd.Flags().StringSliceVar(&appCfg.Helm.ValueFiles, helmValuesFlag, nil, "Value files to render the Helm chart with, relative to the chart, can be repeated")
+	cmd.Flags().StringArrayVar(&appCfg.Helm.Values, helmSetFlag, nil, "Values to override when rendering the Helm chart e.g. image.tag=v1.0.0, can be repeated")
+	cmd.Flags().StringVar(&appCfg.Jsonnet.Main, jsonnetMainFlag, "", "The Jsonnet file to evaluate, relative to the path, defaults to the only .jsonnet file, or main.jsonnet")
+	cmd.Flags().StringToStringVar(&appCfg.Jsonnet.ExtVars, jsonnetExtVarFlag, nil, "External variables for the Jsonnet as name=value, can be repeated")
+	cmd.Flags().StringToStringVar(&appCfg.Jsonnet.TLAs, jsonnetTLAFlag, nil, "Top-level arguments for the Jsonnet as name=value, can be repeated")
+	cmd.Flags().StringSliceVar(&appCfg.Jsonnet.LibPaths, jsonnetLibFlag, nil, "Library paths to search for Jsonnet imports, relative to the path, can be repeated")
+
+	cmd.Flags().StringArrayVar(&appCfg.Plugin.Command, pluginCommandFlag, nil, "The command for the plugin parser, run in the path, repeat for each argument e.g. --plugin-command ./generate.sh --plugin-command production")
+	cmd.Flags().StringToStringVar(&appCfg.Plugin.Env, pluginEnvFlag, nil, "Environment variables for the plugin command as name=value, can be repeated")
+	cmd.Flags().DurationVar(&appCfg.Plugin.Timeout.Duration, pluginTimeoutFlag, plugin.DefaultTimeout, "How long the plugin command can run")
+	cmd.Flags().Int64Var(&appCfg.Plugin.MaxOutputBytes, pluginMaxOutputFlag, plugin.DefaultMaxOutput, "The number of bytes the plugin command can output")
+	cmd.Flags().StringVar(&appCfg.Transform.Namespace, transformNamespaceFlag, "", "Replaces the namespace of the namespaced resources")
+	cmd.Flags().StringToStringVar(&appCfg.Transform.Labels, transformLabelFlag, nil, "Labels to add to the resources as name=value, can be repeated")
+	cmd.Flags().StringToStringVar(&appCfg.Transform.Annotations, transformAnnotationFlag, nil, "Annotations to add to the resources as name=value, can be repeated")
+	cmd.Flags().Var((*imagesValue)(&appCfg.Transform.Images), transformImageFlag, "Overrides the images of containers as name=reference e.g. nginx=registry.example.com/nginx:1.25, can be repeated")
+	cmd.Flags().StringArrayVar(&appCfg.Transform.StripFields, transformStripFieldFlag, nil, "JSON pointer to a field to remove from the resources e.g. /spec/replicas, can be repeated")
+
+	cmd.Flags().IntVar(&appCfg.Depth, depthFlag, 0, "Limits the number of commits that are fetched, by default the full history is fetched")
+	cmd.Flags().BoolVar(&appCfg.SingleBranch, singleBranchFlag, false, "Fetches only the branch, instead of all branches")
+	cmd.Flags().BoolVar(&appCfg.Sparse, sparseFlag, false, "Checks out only the path, and the directories that Kustomizations in the path reference")
+
+	cmd.Flags().BoolVar(&appCfg.Prune, pruneFlag, false, "Enables resource pruning - i.e. resources not in the set will be removed")
+
+	cmd.Flags().StringVar(&appCfg.AuthToken, authTokenFlag, "", "Authentication token to use for private repositories, prefer the other token flags, as this is visible in the process arguments")
+	cmd.Flags().StringVar(&appCfg.AuthTokenFile, authTokenFileFlag, "", "File containing the authentication token, the file is read again when it changes")
+	cmd.Flags().StringVar(&appCfg.AuthTokenEnv, authTokenEnvFlag, "", "Environment variable containing the authentication token")
+	cmd.Flags().StringVar(&appCfg.AuthTokenSecret, authTokenSecretFlag, "", "Secret containing the authentication token as [namespace/]name, the Secret is watched for changes")
+	cmd.Flags().StringVar(&appCfg.AuthTokenSecretKey, authTokenSecretKeyFlag, credentials.DefaultSecretKey, "The key in the --auth-token-secret Secret that contains the token")
+	cmd.Flags().StringVar(&appCfg.Username, authUsernameFlag, engine.DefaultUsername, "Username to authenticate with the token")
+
+	cmd.Flags().StringVar(&appCfg.SSHPrivateKeyFile, sshPrivateKeyFileFlag, "", "SSH private key to authenticate with for SSH repository URLs")
+	cmd.Flags().StringVar(&appCfg.SSHPrivateKeyPassphraseFile, sshPrivateKeyPassphraseFileFlag, "", "File containing the passphrase for an encrypted SSH private key")
+	cmd.Flags().StringVar(&appCfg.SSHKnownHostsFile, sshKnownHostsFileFlag, "", "SSH known_hosts file to check the host key against, defaults to $SSH_KNOWN_HOSTS or ~/.ssh/known_hosts")
+	cmd.Flags().BoolVar(&appCfg.SSHInsecureIgnoreHostKey, sshInsecureIgnoreHostKeyFlag, false, "Disables checking the SSH host key, this is insecure")
+
+	cmd.Flags().StringVar(&appCfg.VerifyKeyring, verifyKeyringFlag, "", "File of armored OpenPGP public keys, only commits signed by these keys are synchronised")
+	cmd.Flags().StringVar(&appCfg.SOPSAgeKeyFile, sopsAgeKeyFileFlag, "", "File of age identities to decrypt files encrypted by SOPS with, applies to all applications that don't configure their own keys")
+	cmd.Flags().StringVar(&appCfg.SOPSGnuPGHome, sopsGnuPGHomeFlag, "", "GnuPG home directory with the PGP private keys to decrypt files encrypted by SOPS with, applies to all applications that don't configure their own keys")
+	cmd.Flags().StringVar(&appCfg.VerifyAllowedSigners, verifyAllowedSignersFlag, "", "File of SSH public keys in the authorized_keys or allowed_signers format, only commits signed by these keys are synchronised")
+}
+
+// imagesValue is a flag of image overrides, each value is parsed as
+// name=reference.
+type imagesValue []transform.Image
+
+func (v *imagesValue) String() string {
+	s := []string{}
+	for _, i := range *v {
+		s = append(s, i.Name)
+	}
+	return strings.Join(s, ",")
+}
+
+func (v *imagesValue) Set(s string) error {
+	i, err := transform.ParseImage(s)
+	if err != nil {
+		return err
+	}
+	*v = append(*v, i)
+	return nil
+}
+
+func (v *imagesValue) Type() string {
+	return "stringArray"
+}
+
+func addDefaultNamespaceFlag(cmd *cobra.Command, defaultNamespace *string) {
+	cmd.Flags().StringVar(defaultNamespace, defaultNamespaceFlag, "",
 		"The namespace that should be used if resource namespace is not specified."+
 			"By default resources are installed into the same namespace where peanut-engine is installed.")
+}
 
-	cmd.Flags().StringVar(&gitCfg.AuthToken, authTokenFlag, "", "Authentication token to use for private repositories")
-	return &cmd
+// loadApplications returns the applications from the configuration file if
+// provided, otherwise a single application configured from the flags.
+//
+// When watching for PeanutApplications, the flags are optional.
+func loadApplications(configFile string, flagApp config.Application, watching bool) ([]config.Application, error) {
+	if configFile != "" {
+		cfg, err := config.Load(configFile)
+		if err != nil {
+			return nil, err
+		}
+		return cfg.Applications, nil
+	}
+	if flagApp.RepoURL == "" && flagApp.Branch == "" && flagApp.Revision == "" && flagApp.Path == "" && watching {
+		return nil, nil
+	}
+	if flagApp.RepoURL == "" || (flagApp.Branch == "" && flagApp.Revision == "") || flagApp.Path == "" {
+		return nil, errors.New(`either --config, --watch-applications or all of --repo-url, --branch or --revision, and --path must be provided`)
+	}
+	flagApp.Name = defaultApplicationName
+	if err := flagApp.Validate(); err != nil {
+		return nil, err
+	}
+	return []config.Application{flagApp}, nil
+}
+
+// applicationOptions are the settings that are shared by all applications.
+type applicationOptions struct {
+	defaultNamespace string
+	metrics          *metrics.PrometheusMetrics
+	historyDepth     int
+	historyStore     recent.Store
+	kubeClient       kubernetes.Interface
+	// cloneDir is optional, and if provided, the repositories are cloned to
+	// it and kept when the application stops.
+	cloneDir string
+	// The keys for decrypting SOPS files, for applications that don't
+	// configure their own.
+	sopsAgeKeyFile string
+	sopsGnuPGHome  string
+	// mode is the engine mode that all applications are synchronised in.
+	mode string
+}
+
+// makeHistoryStore returns the store for the synchronisation history.
+//
+// The configmap store keeps the history in ConfigMaps in the provided
+// namespace.
+func makeHistoryStore(kind, dir string, client kubernetes.Interface, namespace string) (recent.Store, error) {
+	switch kind {
+	case memoryHistoryStore:
+		return recent.NewMemoryStore(), nil
+	case fileHistoryStore:
+		if dir == "" {
+			return nil, fmt.Errorf("--%s must be provided with the file history store", historyDirFlag)
+		}
+		return recent.NewFileStore(dir)
+	case configMapHistoryStore:
+		return recent.NewConfigMapStore(client, namespace), nil
+	}
+	return nil, fmt.Errorf("unknown history store %q", kind)
+}
+
+// makeApplication clones the application's repository and returns an
+// Application ready to be synchronised, the returned function removes the
+// clone, unless it's in the clone directory.
+func makeApplication(cfg config.Application, opts applicationOptions) (_ *engine.Application, _ func(), err error) {
+	if cfg.SOPSAgeKeyFile == "" && cfg.SOPSGnuPGHome == "" {
+		cfg.SOPSAgeKeyFile, cfg.SOPSGnuPGHome = opts.sopsAgeKeyFile, opts.sopsGnuPGHome
+	}
+	p, err := cfg.NewParser(opts.defaultNamespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	gitConfig := cfg.GitConfig()
+	stopCredentials := make(chan struct{})
+	// The credentials are stopped by the cleanup, unless the application
+	// can't be created.
+	defer func() {
+		if err != nil {
+			close(stopCredentials)
+		}
+	}()
+	if cfg.AuthTokenSecret != "" {
+		ns, name, err := cfg.SecretRef(opts.defaultNamespace)
+		if err != nil {
+			return nil, nil, err
+		}
+		gitConfig.Credentials = credentials.NewSecret(opts.kubeClient, ns, name, cfg.AuthTokenSecretKey, stopCredentials)
+	}
+	verifier, err := cfg.Verifier()
+	if err != nil {
+		return nil, nil, err
+	}
+	transformers, err := cfg.Transformers()
+	if err != nil {
+		return nil, nil, err
+	}
+	windows, err := cfg.Windows()
+	if err != nil {
+		return nil, nil, err
+	}
+	peanutRepo := engine.NewRepository(gitConfig, p, transformers...)
+	dir, removeDir, err := makeCloneDir(cfg.Name, opts.cloneDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("Cloning %s to %s", cfg.Name, dir)
+	if err := peanutRepo.OpenOrClone(dir); err != nil {
+		removeDir()
+		return nil, nil, fmt.Errorf("failed to clone repository for %s: %w", cfg.Name, err)
+	}
+	syncs, err := recent.LoadRecentSynchronisations(cfg.Name, ring.New(opts.historyDepth), opts.historyStore)
+	if err != nil {
+		removeDir()
+		return nil, nil, fmt.Errorf("failed to load the history for %s: %w", cfg.Name, err)
+	}
+	cleanup := func() {
+		close(stopCredentials)
+		removeDir()
+	}
+	peanutCfg := cfg.PeanutConfig(opts.defaultNamespace)
+	peanutCfg.Mode = opts.mode
+	peanutCfg.SyncWindows = windows
+	return &engine.Application{
+		Name:             cfg.Name,
+		Git:              gitConfig,
+		Config:           peanutCfg,
+		Repository:       peanutRepo,
+		Metrics:          opts.metrics.ForApplication(cfg.Name),
+		Synchronisations: syncs,
+		Resync:           make(chan bool, 1),
+		Verifier:         verifier,
+		StateStore:       opts.historyStore,
+	}, cleanup, nil
+}
+
+// makeCloneDir returns the directory to clone the application to, and a
+// function that removes it if it's a temporary directory.
+func makeCloneDir(name, cloneDir string) (string, func(), error) {
+	if cloneDir == "" {
+		dir, err := os.MkdirTemp("", "peanut")
+		if err != nil {
+			return "", nil, err
+		}
+		return dir, func() { os.RemoveAll(dir) }, nil
+	}
+	return filepath.Join(cloneDir, url.PathEscape(name)), func() {}, nil
 }
 
 func initConfig() {
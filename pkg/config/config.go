@@ -0,0 +1,574 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/credentials"
+	"github.com/bigkevmcd/peanut-engine/pkg/engine"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/auto"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/composite"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/helm"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/jsonnet"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/kustomize"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/manifest"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/plugin"
+	"github.com/bigkevmcd/peanut-engine/pkg/signature"
+	"github.com/bigkevmcd/peanut-engine/pkg/sops"
+	"github.com/bigkevmcd/peanut-engine/pkg/transform"
+	"github.com/bigkevmcd/peanut-engine/pkg/window"
+)
+
+const (
+	// KustomizeParser is the name of the parser for Kustomize definitions.
+	KustomizeParser = "kustomize"
+	// ManifestParser is the name of the parser for plain YAML manifests.
+	ManifestParser = "manifest"
+	// HelmParser is the name of the parser for Helm charts.
+	HelmParser = "helm"
+	// JsonnetParser is the name of the parser for Jsonnet.
+	JsonnetParser = "jsonnet"
+	// PluginParser is the name of the parser that runs a command to generate
+	// the resources.
+	PluginParser = "plugin"
+	// AutoParser is the name of the parser that detects which parser to use
+	// for the path.
+	AutoParser = "auto"
+
+	// DefaultResync is the resync frequency for applications that don't
+	// specify one.
+	DefaultResync = time.Minute * 5
+)
+
+// Config is the configuration for synchronising several applications from a
+// single process.
+type Config struct {
+	Applications []Application `json:"applications"`
+}
+
+// Application is the configuration for a single synchronised application.
+type Application struct {
+	Name    string `json:"name"`
+	RepoURL string `json:"repoURL"`
+	Branch  string `json:"branch,omitempty"`
+	// Revision is a commit SHA, tag or semver constraint to deploy instead of
+	// a branch.
+	Revision  string          `json:"revision,omitempty"`
+	Path      string          `json:"path,omitempty"`
+	Parser    string          `json:"parser,omitempty"`
+	Prune     bool            `json:"prune,omitempty"`
+	Namespace string          `json:"namespace,omitempty"`
+	Resync    metav1.Duration `json:"resync,omitempty"`
+	// HealthTimeout is how long to wait for the resources to become healthy
+	// after synchronising, if it's zero, the health is assessed once.
+	HealthTimeout metav1.Duration `json:"healthTimeout,omitempty"`
+	// AutoRollback applies the resources from the last healthy commit if the
+	// resources from a commit are degraded.
+	AutoRollback bool `json:"autoRollback,omitempty"`
+	// SyncWindows restrict when the resources are applied, and
+	// DetectDriftOutsideWindows compares the resources with the cluster when
+	// they can't be applied.
+	SyncWindows               []SyncWindow `json:"syncWindows,omitempty"`
+	DetectDriftOutsideWindows bool         `json:"detectDriftOutsideWindows,omitempty"`
+	// Helm configures the rendering of the chart for the helm parser.
+	Helm HelmOptions `json:"helm,omitempty"`
+	// Jsonnet configures the evaluation for the jsonnet parser.
+	Jsonnet JsonnetOptions `json:"jsonnet,omitempty"`
+	// Plugin configures the command for the plugin parser.
+	Plugin PluginOptions `json:"plugin,omitempty"`
+	// Transform modifies the parsed resources before they are synchronised.
+	Transform TransformOptions `json:"transform,omitempty"`
+	// Sources combine several paths, each with its own parser, into the
+	// application, these are used instead of the path.
+	Sources []Source `json:"sources,omitempty"`
+
+	// Depth limits the number of commits that are fetched.
+	Depth        int  `json:"depth,omitempty"`
+	SingleBranch bool `json:"singleBranch,omitempty"`
+	// Sparse checks out only the path, and the directories that
+	// Kustomizations in the path reference.
+	Sparse bool `json:"sparse,omitempty"`
+
+	// Username is the username for authenticating with the token, this
+	// defaults to engine.DefaultUsername.
+	Username string `json:"username,omitempty"`
+	// Only one of the token sources can be configured.
+	AuthToken     string `json:"authToken,omitempty"`
+	AuthTokenFile string `json:"authTokenFile,omitempty"`
+	AuthTokenEnv  string `json:"authTokenEnv,omitempty"`
+	// AuthTokenSecret is the Secret that contains the token, as
+	// "[namespace/]name", the Secret is in the default namespace if no
+	// namespace is provided.
+	AuthTokenSecret    string `json:"authTokenSecret,omitempty"`
+	AuthTokenSecretKey string `json:"authTokenSecretKey,omitempty"`
+
+	SSHPrivateKeyFile           string `json:"sshPrivateKeyFile,omitempty"`
+	SSHPrivateKeyPassphraseFile string `json:"sshPrivateKeyPassphraseFile,omitempty"`
+	SSHKnownHostsFile           string `json:"sshKnownHostsFile,omitempty"`
+	SSHInsecureIgnoreHostKey    bool   `json:"sshInsecureIgnoreHostKey,omitempty"`
+
+	// VerifyKeyring is a file of armored OpenPGP public keys, and
+	// VerifyAllowedSigners is a file of SSH public keys, if either is
+	// provided, only commits signed by these keys are synchronised.
+	VerifyKeyring        string `json:"verifyKeyring,omitempty"`
+	VerifyAllowedSigners string `json:"verifyAllowedSigners,omitempty"`
+
+	// SOPSAgeKeyFile is a file of age identities, and SOPSGnuPGHome is a
+	// GnuPG home directory with PGP private keys, if either is provided,
+	// files encrypted by SOPS are decrypted by the kustomize and manifest
+	// parsers.
+	SOPSAgeKeyFile string `json:"sopsAgeKeyFile,omitempty"`
+	SOPSGnuPGHome  string `json:"sopsGnuPGHome,omitempty"`
+
+	// Confined restricts the application to the files of the repository, for
+	// applications that are not trusted, the paths must be within the
+	// repository, and the parsers can't read files outside it. It can't be
+	// configured.
+	Confined bool `json:"-"`
+}
+
+// Source is a path in the repository that is parsed with a parser, and
+// combined with the other sources of the application.
+type Source struct {
+	Path    string         `json:"path"`
+	Parser  string         `json:"parser,omitempty"`
+	Helm    HelmOptions    `json:"helm,omitempty"`
+	Jsonnet JsonnetOptions `json:"jsonnet,omitempty"`
+	Plugin  PluginOptions  `json:"plugin,omitempty"`
+}
+
+// HelmOptions configure how the helm parser renders the chart.
+type HelmOptions struct {
+	// ReleaseName defaults to the name of the application.
+	ReleaseName string `json:"releaseName,omitempty"`
+	// ValueFiles are relative to the chart.
+	ValueFiles []string `json:"valueFiles,omitempty"`
+	// Values override the value files, in the same format as helm's --set
+	// e.g. image.tag=v1.0.0
+	Values []string `json:"values,omitempty"`
+}
+
+// JsonnetOptions configure how the jsonnet parser evaluates the Jsonnet.
+type JsonnetOptions struct {
+	// Main is the file to evaluate, relative to the path, this defaults to
+	// the only .jsonnet file, or main.jsonnet.
+	Main     string            `json:"main,omitempty"`
+	ExtVars  map[string]string `json:"extVars,omitempty"`
+	TLAs     map[string]string `json:"tlas,omitempty"`
+	LibPaths []string          `json:"libPaths,omitempty"`
+}
+
+// PluginOptions configure the command that the plugin parser runs.
+type PluginOptions struct {
+	// Command is the command and its arguments, it's run in the path, and
+	// must output YAML.
+	Command []string `json:"command,omitempty"`
+	// Env is added to the environment of the command, which only gets PATH
+	// and HOME from the environment of peanut-engine.
+	Env map[string]string `json:"env,omitempty"`
+	// Timeout defaults to a minute.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// MaxOutputBytes defaults to 10MiB.
+	MaxOutputBytes int64 `json:"maxOutputBytes,omitempty"`
+}
+
+// TransformOptions configure the changes to the parsed resources, these are
+// applied in the order of the fields.
+type TransformOptions struct {
+	// Namespace replaces the namespace of namespaced resources.
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Images      []transform.Image `json:"images,omitempty"`
+	Patches     []Patch           `json:"patches,omitempty"`
+	// StripFields are JSON pointers to fields that are removed e.g.
+	// /spec/replicas
+	StripFields []string `json:"stripFields,omitempty"`
+}
+
+// Patch is a JSON patch that is applied to the resources that the target
+// selects.
+type Patch struct {
+	Target transform.Target `json:"target,omitempty"`
+	// Patch is the JSON patch operations, as JSON or YAML.
+	Patch string `json:"patch"`
+}
+
+// SyncWindow is a period that starts on a cron schedule, when resources are
+// allowed, or denied, to be applied.
+type SyncWindow struct {
+	// Kind is allow or deny.
+	Kind string `json:"kind"`
+	// Schedule is a cron expression for the start of the window e.g.
+	// "0 9 * * mon-fri"
+	Schedule string          `json:"schedule"`
+	Duration metav1.Duration `json:"duration"`
+	// TimeZone is the IANA time zone of the schedule e.g. Europe/London, this
+	// defaults to UTC.
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Load reads and parses the configuration from a file.
+func Load(filename string) (*Config, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration: %w", err)
+	}
+	return Parse(b)
+}
+
+// Parse parses the configuration, applying defaults and validating each of the
+// applications.
+func Parse(b []byte) (*Config, error) {
+	var cfg Config
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+	if len(cfg.Applications) == 0 {
+		return nil, fmt.Errorf("no applications configured")
+	}
+	names := map[string]bool{}
+	for i := range cfg.Applications {
+		app := &cfg.Applications[i]
+		if app.Resync.Duration == 0 {
+			app.Resync.Duration = DefaultResync
+		}
+		if err := app.Validate(); err != nil {
+			return nil, err
+		}
+		if names[app.Name] {
+			return nil, fmt.Errorf("duplicate application name %q", app.Name)
+		}
+		names[app.Name] = true
+	}
+	return &cfg, nil
+}
+
+// Validate checks that the application has the required fields.
+func (a Application) Validate() error {
+	if a.Name == "" {
+		return fmt.Errorf("application has no name")
+	}
+	if a.RepoURL == "" {
+		return fmt.Errorf("application %q has no repoURL", a.Name)
+	}
+	if a.Branch == "" && a.Revision == "" {
+		return fmt.Errorf("application %q has no branch or revision", a.Name)
+	}
+	if a.Branch != "" && a.Revision != "" {
+		return fmt.Errorf("application %q has both a branch and a revision", a.Name)
+	}
+	if a.Path == "" && len(a.Sources) == 0 {
+		return fmt.Errorf("application %q has no path", a.Name)
+	}
+	if a.Path != "" && len(a.Sources) > 0 {
+		return fmt.Errorf("application %q has both a path and sources", a.Name)
+	}
+	for i, v := range a.Sources {
+		if v.Path == "" {
+			return fmt.Errorf("application %q source %d has no path", a.Name, i)
+		}
+	}
+	if a.Confined {
+		if err := a.validatePaths(); err != nil {
+			return fmt.Errorf("application %q: %w", a.Name, err)
+		}
+	}
+	if a.Depth < 0 {
+		return fmt.Errorf("application %q has an invalid depth %d", a.Name, a.Depth)
+	}
+	if a.SingleBranch && a.Branch == "" {
+		return fmt.Errorf("application %q is single branch, but has no branch", a.Name)
+	}
+	if a.Resync.Duration <= 0 {
+		return fmt.Errorf("application %q has an invalid resync %s", a.Name, a.Resync.Duration)
+	}
+	if a.HealthTimeout.Duration < 0 {
+		return fmt.Errorf("application %q has an invalid health timeout %s", a.Name, a.HealthTimeout.Duration)
+	}
+	if _, err := a.NewParser(""); err != nil {
+		return fmt.Errorf("application %q: %w", a.Name, err)
+	}
+	if _, err := a.Transformers(); err != nil {
+		return fmt.Errorf("application %q: %w", a.Name, err)
+	}
+	if _, err := a.Windows(); err != nil {
+		return fmt.Errorf("application %q: %w", a.Name, err)
+	}
+	if a.SSHPrivateKeyFile != "" && isHTTPURL(a.RepoURL) {
+		return fmt.Errorf("application %q has an SSH private key, but the repoURL is not an SSH URL", a.Name)
+	}
+	sources := 0
+	for _, v := range []string{a.AuthToken, a.AuthTokenFile, a.AuthTokenEnv, a.AuthTokenSecret} {
+		if v != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("application %q has more than one of authToken, authTokenFile, authTokenEnv and authTokenSecret", a.Name)
+	}
+	if a.AuthTokenSecret != "" {
+		if _, _, err := a.SecretRef(""); err != nil {
+			return fmt.Errorf("application %q: %w", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// SecretRef returns the namespace and name of the Secret that contains the
+// token, using the default namespace if the authTokenSecret doesn't include
+// one.
+func (a Application) SecretRef(defaultNamespace string) (string, string, error) {
+	parts := strings.Split(a.AuthTokenSecret, "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return defaultNamespace, parts[0], nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return parts[0], parts[1], nil
+	}
+	return "", "", fmt.Errorf("invalid authTokenSecret %q, must be [namespace/]name", a.AuthTokenSecret)
+}
+
+// validatePaths returns an error if any of the paths in the repository, or the
+// paths of the sources, are not within the repository.
+func (a Application) validatePaths() error {
+	paths := append(append([]string{a.Path, a.Jsonnet.Main}, a.Helm.ValueFiles...), a.Jsonnet.LibPaths...)
+	for _, v := range a.Sources {
+		paths = append(append(append(paths, v.Path, v.Jsonnet.Main), v.Helm.ValueFiles...), v.Jsonnet.LibPaths...)
+	}
+	for _, v := range paths {
+		if v != "" && !filepath.IsLocal(v) {
+			return fmt.Errorf("invalid path %q, must be within the repository", v)
+		}
+	}
+	return nil
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// NewParser creates the ManifestParser that is configured for the
+// application, this defaults to the Kustomize parser.
+//
+// Helm charts are rendered in the application's namespace, or the default
+// namespace, if no namespace is configured.
+//
+// Applications with sources are parsed with a parser that combines the
+// parsers of the sources.
+func (a Application) NewParser(defaultNamespace string) (parser.ManifestParser, error) {
+	if len(a.Sources) > 0 {
+		return a.sourcesParser(defaultNamespace)
+	}
+	parsers := a.parsers(defaultNamespace)
+	switch a.Parser {
+	case "":
+		return parsers[KustomizeParser], nil
+	case AutoParser:
+		return auto.New(parsers), nil
+	case PluginParser:
+		if len(a.Plugin.Command) == 0 {
+			return nil, fmt.Errorf("the plugin parser has no command")
+		}
+	}
+	if p, ok := parsers[a.Parser]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown parser %q", a.Parser)
+}
+
+func (a Application) sourcesParser(defaultNamespace string) (parser.ManifestParser, error) {
+	sources := []composite.Source{}
+	for _, v := range a.Sources {
+		src := a
+		src.Path, src.Parser, src.Helm, src.Jsonnet, src.Plugin, src.Sources = v.Path, v.Parser, v.Helm, v.Jsonnet, v.Plugin, nil
+		p, err := src.NewParser(defaultNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", v.Path, err)
+		}
+		sources = append(sources, composite.Source{Path: v.Path, Parser: p})
+	}
+	return composite.New(sources), nil
+}
+
+// parsers returns the parsers that can be configured, by name.
+func (a Application) parsers(defaultNamespace string) map[string]parser.ManifestParser {
+	releaseName := a.Helm.ReleaseName
+	if releaseName == "" {
+		releaseName = a.Name
+	}
+	namespace := a.PeanutConfig(defaultNamespace).Namespace
+	var kustomizeParser, manifestParser parser.ManifestParser = kustomize.New(), manifest.New()
+	if a.SOPSAgeKeyFile != "" || a.SOPSGnuPGHome != "" {
+		decryptor := sops.New(sops.Options{AgeKeyFile: a.SOPSAgeKeyFile, GnuPGHome: a.SOPSGnuPGHome})
+		kustomizeParser, manifestParser = kustomize.NewWithDecryptor(decryptor), manifest.NewWithDecryptor(decryptor)
+	}
+	return map[string]parser.ManifestParser{
+		KustomizeParser: kustomizeParser,
+		ManifestParser:  manifestParser,
+		HelmParser: helm.New(helm.Options{
+			ReleaseName: releaseName,
+			Namespace:   namespace,
+			ValueFiles:  a.Helm.ValueFiles,
+			Values:      a.Helm.Values,
+		}),
+		JsonnetParser: jsonnet.New(jsonnet.Options{
+			Main:     a.Jsonnet.Main,
+			ExtVars:  a.Jsonnet.ExtVars,
+			TLAs:     a.Jsonnet.TLAs,
+			LibPaths: a.Jsonnet.LibPaths,
+			Confined: a.Confined,
+		}),
+		PluginParser: plugin.New(plugin.Options{
+			Command:   a.Plugin.Command,
+			Env:       a.Plugin.Env,
+			Timeout:   a.Plugin.Timeout.Duration,
+			MaxOutput: a.Plugin.MaxOutputBytes,
+			AppName:   a.Name,
+			RepoURL:   a.RepoURL,
+			Path:      a.Path,
+			Namespace: namespace,
+		}),
+	}
+}
+
+// Transformers returns the transformers for the parsed resources, in the
+// order that they are applied.
+func (a Application) Transformers() ([]transform.Transformer, error) {
+	opts := a.Transform
+	transformers := []transform.Transformer{}
+	if opts.Namespace != "" {
+		transformers = append(transformers, transform.Namespace(opts.Namespace))
+	}
+	if len(opts.Labels) > 0 {
+		transformers = append(transformers, transform.Labels(opts.Labels))
+	}
+	if len(opts.Annotations) > 0 {
+		transformers = append(transformers, transform.Annotations(opts.Annotations))
+	}
+	if len(opts.Images) > 0 {
+		for _, v := range opts.Images {
+			if v.Name == "" {
+				return nil, fmt.Errorf("image override has no name")
+			}
+		}
+		transformers = append(transformers, transform.Images(opts.Images))
+	}
+	for i, v := range opts.Patches {
+		p, err := transform.Patch(v.Target, v.Patch)
+		if err != nil {
+			return nil, fmt.Errorf("patch %d: %w", i, err)
+		}
+		transformers = append(transformers, p)
+	}
+	if len(opts.StripFields) > 0 {
+		for _, v := range opts.StripFields {
+			if !strings.HasPrefix(v, "/") || v == "/" {
+				return nil, fmt.Errorf("invalid field %q to strip, must be a JSON pointer e.g. /spec/replicas", v)
+			}
+		}
+		transformers = append(transformers, transform.StripFields(opts.StripFields))
+	}
+	return transformers, nil
+}
+
+// Windows returns the parsed sync windows.
+func (a Application) Windows() (window.Windows, error) {
+	windows := window.Windows{}
+	for i, v := range a.SyncWindows {
+		w, err := window.New(window.Kind(v.Kind), v.Schedule, v.Duration.Duration, v.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("sync window %d: %w", i, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// GitConfig returns the configuration for the application's repository.
+//
+// The credentials for an authTokenSecret need a Kubernetes client, so they are
+// not configured here.
+func (a Application) GitConfig() engine.GitConfig {
+	return engine.GitConfig{
+		RepoURL:      a.RepoURL,
+		Branch:       a.Branch,
+		Revision:     a.Revision,
+		Path:         a.Path,
+		Sources:      a.sourcePaths(),
+		Depth:        a.Depth,
+		SingleBranch: a.SingleBranch,
+		Sparse:       a.Sparse,
+		Confined:     a.Confined,
+		Username:     a.Username,
+		Credentials:  a.credentials(),
+		SSH: engine.SSHConfig{
+			PrivateKeyFile:        a.SSHPrivateKeyFile,
+			PassphraseFile:        a.SSHPrivateKeyPassphraseFile,
+			KnownHostsFile:        a.SSHKnownHostsFile,
+			InsecureIgnoreHostKey: a.SSHInsecureIgnoreHostKey,
+		},
+	}
+}
+
+func (a Application) sourcePaths() []string {
+	if len(a.Sources) == 0 {
+		return nil
+	}
+	paths := []string{}
+	for _, v := range a.Sources {
+		paths = append(paths, v.Path)
+	}
+	return paths
+}
+
+func (a Application) credentials() credentials.Provider {
+	switch {
+	case a.AuthToken != "":
+		return credentials.Static(a.AuthToken)
+	case a.AuthTokenFile != "":
+		return credentials.NewFile(a.AuthTokenFile)
+	case a.AuthTokenEnv != "":
+		return credentials.Env(a.AuthTokenEnv)
+	}
+	return nil
+}
+
+// PeanutConfig returns the configuration for synchronising the application,
+// if no namespace is configured, the default namespace is used.
+func (a Application) PeanutConfig(defaultNamespace string) engine.PeanutConfig {
+	ns := a.Namespace
+	if ns == "" {
+		ns = defaultNamespace
+	}
+	return engine.PeanutConfig{
+		Prune:     a.Prune,
+		Namespace: ns,
+		Resync:    a.Resync.Duration,
+
+		HealthTimeout: a.HealthTimeout.Duration,
+		AutoRollback:  a.AutoRollback,
+
+		DetectDriftOutsideWindows: a.DetectDriftOutsideWindows,
+	}
+}
+
+// Verifier returns the verifier for the signatures of commits, or nil if
+// signatures are not verified.
+func (a Application) Verifier() (engine.CommitVerifier, error) {
+	if a.VerifyKeyring == "" && a.VerifyAllowedSigners == "" {
+		return nil, nil
+	}
+	v, err := signature.New(a.VerifyKeyring, a.VerifyAllowedSigners)
+	if err != nil {
+		return nil, fmt.Errorf("application %q: %w", a.Name, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,474 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/engine"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/auto"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/composite"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/helm"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/jsonnet"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/manifest"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/plugin"
+	"github.com/bigkevmcd/peanut-engine/pkg/sops"
+	"github.com/bigkevmcd/peanut-engine/pkg/window"
+)
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load("testdata/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Config{
+		Applications: []Application{
+			{
+				Name:      "taxi",
+				RepoURL:   "https://github.com/bigkevmcd/taxi.git",
+				Branch:    "main",
+				Path:      "deploy",
+				Prune:     true,
+				Namespace: "taxi-dev",
+				Resync:    metav1.Duration{Duration: time.Minute},
+			},
+			{
+				Name:    "peanut",
+				RepoURL: "https://github.com/bigkevmcd/peanut-engine.git",
+				Branch:  "main",
+				Path:    "pkg/testdata",
+				Parser:  "manifest",
+				Resync:  metav1.Duration{Duration: DefaultResync},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Fatalf("loaded configuration:\n%s", diff)
+	}
+}
+
+func TestLoadWithMissingFile(t *testing.T) {
+	_, err := Load("testdata/unknown.yaml")
+
+	assertErrorMatch(t, "failed to read configuration", err)
+}
+
+func TestParseErrors(t *testing.T) {
+	parseTests := []struct {
+		name string
+		cfg  string
+		want string
+	}{
+		{"no applications", `applications: []`, "no applications configured"},
+		{"unknown field", `applicatons: []`, "failed to parse configuration"},
+		{"missing name", `applications: [{repoURL: https://example.com, branch: main, path: deploy}]`, "application has no name"},
+		{"missing repoURL", `applications: [{name: test, branch: main, path: deploy}]`, `application "test" has no repoURL`},
+		{"missing branch", `applications: [{name: test, repoURL: https://example.com, path: deploy}]`, `application "test" has no branch or revision`},
+		{"branch and revision", `applications: [{name: test, repoURL: https://example.com, branch: main, revision: v1.4.x, path: deploy}]`, `application "test" has both a branch and a revision`},
+		{"missing path", `applications: [{name: test, repoURL: https://example.com, branch: main}]`, `application "test" has no path`},
+		{"path and sources", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, sources: [{path: crds}]}]`, `application "test" has both a path and sources`},
+		{"source without path", `applications: [{name: test, repoURL: https://example.com, branch: main, sources: [{parser: manifest}]}]`, `application "test" source 0 has no path`},
+		{"unknown source parser", `applications: [{name: test, repoURL: https://example.com, branch: main, sources: [{path: crds, parser: unknown}]}]`, `application "test": source crds: unknown parser "unknown"`},
+		{"invalid patch", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, transform: {patches: [{patch: "{op: remove}"}]}}]`, `application "test": patch 0: failed to parse the patch`},
+		{"invalid strip field", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, transform: {stripFields: [spec.replicas]}}]`, `application "test": invalid field "spec.replicas" to strip`},
+		{"image without name", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, transform: {images: [{newTag: v1}]}}]`, `application "test": image override has no name`},
+		{"plugin without command", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, parser: plugin}]`, `application "test": the plugin parser has no command`},
+		{"unknown parser", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, parser: unknown}]`, `application "test": unknown parser "unknown"`},
+		{"ssh key with https", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, sshPrivateKeyFile: /etc/ssh/id_rsa}]`, `application "test" has an SSH private key, but the repoURL is not an SSH URL`},
+		{"multiple token sources", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, authToken: token, authTokenEnv: GIT_TOKEN}]`, `application "test" has more than one of authToken, authTokenFile, authTokenEnv and authTokenSecret`},
+		{"invalid token secret", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, authTokenSecret: a/b/c}]`, `application "test": invalid authTokenSecret "a/b/c", must be \[namespace/\]name`},
+		{"negative depth", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, depth: -1}]`, `application "test" has an invalid depth -1`},
+		{"negative health timeout", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, healthTimeout: -1m}]`, `application "test" has an invalid health timeout -1m0s`},
+		{"invalid sync window", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy, syncWindows: [{kind: allow, schedule: "0 9 * *", duration: 8h}]}]`, `application "test": sync window 0: invalid schedule "0 9 \* \*", must have 5 fields`},
+		{"single branch without branch", `applications: [{name: test, repoURL: https://example.com, revision: v1.4.x, path: deploy, singleBranch: true}]`, `application "test" is single branch, but has no branch`},
+		{"duplicate names", `applications: [{name: test, repoURL: https://example.com, branch: main, path: deploy}, {name: test, repoURL: https://example.com, branch: main, path: deploy}]`, `duplicate application name "test"`},
+	}
+
+	for _, tt := range parseTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.cfg))
+			assertErrorMatch(t, tt.want, err)
+		})
+	}
+}
+
+func TestNewParser(t *testing.T) {
+	p, err := Application{Parser: ManifestParser}.NewParser("default-ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := p.(*manifest.ManifestivalParser); !ok {
+		t.Fatalf("NewParser() got %T, want *manifest.ManifestivalParser", p)
+	}
+}
+
+func TestNewParserWithDecryption(t *testing.T) {
+	app := Application{Parser: ManifestParser, SOPSAgeKeyFile: "/etc/peanut/age.txt"}
+
+	p, err := app.NewParser("default-ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := manifest.NewWithDecryptor(sops.New(sops.Options{AgeKeyFile: "/etc/peanut/age.txt"}))
+	if diff := cmp.Diff(want, p, cmp.AllowUnexported(manifest.ManifestivalParser{}, sops.Decryptor{}, sops.Options{})); diff != "" {
+		t.Fatalf("NewParser() failed:\n%s", diff)
+	}
+}
+
+func TestNewParserWithHelm(t *testing.T) {
+	app := Application{
+		Name:   "taxi",
+		Parser: HelmParser,
+		Helm:   HelmOptions{ValueFiles: []string{"values-production.yaml"}, Values: []string{"image.tag=v2.0.0"}},
+	}
+
+	p, err := app.NewParser("default-ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := helm.New(helm.Options{
+		ReleaseName: "taxi",
+		Namespace:   "default-ns",
+		ValueFiles:  []string{"values-production.yaml"},
+		Values:      []string{"image.tag=v2.0.0"},
+	})
+	if diff := cmp.Diff(want, p, cmp.AllowUnexported(helm.HelmParser{})); diff != "" {
+		t.Fatalf("NewParser() failed:\n%s", diff)
+	}
+}
+
+func TestNewParserWithJsonnet(t *testing.T) {
+	app := Application{
+		Parser:  JsonnetParser,
+		Jsonnet: JsonnetOptions{ExtVars: map[string]string{"environment": "production"}, LibPaths: []string{"vendor"}},
+	}
+
+	p, err := app.NewParser("default-ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := jsonnet.New(jsonnet.Options{ExtVars: map[string]string{"environment": "production"}, LibPaths: []string{"vendor"}})
+	if diff := cmp.Diff(want, p, cmp.AllowUnexported(jsonnet.JsonnetParser{})); diff != "" {
+		t.Fatalf("NewParser() failed:\n%s", diff)
+	}
+}
+
+func TestNewParserWithPlugin(t *testing.T) {
+	app := Application{
+		Name:    "taxi",
+		RepoURL: "https://github.com/org/taxi.git",
+		Path:    "deploy",
+		Parser:  PluginParser,
+		Plugin:  PluginOptions{Command: []string{"./generate.sh", "production"}, Env: map[string]string{"CLUSTER": "eu-west-1"}, Timeout: metav1.Duration{Duration: time.Second * 30}},
+	}
+
+	p, err := app.NewParser("default-ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := plugin.New(plugin.Options{
+		Command:   []string{"./generate.sh", "production"},
+		Env:       map[string]string{"CLUSTER": "eu-west-1"},
+		Timeout:   time.Second * 30,
+		AppName:   "taxi",
+		RepoURL:   "https://github.com/org/taxi.git",
+		Path:      "deploy",
+		Namespace: "default-ns",
+	})
+	if diff := cmp.Diff(want, p, cmp.AllowUnexported(plugin.PluginParser{})); diff != "" {
+		t.Fatalf("NewParser() failed:\n%s", diff)
+	}
+}
+
+func TestNewParserWithAuto(t *testing.T) {
+	p, err := Application{Parser: AutoParser}.NewParser("default-ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := p.(*auto.AutoParser); !ok {
+		t.Fatalf("NewParser() got %T, want *auto.AutoParser", p)
+	}
+}
+
+func TestNewParserWithSources(t *testing.T) {
+	app := Application{
+		Name: "taxi",
+		Sources: []Source{
+			{Path: "crds", Parser: ManifestParser},
+			{Path: "chart", Parser: HelmParser, Helm: HelmOptions{Values: []string{"image.tag=v2.0.0"}}},
+		},
+	}
+
+	p, err := app.NewParser("default-ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := composite.New([]composite.Source{
+		{Path: "crds", Parser: manifest.New()},
+		{Path: "chart", Parser: helm.New(helm.Options{ReleaseName: "taxi", Namespace: "default-ns", Values: []string{"image.tag=v2.0.0"}})},
+	})
+	if diff := cmp.Diff(want, p, cmp.AllowUnexported(composite.CompositeParser{}, helm.HelmParser{}), cmp.Comparer(func(a, b *manifest.ManifestivalParser) bool { return true })); diff != "" {
+		t.Fatalf("NewParser() failed:\n%s", diff)
+	}
+}
+
+func TestTransformers(t *testing.T) {
+	cfg, err := Parse([]byte(`
+applications:
+- name: taxi
+  repoURL: https://github.com/org/taxi.git
+  branch: main
+  path: deploy
+  transform:
+    namespace: taxi-production
+    labels:
+      cluster: eu-west-1
+    images:
+    - name: bigkevmcd/taxi
+      newTag: v0.0.4
+    patches:
+    - target:
+        kind: Deployment
+        name: taxi
+      patch: |
+        - op: replace
+          path: /spec/replicas
+          value: 3
+    stripFields:
+    - /spec/template/spec/priorityClassName
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transformers, err := cfg.Applications[0].Transformers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("apps/v1")
+	u.SetKind("Deployment")
+	u.SetName("taxi")
+	u.Object["spec"] = map[string]interface{}{
+		"replicas": int64(1),
+		"template": map[string]interface{}{"spec": map[string]interface{}{"priorityClassName": "high"}},
+	}
+	for _, v := range transformers {
+		if err := v.Transform(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      "taxi",
+			"namespace": "taxi-production",
+			"labels":    map[string]interface{}{"cluster": "eu-west-1"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+			"template": map[string]interface{}{"spec": map[string]interface{}{}},
+		},
+	}
+	if diff := cmp.Diff(want, u.Object); diff != "" {
+		t.Fatalf("transformed resource:\n%s", diff)
+	}
+}
+
+func TestWindows(t *testing.T) {
+	cfg, err := Parse([]byte(`
+applications:
+- name: taxi
+  repoURL: https://github.com/org/taxi.git
+  branch: main
+  path: deploy
+  syncWindows:
+  - kind: allow
+    schedule: "0 9 * * mon-fri"
+    duration: 8h
+    timeZone: Europe/London
+  - kind: deny
+    schedule: "0 0 24 dec *"
+    duration: 48h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	windows, err := cfg.Applications[0].Windows()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(windows); l != 2 {
+		t.Fatalf("got %d windows, want 2", l)
+	}
+	if w := windows[0]; w.Kind != window.Allow || w.Duration != 8*time.Hour || w.Location.String() != "Europe/London" {
+		t.Fatalf("got %s window for %s in %s, want an allow window for 8h in Europe/London", w.Kind, w.Duration, w.Location)
+	}
+	if w := windows[1]; w.Kind != window.Deny || w.Location != time.UTC {
+		t.Fatalf("got %s window in %s, want a deny window in UTC", w.Kind, w.Location)
+	}
+}
+
+func TestPeanutConfig(t *testing.T) {
+	app := Application{Prune: true, Resync: metav1.Duration{Duration: time.Minute}, HealthTimeout: metav1.Duration{Duration: 5 * time.Minute}, AutoRollback: true, DetectDriftOutsideWindows: true}
+
+	cfg := app.PeanutConfig("default-ns")
+
+	want := engine.PeanutConfig{Prune: true, Namespace: "default-ns", Resync: time.Minute, HealthTimeout: 5 * time.Minute, AutoRollback: true, DetectDriftOutsideWindows: true}
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Fatalf("PeanutConfig() failed:\n%s", diff)
+	}
+}
+
+func TestGitConfig(t *testing.T) {
+	app := Application{
+		RepoURL:                     "git@example.com:example/example.git",
+		Branch:                      "main",
+		Path:                        "deploy",
+		SSHPrivateKeyFile:           "/etc/peanut/id_ed25519",
+		SSHPrivateKeyPassphraseFile: "/etc/peanut/passphrase",
+		SSHKnownHostsFile:           "/etc/peanut/known_hosts",
+		Depth:                       1,
+		SingleBranch:                true,
+		Sparse:                      true,
+	}
+
+	cfg := app.GitConfig()
+
+	want := engine.GitConfig{
+		RepoURL:      "git@example.com:example/example.git",
+		Branch:       "main",
+		Path:         "deploy",
+		Depth:        1,
+		SingleBranch: true,
+		Sparse:       true,
+		SSH: engine.SSHConfig{
+			PrivateKeyFile: "/etc/peanut/id_ed25519",
+			PassphraseFile: "/etc/peanut/passphrase",
+			KnownHostsFile: "/etc/peanut/known_hosts",
+		},
+	}
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Fatalf("GitConfig() failed:\n%s", diff)
+	}
+}
+
+func TestGitConfigWithSources(t *testing.T) {
+	app := Application{
+		RepoURL: "https://example.com/example.git",
+		Branch:  "main",
+		Sources: []Source{{Path: "crds"}, {Path: "deploy"}},
+	}
+
+	cfg := app.GitConfig()
+
+	if diff := cmp.Diff([]string{"crds", "deploy"}, cfg.Paths()); diff != "" {
+		t.Fatalf("GitConfig() paths:\n%s", diff)
+	}
+}
+
+func TestGitConfigCredentials(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("file-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PEANUT_TEST_TOKEN", "env-token")
+	credentialsTests := []struct {
+		name string
+		app  Application
+		want string
+	}{
+		{"static token", Application{AuthToken: "static-token"}, "static-token"},
+		{"token file", Application{AuthTokenFile: tokenFile}, "file-token"},
+		{"token environment variable", Application{AuthTokenEnv: "PEANUT_TEST_TOKEN"}, "env-token"},
+	}
+
+	for _, tt := range credentialsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.app.GitConfig()
+
+			token, err := cfg.Credentials.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.want {
+				t.Fatalf("got token %q, want %q", token, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitConfigWithNoCredentials(t *testing.T) {
+	if c := (Application{AuthTokenSecret: "git-auth"}).GitConfig().Credentials; c != nil {
+		t.Fatalf("got credentials %#v, want nil", c)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	v, err := Application{}.Verifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != nil {
+		t.Fatalf("Verifier() got %#v, want nil", v)
+	}
+
+	_, err = Application{Name: "test", VerifyKeyring: "testdata/unknown.asc"}.Verifier()
+	assertErrorMatch(t, `application "test": failed to open keyring`, err)
+}
+
+func TestSecretRef(t *testing.T) {
+	refTests := []struct {
+		secret        string
+		wantNamespace string
+		wantName      string
+	}{
+		{"git-auth", "default-ns", "git-auth"},
+		{"other-ns/git-auth", "other-ns", "git-auth"},
+	}
+
+	for _, tt := range refTests {
+		ns, name, err := Application{AuthTokenSecret: tt.secret}.SecretRef("default-ns")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ns != tt.wantNamespace || name != tt.wantName {
+			t.Errorf("SecretRef(%q) got %s/%s, want %s/%s", tt.secret, ns, name, tt.wantNamespace, tt.wantName)
+		}
+	}
+}
+
+func assertErrorMatch(t *testing.T, s string, e error) {
+	t.Helper()
+	if s == "" && e == nil {
+		return
+	}
+	if s != "" && e == nil {
+		t.Fatalf("wanted error matching %s, got nil", s)
+	}
+	match, err := regexp.MatchString(s, e.Error())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match {
+		t.Fatalf("error did not match, got %s, want %s", e, s)
+	}
+}
@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/argoproj/gitops-engine/pkg/sync/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/config"
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+const (
+	// HealthHealthy indicates that the last synchronisation succeeded, and
+	// the resources are healthy, other health statuses of the resources are
+	// reported as they were assessed.
+	HealthHealthy = "Healthy"
+	// HealthDegraded indicates that the last synchronisation failed, or that
+	// resources failed to synchronise.
+	HealthDegraded = "Degraded"
+	// HealthOutOfSync indicates that the application is monitored, and the
+	// resources differ from the cluster.
+	HealthOutOfSync = "OutOfSync"
+)
+
+// ApplicationGVR is the resource that the controller watches.
+var ApplicationGVR = schema.GroupVersionResource{
+	Group:    "peanut.bigkevmcd.com",
+	Version:  "v1alpha1",
+	Resource: "peanutapplications",
+}
+
+// ApplicationSpec is the desired configuration of a PeanutApplication.
+type ApplicationSpec struct {
+	RepoURL         string                  `json:"repoURL"`
+	Branch          string                  `json:"branch,omitempty"`
+	Revision        string                  `json:"revision,omitempty"`
+	Path            string                  `json:"path,omitempty"`
+	Parser          string                  `json:"parser,omitempty"`
+	Prune           bool                    `json:"prune,omitempty"`
+	TargetNamespace string                  `json:"targetNamespace,omitempty"`
+	Resync          metav1.Duration         `json:"resync,omitempty"`
+	HealthTimeout   metav1.Duration         `json:"healthTimeout,omitempty"`
+	AutoRollback    bool                    `json:"autoRollback,omitempty"`
+	Depth           int                     `json:"depth,omitempty"`
+	SingleBranch    bool                    `json:"singleBranch,omitempty"`
+	Sparse          bool                    `json:"sparse,omitempty"`
+	Helm            config.HelmOptions      `json:"helm,omitempty"`
+	Jsonnet         config.JsonnetOptions   `json:"jsonnet,omitempty"`
+	Transform       config.TransformOptions `json:"transform,omitempty"`
+	Sources         []config.Source         `json:"sources,omitempty"`
+
+	SyncWindows               []config.SyncWindow `json:"syncWindows,omitempty"`
+	DetectDriftOutsideWindows bool                `json:"detectDriftOutsideWindows,omitempty"`
+}
+
+// ApplicationStatus is the observed synchronisation state of a
+// PeanutApplication.
+type ApplicationStatus struct {
+	ObservedGeneration int64            `json:"observedGeneration,omitempty"`
+	SHA                string           `json:"sha,omitempty"`
+	LastSyncTime       *metav1.Time     `json:"lastSyncTime,omitempty"`
+	Error              string           `json:"error,omitempty"`
+	Health             string           `json:"health,omitempty"`
+	Resources          []ResourceStatus `json:"resources,omitempty"`
+}
+
+// ResourceStatus is the result of synchronising a single resource.
+type ResourceStatus struct {
+	Group     string            `json:"group,omitempty"`
+	Kind      string            `json:"kind"`
+	Namespace string            `json:"namespace,omitempty"`
+	Name      string            `json:"name"`
+	Status    common.ResultCode `json:"status"`
+	Message   string            `json:"message,omitempty"`
+}
+
+// applicationName is the name that a PeanutApplication is synchronised as.
+//
+// Namespaces can't contain a ".", so this is unambiguous.
+func applicationName(u *unstructured.Unstructured) string {
+	return u.GetNamespace() + "." + u.GetName()
+}
+
+// applicationConfig converts a PeanutApplication to the configuration for
+// synchronising it.
+//
+// PeanutApplications are confined to the files of their repositories, and
+// deploy to their own namespace, unless the target namespace is one of the
+// targetNamespaces, or these include "*".
+func applicationConfig(u *unstructured.Unstructured, targetNamespaces []string) (config.Application, error) {
+	raw, ok, err := unstructured.NestedMap(u.Object, "spec")
+	if err != nil {
+		return config.Application{}, fmt.Errorf("failed to get the spec: %w", err)
+	}
+	if !ok {
+		return config.Application{}, fmt.Errorf("no spec")
+	}
+	var spec ApplicationSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &spec); err != nil {
+		return config.Application{}, fmt.Errorf("failed to parse the spec: %w", err)
+	}
+	// Anyone who can create PeanutApplications could run commands in the
+	// controller with the plugin parser.
+	parsers := []string{spec.Parser}
+	for _, v := range spec.Sources {
+		parsers = append(parsers, v.Parser)
+	}
+	for _, v := range parsers {
+		if v == config.PluginParser {
+			return config.Application{}, fmt.Errorf("the %s parser can't be used by PeanutApplications", config.PluginParser)
+		}
+	}
+	if spec.TargetNamespace == "" {
+		spec.TargetNamespace = u.GetNamespace()
+	}
+	for _, v := range []string{spec.TargetNamespace, spec.Transform.Namespace} {
+		if v != "" && v != u.GetNamespace() && !allowed(targetNamespaces, v) {
+			return config.Application{}, fmt.Errorf("PeanutApplications in %s can't deploy to namespace %s", u.GetNamespace(), v)
+		}
+	}
+	cfg := config.Application{
+		Name:      applicationName(u),
+		RepoURL:   spec.RepoURL,
+		Branch:    spec.Branch,
+		Revision:  spec.Revision,
+		Path:      spec.Path,
+		Parser:    spec.Parser,
+		Prune:     spec.Prune,
+		Namespace: spec.TargetNamespace,
+		Resync:    spec.Resync,
+		Helm:      spec.Helm,
+		Jsonnet:   spec.Jsonnet,
+		Sources:   spec.Sources,
+		Transform: spec.Transform,
+
+		Depth:        spec.Depth,
+		SingleBranch: spec.SingleBranch,
+		Sparse:       spec.Sparse,
+
+		HealthTimeout: spec.HealthTimeout,
+		AutoRollback:  spec.AutoRollback,
+
+		SyncWindows:               spec.SyncWindows,
+		DetectDriftOutsideWindows: spec.DetectDriftOutsideWindows,
+
+		Confined: true,
+	}
+	if cfg.Resync.Duration == 0 {
+		cfg.Resync.Duration = config.DefaultResync
+	}
+	return cfg, cfg.Validate()
+}
+
+func allowed(namespaces []string, ns string) bool {
+	for _, v := range namespaces {
+		if v == ns || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// syncStatus converts a recorded synchronisation to the status of a
+// PeanutApplication.
+func syncStatus(generation int64, s recent.Synchronisation) ApplicationStatus {
+	status := ApplicationStatus{
+		ObservedGeneration: generation,
+		SHA:                s.SHA,
+		LastSyncTime:       &metav1.Time{Time: s.End},
+		Health:             HealthHealthy,
+	}
+	if s.Health != "" {
+		status.Health = string(s.Health)
+	}
+	if s.Monitored && len(s.Diffs) > 0 {
+		status.Health = HealthOutOfSync
+	}
+	if s.Error != nil {
+		status.Error = s.Error.Error()
+		status.Health = HealthDegraded
+	}
+	for _, v := range s.Results {
+		if v.Status == common.ResultCodeSyncFailed {
+			status.Health = HealthDegraded
+		}
+		status.Resources = append(status.Resources, ResourceStatus{
+			Group:     v.ResourceKey.Group,
+			Kind:      v.ResourceKey.Kind,
+			Namespace: v.ResourceKey.Namespace,
+			Name:      v.ResourceKey.Name,
+			Status:    v.Status,
+			Message:   v.Message,
+		})
+	}
+	return status
+}
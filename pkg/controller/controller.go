@@ -0,0 +1,245 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/config"
+	"github.com/bigkevmcd/peanut-engine/pkg/engine"
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+const (
+	informerResync = time.Minute * 10
+	// workers is the number of PeanutApplications that can be started at
+	// the same time, starting an application can clone a repository.
+	workers = 4
+)
+
+// ApplicationFactory prepares an application for synchronisation, the returned
+// function is called to release any resources when the synchronisation stops.
+type ApplicationFactory func(config.Application) (*engine.Application, func(), error)
+
+// Runner starts the synchronisation of applications.
+//
+// This is implemented by engine.Manager.
+type Runner interface {
+	Start(app *engine.Application, done <-chan struct{}) <-chan struct{}
+}
+
+// Controller watches PeanutApplication resources and starts, reconfigures or
+// stops the synchronisation of each application.
+//
+// Changes are queued, and applied by workers, so that cloning a repository
+// doesn't hold up the informer.
+type Controller struct {
+	client           dynamic.Interface
+	namespace        string
+	targetNamespaces []string
+	runner           Runner
+	factory          ApplicationFactory
+	queue            workqueue.RateLimitingInterface
+
+	mu      sync.Mutex
+	running map[string]*runningApplication
+}
+
+type runningApplication struct {
+	config  config.Application
+	stop    chan struct{}
+	stopped <-chan struct{}
+	cleanup func()
+}
+
+// New creates and returns a new Controller.
+//
+// If namespace is empty, resources are watched in all namespaces.
+//
+// Applications deploy to their own namespace, or to one of the
+// targetNamespaces, "*" allows any namespace.
+func New(client dynamic.Interface, namespace string, targetNamespaces []string, runner Runner, factory ApplicationFactory) *Controller {
+	return &Controller{
+		client:           client,
+		namespace:        namespace,
+		targetNamespaces: targetNamespaces,
+		runner:           runner,
+		factory:          factory,
+		queue:            workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "peanutapplications"),
+		running:          map[string]*runningApplication{},
+	}
+}
+
+// Run watches for changes to PeanutApplications until done is closed, and then
+// stops all the running applications.
+func (c *Controller) Run(done <-chan struct{}) {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.client, informerResync, c.namespace, nil)
+	informer := factory.ForResource(ApplicationGVR).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(_, obj interface{}) {
+			c.enqueue(obj)
+		},
+		DeleteFunc: c.enqueue,
+	})
+	factory.Start(done)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c.processNextItem(informer.GetIndexer()) {
+			}
+		}()
+	}
+	<-done
+	c.queue.ShutDown()
+	wg.Wait()
+	c.stopAll()
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Errorf("Failed to get the key for a PeanutApplication: %s", err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// processNextItem applies the current state of the next queued
+// PeanutApplication, and returns false when the queue is shut down.
+//
+// Applications that fail to start are queued again with a backoff.
+func (c *Controller) processNextItem(indexer cache.Indexer) bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+	key := item.(string)
+	obj, exists, err := indexer.GetByKey(key)
+	if err != nil {
+		log.Errorf("Failed to get PeanutApplication %s: %s", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	if !exists {
+		namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+		c.remove(namespace + "." + name)
+		c.queue.Forget(key)
+		return true
+	}
+	if err := c.apply(obj.(*unstructured.Unstructured)); err != nil {
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// apply starts the application if it's not running, and restarts it if the
+// configuration has changed.
+//
+// An error is returned if the application could not be started, and applying
+// it again could succeed.
+func (c *Controller) apply(u *unstructured.Unstructured) error {
+	name := applicationName(u)
+	logger := log.WithField("application", name)
+	cfg, err := applicationConfig(u, c.targetNamespaces)
+	if err != nil {
+		logger.Errorf("Invalid PeanutApplication: %s", err)
+		c.remove(name)
+		c.updateStatus(u.GetNamespace(), u.GetName(), ApplicationStatus{ObservedGeneration: u.GetGeneration(), Error: err.Error()})
+		return nil
+	}
+
+	c.mu.Lock()
+	current, ok := c.running[name]
+	c.mu.Unlock()
+	if ok && reflect.DeepEqual(current.config, cfg) {
+		return nil
+	}
+	if ok {
+		logger.Info("Configuration changed, restarting synchronisation")
+		c.remove(name)
+	}
+
+	app, cleanup, err := c.factory(cfg)
+	if err != nil {
+		logger.Errorf("Failed to start synchronisation: %s", err)
+		c.updateStatus(u.GetNamespace(), u.GetName(), ApplicationStatus{ObservedGeneration: u.GetGeneration(), Error: err.Error()})
+		return err
+	}
+	namespace, objName, generation := u.GetNamespace(), u.GetName(), u.GetGeneration()
+	app.OnSync = func(s recent.Synchronisation) {
+		c.updateStatus(namespace, objName, syncStatus(generation, s))
+	}
+	stop := make(chan struct{})
+	stopped := c.runner.Start(app, stop)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running[name] = &runningApplication{config: cfg, stop: stop, stopped: stopped, cleanup: cleanup}
+	logger.Info("Started synchronisation")
+	return nil
+}
+
+// remove stops the named application if it's running, and waits for the
+// synchronisation to stop.
+func (c *Controller) remove(name string) {
+	c.mu.Lock()
+	current, ok := c.running[name]
+	delete(c.running, name)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	close(current.stop)
+	<-current.stopped
+	current.cleanup()
+	log.WithField("application", name).Info("Stopped synchronisation")
+}
+
+func (c *Controller) stopAll() {
+	c.mu.Lock()
+	names := []string{}
+	for k := range c.running {
+		names = append(names, k)
+	}
+	c.mu.Unlock()
+	for _, v := range names {
+		c.remove(v)
+	}
+}
+
+// updateStatus replaces the status of the named PeanutApplication.
+func (c *Controller) updateStatus(namespace, name string, status ApplicationStatus) {
+	logger := log.WithField("application", namespace+"."+name)
+	client := c.client.Resource(ApplicationGVR).Namespace(namespace)
+	u, err := client.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		logger.Errorf("Failed to get PeanutApplication to update status: %s", err)
+		return
+	}
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		logger.Errorf("Failed to convert status: %s", err)
+		return
+	}
+	u.Object["status"] = raw
+	if _, err := client.UpdateStatus(context.Background(), u, metav1.UpdateOptions{}); err != nil {
+		logger.Errorf("Failed to update PeanutApplication status: %s", err)
+	}
+}
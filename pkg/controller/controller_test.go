@@ -0,0 +1,451 @@
+package controller
+
+import (
+	"container/ring"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/argoproj/gitops-engine/pkg/sync/common"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/config"
+	"github.com/bigkevmcd/peanut-engine/pkg/diff"
+	"github.com/bigkevmcd/peanut-engine/pkg/engine"
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+var _ Runner = (*engine.Manager)(nil)
+
+func TestControllerStartsApplications(t *testing.T) {
+	c, runner, _ := runController(t, makeApplication("test-ns", "test-app", "deploy"))
+
+	app := runner.waitForApplication(t, "test-ns.test-app")
+
+	want := config.Application{
+		Name:      "test-ns.test-app",
+		RepoURL:   "https://github.com/bigkevmcd/peanut-engine.git",
+		Branch:    "main",
+		Path:      "deploy",
+		Namespace: "test-ns",
+		Resync:    metav1.Duration{Duration: config.DefaultResync},
+		Confined:  true,
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if diff := cmp.Diff(want, c.running[app.Name].config); diff != "" {
+		t.Fatalf("started application:\n%s", diff)
+	}
+}
+
+func TestControllerRestartsChangedApplications(t *testing.T) {
+	_, runner, client := runController(t, makeApplication("test-ns", "test-app", "deploy"))
+	first := runner.waitForApplication(t, "test-ns.test-app")
+
+	updated := makeApplication("test-ns", "test-app", "deploy/production")
+	_, err := client.Resource(ApplicationGVR).Namespace("test-ns").Update(context.Background(), updated, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		return runner.startCount("test-ns.test-app") == 2
+	})
+	waitFor(t, func() bool {
+		return runner.isStopped(first)
+	})
+}
+
+func TestControllerStopsDeletedApplications(t *testing.T) {
+	c, runner, client := runController(t, makeApplication("test-ns", "test-app", "deploy"))
+	app := runner.waitForApplication(t, "test-ns.test-app")
+
+	err := client.Resource(ApplicationGVR).Namespace("test-ns").Delete(context.Background(), "test-app", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		return runner.isStopped(app)
+	})
+	waitFor(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.running) == 0
+	})
+}
+
+func TestControllerUpdatesStatus(t *testing.T) {
+	_, runner, client := runController(t, makeApplication("test-ns", "test-app", "deploy"))
+	app := runner.waitForApplication(t, "test-ns.test-app")
+	end := time.Date(2020, time.June, 24, 22, 1, 0, 0, time.UTC)
+
+	app.OnSync(recent.Synchronisation{
+		End: end,
+		SHA: "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+		Results: []common.ResourceSyncResult{
+			{
+				Status:      common.ResultCodeSyncFailed,
+				Message:     "failed to apply",
+				ResourceKey: kube.ResourceKey{Kind: "ConfigMap", Namespace: "test-ns", Name: "test-cfg"},
+			},
+		},
+	})
+
+	u, err := client.Resource(ApplicationGVR).Namespace("test-ns").Get(context.Background(), "test-app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"observedGeneration": int64(1),
+		"sha":                "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+		"lastSyncTime":       "2020-06-24T22:01:00Z",
+		"health":             HealthDegraded,
+		"resources": []interface{}{
+			map[string]interface{}{
+				"kind":      "ConfigMap",
+				"namespace": "test-ns",
+				"name":      "test-cfg",
+				"status":    "SyncFailed",
+				"message":   "failed to apply",
+			},
+		},
+	}
+	if diff := cmp.Diff(want, u.Object["status"]); diff != "" {
+		t.Fatalf("status update failed:\n%s", diff)
+	}
+}
+
+func TestSyncStatusWithHealth(t *testing.T) {
+	status := syncStatus(1, recent.Synchronisation{
+		SHA:    "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+		Health: health.HealthStatusProgressing,
+	})
+
+	if status.Health != "Progressing" {
+		t.Fatalf("got health %s, want Progressing", status.Health)
+	}
+}
+
+func TestApplicationConfigWithPluginParser(t *testing.T) {
+	app := makeApplication("test-ns", "test-app", "deploy")
+	app.Object["spec"].(map[string]interface{})["parser"] = "plugin"
+	withSource := makeApplication("test-ns", "test-app", "")
+	withSource.Object["spec"].(map[string]interface{})["sources"] = []interface{}{
+		map[string]interface{}{"path": "deploy", "parser": "plugin", "plugin": map[string]interface{}{"command": []interface{}{"sh"}}},
+	}
+
+	for _, u := range []*unstructured.Unstructured{app, withSource} {
+		_, err := applicationConfig(u, nil)
+
+		if err == nil || err.Error() != "the plugin parser can't be used by PeanutApplications" {
+			t.Fatalf("got error %v, want the plugin parser to be rejected", err)
+		}
+	}
+}
+
+func TestApplicationConfigWithPathsOutsideTheRepository(t *testing.T) {
+	pathTests := []struct {
+		name string
+		spec map[string]interface{}
+		want string
+	}{
+		{"absolute path", map[string]interface{}{"path": "/etc"}, `invalid path "/etc"`},
+		{"parent path", map[string]interface{}{"path": "../deploy"}, `invalid path "../deploy"`},
+		{"absolute value file", map[string]interface{}{"helm": map[string]interface{}{"valueFiles": []interface{}{"/etc/passwd"}}}, `invalid path "/etc/passwd"`},
+		{"absolute jsonnet main", map[string]interface{}{"jsonnet": map[string]interface{}{"main": "/etc/main.jsonnet"}}, `invalid path "/etc/main.jsonnet"`},
+		{"parent lib path", map[string]interface{}{"jsonnet": map[string]interface{}{"libPaths": []interface{}{"../../vendor"}}}, `invalid path "../../vendor"`},
+		{"source path", map[string]interface{}{"path": "", "sources": []interface{}{map[string]interface{}{"path": "/etc"}}}, `invalid path "/etc"`},
+		{"source value file", map[string]interface{}{"path": "", "sources": []interface{}{map[string]interface{}{"path": "chart", "helm": map[string]interface{}{"valueFiles": []interface{}{"../../values.yaml"}}}}}, `invalid path "../../values.yaml"`},
+	}
+
+	for _, tt := range pathTests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := makeApplication("test-ns", "test-app", "deploy")
+			spec := u.Object["spec"].(map[string]interface{})
+			for k, v := range tt.spec {
+				spec[k] = v
+			}
+
+			_, err := applicationConfig(u, nil)
+
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplicationConfigTargetNamespace(t *testing.T) {
+	namespaceTests := []struct {
+		name             string
+		spec             map[string]interface{}
+		targetNamespaces []string
+		want             string
+		wantErr          string
+	}{
+		{"default", nil, nil, "test-ns", ""},
+		{"own namespace", map[string]interface{}{"targetNamespace": "test-ns"}, nil, "test-ns", ""},
+		{"other namespace", map[string]interface{}{"targetNamespace": "kube-system"}, nil, "", "PeanutApplications in test-ns can't deploy to namespace kube-system"},
+		{"allowed namespace", map[string]interface{}{"targetNamespace": "shared"}, []string{"shared"}, "shared", ""},
+		{"any namespace", map[string]interface{}{"targetNamespace": "kube-system"}, []string{"*"}, "kube-system", ""},
+		{"transformed namespace", map[string]interface{}{"transform": map[string]interface{}{"namespace": "kube-system"}}, []string{"shared"}, "", "PeanutApplications in test-ns can't deploy to namespace kube-system"},
+	}
+
+	for _, tt := range namespaceTests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := makeApplication("test-ns", "test-app", "deploy")
+			spec := u.Object["spec"].(map[string]interface{})
+			for k, v := range tt.spec {
+				spec[k] = v
+			}
+
+			cfg, err := applicationConfig(u, tt.targetNamespaces)
+
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Namespace != tt.want {
+				t.Fatalf("got namespace %q, want %q", cfg.Namespace, tt.want)
+			}
+			if !cfg.Confined {
+				t.Fatal("PeanutApplication is not confined to the repository")
+			}
+		})
+	}
+}
+
+func TestSyncStatusWhenMonitored(t *testing.T) {
+	statusTests := []struct {
+		name  string
+		diffs []diff.ResourceDiff
+		want  string
+	}{
+		{"in sync", nil, HealthHealthy},
+		{"with drift", []diff.ResourceDiff{{Kind: "ConfigMap", Namespace: "test-ns", Name: "test-cfg"}}, HealthOutOfSync},
+	}
+
+	for _, tt := range statusTests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := syncStatus(1, recent.Synchronisation{
+				SHA:       "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+				Monitored: true,
+				Diffs:     tt.diffs,
+			})
+
+			if status.Health != tt.want {
+				t.Fatalf("got health %s, want %s", status.Health, tt.want)
+			}
+		})
+	}
+}
+
+func TestControllerWithInvalidApplication(t *testing.T) {
+	_, runner, client := runController(t, makeApplication("test-ns", "test-app", ""))
+
+	var status interface{}
+	waitFor(t, func() bool {
+		u, err := client.Resource(ApplicationGVR).Namespace("test-ns").Get(context.Background(), "test-app", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		status = u.Object["status"]
+		return status != nil
+	})
+
+	want := map[string]interface{}{
+		"observedGeneration": int64(1),
+		"error":              `application "test-ns.test-app" has no path`,
+	}
+	if diff := cmp.Diff(want, status); diff != "" {
+		t.Fatalf("status update failed:\n%s", diff)
+	}
+	if n := runner.startCount("test-ns.test-app"); n != 0 {
+		t.Fatalf("invalid application was started %d times", n)
+	}
+}
+
+func TestControllerWithFailingFactory(t *testing.T) {
+	client := newFakeClient(makeApplication("test-ns", "test-app", "deploy"))
+	runner := &fakeRunner{}
+	c := New(client, "", nil, runner, func(config.Application) (*engine.Application, func(), error) {
+		return nil, nil, errors.New("failed to clone")
+	})
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go c.Run(done)
+
+	waitFor(t, func() bool {
+		u, err := client.Resource(ApplicationGVR).Namespace("test-ns").Get(context.Background(), "test-app", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, _, _ := unstructured.NestedString(u.Object, "status", "error")
+		return msg == "failed to clone"
+	})
+}
+
+func TestControllerStartsApplicationsWhileCloning(t *testing.T) {
+	client := newFakeClient(makeApplication("test-ns", "slow-app", "deploy"), makeApplication("test-ns", "test-app", "deploy"))
+	runner := &fakeRunner{}
+	cloned := make(chan struct{})
+	c := New(client, "", nil, runner, func(cfg config.Application) (*engine.Application, func(), error) {
+		if cfg.Name == "test-ns.slow-app" {
+			<-cloned
+		}
+		return &engine.Application{
+			Name:             cfg.Name,
+			Synchronisations: recent.NewRecentSynchronisations(ring.New(1)),
+		}, func() {}, nil
+	})
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		c.Run(done)
+		close(finished)
+	}()
+	var once sync.Once
+	finishCloning := func() { once.Do(func() { close(cloned) }) }
+	t.Cleanup(func() {
+		finishCloning()
+		close(done)
+		<-finished
+	})
+
+	runner.waitForApplication(t, "test-ns.test-app")
+	finishCloning()
+	runner.waitForApplication(t, "test-ns.slow-app")
+}
+
+func runController(t *testing.T, objs ...runtime.Object) (*Controller, *fakeRunner, *fake.FakeDynamicClient) {
+	t.Helper()
+	client := newFakeClient(objs...)
+	runner := &fakeRunner{}
+	c := New(client, "", nil, runner, func(cfg config.Application) (*engine.Application, func(), error) {
+		return &engine.Application{
+			Name:             cfg.Name,
+			Synchronisations: recent.NewRecentSynchronisations(ring.New(1)),
+		}, func() {}, nil
+	})
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		c.Run(done)
+		close(finished)
+	}()
+	t.Cleanup(func() {
+		close(done)
+		<-finished
+	})
+	return c, runner, client
+}
+
+func newFakeClient(objs ...runtime.Object) *fake.FakeDynamicClient {
+	return fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{ApplicationGVR: "PeanutApplicationList"}, objs...)
+}
+
+func makeApplication(ns, name, path string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "peanut.bigkevmcd.com/v1alpha1",
+			"kind":       "PeanutApplication",
+			"metadata": map[string]interface{}{
+				"namespace":  ns,
+				"name":       name,
+				"generation": int64(1),
+			},
+			"spec": map[string]interface{}{
+				"repoURL": "https://github.com/bigkevmcd/peanut-engine.git",
+				"branch":  "main",
+				"path":    path,
+			},
+		},
+	}
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if f() {
+			return
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Fatal("timed out waiting for condition")
+}
+
+type fakeRunner struct {
+	mu      sync.Mutex
+	started []*engine.Application
+	stopped map[*engine.Application]bool
+}
+
+func (f *fakeRunner) Start(app *engine.Application, done <-chan struct{}) <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.started = append(f.started, app)
+	stopped := make(chan struct{})
+	go func() {
+		<-done
+		f.mu.Lock()
+		if f.stopped == nil {
+			f.stopped = map[*engine.Application]bool{}
+		}
+		f.stopped[app] = true
+		f.mu.Unlock()
+		close(stopped)
+	}()
+	return stopped
+}
+
+func (f *fakeRunner) startCount(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, v := range f.started {
+		if v.Name == name {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeRunner) isStopped(app *engine.Application) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stopped[app]
+}
+
+func (f *fakeRunner) waitForApplication(t *testing.T, name string) *engine.Application {
+	t.Helper()
+	var app *engine.Application
+	waitFor(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, v := range f.started {
+			if v.Name == name {
+				app = v
+				return true
+			}
+		}
+		return false
+	})
+	return app
+}
@@ -0,0 +1,34 @@
+package credentials
+
+import (
+	"fmt"
+	"os"
+)
+
+// Provider provides the token for authenticating with a repository.
+//
+// The token is requested before each clone or fetch, so that rotated tokens
+// are used without restarting.
+type Provider interface {
+	Token() (string, error)
+}
+
+// Static is a Provider for a token that never changes.
+type Static string
+
+// Token implements the Provider interface.
+func (s Static) Token() (string, error) {
+	return string(s), nil
+}
+
+// Env is a Provider that reads the token from an environment variable.
+type Env string
+
+// Token implements the Provider interface.
+func (e Env) Token() (string, error) {
+	token, ok := os.LookupEnv(string(e))
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", string(e))
+	}
+	return token, nil
+}
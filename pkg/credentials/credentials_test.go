@@ -0,0 +1,44 @@
+package credentials
+
+import (
+	"testing"
+)
+
+func TestStatic(t *testing.T) {
+	token, err := Static("test-token").Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "test-token" {
+		t.Fatalf("got token %q, want %q", token, "test-token")
+	}
+}
+
+func TestEnv(t *testing.T) {
+	t.Setenv("PEANUT_TEST_TOKEN", "first-token")
+	p := Env("PEANUT_TEST_TOKEN")
+	assertToken(t, p, "first-token")
+
+	t.Setenv("PEANUT_TEST_TOKEN", "second-token")
+	assertToken(t, p, "second-token")
+}
+
+func TestEnvWithUnsetVariable(t *testing.T) {
+	_, err := Env("PEANUT_TEST_UNSET_TOKEN").Token()
+
+	if err == nil || err.Error() != "environment variable PEANUT_TEST_UNSET_TOKEN is not set" {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func assertToken(t *testing.T, p Provider, want string) {
+	t.Helper()
+	token, err := p.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != want {
+		t.Fatalf("got token %q, want %q", token, want)
+	}
+}
@@ -0,0 +1,46 @@
+package credentials
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File is a Provider that reads the token from a file, e.g. a mounted Secret.
+//
+// The file is only read again when it changes.
+type File struct {
+	filename string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+// NewFile creates and returns a new File provider.
+func NewFile(filename string) *File {
+	return &File{filename: filename}
+}
+
+// Token implements the Provider interface.
+func (f *File) Token() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, err := os.Stat(f.filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token: %w", err)
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.token, nil
+	}
+	b, err := os.ReadFile(f.filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token: %w", err)
+	}
+	f.token = strings.TrimSpace(string(b))
+	f.modTime, f.size = info.ModTime(), info.Size()
+	return f.token, nil
+}
@@ -0,0 +1,59 @@
+package credentials
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "token")
+	writeToken(t, filename, "first-token\n", time.Now().Add(-time.Minute))
+	p := NewFile(filename)
+	assertToken(t, p, "first-token")
+
+	writeToken(t, filename, "second-token\n", time.Now())
+	assertToken(t, p, "second-token")
+}
+
+func TestFileWithMountedSecret(t *testing.T) {
+	// Kubernetes updates mounted Secrets by replacing a symlink to a new
+	// directory.
+	dir := t.TempDir()
+	for _, v := range []string{"first", "second"} {
+		assertNoError(t, os.Mkdir(filepath.Join(dir, v), 0o755))
+		writeToken(t, filepath.Join(dir, v, "token"), v+"-token", time.Now())
+	}
+	assertNoError(t, os.Symlink("first", filepath.Join(dir, "..data")))
+	assertNoError(t, os.Symlink(filepath.Join("..data", "token"), filepath.Join(dir, "token")))
+	p := NewFile(filepath.Join(dir, "token"))
+	assertToken(t, p, "first-token")
+
+	assertNoError(t, os.Symlink("second", filepath.Join(dir, "..data_tmp")))
+	assertNoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	assertToken(t, p, "second-token")
+}
+
+func TestFileWithMissingFile(t *testing.T) {
+	_, err := NewFile(filepath.Join(t.TempDir(), "token")).Token()
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got error %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func writeToken(t *testing.T, filename, token string, modTime time.Time) {
+	t.Helper()
+	assertNoError(t, os.WriteFile(filename, []byte(token), 0o600))
+	assertNoError(t, os.Chtimes(filename, modTime, modTime))
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,75 @@
+package credentials
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultSecretKey is the key in the Secret that the token is read from if no
+// key is provided.
+const DefaultSecretKey = "token"
+
+const secretResync = time.Minute * 10
+
+// secretSyncTimeout is how long to wait for the Secret to be loaded when the
+// token is first requested.
+var secretSyncTimeout = time.Second * 30
+
+// Secret is a Provider that reads the token from a Kubernetes Secret, the
+// Secret is watched, so changes are picked up without restarting.
+type Secret struct {
+	namespace string
+	name      string
+	key       string
+	lister    corelisters.SecretNamespaceLister
+	synced    func() bool
+}
+
+// NewSecret creates and returns a new Secret provider, the Secret is watched
+// until done is closed.
+func NewSecret(client kubernetes.Interface, namespace, name, key string, done <-chan struct{}) *Secret {
+	if key == "" {
+		key = DefaultSecretKey
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(client, secretResync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	informer := factory.Core().V1().Secrets()
+	s := &Secret{
+		namespace: namespace,
+		name:      name,
+		key:       key,
+		lister:    informer.Lister().Secrets(namespace),
+		synced:    informer.Informer().HasSynced,
+	}
+	factory.Start(done)
+	return s
+}
+
+// Token implements the Provider interface.
+func (s *Secret) Token() (string, error) {
+	timeout := make(chan struct{})
+	timer := time.AfterFunc(secretSyncTimeout, func() { close(timeout) })
+	defer timer.Stop()
+	if !cache.WaitForCacheSync(timeout, s.synced) {
+		return "", fmt.Errorf("secret %s/%s has not been loaded", s.namespace, s.name)
+	}
+	secret, err := s.lister.Get(s.name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	token, ok := secret.Data[s.key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no key %q", s.namespace, s.name, s.key)
+	}
+	return string(token), nil
+}
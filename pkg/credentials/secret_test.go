@@ -0,0 +1,62 @@
+package credentials
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecret(t *testing.T) {
+	client := fake.NewSimpleClientset(makeSecret("first-token"))
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	p := NewSecret(client, "test-ns", "git-auth", "", done)
+	assertToken(t, p, "first-token")
+
+	_, err := client.CoreV1().Secrets("test-ns").Update(context.TODO(), makeSecret("second-token"), metav1.UpdateOptions{})
+	assertNoError(t, err)
+
+	err = wait.PollImmediate(time.Millisecond*10, time.Second*5, func() (bool, error) {
+		token, err := p.Token()
+		return token == "second-token", err
+	})
+	if err != nil {
+		t.Fatalf("the rotated token was not loaded: %s", err)
+	}
+}
+
+func TestSecretWithMissingKey(t *testing.T) {
+	client := fake.NewSimpleClientset(makeSecret("first-token"))
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+
+	_, err := NewSecret(client, "test-ns", "git-auth", "password", done).Token()
+
+	if err == nil || err.Error() != `secret test-ns/git-auth has no key "password"` {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestSecretWithMissingSecret(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+
+	_, err := NewSecret(client, "test-ns", "git-auth", "", done).Token()
+
+	if err == nil || err.Error() != `failed to get secret test-ns/git-auth: secret "git-auth" not found` {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func makeSecret(token string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "git-auth", Namespace: "test-ns"},
+		Data:       map[string][]byte{DefaultSecretKey: []byte(token)},
+	}
+}
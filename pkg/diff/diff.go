@@ -0,0 +1,210 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+
+	gitopsdiff "github.com/argoproj/gitops-engine/pkg/diff"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/pmezard/go-difflib/difflib"
+	"gomodules.xyz/jsonpatch/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// serverFields are the metadata fields that are populated by the API server,
+// and are ignored when comparing resources.
+var serverFields = []string{
+	"creationTimestamp",
+	"generation",
+	"managedFields",
+	"resourceVersion",
+	"selfLink",
+	"uid",
+}
+
+// ResourceDiff is the difference between the desired state of a resource and
+// the live state in the cluster.
+type ResourceDiff struct {
+	Group     string `json:"group"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Diff is a unified diff from the YAML of the live resource to the YAML of
+	// the desired resource.
+	Diff string `json:"diff"`
+	// Patch is a JSON patch that transforms the live resource into the
+	// desired resource.
+	Patch []jsonpatch.Operation `json:"patch"`
+}
+
+// Compute compares the target resources with the live resources, and returns
+// the differences for the resources that are out of sync.
+//
+// The targets and live resources are paired by index, a nil target indicates
+// a live resource that is not in the manifests, and a nil live resource
+// indicates a target resource that doesn't exist in the cluster.
+//
+// The values of Secrets are masked, and differ in the diffs only if the
+// values differ.
+func Compute(targets, live []*unstructured.Unstructured) ([]ResourceDiff, error) {
+	targets, live, err := hideSecretData(targets, live)
+	if err != nil {
+		return nil, err
+	}
+	results, err := gitopsdiff.DiffArray(targets, live)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare resources: %w", err)
+	}
+	diffs := []ResourceDiff{}
+	for i, v := range results.Diffs {
+		obj := targets[i]
+		if obj == nil {
+			obj = live[i]
+		}
+		// Resources that are not in the manifests are out of sync, even though
+		// they are not reported as modified.
+		if obj == nil || (targets[i] != nil && !v.Modified) {
+			continue
+		}
+		d, err := makeDiff(kube.GetResourceKey(obj), v.NormalizedLive, v.PredictedLive)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+// hideSecretData returns copies of the resources, with the values of Secrets
+// replaced.
+//
+// The resources are paired by index, as with Compute.
+func hideSecretData(targets, live []*unstructured.Unstructured) ([]*unstructured.Unstructured, []*unstructured.Unstructured, error) {
+	if len(targets) != len(live) {
+		return nil, nil, fmt.Errorf("got %d targets and %d live resources", len(targets), len(live))
+	}
+	maskedTargets := make([]*unstructured.Unstructured, len(targets))
+	maskedLive := make([]*unstructured.Unstructured, len(live))
+	for i := range targets {
+		maskedTargets[i], maskedLive[i] = targets[i], live[i]
+		obj := targets[i]
+		if obj == nil {
+			obj = live[i]
+		}
+		if obj == nil || !isSecret(obj) {
+			continue
+		}
+		t, l, err := gitopsdiff.HideSecretData(targets[i], live[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to hide the data of %s: %w", kube.GetResourceKey(obj), err)
+		}
+		maskedTargets[i], maskedLive[i] = t, l
+	}
+	return maskedTargets, maskedLive, nil
+}
+
+func isSecret(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	return gvk.Group == "" && gvk.Kind == kube.SecretKind
+}
+
+func makeDiff(key kube.ResourceKey, liveJSON, desiredJSON []byte) (ResourceDiff, error) {
+	live, err := clean(liveJSON)
+	if err != nil {
+		return ResourceDiff{}, fmt.Errorf("failed to parse live resource %s: %w", key, err)
+	}
+	desired, err := clean(desiredJSON)
+	if err != nil {
+		return ResourceDiff{}, fmt.Errorf("failed to parse desired resource %s: %w", key, err)
+	}
+	patch, err := createPatch(live, desired)
+	if err != nil {
+		return ResourceDiff{}, fmt.Errorf("failed to create patch for %s: %w", key, err)
+	}
+	unified, err := unifiedDiff(live, desired)
+	if err != nil {
+		return ResourceDiff{}, fmt.Errorf("failed to create diff for %s: %w", key, err)
+	}
+	return ResourceDiff{
+		Group:     key.Group,
+		Kind:      key.Kind,
+		Namespace: key.Namespace,
+		Name:      key.Name,
+		Diff:      unified,
+		Patch:     patch,
+	}, nil
+}
+
+// clean parses a JSON resource and removes the fields that are populated by
+// the API server.
+//
+// If the JSON is null, this returns nil.
+func clean(b []byte) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, nil
+	}
+	delete(obj, "status")
+	u := unstructured.Unstructured{Object: obj}
+	for _, v := range serverFields {
+		unstructured.RemoveNestedField(obj, "metadata", v)
+	}
+	annotations := u.GetAnnotations()
+	delete(annotations, lastAppliedAnnotation)
+	if len(annotations) == 0 {
+		unstructured.RemoveNestedField(obj, "metadata", "annotations")
+	} else {
+		u.SetAnnotations(annotations)
+	}
+	return obj, nil
+}
+
+func createPatch(live, desired map[string]interface{}) ([]jsonpatch.Operation, error) {
+	if live == nil {
+		live = map[string]interface{}{}
+	}
+	if desired == nil {
+		desired = map[string]interface{}{}
+	}
+	a, err := json.Marshal(live)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(desired)
+	if err != nil {
+		return nil, err
+	}
+	return jsonpatch.CreatePatch(a, b)
+}
+
+func unifiedDiff(live, desired map[string]interface{}) (string, error) {
+	a, err := toYAML(live)
+	if err != nil {
+		return "", err
+	}
+	b, err := toYAML(desired)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: "live",
+		ToFile:   "desired",
+		Context:  3,
+	})
+}
+
+func toYAML(obj map[string]interface{}) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	b, err := yaml.Marshal(obj)
+	return string(b), err
+}
@@ -0,0 +1,145 @@
+package diff
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gomodules.xyz/jsonpatch/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestCompute(t *testing.T) {
+	live := makeConfigMap("test-ns", "test-cfg", "old")
+	live.SetResourceVersion("1234")
+	live.SetUID("c0c2e3f6-2d5a-4c47-9c3c-4c7cc3dbe0a6")
+	live.SetGeneration(2)
+
+	diffs, err := Compute(
+		[]*unstructured.Unstructured{makeConfigMap("test-ns", "test-cfg", "new"), makeConfigMap("test-ns", "same-cfg", "value")},
+		[]*unstructured.Unstructured{live, makeConfigMap("test-ns", "same-cfg", "value")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ResourceDiff{
+		{
+			Kind:      "ConfigMap",
+			Namespace: "test-ns",
+			Name:      "test-cfg",
+			Diff: `--- live
++++ desired
+@@ -1,6 +1,6 @@
+ apiVersion: v1
+ data:
+-  key: old
++  key: new
+ kind: ConfigMap
+ metadata:
+   name: test-cfg
+`,
+			Patch: []jsonpatch.Operation{
+				{Operation: "replace", Path: "/data/key", Value: "new"},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, diffs); diff != "" {
+		t.Fatalf("Compute() failed:\n%s", diff)
+	}
+}
+
+func TestComputeWithMissingResources(t *testing.T) {
+	diffs, err := Compute(
+		[]*unstructured.Unstructured{makeConfigMap("test-ns", "new-cfg", "value"), nil},
+		[]*unstructured.Unstructured{nil, makeConfigMap("test-ns", "old-cfg", "value")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(diffs); l != 2 {
+		t.Fatalf("got %d diffs, want 2", l)
+	}
+	if n := diffs[0].Name; n != "new-cfg" {
+		t.Fatalf("got %q, want new-cfg", n)
+	}
+	if l := len(diffs[0].Patch); l != 4 {
+		t.Fatalf("got %d operations creating the resource, want 4", l)
+	}
+	if n := diffs[1].Name; n != "old-cfg" {
+		t.Fatalf("got %q, want old-cfg", n)
+	}
+	for _, v := range diffs[1].Patch {
+		if v.Operation != "remove" {
+			t.Fatalf("got operation %q removing the resource, want remove", v.Operation)
+		}
+	}
+}
+
+func TestComputeHidesSecretData(t *testing.T) {
+	live := makeSecret("test-ns", "test-secret", map[string]interface{}{"data": map[string]interface{}{
+		"password": base64.StdEncoding.EncodeToString([]byte("old-password")),
+		"username": base64.StdEncoding.EncodeToString([]byte("admin")),
+	}})
+	target := makeSecret("test-ns", "test-secret", map[string]interface{}{"stringData": map[string]interface{}{
+		"password": "new-password",
+		"username": "admin",
+	}})
+
+	diffs, err := Compute([]*unstructured.Unstructured{target}, []*unstructured.Unstructured{live})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(diffs); l != 1 {
+		t.Fatalf("got %d diffs, want 1", l)
+	}
+	b, err := json.Marshal(diffs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"old-password", "new-password", "admin"} {
+		for _, s := range []string{v, base64.StdEncoding.EncodeToString([]byte(v))} {
+			if strings.Contains(string(b), s) {
+				t.Fatalf("diff contains %q: %s", s, b)
+			}
+		}
+	}
+	for _, v := range []string{"-  password: ++++++++++++\n", "+  password: ++++++++\n", "   username: ++++++++\n"} {
+		if !strings.Contains(diffs[0].Diff, v) {
+			t.Fatalf("diff doesn't contain %q:\n%s", v, diffs[0].Diff)
+		}
+	}
+}
+
+func makeSecret(ns, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	obj := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": ns,
+		},
+	}
+	for k, v := range fields {
+		obj[k] = v
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func makeConfigMap(ns, name, value string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"namespace": ns,
+				"name":      name,
+			},
+			"data": map[string]interface{}{
+				"key": value,
+			},
+		},
+	}
+}
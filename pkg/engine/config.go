@@ -1,33 +1,120 @@
 package engine
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/credentials"
+	"github.com/bigkevmcd/peanut-engine/pkg/window"
+)
+
+// DefaultUsername is the username for basic authentication if none is
+// configured.
+const DefaultUsername = "peanut"
+
+// The modes that applications are synchronised in.
+const (
+	// SyncMode applies the resources to the cluster.
+	SyncMode = "sync"
+	// MonitorMode compares the resources with the cluster, and records the
+	// drift, without changing the cluster.
+	MonitorMode = "monitor"
 )
 
 // GitConfig is the configuration for the repo to extract resources.
 type GitConfig struct {
-	RepoURL   string
-	Branch    string
-	Path      string
-	AuthToken string
+	RepoURL string
+	Branch  string
+	// Revision is a commit SHA, tag or semver constraint to deploy instead of
+	// the head of the branch.
+	Revision string
+	Path     string
+	// Sources are the paths of the sources, relative to the repository, for
+	// applications that combine several paths, the Path is empty.
+	Sources []string
+	// Depth limits the number of commits that are fetched, if it's zero, the
+	// full history is fetched.
+	Depth int
+	// SingleBranch fetches only the configured branch.
+	SingleBranch bool
+	// Sparse checks out only the path, and any directories that Kustomizations
+	// in the path reference.
+	Sparse bool
+	// Confined rejects symlinks to files outside the repository, for
+	// repositories that are not trusted.
+	Confined bool
+	Username string
+	// Credentials is optional, and provides the token for basic
+	// authentication, it's called before each clone or fetch.
+	Credentials credentials.Provider
+	SSH         SSHConfig
+}
+
+// Paths returns the paths in the repository that are deployed, this is the
+// paths of the sources, or the path.
+func (c GitConfig) Paths() []string {
+	if len(c.Sources) > 0 {
+		return c.Sources
+	}
+	return []string{c.Path}
 }
 
 // PeanutConfig configures the engine synchronisation.
 type PeanutConfig struct {
-	Prune      bool
-	Namespace  string
-	Namespaced bool
-	Resync     time.Duration
+	Prune     bool
+	Namespace string
+	Resync    time.Duration
+	// HealthTimeout is how long to wait for the resources to stop progressing
+	// after synchronising, if it's zero, the health is assessed once.
+	HealthTimeout time.Duration
+	// AutoRollback applies the resources from the last healthy commit if the
+	// resources from a commit are degraded, the degraded commit is not
+	// synchronised again.
+	AutoRollback bool
+	// Mode is SyncMode or MonitorMode, it defaults to SyncMode.
+	Mode string
+	// SyncWindows restrict when the resources are applied, if
+	// DetectDriftOutsideWindows is true, the resources are compared with the
+	// cluster when they can't be applied.
+	SyncWindows               window.Windows
+	DetectDriftOutsideWindows bool
 }
 
-func (c *GitConfig) BasicAuth() *http.BasicAuth {
-	if c.AuthToken != "" {
-		return &http.BasicAuth{
-			Username: "peanut",
-			Password: c.AuthToken,
-		}
+// Auth returns the authentication for the repository, an SSH private key takes
+// precedence over the credentials.
+//
+// If no authentication is configured, this returns nil.
+func (c *GitConfig) Auth() (transport.AuthMethod, error) {
+	if c.SSH.PrivateKeyFile != "" {
+		return c.SSH.publicKeys(c.RepoURL)
+	}
+	auth, err := c.BasicAuth()
+	if err != nil || auth == nil {
+		// Avoid returning a typed nil.
+		return nil, err
+	}
+	return auth, nil
+}
+
+// BasicAuth returns the basic authentication with the current token from the
+// credentials, or nil if there is no token.
+func (c *GitConfig) BasicAuth() (*http.BasicAuth, error) {
+	if c.Credentials == nil {
+		return nil, nil
+	}
+	token, err := c.Credentials.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials: %w", err)
+	}
+	if token == "" {
+		return nil, nil
+	}
+	username := c.Username
+	if username == "" {
+		username = DefaultUsername
 	}
-	return nil
+	return &http.BasicAuth{Username: username, Password: token}, nil
 }
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/credentials"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/kustomize"
+)
+
+func TestAuthWithNoCredentials(t *testing.T) {
+	c := GitConfig{RepoURL: "https://example.com/example.git"}
+
+	auth, err := c.Auth()
+	assertNoError(t, err)
+
+	if auth != nil {
+		t.Fatalf("got auth %#v, want nil", auth)
+	}
+}
+
+func TestAuthWithEmptyToken(t *testing.T) {
+	c := GitConfig{RepoURL: "https://example.com/example.git", Credentials: credentials.Static("")}
+
+	auth, err := c.Auth()
+	assertNoError(t, err)
+
+	if auth != nil {
+		t.Fatalf("got auth %#v, want nil", auth)
+	}
+}
+
+func TestBasicAuthUsername(t *testing.T) {
+	usernameTests := []struct {
+		username string
+		want     string
+	}{
+		{"", DefaultUsername},
+		{"x-access-token", "x-access-token"},
+	}
+
+	for _, tt := range usernameTests {
+		c := GitConfig{Username: tt.username, Credentials: credentials.Static("test-token")}
+
+		auth, err := c.BasicAuth()
+		assertNoError(t, err)
+
+		if auth.Username != tt.want || auth.Password != "test-token" {
+			t.Errorf("BasicAuth() got %s:%s, want %s:test-token", auth.Username, auth.Password, tt.want)
+		}
+	}
+}
+
+func TestAuthWithFailingCredentials(t *testing.T) {
+	c := GitConfig{Credentials: credentials.Env("PEANUT_TEST_UNSET_TOKEN")}
+
+	_, err := c.Auth()
+
+	if err == nil || !strings.Contains(err.Error(), "failed to get credentials") {
+		t.Fatalf("got error %v, want failed to get credentials", err)
+	}
+}
+
+func TestSyncWithRotatedToken(t *testing.T) {
+	server := startHTTPGitServer(t, "first-token")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assertNoError(t, os.WriteFile(tokenFile, []byte("first-token\n"), 0o600))
+	c := GitConfig{
+		RepoURL:     server.repoURL,
+		Branch:      "main",
+		Path:        "deploy",
+		Credentials: credentials.NewFile(tokenFile),
+	}
+	r := NewRepository(c, kustomize.New())
+	assertNoError(t, r.Clone(mkTempDir(t)))
+
+	server.setToken("second-token")
+	commitFile(t, server.dir, "deploy/new.yaml")
+	if _, err := r.Sync(); err == nil {
+		t.Fatal("expected the sync to fail with the old token")
+	}
+
+	assertNoError(t, os.WriteFile(tokenFile, []byte("second-token\n"), 0o600))
+	got, err := r.Sync()
+	assertNoError(t, err)
+	if want := execGitHead(t, server.dir); got.String() != want {
+		t.Fatalf("Sync() got %s, want %s", got, want)
+	}
+}
+
+type httpGitServer struct {
+	dir     string
+	repoURL string
+
+	mu    sync.Mutex
+	token string
+}
+
+func (s *httpGitServer) setToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// startHTTPGitServer serves a new repository with git http-backend, requiring
+// basic authentication with the token.
+func startHTTPGitServer(t *testing.T, token string) *httpGitServer {
+	t.Helper()
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("this test needs git")
+	}
+	dir := makeGitRepository(t)
+	s := &httpGitServer{dir: dir, token: token}
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + filepath.Dir(dir), "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		want := s.token
+		s.mu.Unlock()
+		if _, password, ok := r.BasicAuth(); !ok || password != want {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	s.repoURL = ts.URL + "/" + filepath.Base(dir)
+	return s
+}
+
+// commitFile adds a new file to the repository, and commits it.
+func commitFile(t *testing.T, dir, name string) {
+	t.Helper()
+	assertNoError(t, os.WriteFile(filepath.Join(dir, name), []byte("# "+name+"\n"), 0o644))
+	for _, args := range [][]string{
+		{"add", "."},
+		{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "Add " + name},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %s", strings.Join(args, " "), out)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+// ErrNoPreviousCommit is returned when rolling back to the previous commit,
+// and no other commit was synchronised.
+var ErrNoPreviousCommit = errors.New("no previous commit to roll back to")
+
+// ErrMonitoring is returned when deploying an application that is only
+// monitored.
+var ErrMonitoring = errors.New("the application is in monitor mode")
+
+// ErrUntrustedCommit is returned when deploying a commit that the
+// application's Verifier doesn't trust.
+var ErrUntrustedCommit = errors.New("the commit is not trusted")
+
+// Deploy applies the resources from a commit in the application's clone, and
+// pins the application to it, the application is not synchronised
+// automatically until it's resumed.
+//
+// The application is pinned even if applying the resources fails, and
+// deploying is refused outside the application's sync windows, unless they
+// are overridden, and for commits without a trusted signature, if the
+// application has a Verifier.
+//
+// This waits for the resources to become healthy, for up to the application's
+// HealthTimeout, or until the context is done.
+func (m *Manager) Deploy(ctx context.Context, app *Application, sha plumbing.Hash) (recent.Synchronisation, error) {
+	return m.deploy(ctx, app, sha, false)
+}
+
+// Rollback is Deploy, recorded as a rollback from the currently deployed
+// commit.
+//
+// If the SHA is zero, this rolls back to the commit that was synchronised
+// before the currently deployed commit.
+func (m *Manager) Rollback(ctx context.Context, app *Application, sha plumbing.Hash) (recent.Synchronisation, error) {
+	return m.deploy(ctx, app, sha, true)
+}
+
+func (m *Manager) deploy(ctx context.Context, app *Application, sha plumbing.Hash, rollback bool) (recent.Synchronisation, error) {
+	if app.Config.Mode == MonitorMode {
+		return recent.Synchronisation{}, ErrMonitoring
+	}
+	if !app.SyncWindowOpen(time.Now()) {
+		return recent.Synchronisation{}, ErrSyncWindowClosed
+	}
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	// The deployed commit is read with the lock held, so that a
+	// synchronisation can't deploy another commit before this is applied.
+	var rollbackFrom string
+	if rollback {
+		rollbackFrom = app.latest().SHA
+		if sha.IsZero() {
+			previous, found := previousSHA(app, rollbackFrom)
+			if !found {
+				return recent.Synchronisation{}, ErrNoPreviousCommit
+			}
+			sha = previous
+		}
+	}
+	logger := log.WithField("application", app.Name)
+	logger.Infof("Deploying %s", sha)
+	var signer string
+	if app.Verifier != nil {
+		c, err := app.Repository.CommitAt(sha)
+		if err != nil {
+			return recent.Synchronisation{}, err
+		}
+		if signer, err = app.Verifier.Verify(c); err != nil {
+			app.Metrics.CountRejected()
+			logger.Errorf("Refusing to deploy %s: %s", sha, err)
+			return recent.Synchronisation{}, fmt.Errorf("%w: %s", ErrUntrustedCommit, err)
+		}
+	}
+	targets, err := app.Repository.ParseManifestsAt(sha)
+	if err != nil {
+		return recent.Synchronisation{}, fmt.Errorf("failed to parse the manifests from %s: %w", sha, err)
+	}
+	record := recent.Synchronisation{
+		Start:        time.Now(),
+		SHA:          sha.String(),
+		Ref:          sha.String(),
+		Parser:       app.Repository.Parser(),
+		RollbackFrom: rollbackFrom,
+		Pinned:       true,
+		SignedBy:     signer,
+	}
+	if rollbackFrom != "" {
+		app.Metrics.CountRollback()
+	}
+	app.pin(sha.String())
+	err = m.apply(app, sha, targets, &record, ctx.Done(), logger)
+	app.record(record)
+	if err != nil {
+		app.Metrics.CountError()
+		logger.Errorf("Failed to deploy %s: %s", sha, err)
+		return app.latest(), err
+	}
+	app.Metrics.Record(record.Results)
+	return app.latest(), nil
+}
+
+func (a *Application) latest() recent.Synchronisation {
+	s, _ := a.Synchronisations.Latest()
+	return s
+}
+
+// previousSHA returns the SHA of the most recent successful synchronisation
+// of a commit other than the deployed commit.
+func previousSHA(app *Application, deployed string) (plumbing.Hash, bool) {
+	for _, v := range app.Synchronisations.History() {
+		if v.SHA != "" && v.SHA != deployed && !v.Failed() && !v.Monitored {
+			return plumbing.NewHash(v.SHA), true
+		}
+	}
+	return plumbing.ZeroHash, false
+}
@@ -0,0 +1,303 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argoproj/gitops-engine/pkg/cache"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	gitopssync "github.com/argoproj/gitops-engine/pkg/sync"
+	"github.com/argoproj/gitops-engine/pkg/sync/common"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/metrics"
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+func TestDeploy(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{makeConfigMap("test-ns", "head-cfg", "a")}
+	repo.commits = map[plumbing.Hash][]*unstructured.Unstructured{
+		plumbing.NewHash(healthySHA): {makeConfigMap("test-ns", "deployed-cfg", "a")},
+	}
+	app := testApplication(repo)
+	app.Resync = make(chan bool, 1)
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	s, err := m.Deploy(context.Background(), app, plumbing.NewHash(healthySHA))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.SHA != healthySHA || !s.Pinned || s.ID != 1 {
+		t.Fatalf("got %s pinned %v with ID %d, want a pinned deployment of %s", s.SHA, s.Pinned, s.ID, healthySHA)
+	}
+	if rev := syncer.synced()[0].revision; rev != healthySHA {
+		t.Fatalf("got revision %s, want %s", rev, healthySHA)
+	}
+	if p := app.Pinned(); p != healthySHA {
+		t.Fatalf("got pinned %q, want %s", p, healthySHA)
+	}
+
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+	if l := len(syncer.synced()); l != 1 {
+		t.Fatalf("got %d syncs while pinned, want 1", l)
+	}
+
+	if resumed, err := app.Resume(); err != nil || !resumed {
+		t.Fatalf("Resume() got %v, %v, want true", resumed, err)
+	}
+	if l := len(app.Resync); l != 1 {
+		t.Fatalf("got %d resyncs, want 1", l)
+	}
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+	if rev := syncer.synced()[1].revision; rev != testSHA {
+		t.Fatalf("got revision %s after resuming, want %s", rev, testSHA)
+	}
+	if latest, _ := app.Synchronisations.Latest(); latest.Pinned {
+		t.Fatal("synchronisation after resuming is pinned")
+	}
+}
+
+func TestDeployStopsWaitingWhenCancelled(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.commits = map[plumbing.Hash][]*unstructured.Unstructured{
+		plumbing.NewHash(healthySHA): {makeDeployment("test-ns", "taxi", 1)},
+	}
+	app := testApplication(repo)
+	app.Config.HealthTimeout = time.Hour
+	m := &Manager{
+		gitOpsEngine: &fakeGitOpsEngine{},
+		clusterCache: &fakeClusterCache{
+			live: map[kube.ResourceKey]*unstructured.Unstructured{
+				kube.NewResourceKey("apps", "Deployment", "test-ns", "taxi"): makeDeployment("test-ns", "taxi", 0),
+			},
+		},
+		healthInterval: time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	s, err := m.Deploy(ctx, app, plumbing.NewHash(healthySHA))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Health != health.HealthStatusProgressing {
+		t.Fatalf("got health %s, want %s", s.Health, health.HealthStatusProgressing)
+	}
+}
+
+func TestDeployWithUnknownCommit(t *testing.T) {
+	app := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+	m := &Manager{gitOpsEngine: &fakeGitOpsEngine{}, clusterCache: &fakeClusterCache{}}
+
+	_, err := m.Deploy(context.Background(), app, plumbing.NewHash(healthySHA))
+
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if p := app.Pinned(); p != "" {
+		t.Fatalf("got pinned %q, want no pin", p)
+	}
+}
+
+func TestRollbackToPreviousCommit(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.commits = map[plumbing.Hash][]*unstructured.Unstructured{
+		plumbing.NewHash(healthySHA): {makeConfigMap("test-ns", "previous-cfg", "a")},
+	}
+	app := testApplication(repo)
+	app.Synchronisations.Record(recent.Synchronisation{SHA: healthySHA})
+	app.Synchronisations.Record(recent.Synchronisation{SHA: testSHA})
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	s, err := m.Rollback(context.Background(), app, plumbing.ZeroHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.SHA != healthySHA || s.RollbackFrom != testSHA || !s.Pinned {
+		t.Fatalf("got %s rolled back from %q, pinned %v, want a pinned rollback to %s from %s", s.SHA, s.RollbackFrom, s.Pinned, healthySHA, testSHA)
+	}
+	if r := app.Metrics.(*metrics.MockMetrics).Rollbacks; r != 1 {
+		t.Fatalf("got %d rollbacks, want 1", r)
+	}
+}
+
+func TestRollbackAndResume(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{makeConfigMap("test-ns", "head-cfg", "a")}
+	repo.commits = map[plumbing.Hash][]*unstructured.Unstructured{
+		plumbing.NewHash(healthySHA): {makeConfigMap("test-ns", "previous-cfg", "a")},
+	}
+	app := testApplication(repo)
+	app.Resync = make(chan bool, 1)
+	app.Synchronisations.Record(recent.Synchronisation{SHA: healthySHA})
+	app.Synchronisations.Record(recent.Synchronisation{SHA: testSHA})
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	if _, err := m.Rollback(context.Background(), app, plumbing.ZeroHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	synced := syncer.synced()
+	if l := len(synced); l != 2 {
+		t.Fatalf("got %d syncs, want the rollback and the head", l)
+	}
+	if rev := synced[1].revision; rev != testSHA {
+		t.Fatalf("got revision %s after resuming, want %s", rev, testSHA)
+	}
+}
+
+func TestRollbackDuringSynchronisation(t *testing.T) {
+	newSHA := "d2a3b1c4e5f60718293a4b5c6d7e8f9012345678"
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.syncHead = plumbing.NewHash(newSHA)
+	repo.resources = []*unstructured.Unstructured{makeConfigMap("test-ns", "head-cfg", "a")}
+	repo.commits = map[plumbing.Hash][]*unstructured.Unstructured{
+		plumbing.NewHash(testSHA): {makeConfigMap("test-ns", "previous-cfg", "a")},
+	}
+	app := testApplication(repo)
+	app.Synchronisations.Record(recent.Synchronisation{SHA: healthySHA})
+	app.Synchronisations.Record(recent.Synchronisation{SHA: testSHA})
+	syncer := &blockingGitOpsEngine{started: make(chan struct{}), release: make(chan struct{})}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	synchronised := make(chan plumbing.Hash)
+	go func() {
+		synchronised <- m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+	}()
+	<-syncer.started
+	rolledBack := make(chan recent.Synchronisation)
+	go func() {
+		s, err := m.Rollback(context.Background(), app, plumbing.ZeroHash)
+		if err != nil {
+			t.Error(err)
+		}
+		rolledBack <- s
+	}()
+	// Give the rollback time to wait for the synchronisation to finish.
+	time.Sleep(50 * time.Millisecond)
+	close(syncer.release)
+
+	if sha := <-synchronised; sha.String() != newSHA {
+		t.Fatalf("synchronise() got %s, want %s", sha, newSHA)
+	}
+	s := <-rolledBack
+	if s.SHA != testSHA || s.RollbackFrom != newSHA {
+		t.Fatalf("got %s rolled back from %q, want a rollback to %s from %s", s.SHA, s.RollbackFrom, testSHA, newSHA)
+	}
+	synced := syncer.synced()
+	if l := len(synced); l != 2 {
+		t.Fatalf("got %d syncs, want the synchronisation and the rollback", l)
+	}
+	if rev := synced[1].revision; rev != testSHA {
+		t.Fatalf("got revision %s after rolling back, want %s", rev, testSHA)
+	}
+}
+
+func TestRollbackWithNoPreviousCommit(t *testing.T) {
+	app := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+	app.Synchronisations.Record(recent.Synchronisation{SHA: testSHA})
+	m := &Manager{gitOpsEngine: &fakeGitOpsEngine{}, clusterCache: &fakeClusterCache{}}
+
+	_, err := m.Rollback(context.Background(), app, plumbing.ZeroHash)
+
+	if !errors.Is(err, ErrNoPreviousCommit) {
+		t.Fatalf("got error %v, want %v", err, ErrNoPreviousCommit)
+	}
+}
+
+func TestDeployWithUntrustedCommit(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.commits = map[plumbing.Hash][]*unstructured.Unstructured{
+		plumbing.NewHash(healthySHA): {makeConfigMap("test-ns", "deployed-cfg", "a")},
+	}
+	app := testApplication(repo)
+	app.Verifier = fakeVerifier{testSHA: "Test User"}
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	_, err := m.Deploy(context.Background(), app, plumbing.NewHash(healthySHA))
+
+	if !errors.Is(err, ErrUntrustedCommit) {
+		t.Fatalf("got error %v, want %v", err, ErrUntrustedCommit)
+	}
+	if l := len(syncer.synced()); l != 0 {
+		t.Fatalf("got %d syncs, want 0", l)
+	}
+	if p := app.Pinned(); p != "" {
+		t.Fatalf("got pinned %q, want no pin", p)
+	}
+	if r := app.Metrics.(*metrics.MockMetrics).Rejected; r != 1 {
+		t.Fatalf("got %d rejected, want 1", r)
+	}
+}
+
+func TestDeployWithTrustedCommit(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.commits = map[plumbing.Hash][]*unstructured.Unstructured{
+		plumbing.NewHash(healthySHA): {makeConfigMap("test-ns", "deployed-cfg", "a")},
+	}
+	app := testApplication(repo)
+	app.Verifier = fakeVerifier{healthySHA: "Test User"}
+	m := &Manager{gitOpsEngine: &fakeGitOpsEngine{}, clusterCache: &fakeClusterCache{}}
+
+	s, err := m.Deploy(context.Background(), app, plumbing.NewHash(healthySHA))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.SignedBy != "Test User" {
+		t.Fatalf("got signed by %q, want %q", s.SignedBy, "Test User")
+	}
+}
+
+func TestDeployInMonitorMode(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.commits = map[plumbing.Hash][]*unstructured.Unstructured{
+		plumbing.NewHash(healthySHA): {makeConfigMap("test-ns", "deployed-cfg", "a")},
+	}
+	app := testApplication(repo)
+	app.Config.Mode = MonitorMode
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	_, err := m.Deploy(context.Background(), app, plumbing.NewHash(healthySHA))
+
+	if !errors.Is(err, ErrMonitoring) {
+		t.Fatalf("got error %v, want %v", err, ErrMonitoring)
+	}
+	if l := len(syncer.synced()); l != 0 {
+		t.Fatalf("got %d syncs, want 0", l)
+	}
+}
+
+// blockingGitOpsEngine waits for release to be closed before synchronising,
+// started is closed when the first synchronisation starts.
+type blockingGitOpsEngine struct {
+	fakeGitOpsEngine
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingGitOpsEngine) Sync(ctx context.Context, resources []*unstructured.Unstructured, isManaged func(r *cache.Resource) bool, revision string, namespace string, opts ...gitopssync.SyncOpt) ([]common.ResourceSyncResult, error) {
+	b.once.Do(func() { close(b.started) })
+	<-b.release
+	return b.fakeGitOpsEngine.Sync(ctx, resources, isManaged, revision, namespace, opts...)
+}
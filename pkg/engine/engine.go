@@ -2,7 +2,9 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-git/go-git/v5"
@@ -10,7 +12,9 @@ import (
 
 	"github.com/argoproj/gitops-engine/pkg/cache"
 	"github.com/argoproj/gitops-engine/pkg/engine"
-	"github.com/argoproj/gitops-engine/pkg/sync"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	gitopssync "github.com/argoproj/gitops-engine/pkg/sync"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/client-go/rest"
@@ -27,77 +31,355 @@ type resourceInfo struct {
 	gcMark string
 }
 
-// StartPeanutSync starts watching the configured Git repository, and
-// synchronising the resources.
-func StartPeanutSync(clientConfig *rest.Config, config PeanutConfig, peanutRepo GitRepository, met metrics.Interface, syncs *recent.RecentSynchronisations, resync chan bool, done <-chan struct{}) error {
-	currentSHA, err := peanutRepo.HeadHash()
-	if err != nil {
-		return fmt.Errorf("failed to get the head hash: %w", err)
+// Application is a named Git repository that is synchronised to the cluster.
+type Application struct {
+	Name             string
+	Git              GitConfig
+	Config           PeanutConfig
+	Repository       GitRepository
+	Metrics          metrics.Interface
+	Synchronisations *recent.RecentSynchronisations
+	// Sending to Resync triggers an immediate synchronisation, if it's
+	// buffered, TriggerSync can queue a synchronisation while synchronising.
+	Resync chan bool
+	// OnSync is optional, and is called with each recorded synchronisation.
+	OnSync func(recent.Synchronisation)
+	// Verifier is optional, and if provided, only commits with trusted
+	// signatures are synchronised.
+	Verifier CommitVerifier
+	// StateStore is optional, and if provided, the pin and the suspension
+	// are saved to it, and restored when the application starts.
+	StateStore recent.StateStore
+
+	// mu is held while the repository is in use.
+	mu sync.Mutex
+
+	stateMu sync.Mutex
+	// pinned is the SHA that the application is held on until it's resumed,
+	// it's empty if the application is synchronised automatically.
+	pinned    string
+	suspended bool
+	// overrideUntil is when the override of the sync windows expires.
+	overrideUntil time.Time
+}
+
+// TriggerSync requests an immediate synchronisation without waiting, and
+// returns false if the request could not be queued, e.g. because a
+// synchronisation is already pending.
+func (a *Application) TriggerSync() bool {
+	select {
+	case a.Resync <- true:
+		return true
+	default:
+		return false
 	}
-	log.Infof("Starting synchronisation from commit: %s", currentSHA)
+}
 
-	namespaces := []string{}
-	if config.Namespaced {
-		namespaces = []string{config.Namespace}
+// record adds the synchronisation to the application's recent
+// synchronisations, and notifies the OnSync hook.
+func (a *Application) record(s recent.Synchronisation) {
+	s.End = time.Now()
+	s = a.Synchronisations.Record(s)
+	if a.OnSync != nil {
+		a.OnSync(s)
 	}
+}
+
+// Manager synchronises applications concurrently against a single shared
+// cluster cache.
+type Manager struct {
+	gitOpsEngine engine.GitOpsEngine
+	clusterCache liveStateCache
+	wg           sync.WaitGroup
+	// healthInterval is how often the health is assessed while waiting for
+	// the resources to settle, it defaults to defaultHealthInterval.
+	healthInterval time.Duration
+
+	mu           sync.RWMutex
+	applications map[string]*Application
+}
 
+// NewManager creates and returns a new Manager.
+//
+// The cluster cache is limited to the provided namespaces, if no namespaces are
+// provided, the cache is cluster-wide.
+func NewManager(clientConfig *rest.Config, namespaces []string) *Manager {
 	clusterCache := createClusterCache(namespaces, clientConfig)
-	gitOpsEngine := engine.NewEngine(clientConfig, clusterCache)
-	cleanup, err := gitOpsEngine.Run()
+	return &Manager{
+		gitOpsEngine: engine.NewEngine(clientConfig, clusterCache),
+		clusterCache: clusterCache,
+		applications: map[string]*Application{},
+	}
+}
+
+// Run initialises the cluster cache, and returns a function that should be
+// called to stop the engine.
+func (m *Manager) Run() (func(), error) {
+	cleanup, err := m.gitOpsEngine.Run()
 	if err != nil {
-		return fmt.Errorf("failed to start GitOps engine: %w", err)
+		return nil, fmt.Errorf("failed to start GitOps engine: %w", err)
 	}
-	defer cleanup()
+	return cleanup, nil
+}
 
+// Start starts synchronising the application until done is closed.
+//
+// The returned channel is closed when the synchronisation has stopped.
+func (m *Manager) Start(app *Application, done <-chan struct{}) <-chan struct{} {
+	stopped := make(chan struct{})
+	m.mu.Lock()
+	m.applications[app.Name] = app
+	m.mu.Unlock()
+	m.wg.Add(1)
 	go func() {
-		ticker := time.NewTicker(config.Resync)
-		for {
-			<-ticker.C
-			resync <- true
+		defer m.wg.Done()
+		defer close(stopped)
+		defer m.remove(app)
+		if err := m.run(app, done); err != nil {
+			log.WithField("application", app.Name).Errorf("Synchronisation failed: %s", err)
 		}
 	}()
+	return stopped
+}
+
+// Application returns the named running application, or nil if it's not
+// running.
+func (m *Manager) Application(name string) *Application {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.applications[name]
+}
+
+// Applications returns the running applications.
+func (m *Manager) Applications() []*Application {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	apps := []*Application{}
+	for _, v := range m.applications {
+		apps = append(apps, v)
+	}
+	return apps
+}
+
+func (m *Manager) remove(app *Application) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// The application may have been replaced by a new application with the
+	// same name.
+	if m.applications[app.Name] == app {
+		delete(m.applications, app.Name)
+	}
+}
+
+// Wait blocks until all started applications have stopped synchronising.
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// run watches the application's Git repository, and synchronises the
+// resources until done is closed.
+func (m *Manager) run(app *Application, done <-chan struct{}) error {
+	logger := log.WithField("application", app.Name)
+	currentSHA, err := app.Repository.HeadHash()
+	if err != nil {
+		return fmt.Errorf("failed to get the head hash: %w", err)
+	}
+	logger.Infof("Starting synchronisation from commit: %s", currentSHA)
+	if err := app.restoreState(); err != nil {
+		return fmt.Errorf("failed to restore the state: %w", err)
+	}
+	if app.Config.Mode == MonitorMode {
+		logger.Info("Monitoring, the resources are compared with the cluster, but not applied")
+	}
+
+	ticker := time.NewTicker(app.Config.Resync)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-resync:
-			log.Infof("Starting Synchronisation from %s", currentSHA)
-			start := time.Now()
-			newSHA, err := peanutRepo.Sync()
-			if err != nil && err != git.NoErrAlreadyUpToDate {
-				met.CountError()
-				log.Errorf("Failed to fetch updates to the repository: %s", err)
-				continue
-			}
-			if newSHA != currentSHA {
-				if newSHA != plumbing.ZeroHash {
-					log.Infof("New commit detected: previous SHA %s, new SHA %s", currentSHA, newSHA)
-					currentSHA = newSHA
-				}
-			}
-			targets, err := peanutRepo.ParseManifests()
-			if err != nil {
-				met.CountError()
-				log.Errorf("Failed to synchronize cluster state: %s", err)
-			}
+		case <-app.Resync:
+		case <-ticker.C:
+		case <-done:
+			logger.Println("Terminating synchronisation")
+			return nil
+		}
 
-			result, err := gitOpsEngine.Sync(
-				context.Background(), targets, peanutRepo.IsManaged,
-				currentSHA.String(), config.Namespace,
-				sync.WithPrune(config.Prune))
+		currentSHA = m.synchronise(app, currentSHA, done, logger)
+	}
+}
 
-			syncs.Add(start, time.Now(), currentSHA, err, result)
+// synchronise fetches the latest changes to the application's repository, and
+// applies the resources, returning the synchronised SHA.
+//
+// After applying the resources, this waits for them to settle, unless done is
+// closed.
+func (m *Manager) synchronise(app *Application, currentSHA plumbing.Hash, done <-chan struct{}, logger *log.Entry) plumbing.Hash {
+	app.mu.Lock()
+	defer app.mu.Unlock()
 
-			if err != nil {
-				met.CountError()
-				log.Infof("Failed to synchronize cluster state: %v", err)
-				continue
+	if pinned := app.Pinned(); pinned != "" {
+		logger.Infof("Pinned to %s, not synchronising until resumed", pinned)
+		return currentSHA
+	}
+	if app.Suspended() {
+		logger.Info("Suspended, not synchronising until resumed")
+		return currentSHA
+	}
+	windowOpen := app.SyncWindowOpen(time.Now())
+	if !windowOpen && !app.Config.DetectDriftOutsideWindows {
+		logger.Info("Outside the sync windows, not synchronising")
+		return currentSHA
+	}
+	logger.Infof("Starting Synchronisation from %s", currentSHA)
+	start := time.Now()
+	previousRef := app.Repository.Ref()
+	newSHA, err := app.Repository.Sync()
+	record := recent.Synchronisation{Start: start}
+	var rewritten *HistoryRewrittenError
+	if errors.As(err, &rewritten) {
+		app.Metrics.CountHistoryRewritten()
+		logger.Warnf("History of %s was rewritten: previous SHA %s is not an ancestor of new SHA %s", rewritten.Ref, rewritten.Previous, rewritten.Current)
+		record.HistoryRewritten = true
+		record.PreviousSHA = rewritten.Previous.String()
+		err = nil
+	}
+	var recloned *ReclonedError
+	if errors.As(err, &recloned) {
+		app.Metrics.CountReclone()
+		logger.Warnf("The clone was corrupted, and was cloned again: %s", recloned.Err)
+		record.Recloned = true
+		err = nil
+	}
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		app.Metrics.CountError()
+		logger.Errorf("Failed to fetch updates to the repository: %s", err)
+		return currentSHA
+	}
+	if app.Verifier != nil {
+		head, signer, err := verifyHead(app)
+		if err != nil {
+			app.Metrics.CountRejected()
+			record.SHA = head.String()
+			record.Ref = app.Repository.Ref()
+			record.Rejected = true
+			record.Error = err
+			app.record(record)
+			logger.Errorf("Refusing to synchronise, remaining on %s: %s", currentSHA, err)
+			// The untrusted commit is checked out, and would be parsed by
+			// plans.
+			if head != currentSHA && !currentSHA.IsZero() {
+				if err := app.Repository.Checkout(currentSHA, previousRef); err != nil {
+					app.Metrics.CountError()
+					logger.Errorf("Failed to restore %s: %s", currentSHA, err)
+				}
 			}
-			met.Record(result)
-		case <-done:
-			log.Println("Terminating synchronisation")
-			return nil
+			return currentSHA
 		}
+		record.SignedBy = signer
+	}
+	if newSHA != currentSHA {
+		if newSHA != plumbing.ZeroHash {
+			logger.Infof("New commit detected: previous SHA %s, new SHA %s", currentSHA, newSHA)
+			currentSHA = newSHA
+		}
+	}
+	if app.rolledBackFrom(currentSHA) {
+		logger.Infof("Remaining rolled back from %s until there is a newer commit", currentSHA)
+		return currentSHA
+	}
+	record.SHA = currentSHA.String()
+	record.Ref = app.Repository.Ref()
+	targets, err := app.Repository.ParseManifests()
+	record.Parser = app.Repository.Parser()
+	if err != nil {
+		app.Metrics.CountError()
+		record.Error = err
+		app.record(record)
+		logger.Errorf("Failed to parse manifests: %s", err)
+		return currentSHA
+	}
+	if app.Config.Mode == MonitorMode || !windowOpen {
+		if !windowOpen {
+			logger.Info("Outside the sync windows, comparing the resources without applying them")
+		}
+		m.monitor(app, targets, &record, logger)
+		app.record(record)
+		return currentSHA
+	}
+	err = m.apply(app, currentSHA, targets, &record, done, logger)
+	app.record(record)
+	if err != nil {
+		app.Metrics.CountError()
+		logger.Infof("Failed to synchronize cluster state: %v", err)
+		return currentSHA
+	}
+	app.Metrics.Record(record.Results)
+	if app.Config.AutoRollback && record.Health == health.HealthStatusDegraded {
+		m.rollback(app, currentSHA, done, logger)
 	}
+	return currentSHA
+}
+
+// apply synchronises the resources to the cluster, and waits for them to
+// settle, the results are recorded in the synchronisation.
+func (m *Manager) apply(app *Application, sha plumbing.Hash, targets []*unstructured.Unstructured, record *recent.Synchronisation, done <-chan struct{}, logger *log.Entry) error {
+	// The differences are informational, failing to calculate them doesn't
+	// prevent synchronisation.
+	diffs, err := m.diff(app, targets)
+	if err != nil {
+		logger.Errorf("Failed to compare resources with the cluster: %s", err)
+	} else {
+		app.Metrics.RecordOutOfSync(len(diffs))
+	}
+	record.Diffs = diffs
+
+	result, err := m.gitOpsEngine.Sync(
+		context.Background(), targets, app.Repository.IsManaged,
+		sha.String(), app.Config.Namespace,
+		gitopssync.WithPrune(app.Config.Prune))
+	record.Error = err
+	record.Results = result
+	if err != nil {
+		return err
+	}
+
+	status, resources, err := m.waitForHealth(app, targets, done)
+	if err != nil {
+		logger.Errorf("Failed to assess the health of the resources: %s", err)
+		return nil
+	}
+	record.Health = status
+	record.ResourceHealth = resources
+	app.Metrics.RecordHealth(status, countHealth(resources))
+	logger.Infof("Resources are %s", status)
+	return nil
+}
+
+// monitor compares the resources with the cluster, without applying them, the
+// resources that are out of sync are recorded in the synchronisation.
+func (m *Manager) monitor(app *Application, targets []*unstructured.Unstructured, record *recent.Synchronisation, logger *log.Entry) {
+	record.Monitored = true
+	diffs, err := m.diff(app, targets)
+	if err != nil {
+		app.Metrics.CountError()
+		record.Error = err
+		logger.Errorf("Failed to compare resources with the cluster: %s", err)
+		return
+	}
+	record.Diffs = diffs
+	app.Metrics.RecordOutOfSync(len(diffs))
+	logger.Infof("%d resources are out of sync", len(diffs))
+}
+
+// verifyHead verifies the signature of the checked out commit, and returns
+// the commit SHA and the signer.
+func verifyHead(app *Application) (plumbing.Hash, string, error) {
+	c, err := app.Repository.HeadCommit()
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+	signer, err := app.Verifier.Verify(c)
+	return c.Hash, signer, err
 }
 
 func infoHandler(un *unstructured.Unstructured, isRoot bool) (interface{}, bool) {
@@ -109,6 +391,13 @@ func infoHandler(un *unstructured.Unstructured, isRoot bool) (interface{}, bool)
 	return info, cacheManifest
 }
 
+// liveStateCache is the part of the cluster cache that is used to compare
+// resources with the live state.
+type liveStateCache interface {
+	kube.ResourceInfoProvider
+	GetManagedLiveObjs(targetObjs []*unstructured.Unstructured, isManaged func(r *cache.Resource) bool) (map[kube.ResourceKey]*unstructured.Unstructured, error)
+}
+
 func createClusterCache(namespaces []string, clientConfig *rest.Config) cache.ClusterCache {
 	return cache.NewClusterCache(
 		clientConfig,
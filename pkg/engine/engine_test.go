@@ -0,0 +1,566 @@
+package engine
+
+import (
+	"container/ring"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argoproj/gitops-engine/pkg/cache"
+	"github.com/argoproj/gitops-engine/pkg/engine"
+	gitopssync "github.com/argoproj/gitops-engine/pkg/sync"
+	"github.com/argoproj/gitops-engine/pkg/sync/common"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/metrics"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/kustomize"
+	"github.com/bigkevmcd/peanut-engine/pkg/plan"
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+const testSHA = "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f"
+
+func TestRun(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.parser = "kustomize"
+	syncer := &fakeGitOpsEngine{results: []common.ResourceSyncResult{{Status: common.ResultCodeSynced}}}
+	app := testApplication(repo)
+
+	runSync(t, syncer, app, func() {
+		app.Resync <- true
+	})
+
+	latest, ok := app.Synchronisations.Latest()
+	if !ok {
+		t.Fatal("no synchronisation was recorded")
+	}
+	if latest.SHA != testSHA {
+		t.Fatalf("got SHA %s, want %s", latest.SHA, testSHA)
+	}
+	if latest.Ref != "refs/heads/main" {
+		t.Fatalf("got ref %s, want %s", latest.Ref, "refs/heads/main")
+	}
+	if latest.Parser != "kustomize" {
+		t.Fatalf("got parser %s, want %s", latest.Parser, "kustomize")
+	}
+	if l := len(syncer.synced()); l != 1 {
+		t.Fatalf("got %d syncs, want 1", l)
+	}
+	if s := app.Metrics.(*metrics.MockMetrics).Synced; s != 1 {
+		t.Fatalf("got %d synced, want 1", s)
+	}
+}
+
+func TestRunWithParseFailure(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.parseErr = errors.New("failed to parse")
+	syncer := &fakeGitOpsEngine{}
+	app := testApplication(repo)
+
+	runSync(t, syncer, app, func() {
+		app.Resync <- true
+	})
+
+	if l := len(syncer.synced()); l != 0 {
+		t.Fatalf("got %d syncs, want 0", l)
+	}
+	latest, _ := app.Synchronisations.Latest()
+	if latest.Error != repo.parseErr {
+		t.Fatalf("got error %v, want %v", latest.Error, repo.parseErr)
+	}
+	if e := app.Metrics.(*metrics.MockMetrics).Errors; e != 1 {
+		t.Fatalf("got %d errors, want 1", e)
+	}
+}
+
+func TestRunWithRewrittenHistory(t *testing.T) {
+	previous := plumbing.NewHash("9b2e1ea1e5c1e2d3cc5d4b1a0e7b1f2c3d4e5f60")
+	repo := newFakeRepository(previous)
+	repo.syncHead = plumbing.NewHash(testSHA)
+	repo.syncErr = &HistoryRewrittenError{Ref: "refs/heads/main", Previous: previous, Current: repo.syncHead}
+	syncer := &fakeGitOpsEngine{}
+	app := testApplication(repo)
+
+	runSync(t, syncer, app, func() {
+		app.Resync <- true
+	})
+
+	latest, _ := app.Synchronisations.Latest()
+	if !latest.HistoryRewritten || latest.PreviousSHA != previous.String() {
+		t.Fatalf("got rewritten %v from %s, want true from %s", latest.HistoryRewritten, latest.PreviousSHA, previous)
+	}
+	if latest.SHA != testSHA || latest.Error != nil {
+		t.Fatalf("got SHA %s and error %v, want %s and no error", latest.SHA, latest.Error, testSHA)
+	}
+	if l := len(syncer.synced()); l != 1 {
+		t.Fatalf("got %d syncs, want 1", l)
+	}
+	if r := app.Metrics.(*metrics.MockMetrics).Rewritten; r != 1 {
+		t.Fatalf("got %d rewritten, want 1", r)
+	}
+}
+
+func TestRunWithReclonedRepository(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.syncHead = plumbing.NewHash(testSHA)
+	repo.syncErr = &ReclonedError{Err: plumbing.ErrObjectNotFound}
+	syncer := &fakeGitOpsEngine{}
+	app := testApplication(repo)
+
+	runSync(t, syncer, app, func() {
+		app.Resync <- true
+	})
+
+	latest, _ := app.Synchronisations.Latest()
+	if !latest.Recloned || latest.Error != nil {
+		t.Fatalf("got recloned %v and error %v, want true and no error", latest.Recloned, latest.Error)
+	}
+	if l := len(syncer.synced()); l != 1 {
+		t.Fatalf("got %d syncs, want 1", l)
+	}
+	m := app.Metrics.(*metrics.MockMetrics)
+	if m.Reclones != 1 || m.Errors != 0 {
+		t.Fatalf("got %d reclones and %d errors, want 1 and 0", m.Reclones, m.Errors)
+	}
+}
+
+func TestRunWithTrustedSignature(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	syncer := &fakeGitOpsEngine{}
+	app := testApplication(repo)
+	app.Verifier = fakeVerifier{testSHA: "Test User"}
+
+	runSync(t, syncer, app, func() {
+		app.Resync <- true
+	})
+
+	latest, _ := app.Synchronisations.Latest()
+	if latest.SignedBy != "Test User" || latest.Rejected {
+		t.Fatalf("got signer %q and rejected %v, want %q and false", latest.SignedBy, latest.Rejected, "Test User")
+	}
+	if l := len(syncer.synced()); l != 1 {
+		t.Fatalf("got %d syncs, want 1", l)
+	}
+}
+
+func TestRunWithUntrustedSignature(t *testing.T) {
+	verified := plumbing.NewHash("9b2e1ea1e5c1e2d3cc5d4b1a0e7b1f2c3d4e5f60")
+	repo := newFakeRepository(verified)
+	syncer := &fakeGitOpsEngine{}
+	app := testApplication(repo)
+	app.Verifier = fakeVerifier{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+	repo.head = plumbing.NewHash(testSHA)
+	repo.syncHead = repo.head
+	repo.syncErr = &HistoryRewrittenError{Ref: "refs/heads/main", Previous: verified, Current: repo.head}
+
+	sha := m.synchronise(app, verified, nil, log.WithField("application", app.Name))
+
+	if sha != verified {
+		t.Fatalf("synchronise() got %s, want %s", sha, verified)
+	}
+	if l := len(syncer.synced()); l != 0 {
+		t.Fatalf("got %d syncs, want 0", l)
+	}
+	latest, _ := app.Synchronisations.Latest()
+	if !latest.Rejected || latest.SHA != testSHA || latest.Error == nil {
+		t.Fatalf("got rejected %v for %s with error %v, want a rejection of %s", latest.Rejected, latest.SHA, latest.Error, testSHA)
+	}
+	if r := app.Metrics.(*metrics.MockMetrics).Rejected; r != 1 {
+		t.Fatalf("got %d rejected, want 1", r)
+	}
+}
+
+func TestSynchroniseRestoresTheTrustedCommit(t *testing.T) {
+	source := makeGitRepository(t)
+	writeConfigMap(t, source, "trusted-cfg")
+	repo := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+	assertNoError(t, repo.Clone(mkTempDir(t)))
+	trusted, err := repo.HeadHash()
+	assertNoError(t, err)
+	writeConfigMap(t, source, "untrusted-cfg")
+	syncer := &fakeGitOpsEngine{}
+	app := testApplication(repo)
+	app.Verifier = fakeVerifier{trusted.String(): "Test User"}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	sha := m.synchronise(app, trusted, nil, log.WithField("application", app.Name))
+
+	if sha != trusted {
+		t.Fatalf("synchronise() got %s, want %s", sha, trusted)
+	}
+	latest, _ := app.Synchronisations.Latest()
+	if want := execGit(t, source, "rev-parse", "HEAD"); !latest.Rejected || latest.SHA != want {
+		t.Fatalf("got rejected %v for %s, want a rejection of %s", latest.Rejected, latest.SHA, want)
+	}
+	p, err := m.Plan(app)
+	assertNoError(t, err)
+	want := &plan.Plan{
+		SHA: trusted.String(),
+		Resources: []plan.ResourceChange{
+			{Kind: "ConfigMap", Namespace: "test-ns", Name: "trusted-cfg", Action: plan.ActionCreate},
+		},
+	}
+	if diff := cmp.Diff(want, p); diff != "" {
+		t.Fatalf("plan failed:\n%s", diff)
+	}
+	assertHead(t, repo, trusted.String())
+}
+
+func TestRunNotifiesOnSync(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	app := testApplication(repo)
+	var notified []recent.Synchronisation
+	app.OnSync = func(s recent.Synchronisation) {
+		notified = append(notified, s)
+	}
+
+	runSync(t, &fakeGitOpsEngine{}, app, func() {
+		app.Resync <- true
+	})
+
+	if l := len(notified); l != 1 {
+		t.Fatalf("got %d notifications, want 1", l)
+	}
+	if sha := notified[0].SHA; sha != testSHA {
+		t.Fatalf("got SHA %s, want %s", sha, testSHA)
+	}
+}
+
+func TestSynchroniseRecordsDiffs(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{
+		makeConfigMap("test-ns", "new-cfg", "a"),
+		makeConfigMap("test-ns", "existing-cfg", "b"),
+		makeConfigMap("test-ns", "same-cfg", "a"),
+	}
+	app := testApplication(repo)
+	m := &Manager{
+		gitOpsEngine: &fakeGitOpsEngine{},
+		clusterCache: &fakeClusterCache{
+			live: map[kube.ResourceKey]*unstructured.Unstructured{
+				kube.NewResourceKey("", "ConfigMap", "test-ns", "existing-cfg"): makeConfigMap("test-ns", "existing-cfg", "a"),
+				kube.NewResourceKey("", "ConfigMap", "test-ns", "same-cfg"):     makeConfigMap("test-ns", "same-cfg", "a"),
+			},
+		},
+	}
+
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	latest, _ := app.Synchronisations.Latest()
+	names := []string{}
+	for _, v := range latest.Diffs {
+		names = append(names, v.Name)
+	}
+	if diff := cmp.Diff([]string{"new-cfg", "existing-cfg"}, names); diff != "" {
+		t.Fatalf("recorded diffs:\n%s", diff)
+	}
+	if n := app.Metrics.(*metrics.MockMetrics).OutOfSync; n != 2 {
+		t.Fatalf("got %d out of sync, want 2", n)
+	}
+}
+
+func TestSynchroniseDoesNotRecordDecryptedSecrets(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	// The SOPS parser returns the decrypted values.
+	repo.resources = []*unstructured.Unstructured{
+		makeSecret("test-ns", "test-secret", map[string]interface{}{"stringData": map[string]interface{}{"password": "decrypted-password"}}),
+	}
+	dir := mkTempDir(t)
+	store, err := recent.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := testApplication(repo)
+	app.Synchronisations, err = recent.LoadRecentSynchronisations(app.Name, ring.New(5), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{
+		gitOpsEngine: &fakeGitOpsEngine{},
+		clusterCache: &fakeClusterCache{
+			live: map[kube.ResourceKey]*unstructured.Unstructured{
+				kube.NewResourceKey("", "Secret", "test-ns", "test-secret"): makeSecret("test-ns", "test-secret", map[string]interface{}{"data": map[string]interface{}{
+					"password": base64.StdEncoding.EncodeToString([]byte("previous-password")),
+				}}),
+			},
+		},
+	}
+
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	latest, _ := app.Synchronisations.Latest()
+	if l := len(latest.Diffs); l != 1 {
+		t.Fatalf("got %d diffs, want 1", l)
+	}
+	recorded, err := json.Marshal(latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(filepath.Join(dir, app.Name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"decrypted-password", "previous-password"} {
+		for _, secret := range []string{v, base64.StdEncoding.EncodeToString([]byte(v))} {
+			if strings.Contains(string(recorded), secret) || strings.Contains(string(saved), secret) {
+				t.Fatalf("the synchronisation contains %q", secret)
+			}
+		}
+	}
+}
+
+func TestSynchroniseInMonitorMode(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{
+		makeConfigMap("test-ns", "existing-cfg", "b"),
+		makeConfigMap("test-ns", "same-cfg", "a"),
+	}
+	app := testApplication(repo)
+	app.Config.Mode = MonitorMode
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{
+		gitOpsEngine: syncer,
+		clusterCache: &fakeClusterCache{
+			live: map[kube.ResourceKey]*unstructured.Unstructured{
+				kube.NewResourceKey("", "ConfigMap", "test-ns", "existing-cfg"): makeConfigMap("test-ns", "existing-cfg", "a"),
+				kube.NewResourceKey("", "ConfigMap", "test-ns", "same-cfg"):     makeConfigMap("test-ns", "same-cfg", "a"),
+			},
+		},
+	}
+
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	if l := len(syncer.synced()); l != 0 {
+		t.Fatalf("got %d syncs, want 0", l)
+	}
+	latest, _ := app.Synchronisations.Latest()
+	if !latest.Monitored || latest.SHA != testSHA {
+		t.Fatalf("got %s monitored %v, want a monitored synchronisation of %s", latest.SHA, latest.Monitored, testSHA)
+	}
+	if l := len(latest.Diffs); l != 1 || latest.Diffs[0].Name != "existing-cfg" {
+		t.Fatalf("got diffs %#v, want existing-cfg", latest.Diffs)
+	}
+	if n := app.Metrics.(*metrics.MockMetrics).OutOfSync; n != 1 {
+		t.Fatalf("got %d out of sync, want 1", n)
+	}
+}
+
+func TestTriggerSync(t *testing.T) {
+	app := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+	app.Resync = make(chan bool, 1)
+
+	if !app.TriggerSync() {
+		t.Fatal("TriggerSync() did not queue a synchronisation")
+	}
+	if app.TriggerSync() {
+		t.Fatal("TriggerSync() queued a second synchronisation")
+	}
+}
+
+func TestManagerStartsApplications(t *testing.T) {
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}, applications: map[string]*Application{}}
+	app1 := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+	app1.Name = "app-1"
+	app2 := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+	app2.Name = "app-2"
+	done := make(chan struct{})
+
+	stopped := m.Start(app1, done)
+	m.Start(app2, done)
+	app1.Resync <- true
+	app2.Resync <- true
+	app1.Resync <- true
+	app2.Resync <- true
+	if app := m.Application("app-1"); app != app1 {
+		t.Fatalf("Application() got %v, want %v", app, app1)
+	}
+	if l := len(m.Applications()); l != 2 {
+		t.Fatalf("Applications() got %d, want 2", l)
+	}
+	close(done)
+	<-stopped
+	m.Wait()
+
+	if l := len(syncer.synced()); l != 4 {
+		t.Fatalf("got %d syncs, want 4", l)
+	}
+	if l := len(m.Applications()); l != 0 {
+		t.Fatalf("Applications() got %d after stopping, want 0", l)
+	}
+}
+
+// runSync runs the synchronisation loop, calls the provided function, and
+// then terminates the loop.
+//
+// Synchronisations triggered by the function will be completed before this
+// returns.
+func runSync(t *testing.T, e engine.GitOpsEngine, app *Application, f func()) {
+	t.Helper()
+	m := &Manager{gitOpsEngine: e, clusterCache: &fakeClusterCache{}}
+	done := make(chan struct{})
+	errc := make(chan error)
+	go func() {
+		errc <- m.run(app, done)
+	}()
+	f()
+	// Closing done terminates the loop after the current synchronisation
+	// completes.
+	close(done)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func makeSecret(ns, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	obj := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": ns,
+		},
+	}
+	for k, v := range fields {
+		obj[k] = v
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func testApplication(repo GitRepository) *Application {
+	return &Application{
+		Name:             "test-app",
+		Config:           PeanutConfig{Namespace: "test-ns", Resync: time.Hour},
+		Repository:       repo,
+		Metrics:          metrics.NewMock(),
+		Synchronisations: recent.NewRecentSynchronisations(ring.New(5)),
+		Resync:           make(chan bool),
+	}
+}
+
+type fakeSync struct {
+	resources []*unstructured.Unstructured
+	revision  string
+	namespace string
+}
+
+type fakeGitOpsEngine struct {
+	mu      sync.Mutex
+	syncs   []fakeSync
+	results []common.ResourceSyncResult
+	err     error
+}
+
+func (f *fakeGitOpsEngine) Run() (engine.StopFunc, error) {
+	return func() {}, nil
+}
+
+func (f *fakeGitOpsEngine) Sync(ctx context.Context, resources []*unstructured.Unstructured, isManaged func(r *cache.Resource) bool, revision string, namespace string, opts ...gitopssync.SyncOpt) ([]common.ResourceSyncResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.syncs = append(f.syncs, fakeSync{resources: resources, revision: revision, namespace: namespace})
+	return f.results, f.err
+}
+
+func (f *fakeGitOpsEngine) synced() []fakeSync {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.syncs
+}
+
+// fakeVerifier trusts the commits with SHAs in the map, and returns the
+// signer.
+type fakeVerifier map[string]string
+
+func (f fakeVerifier) Verify(c *object.Commit) (string, error) {
+	if signer, ok := f[c.Hash.String()]; ok {
+		return signer, nil
+	}
+	return "", fmt.Errorf("commit %s is not trusted", c.Hash)
+}
+
+type fakeRepository struct {
+	head      plumbing.Hash
+	resources []*unstructured.Unstructured
+	parseErr  error
+	syncHead  plumbing.Hash
+	syncErr   error
+	parser    string
+	// commits are the resources parsed from other commits.
+	commits map[plumbing.Hash][]*unstructured.Unstructured
+}
+
+func newFakeRepository(head plumbing.Hash) *fakeRepository {
+	return &fakeRepository{head: head}
+}
+
+func (f *fakeRepository) Clone(string) error {
+	return nil
+}
+
+func (f *fakeRepository) Open(string) error {
+	return nil
+}
+
+func (f *fakeRepository) HeadHash() (plumbing.Hash, error) {
+	return f.head, nil
+}
+
+func (f *fakeRepository) HeadCommit() (*object.Commit, error) {
+	return &object.Commit{Hash: f.head, Message: "Test commit"}, nil
+}
+
+func (f *fakeRepository) CommitAt(h plumbing.Hash) (*object.Commit, error) {
+	return &object.Commit{Hash: h, Message: "Test commit"}, nil
+}
+
+func (f *fakeRepository) Sync() (plumbing.Hash, error) {
+	if f.syncErr != nil || !f.syncHead.IsZero() {
+		return f.syncHead, f.syncErr
+	}
+	return plumbing.ZeroHash, git.NoErrAlreadyUpToDate
+}
+
+func (f *fakeRepository) Checkout(h plumbing.Hash, ref string) error {
+	f.head = h
+	return nil
+}
+
+func (f *fakeRepository) Ref() string {
+	return "refs/heads/main"
+}
+
+func (f *fakeRepository) ParseManifests() ([]*unstructured.Unstructured, error) {
+	return f.resources, f.parseErr
+}
+
+func (f *fakeRepository) ParseManifestsAt(h plumbing.Hash) ([]*unstructured.Unstructured, error) {
+	res, ok := f.commits[h]
+	if !ok {
+		return nil, fmt.Errorf("failed to get commit %s", h)
+	}
+	return res, nil
+}
+
+func (f *fakeRepository) Parser() string {
+	return f.parser
+}
+
+func (f *fakeRepository) IsManaged(r *cache.Resource) bool {
+	return true
+}
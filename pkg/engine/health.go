@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+// defaultHealthInterval is how often the health is assessed while waiting for
+// the resources to settle.
+const defaultHealthInterval = 5 * time.Second
+
+// waitForHealth assesses the health of the application's resources until
+// they are no longer progressing, the application's health timeout expires,
+// or done is closed.
+//
+// If the health timeout is zero, the health is assessed once.
+func (m *Manager) waitForHealth(app *Application, targets []*unstructured.Unstructured, done <-chan struct{}) (health.HealthStatusCode, []recent.ResourceHealth, error) {
+	deadline := time.Now().Add(app.Config.HealthTimeout)
+	for {
+		status, resources, err := m.assessHealth(app, targets)
+		remaining := time.Until(deadline)
+		if err != nil || settled(status) || remaining <= 0 {
+			return status, resources, err
+		}
+		interval := m.healthInterval
+		if interval == 0 {
+			interval = defaultHealthInterval
+		}
+		if remaining < interval {
+			interval = remaining
+		}
+		select {
+		case <-done:
+			return status, resources, nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// assessHealth returns the aggregate health of the application's resources
+// in the cluster, and the health of each resource that has a health check.
+//
+// The aggregate health is the worst health of the resources.
+func (m *Manager) assessHealth(app *Application, targets []*unstructured.Unstructured) (health.HealthStatusCode, []recent.ResourceHealth, error) {
+	target, live, err := m.reconcile(app, targets)
+	if err != nil {
+		return "", nil, err
+	}
+	status := health.HealthStatusHealthy
+	resources := []recent.ResourceHealth{}
+	for i, t := range target {
+		// Resources that are pruned have no target.
+		if t == nil {
+			continue
+		}
+		h := &health.HealthStatus{Status: health.HealthStatusMissing}
+		if live[i] != nil {
+			// Failed health checks are reported as Unknown.
+			h, _ = health.GetResourceHealth(live[i], crdHealth{})
+			if h == nil {
+				continue
+			}
+		}
+		key := kube.GetResourceKey(t)
+		resources = append(resources, recent.ResourceHealth{
+			Group:     key.Group,
+			Kind:      key.Kind,
+			Namespace: key.Namespace,
+			Name:      key.Name,
+			Status:    h.Status,
+			Message:   h.Message,
+		})
+		if health.IsWorse(status, h.Status) {
+			status = h.Status
+		}
+	}
+	return status, resources, nil
+}
+
+// settled returns false if the resources can still become healthy, resources
+// can be missing from the cluster cache immediately after synchronising.
+func settled(status health.HealthStatusCode) bool {
+	return status != health.HealthStatusProgressing && status != health.HealthStatusMissing
+}
+
+// countHealth returns the number of resources with each health status.
+func countHealth(resources []recent.ResourceHealth) map[health.HealthStatusCode]int {
+	counts := map[health.HealthStatusCode]int{}
+	for _, v := range resources {
+		counts[v.Status]++
+	}
+	return counts
+}
+
+// crdHealth is a health.HealthOverride that assesses
+// CustomResourceDefinitions, which have no built-in health check.
+type crdHealth struct{}
+
+func (crdHealth) GetResourceHealth(obj *unstructured.Unstructured) (*health.HealthStatus, error) {
+	if !kube.IsCRD(obj) {
+		return nil, nil
+	}
+	conditions, _, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the conditions: %w", err)
+	}
+	h := &health.HealthStatus{
+		Status:  health.HealthStatusProgressing,
+		Message: "Waiting for the CustomResourceDefinition to be established",
+	}
+	for _, v := range conditions {
+		c, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch {
+		case c["type"] == "Established" && c["status"] == "True":
+			return &health.HealthStatus{Status: health.HealthStatusHealthy}, nil
+		case c["type"] == "NamesAccepted" && c["status"] == "False":
+			message, _ := c["message"].(string)
+			h = &health.HealthStatus{Status: health.HealthStatusDegraded, Message: message}
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/argoproj/gitops-engine/pkg/cache"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/metrics"
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+func TestAssessHealth(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{
+		makeDeployment("test-ns", "available", 1),
+		makeDeployment("test-ns", "missing", 1),
+		makeConfigMap("test-ns", "no-health-check", "a"),
+	}
+	m := &Manager{
+		clusterCache: &fakeClusterCache{
+			live: map[kube.ResourceKey]*unstructured.Unstructured{
+				kube.NewResourceKey("apps", "Deployment", "test-ns", "available"):  makeDeployment("test-ns", "available", 1),
+				kube.NewResourceKey("", "ConfigMap", "test-ns", "no-health-check"): makeConfigMap("test-ns", "no-health-check", "a"),
+			},
+		},
+	}
+
+	status, resources, err := m.assessHealth(testApplication(repo), repo.resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status != health.HealthStatusMissing {
+		t.Fatalf("got health %s, want %s", status, health.HealthStatusMissing)
+	}
+	want := []recent.ResourceHealth{
+		{Group: "apps", Kind: "Deployment", Namespace: "test-ns", Name: "available", Status: health.HealthStatusHealthy},
+		{Group: "apps", Kind: "Deployment", Namespace: "test-ns", Name: "missing", Status: health.HealthStatusMissing},
+	}
+	if diff := cmp.Diff(want, resources); diff != "" {
+		t.Fatalf("resource health:\n%s", diff)
+	}
+}
+
+func TestWaitForHealth(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{makeDeployment("test-ns", "taxi", 1)}
+	key := kube.NewResourceKey("apps", "Deployment", "test-ns", "taxi")
+	m := &Manager{
+		clusterCache: &sequenceClusterCache{
+			states: []map[kube.ResourceKey]*unstructured.Unstructured{
+				{},
+				{key: makeDeployment("test-ns", "taxi", 0)},
+				{key: makeDeployment("test-ns", "taxi", 1)},
+			},
+		},
+		healthInterval: time.Millisecond,
+	}
+	app := testApplication(repo)
+	app.Config.HealthTimeout = time.Minute
+
+	status, _, err := m.waitForHealth(app, repo.resources, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status != health.HealthStatusHealthy {
+		t.Fatalf("got health %s, want %s", status, health.HealthStatusHealthy)
+	}
+}
+
+func TestWaitForHealthWithTimeout(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{makeDeployment("test-ns", "taxi", 1)}
+	m := &Manager{
+		clusterCache: &fakeClusterCache{
+			live: map[kube.ResourceKey]*unstructured.Unstructured{
+				kube.NewResourceKey("apps", "Deployment", "test-ns", "taxi"): makeDeployment("test-ns", "taxi", 0),
+			},
+		},
+		healthInterval: time.Millisecond,
+	}
+	app := testApplication(repo)
+	app.Config.HealthTimeout = 20 * time.Millisecond
+
+	status, resources, err := m.waitForHealth(app, repo.resources, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status != health.HealthStatusProgressing {
+		t.Fatalf("got health %s, want %s", status, health.HealthStatusProgressing)
+	}
+	if msg := resources[0].Message; msg == "" {
+		t.Fatal("no message for the progressing deployment")
+	}
+}
+
+func TestSynchroniseRecordsHealth(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{makeDeployment("test-ns", "taxi", 1)}
+	app := testApplication(repo)
+	m := &Manager{
+		gitOpsEngine: &fakeGitOpsEngine{},
+		clusterCache: &fakeClusterCache{
+			live: map[kube.ResourceKey]*unstructured.Unstructured{
+				kube.NewResourceKey("apps", "Deployment", "test-ns", "taxi"): makeDeployment("test-ns", "taxi", 1),
+			},
+		},
+	}
+
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	latest, _ := app.Synchronisations.Latest()
+	if latest.Health != health.HealthStatusHealthy {
+		t.Fatalf("got health %s, want %s", latest.Health, health.HealthStatusHealthy)
+	}
+	if l := len(latest.ResourceHealth); l != 1 {
+		t.Fatalf("got %d resources, want 1", l)
+	}
+	mock := app.Metrics.(*metrics.MockMetrics)
+	if mock.Health != health.HealthStatusHealthy || mock.ResourceHealth[health.HealthStatusHealthy] != 1 {
+		t.Fatalf("got health metrics %s %v", mock.Health, mock.ResourceHealth)
+	}
+}
+
+func TestCRDHealth(t *testing.T) {
+	healthTests := []struct {
+		name       string
+		conditions []interface{}
+		want       health.HealthStatusCode
+	}{
+		{"no conditions", nil, health.HealthStatusProgressing},
+		{"established", []interface{}{
+			map[string]interface{}{"type": "NamesAccepted", "status": "True"},
+			map[string]interface{}{"type": "Established", "status": "True"},
+		}, health.HealthStatusHealthy},
+		{"names not accepted", []interface{}{
+			map[string]interface{}{"type": "NamesAccepted", "status": "False", "message": "conflict"},
+			map[string]interface{}{"type": "Established", "status": "False"},
+		}, health.HealthStatusDegraded},
+	}
+
+	for _, tt := range healthTests {
+		t.Run(tt.name, func(t *testing.T) {
+			crd := &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "apiextensions.k8s.io/v1",
+				"kind":       "CustomResourceDefinition",
+				"metadata":   map[string]interface{}{"name": "taxis.example.com"},
+			}}
+			if tt.conditions != nil {
+				if err := unstructured.SetNestedSlice(crd.Object, tt.conditions, "status", "conditions"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			h, err := health.GetResourceHealth(crd, crdHealth{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if h.Status != tt.want {
+				t.Fatalf("got health %s, want %s", h.Status, tt.want)
+			}
+		})
+	}
+}
+
+// makeDeployment returns a Deployment with a single replica, that has the
+// provided number of updated replicas.
+func makeDeployment(ns, name string, updated int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":       name,
+				"namespace":  ns,
+				"generation": int64(1),
+			},
+			"spec": map[string]interface{}{
+				"replicas": int64(1),
+			},
+			"status": map[string]interface{}{
+				"observedGeneration": int64(1),
+				"replicas":           int64(1),
+				"updatedReplicas":    updated,
+				"availableReplicas":  updated,
+			},
+		},
+	}
+}
+
+// sequenceClusterCache returns each of the states in turn, and then the last
+// state.
+type sequenceClusterCache struct {
+	fakeClusterCache
+	states []map[kube.ResourceKey]*unstructured.Unstructured
+	calls  int
+}
+
+func (f *sequenceClusterCache) GetManagedLiveObjs(targetObjs []*unstructured.Unstructured, isManaged func(r *cache.Resource) bool) (map[kube.ResourceKey]*unstructured.Unstructured, error) {
+	f.live = f.states[len(f.states)-1]
+	if f.calls < len(f.states) {
+		f.live = f.states[f.calls]
+	}
+	f.calls++
+	return f.fakeClusterCache.GetManagedLiveObjs(targetObjs, isManaged)
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 
 	"github.com/argoproj/gitops-engine/pkg/cache"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -12,7 +13,19 @@ type GitRepository interface {
 	Clone(string) error
 	Open(string) error
 	HeadHash() (plumbing.Hash, error)
+	HeadCommit() (*object.Commit, error)
+	CommitAt(plumbing.Hash) (*object.Commit, error)
 	Sync() (plumbing.Hash, error)
+	Checkout(plumbing.Hash, string) error
+	Ref() string
 	ParseManifests() ([]*unstructured.Unstructured, error)
+	ParseManifestsAt(plumbing.Hash) ([]*unstructured.Unstructured, error)
+	Parser() string
 	IsManaged(r *cache.Resource) bool
 }
+
+// CommitVerifier verifies the signature of a commit, and returns a
+// description of the key that signed it.
+type CommitVerifier interface {
+	Verify(*object.Commit) (string, error)
+}
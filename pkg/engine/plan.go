@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"fmt"
+
+	gitopssync "github.com/argoproj/gitops-engine/pkg/sync"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/go-git/go-git/v5/plumbing"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/diff"
+	"github.com/bigkevmcd/peanut-engine/pkg/plan"
+)
+
+// Plan compares the application's manifests with the live resources in the
+// cluster, and returns the changes that a synchronisation would make, without
+// applying them.
+func (m *Manager) Plan(app *Application) (*plan.Plan, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	sha, err := app.Repository.HeadHash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the head hash: %w", err)
+	}
+	targets, err := app.Repository.ParseManifests()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifests: %w", err)
+	}
+	return m.plan(app, sha, targets)
+}
+
+func (m *Manager) plan(app *Application, sha plumbing.Hash, targets []*unstructured.Unstructured) (*plan.Plan, error) {
+	target, live, err := m.reconcile(app, targets)
+	if err != nil {
+		return nil, err
+	}
+	return plan.Compute(sha.String(), target, live, app.Config.Prune)
+}
+
+// diff returns the differences between the application's manifests and the
+// live resources in the cluster.
+func (m *Manager) diff(app *Application, targets []*unstructured.Unstructured) ([]diff.ResourceDiff, error) {
+	target, live, err := m.reconcile(app, targets)
+	if err != nil {
+		return nil, err
+	}
+	return diff.Compute(target, live)
+}
+
+// reconcile pairs the target resources with the live resources in the
+// cluster.
+func (m *Manager) reconcile(app *Application, targets []*unstructured.Unstructured) ([]*unstructured.Unstructured, []*unstructured.Unstructured, error) {
+	live, err := m.clusterCache.GetManagedLiveObjs(targets, app.Repository.IsManaged)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the live resources: %w", err)
+	}
+	result := gitopssync.Reconcile(targets, live, app.Config.Namespace, m.clusterCache)
+	// The synchronisation would create resources in the application's
+	// namespace if they don't specify one.
+	for _, v := range result.Target {
+		if v == nil || v.GetNamespace() != "" {
+			continue
+		}
+		if kube.IsNamespacedOrUnknown(m.clusterCache, v.GroupVersionKind().GroupKind()) {
+			v.SetNamespace(app.Config.Namespace)
+		}
+	}
+	return result.Target, result.Live, nil
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/argoproj/gitops-engine/pkg/cache"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/plan"
+)
+
+func TestPlan(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{
+		makeConfigMap("", "new-cfg", "a"),
+		makeConfigMap("", "existing-cfg", "b"),
+	}
+	live := &fakeClusterCache{
+		live: map[kube.ResourceKey]*unstructured.Unstructured{
+			kube.NewResourceKey("", "ConfigMap", "test-ns", "existing-cfg"): makeConfigMap("test-ns", "existing-cfg", "a"),
+			kube.NewResourceKey("", "ConfigMap", "test-ns", "old-cfg"):      makeConfigMap("test-ns", "old-cfg", "a"),
+		},
+	}
+	m := &Manager{clusterCache: live}
+	app := testApplication(repo)
+	app.Config.Prune = true
+
+	p, err := m.Plan(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &plan.Plan{
+		SHA: testSHA,
+		Resources: []plan.ResourceChange{
+			{Kind: "ConfigMap", Namespace: "test-ns", Name: "new-cfg", Action: plan.ActionCreate},
+			{Kind: "ConfigMap", Namespace: "test-ns", Name: "existing-cfg", Action: plan.ActionUpdate},
+			{Kind: "ConfigMap", Namespace: "test-ns", Name: "old-cfg", Action: plan.ActionPrune},
+		},
+	}
+	if diff := cmp.Diff(want, p); diff != "" {
+		t.Fatalf("plan failed:\n%s", diff)
+	}
+}
+
+func makeConfigMap(ns, name, value string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name": name,
+			},
+			"data": map[string]interface{}{
+				"value": value,
+			},
+		},
+	}
+	if ns != "" {
+		u.SetNamespace(ns)
+	}
+	return u
+}
+
+type fakeClusterCache struct {
+	live map[kube.ResourceKey]*unstructured.Unstructured
+}
+
+func (f *fakeClusterCache) IsNamespaced(gk schema.GroupKind) (bool, error) {
+	return gk.Kind != "Namespace", nil
+}
+
+func (f *fakeClusterCache) GetManagedLiveObjs(targetObjs []*unstructured.Unstructured, isManaged func(r *cache.Resource) bool) (map[kube.ResourceKey]*unstructured.Unstructured, error) {
+	live := map[kube.ResourceKey]*unstructured.Unstructured{}
+	for k, v := range f.live {
+		live[k] = v.DeepCopy()
+		// Reconciliation deduplicates the live resources by UID.
+		live[k].SetUID(types.UID(k.String()))
+	}
+	return live, nil
+}
@@ -3,23 +3,28 @@ package engine
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 
 	"github.com/argoproj/gitops-engine/pkg/cache"
 	"github.com/argoproj/gitops-engine/pkg/utils/kube"
 	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+	"github.com/bigkevmcd/peanut-engine/pkg/transform"
+	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
-var defaultRefSpecs = []config.RefSpec{
-	config.RefSpec("+refs/heads/*:refs/remotes/origin/*"),
-}
+// tagsRefSpec fetches the tags when deploying a revision, so that tags that
+// are moved, and new tags that match a semver constraint are found.
+const tagsRefSpec = config.RefSpec("+refs/tags/*:refs/tags/*")
 
 const (
 	defaultRemoteName = "origin"
@@ -32,14 +37,22 @@ type PeanutRepository struct {
 	remoteName string
 	repoPath   string
 	parser     parser.ManifestParser
+	// ref is the ref that was last checked out.
+	ref string
+	// parserName is the parser that was detected by the last ParseManifests.
+	parserName string
+	// transformers are applied in order to each parsed resource.
+	transformers []transform.Transformer
 }
 
-// NewRepository creates and returns a new PeanutRepository.
-func NewRepository(cfg GitConfig, p parser.ManifestParser) *PeanutRepository {
+// NewRepository creates and returns a new PeanutRepository, the parsed
+// manifests are modified by the transformers.
+func NewRepository(cfg GitConfig, p parser.ManifestParser, transformers ...transform.Transformer) *PeanutRepository {
 	return &PeanutRepository{
-		config:     cfg,
-		remoteName: defaultRemoteName,
-		parser:     p,
+		config:       cfg,
+		remoteName:   defaultRemoteName,
+		parser:       p,
+		transformers: transformers,
 	}
 }
 
@@ -49,32 +62,131 @@ func NewRepository(cfg GitConfig, p parser.ManifestParser) *PeanutRepository {
 func (p *PeanutRepository) Clone(repoPath string) error {
 	p.repoPath = repoPath
 
+	auth, err := p.config.Auth()
+	if err != nil {
+		return err
+	}
 	opts := &git.CloneOptions{
-		Auth:          p.config.BasicAuth(),
-		RemoteName:    p.remoteName,
-		URL:           p.config.RepoURL,
-		ReferenceName: plumbing.NewBranchReferenceName(p.config.Branch),
+		Auth:       auth,
+		RemoteName: p.remoteName,
+		URL:        p.config.RepoURL,
+		Depth:      p.config.Depth,
+		NoCheckout: p.detached(),
+	}
+	if p.config.Revision != "" {
+		opts.Tags = git.AllTags
+	} else {
+		opts.ReferenceName = plumbing.NewBranchReferenceName(p.config.Branch)
+		opts.SingleBranch = p.config.SingleBranch
+		p.ref = opts.ReferenceName.String()
 	}
 	clone, err := git.PlainClone(p.repoPath, false, opts)
 	if err != nil {
 		return fmt.Errorf("failed to clone %s to %s: %w", p.config.RepoURL, p.repoPath, err)
 	}
 	p.repo = clone
+	if p.detached() {
+		if _, err := p.checkout(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenOrClone opens an existing clone of the configured repository at the
+// path, and fetches the latest changes, this allows the clone to be kept on a
+// volume across restarts.
+//
+// If the path doesn't contain a usable clone, it's removed and the repository
+// is cloned.
+func (p *PeanutRepository) OpenOrClone(repoPath string) error {
+	if _, err := os.Stat(filepath.Join(repoPath, git.GitDirName)); err == nil {
+		err := p.reopen(repoPath)
+		if err == nil {
+			return nil
+		}
+		log.Warnf("Failed to reuse the clone in %s, cloning again: %s", repoPath, err)
+	}
+	if err := os.RemoveAll(repoPath); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", repoPath, err)
+	}
+	return p.Clone(repoPath)
+}
+
+func (p *PeanutRepository) reopen(repoPath string) error {
+	if err := p.open(repoPath); err != nil {
+		return err
+	}
+	if !p.isClone() {
+		return fmt.Errorf("%s is not a clone of %s", repoPath, p.config.RepoURL)
+	}
+	if p.detached() {
+		if _, err := p.checkout(); err != nil {
+			return err
+		}
+	}
+	if _, err := p.Sync(); err != nil && !upToDate(err) {
+		return err
+	}
 	return nil
 }
 
+// isClone returns true if the open repository is a clone of the configured
+// repository, with the configured branch checked out.
+func (p *PeanutRepository) isClone() bool {
+	remote, err := p.repo.Remote(p.remoteName)
+	if err != nil {
+		return false
+	}
+	if urls := remote.Config().URLs; len(urls) == 0 || urls[0] != p.config.RepoURL {
+		return false
+	}
+	if p.detached() {
+		return true
+	}
+	head, err := p.repo.Head()
+	return err == nil && head.Name() == plumbing.NewBranchReferenceName(p.config.Branch)
+}
+
+// detached returns true if the commit is checked out directly, rather than
+// checking out the branch.
+func (p *PeanutRepository) detached() bool {
+	return p.config.Revision != "" || p.config.Sparse
+}
+
 // Open assumes that the provided path contains a valid Git clone with the
-// correct branch.
+// correct branch or revision.
 func (p *PeanutRepository) Open(openPath string) error {
+	if err := p.open(openPath); err != nil {
+		return err
+	}
+	if p.detached() {
+		if _, err := p.checkout(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *PeanutRepository) open(openPath string) error {
 	p.repoPath = openPath
 	repo, err := git.PlainOpen(p.repoPath)
 	if err != nil {
 		return fmt.Errorf("failed to open %s: %w", p.repoPath, err)
 	}
 	p.repo = repo
+	if !p.detached() {
+		p.ref = plumbing.NewBranchReferenceName(p.config.Branch).String()
+	}
 	return nil
 }
 
+// Ref returns the ref that was last checked out, this is the branch, or for
+// a revision, the tag or commit SHA that the revision resolved to.
+func (p *PeanutRepository) Ref() string {
+	return p.ref
+}
+
 // HeadHash returns the hash of the head commit of the repository.
 func (p *PeanutRepository) HeadHash() (plumbing.Hash, error) {
 	ref, err := p.repo.Head()
@@ -84,46 +196,309 @@ func (p *PeanutRepository) HeadHash() (plumbing.Hash, error) {
 	return ref.Hash(), nil
 }
 
-// Sync does a Fetch and Pull, and returns the HeadHash.
+// HeadCommit returns the commit that is checked out.
+func (p *PeanutRepository) HeadCommit() (*object.Commit, error) {
+	h, err := p.HeadHash()
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.repo.CommitObject(h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the Head commit %s: %w", h, err)
+	}
+	return c, nil
+}
+
+// CommitAt returns the commit with the hash from the clone.
+func (p *PeanutRepository) CommitAt(h plumbing.Hash) (*object.Commit, error) {
+	c, err := p.repo.CommitObject(h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", h, err)
+	}
+	return c, nil
+}
+
+// Sync fetches the changes to the repository, and resets the working tree to
+// the head of the branch, or the configured revision, and returns the new
+// HeadHash.
+//
+// If nothing has changed, this returns git.NoErrAlreadyUpToDate.
+//
+// If the branch or tag was rewritten e.g. by a force-push, the new HeadHash is
+// returned with a *HistoryRewrittenError.
+//
+// If the clone is corrupted, the repository is cloned again, and the new
+// HeadHash is returned with a *ReclonedError.
 func (p *PeanutRepository) Sync() (plumbing.Hash, error) {
-	err := p.repo.Fetch(&git.FetchOptions{
-		Auth:       p.config.BasicAuth(),
+	h, err := p.sync()
+	if err == nil || !isCorrupted(err) {
+		return h, err
+	}
+	log.Warnf("The clone of %s in %s is corrupted, cloning again: %s", p.config.RepoURL, p.repoPath, err)
+	if cloneErr := p.reclone(); cloneErr != nil {
+		return plumbing.ZeroHash, cloneErr
+	}
+	h, headErr := p.HeadHash()
+	if headErr != nil {
+		return plumbing.ZeroHash, headErr
+	}
+	return h, &ReclonedError{Err: err}
+}
+
+func (p *PeanutRepository) sync() (plumbing.Hash, error) {
+	auth, err := p.config.Auth()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	previous, err := p.HeadHash()
+	if err != nil {
+		return plumbing.ZeroHash, corrupted(err)
+	}
+	previousRef := p.ref
+	opts := &git.FetchOptions{
+		Auth:       auth,
 		RemoteName: p.remoteName,
-		RefSpecs:   defaultRefSpecs,
-	})
+		RefSpecs:   p.refSpecs(),
+		Depth:      p.config.Depth,
+		// Branches and tags can be rewritten.
+		Force: true,
+	}
+	if p.config.Revision != "" {
+		opts.Tags = git.AllTags
+	}
+	fetchErr := p.repo.Fetch(opts)
+	if fetchErr != nil && !upToDate(fetchErr) {
+		return plumbing.ZeroHash, fmt.Errorf("failed to fetch from the Repository: %w", fetchErr)
+	}
+	h, ref, err := p.resolve()
 	if err != nil {
-		if !upToDate(err) {
-			return plumbing.ZeroHash, fmt.Errorf("failed to fetch from the Repository: %w", err)
-		}
 		return plumbing.ZeroHash, err
 	}
-	wtree, err := p.repo.Worktree()
+	// The working tree is reset even if nothing was fetched, in case a
+	// previous reset failed.
+	if h == previous && ref == previousRef && upToDate(fetchErr) {
+		return plumbing.ZeroHash, fetchErr
+	}
+	if err := p.checkoutResolved(h, ref); err != nil {
+		return plumbing.ZeroHash, corrupted(err)
+	}
+	if ref == previousRef && p.isRewritten(previous, h) {
+		return h, &HistoryRewrittenError{Ref: ref, Previous: previous, Current: h}
+	}
+	return h, nil
+}
+
+// Checkout resets the working tree to the commit, and records the ref that it
+// was resolved from, this restores a previous commit e.g. when the commit that
+// was synchronised is not trusted.
+func (p *PeanutRepository) Checkout(h plumbing.Hash, ref string) error {
+	return p.checkoutResolved(h, ref)
+}
+
+// isRewritten returns true if the previous commit is not an ancestor of the
+// current commit.
+//
+// Shallow clones may not have the history to check this, in which case this
+// returns false.
+func (p *PeanutRepository) isRewritten(previous, current plumbing.Hash) bool {
+	if previous == current || previous.IsZero() {
+		return false
+	}
+	previousCommit, err := p.repo.CommitObject(previous)
+	if err != nil {
+		return false
+	}
+	currentCommit, err := p.repo.CommitObject(current)
 	if err != nil {
-		return plumbing.ZeroHash, fmt.Errorf("failed to get a Worktree from the Repository: %w", err)
+		return false
 	}
-	err = wtree.Pull(&git.PullOptions{
-		RemoteName:    p.remoteName,
-		ReferenceName: plumbing.NewBranchReferenceName(p.config.Branch),
-	})
+	ancestor, err := previousCommit.IsAncestor(currentCommit)
+	return err == nil && !ancestor
+}
 
+// reclone removes the clone, and clones the repository again.
+func (p *PeanutRepository) reclone() error {
+	if err := os.RemoveAll(p.repoPath); err != nil {
+		return fmt.Errorf("failed to remove the corrupted clone %s: %w", p.repoPath, err)
+	}
+	return p.Clone(p.repoPath)
+}
+
+// refSpecs returns the refs to fetch, this is all the branches, or only the
+// configured branch for single branch fetches.
+func (p *PeanutRepository) refSpecs() []config.RefSpec {
+	branch := "*"
+	if p.config.SingleBranch && p.config.Branch != "" {
+		branch = p.config.Branch
+	}
+	specs := []config.RefSpec{
+		config.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", branch, p.remoteName, branch)),
+	}
+	if p.config.Revision != "" {
+		specs = append(specs, tagsRefSpec)
+	}
+	return specs
+}
+
+// checkout resolves the configured revision, or the head of the branch, and
+// checks out the commit.
+func (p *PeanutRepository) checkout() (plumbing.Hash, error) {
+	h, ref, err := p.resolve()
 	if err != nil {
-		if !upToDate(err) {
-			return plumbing.ZeroHash, fmt.Errorf("failed to pull the Worktree: %w", err)
-		}
 		return plumbing.ZeroHash, err
 	}
-	return p.HeadHash()
+	if err := p.checkoutResolved(h, ref); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return h, nil
 }
 
-// ParseManifests parses this repository's path, and returns the parsed
-// resources.
+func (p *PeanutRepository) checkoutResolved(h plumbing.Hash, ref string) error {
+	var err error
+	switch {
+	case p.config.Sparse:
+		err = p.checkoutSparse(h)
+	case p.config.Revision != "":
+		err = p.checkoutCommit(h)
+	default:
+		err = p.resetBranch(h)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to checkout %s: %w", ref, err)
+	}
+	p.ref = ref
+	return nil
+}
+
+// resetBranch points the local branch at the commit, and hard resets the
+// working tree to it, discarding any local changes.
+func (p *PeanutRepository) resetBranch(h plumbing.Hash) error {
+	branch := plumbing.NewBranchReferenceName(p.config.Branch)
+	if err := p.repo.Storer.SetReference(plumbing.NewHashReference(branch, h)); err != nil {
+		return err
+	}
+	if err := p.repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch)); err != nil {
+		return err
+	}
+	wtree, err := p.repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get a Worktree from the Repository: %w", err)
+	}
+	return wtree.Reset(&git.ResetOptions{Commit: h, Mode: git.HardReset})
+}
+
+func (p *PeanutRepository) resolve() (plumbing.Hash, string, error) {
+	if p.config.Revision != "" {
+		return resolveRevision(p.repo, p.config.Revision)
+	}
+	ref, err := p.repo.Reference(plumbing.NewRemoteReferenceName(p.remoteName, p.config.Branch), true)
+	if err != nil {
+		return plumbing.ZeroHash, "", fmt.Errorf("failed to find branch %s: %w", p.config.Branch, err)
+	}
+	return ref.Hash(), plumbing.NewBranchReferenceName(p.config.Branch).String(), nil
+}
+
+func (p *PeanutRepository) checkoutCommit(h plumbing.Hash) error {
+	wtree, err := p.repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get a Worktree from the Repository: %w", err)
+	}
+	return wtree.Checkout(&git.CheckoutOptions{Hash: h, Force: true})
+}
+
+// checkoutSparse writes only the files that are needed to parse the path to
+// the working tree, and detaches the HEAD at the commit.
+//
+// The index is not updated, so the working tree can't be used with other Git
+// operations.
+func (p *PeanutRepository) checkoutSparse(h plumbing.Hash) error {
+	commit, err := p.repo.CommitObject(h)
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	dirs, err := sparseDirectories(tree, p.config.Paths()...)
+	if err != nil {
+		return err
+	}
+	if err := exportDirectories(tree, dirs, p.repoPath); err != nil {
+		return err
+	}
+	return p.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, h))
+}
+
+// ParseManifests parses this repository's path, transforms the resources, and
+// returns them.
 // TODO: should this take a path? Is there
 func (p *PeanutRepository) ParseManifests() ([]*unstructured.Unstructured, error) {
-	res, err := p.parser.Parse(filepath.Join(p.repoPath, p.config.Path))
+	h, err := p.HeadHash()
+	if err != nil {
+		return nil, err
+	}
+	return p.parseManifests(p.repoPath, h)
+}
+
+// ParseManifestsAt parses the path from the commit, transforms the resources,
+// and returns them, without changing the checked out commit.
+//
+// The files of the commit are written to a temporary directory that is
+// removed after parsing.
+func (p *PeanutRepository) ParseManifestsAt(h plumbing.Hash) ([]*unstructured.Unstructured, error) {
+	commit, err := p.repo.CommitObject(h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", h, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the tree of commit %s: %w", h, err)
+	}
+	dirs := []string{"."}
+	if p.config.Sparse {
+		dirs, err = sparseDirectories(tree, p.config.Paths()...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dir, err := os.MkdirTemp("", "peanut-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := exportDirectories(tree, dirs, dir); err != nil {
+		return nil, fmt.Errorf("failed to export commit %s: %w", h, err)
+	}
+	return p.parseManifests(dir, h)
+}
+
+// parseManifests parses the path in the directory that the commit was checked
+// out to.
+func (p *PeanutRepository) parseManifests(dir string, h plumbing.Hash) ([]*unstructured.Unstructured, error) {
+	if p.config.Confined {
+		if err := checkSymlinks(dir); err != nil {
+			return nil, err
+		}
+	}
+	path := filepath.Join(dir, p.config.Path)
+	if d, ok := p.parser.(parser.Detector); ok {
+		name, err := d.Detect(path)
+		if err != nil {
+			return nil, err
+		}
+		p.parserName = name
+	}
+	res, err := p.parse(path, h)
 	if err != nil {
 		return nil, err
 	}
 	for _, v := range res {
+		for _, t := range p.transformers {
+			if err := t.Transform(v); err != nil {
+				return nil, fmt.Errorf("failed to transform %s %s: %w", v.GetKind(), v.GetName(), err)
+			}
+		}
 		annotations := v.GetAnnotations()
 		if annotations == nil {
 			annotations = map[string]string{}
@@ -138,6 +513,20 @@ func (p *PeanutRepository) ParseManifests() ([]*unstructured.Unstructured, error
 	return res, nil
 }
 
+// parse parses the path, with the commit for parsers that need it.
+func (p *PeanutRepository) parse(path string, h plumbing.Hash) ([]*unstructured.Unstructured, error) {
+	if rp, ok := p.parser.(parser.RevisionParser); ok {
+		return rp.ParseRevision(path, h.String())
+	}
+	return p.parser.Parse(path)
+}
+
+// Parser returns the name of the parser that was detected for the manifests
+// by the last ParseManifests, this is empty if the parser is not detected.
+func (p *PeanutRepository) Parser() string {
+	return p.parserName
+}
+
 // IsManaged is used by the cache to determine whether or not a resource is
 // a managed resource.
 // TODO: is this appropriate for the Repository?
@@ -149,11 +538,11 @@ func (p *PeanutRepository) IsManaged(r *cache.Resource) bool {
 	return r.Info.(*resourceInfo).gcMark == gcm
 }
 
-// GCMark calculates a signature for the resource from the repo URL and path
+// GCMark calculates a signature for the resource from the repo URL and paths
 // along with the GVK.
 func (p *PeanutRepository) GCMark(key kube.ResourceKey) (string, error) {
 	h := sha256.New()
-	_, err := h.Write([]byte(fmt.Sprintf("%s/%s", p.config.RepoURL, p.config.Path)))
+	_, err := h.Write([]byte(fmt.Sprintf("%s/%s", p.config.RepoURL, strings.Join(p.config.Paths(), ","))))
 	if err != nil {
 		return "", err
 	}
@@ -167,3 +556,59 @@ func (p *PeanutRepository) GCMark(key kube.ResourceKey) (string, error) {
 func upToDate(err error) bool {
 	return err == git.NoErrAlreadyUpToDate
 }
+
+// HistoryRewrittenError is returned by Sync along with the new HeadHash, when
+// the previous commit is not an ancestor of the new commit, e.g. because the
+// branch was force-pushed.
+type HistoryRewrittenError struct {
+	Ref      string
+	Previous plumbing.Hash
+	Current  plumbing.Hash
+}
+
+func (e *HistoryRewrittenError) Error() string {
+	return fmt.Sprintf("history of %s was rewritten, %s is not an ancestor of %s", e.Ref, e.Previous, e.Current)
+}
+
+// ReclonedError is returned by Sync along with the new HeadHash, when the
+// clone was corrupted, and the repository was cloned again.
+type ReclonedError struct {
+	Err error
+}
+
+func (e *ReclonedError) Error() string {
+	return fmt.Sprintf("the repository was cloned again, as the clone was corrupted: %s", e.Err)
+}
+
+func (e *ReclonedError) Unwrap() error {
+	return e.Err
+}
+
+// errCorrupted identifies errors that indicate that the clone is corrupted.
+var errCorrupted = errors.New("corrupted clone")
+
+type corruptedError struct {
+	err error
+}
+
+func corrupted(err error) error {
+	return &corruptedError{err: err}
+}
+
+func (e *corruptedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *corruptedError) Unwrap() []error {
+	return []error{errCorrupted, e.err}
+}
+
+// isCorrupted returns true if the error indicates that the clone is
+// corrupted, and can't be used.
+//
+// Only the errors from reading the local clone and resetting the working tree
+// are marked as corrupted, failing to read the credentials, to fetch, or to
+// resolve the revision doesn't indicate a problem with the clone.
+func isCorrupted(err error) bool {
+	return errors.Is(err, errCorrupted)
+}
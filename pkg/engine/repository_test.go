@@ -1,13 +1,23 @@
 package engine
 
 import (
+	"errors"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/credentials"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/auto"
 	"github.com/bigkevmcd/peanut-engine/pkg/parser/kustomize"
+	"github.com/bigkevmcd/peanut-engine/pkg/transform"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -29,6 +39,89 @@ func TestParseManifestAddsAnnotation(t *testing.T) {
 	}
 }
 
+func TestParseManifestTransformsBeforeAnnotation(t *testing.T) {
+	c := GitConfig{RepoURL: "https://github.com/bigkevmcd/peanut-engine.git", Branch: "main", Path: "pkg/testdata"}
+	r := NewRepository(c, kustomize.New(), transform.Namespace("test-ns"), transform.Annotations(map[string]string{"team": "taxi"}))
+	assertNoError(t, r.Open("../.."))
+
+	m, err := r.ParseManifests()
+	assertNoError(t, err)
+
+	d := findResource(t, m, "Deployment")
+	if d.GetNamespace() != "test-ns" {
+		t.Fatalf("got namespace %q, want %q", d.GetNamespace(), "test-ns")
+	}
+	gcm, err := r.GCMark(kube.GetResourceKey(d))
+	assertNoError(t, err)
+	want := map[string]string{
+		annotationGCMark: gcm,
+		"team":           "taxi",
+	}
+	if diff := cmp.Diff(want, d.GetAnnotations()); diff != "" {
+		t.Fatalf("parsed manifest:\n%s", diff)
+	}
+}
+
+func TestParseManifestsWithRevisionParser(t *testing.T) {
+	c := GitConfig{RepoURL: "https://github.com/bigkevmcd/peanut-engine.git", Branch: "main", Path: "pkg/testdata"}
+	p := &revisionParser{}
+	r := NewRepository(c, p)
+	assertNoError(t, r.Open("../.."))
+
+	_, err := r.ParseManifests()
+	assertNoError(t, err)
+
+	h, err := r.HeadHash()
+	assertNoError(t, err)
+	if p.sha != h.String() {
+		t.Fatalf("got sha %q, want %q", p.sha, h)
+	}
+}
+
+func TestParseManifestsRecordsDetectedParser(t *testing.T) {
+	c := GitConfig{RepoURL: "https://github.com/bigkevmcd/peanut-engine.git", Branch: "main", Path: "pkg/testdata"}
+	r := NewRepository(c, auto.New(map[string]parser.ManifestParser{auto.Kustomize: kustomize.New()}))
+	assertNoError(t, r.Open("../.."))
+
+	_, err := r.ParseManifests()
+	assertNoError(t, err)
+
+	if p := r.Parser(); p != auto.Kustomize {
+		t.Fatalf("Parser() got %q, want %q", p, auto.Kustomize)
+	}
+}
+
+func TestParseManifestsAt(t *testing.T) {
+	source := makeGitRepository(t)
+	writeConfigMap(t, source, "first-cfg")
+	first := execGit(t, source, "rev-parse", "HEAD")
+	writeConfigMap(t, source, "second-cfg")
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+	assertNoError(t, r.Clone(mkTempDir(t)))
+
+	res, err := r.ParseManifestsAt(plumbing.NewHash(first))
+	assertNoError(t, err)
+
+	if name := findResource(t, res, "ConfigMap").GetName(); name != "first-cfg" {
+		t.Fatalf("got ConfigMap %q, want first-cfg", name)
+	}
+	if _, ok := res[0].GetAnnotations()[annotationGCMark]; !ok {
+		t.Fatal("resource has no GC mark")
+	}
+	assertHead(t, r, execGit(t, source, "rev-parse", "HEAD"))
+}
+
+func TestParseManifestsAtWithUnknownCommit(t *testing.T) {
+	r := NewRepository(GitConfig{RepoURL: "https://github.com/bigkevmcd/peanut-engine.git", Branch: "main", Path: "pkg/testdata"}, kustomize.New())
+	assertNoError(t, r.Open("../.."))
+
+	_, err := r.ParseManifestsAt(plumbing.NewHash("9b2e1ea1e5c1e2d3cc5d4b1a0e7b1f2c3d4e5f60"))
+
+	if err == nil || !strings.Contains(err.Error(), "failed to get commit 9b2e1ea1e5c1e2d3cc5d4b1a0e7b1f2c3d4e5f60") {
+		t.Fatalf("incorrect error: %v", err)
+	}
+}
+
 func TestOpen(t *testing.T) {
 	c := GitConfig{RepoURL: "https://github.com/bigkevmcd/peanut-engine.git", Branch: "main", Path: "pkg/testdata"}
 	r := NewRepository(c, kustomize.New())
@@ -61,7 +154,7 @@ func TestCloneWithPrivateRepo(t *testing.T) {
 	if os.Getenv("TEST_GITHUB_AUTH_TOKEN") == "" {
 		t.Skip("this test needs a GitHub auth token")
 	}
-	c := GitConfig{RepoURL: "https://github.com/bigkevmcd/go-demo-private.git", Branch: "main", Path: "pkg/engine/testdata", AuthToken: os.Getenv("TEST_GITHUB_AUTH_TOKEN")}
+	c := GitConfig{RepoURL: "https://github.com/bigkevmcd/go-demo-private.git", Branch: "main", Path: "pkg/engine/testdata", Credentials: credentials.Env("TEST_GITHUB_AUTH_TOKEN")}
 	dir := mkTempDir(t)
 	r := NewRepository(c, kustomize.New())
 
@@ -92,7 +185,137 @@ func TestCloneWithMissingSource(t *testing.T) {
 }
 
 func TestSync(t *testing.T) {
-	t.Skip()
+	source := makeGitRepository(t)
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+	assertNoError(t, r.Clone(mkTempDir(t)))
+
+	if _, err := r.Sync(); !upToDate(err) {
+		t.Fatalf("Sync() got %v, want %v", err, git.NoErrAlreadyUpToDate)
+	}
+
+	commitFile(t, source, "deploy/new.yaml")
+	h, err := r.Sync()
+	assertNoError(t, err)
+
+	if want := execGit(t, source, "rev-parse", "HEAD"); h.String() != want {
+		t.Fatalf("Sync() got %s, want %s", h, want)
+	}
+	assertHead(t, r, h.String())
+}
+
+func TestSyncWithRewrittenHistory(t *testing.T) {
+	source := makeGitRepository(t)
+	commitFile(t, source, "deploy/first.yaml")
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+	dir := mkTempDir(t)
+	assertNoError(t, r.Clone(dir))
+	previous := execGit(t, source, "rev-parse", "HEAD")
+
+	execGit(t, source, "reset", "-q", "--hard", "HEAD~1")
+	commitFile(t, source, "deploy/second.yaml")
+	h, err := r.Sync()
+
+	want := execGit(t, source, "rev-parse", "HEAD")
+	var rewritten *HistoryRewrittenError
+	if !errors.As(err, &rewritten) {
+		t.Fatalf("Sync() got error %v, want a HistoryRewrittenError", err)
+	}
+	if rewritten.Previous.String() != previous || rewritten.Current.String() != want || rewritten.Ref != "refs/heads/main" {
+		t.Fatalf("got %#v, want %s rewritten from %s", rewritten, want, previous)
+	}
+	if h.String() != want {
+		t.Fatalf("Sync() got %s, want %s", h, want)
+	}
+	assertHead(t, r, want)
+	assertFiles(t, dir, map[string]bool{
+		"deploy/first.yaml":  false,
+		"deploy/second.yaml": true,
+	})
+	if _, err := r.Sync(); !upToDate(err) {
+		t.Fatalf("Sync() got %v, want %v", err, git.NoErrAlreadyUpToDate)
+	}
+}
+
+func TestSyncDiscardsLocalChanges(t *testing.T) {
+	source := makeGitRepository(t)
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+	dir := mkTempDir(t)
+	assertNoError(t, r.Clone(dir))
+	kustomization := filepath.Join(dir, "deploy", "kustomization.yaml")
+	assertNoError(t, os.WriteFile(kustomization, []byte("local: change\n"), 0o644))
+
+	commitFile(t, source, "deploy/new.yaml")
+	_, err := r.Sync()
+	assertNoError(t, err)
+
+	b, err := os.ReadFile(kustomization)
+	assertNoError(t, err)
+	if s := string(b); s != "resources: []\n" {
+		t.Fatalf("got kustomization %q, want the committed version", s)
+	}
+	assertHead(t, r, execGit(t, source, "rev-parse", "HEAD"))
+}
+
+func TestSyncWithCorruptedClone(t *testing.T) {
+	source := makeGitRepository(t)
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+	dir := mkTempDir(t)
+	assertNoError(t, r.Clone(dir))
+	assertNoError(t, os.WriteFile(filepath.Join(dir, ".git", "index"), []byte("corrupted"), 0o644))
+
+	commitFile(t, source, "deploy/new.yaml")
+	h, err := r.Sync()
+
+	var recloned *ReclonedError
+	if !errors.As(err, &recloned) {
+		t.Fatalf("Sync() got error %v, want a ReclonedError", err)
+	}
+	want := execGit(t, source, "rev-parse", "HEAD")
+	if h.String() != want {
+		t.Fatalf("Sync() got %s, want %s", h, want)
+	}
+	assertHead(t, r, want)
+	assertFiles(t, dir, map[string]bool{"deploy/new.yaml": true})
+}
+
+func TestSyncDoesNotRecloneWithoutCredentials(t *testing.T) {
+	source := makeGitRepository(t)
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+	dir := mkTempDir(t)
+	assertNoError(t, r.Clone(dir))
+	marker := filepath.Join(dir, ".git", "marker")
+	assertNoError(t, os.WriteFile(marker, []byte("marker"), 0o644))
+	r.config.Credentials = credentials.NewFile(filepath.Join(mkTempDir(t), "missing"))
+
+	_, err := r.Sync()
+
+	var recloned *ReclonedError
+	if err == nil || errors.As(err, &recloned) {
+		t.Fatalf("Sync() got error %v, want the credentials error", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("the clone was removed: %s", err)
+	}
+}
+
+func TestSyncDoesNotRecloneWithUnknownRevision(t *testing.T) {
+	source := makeGitRepository(t)
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+	dir := mkTempDir(t)
+	assertNoError(t, r.Clone(dir))
+	marker := filepath.Join(dir, ".git", "marker")
+	assertNoError(t, os.WriteFile(marker, []byte("marker"), 0o644))
+	r.config.Revision = "0123456789abcdef0123456789abcdef01234567"
+
+	_, err := r.Sync()
+
+	var recloned *ReclonedError
+	if err == nil || errors.As(err, &recloned) {
+		t.Fatalf("Sync() got error %v, want the revision error", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("the clone was removed: %s", err)
+	}
 }
 
 func TestHeadHash(t *testing.T) {
@@ -108,10 +331,61 @@ func TestHeadHash(t *testing.T) {
 	}
 }
 
+func TestHeadCommit(t *testing.T) {
+	source := makeGitRepository(t)
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+	assertNoError(t, r.Clone(mkTempDir(t)))
+
+	c, err := r.HeadCommit()
+	assertNoError(t, err)
+
+	if want := execGit(t, source, "rev-parse", "HEAD"); c.Hash.String() != want {
+		t.Fatalf("HeadCommit() got %s, want %s", c.Hash, want)
+	}
+	if c.Message != "Initial commit\n" {
+		t.Fatalf("HeadCommit() got message %q", c.Message)
+	}
+}
+
 func TestIsManaged(t *testing.T) {
 	t.Skip()
 }
 
+type revisionParser struct {
+	sha string
+}
+
+func (r *revisionParser) Parse(path string) ([]*unstructured.Unstructured, error) {
+	return nil, nil
+}
+
+func (r *revisionParser) ParseRevision(path, sha string) ([]*unstructured.Unstructured, error) {
+	r.sha = sha
+	return nil, nil
+}
+
+func findResource(t *testing.T, res []*unstructured.Unstructured, kind string) *unstructured.Unstructured {
+	t.Helper()
+	for _, v := range res {
+		if v.GetKind() == kind {
+			return v
+		}
+	}
+	t.Fatalf("no %s in the resources", kind)
+	return nil
+}
+
+// writeConfigMap commits a kustomization of a single ConfigMap to the deploy
+// directory of the repository.
+func writeConfigMap(t *testing.T, dir, name string) {
+	t.Helper()
+	assertNoError(t, os.WriteFile(filepath.Join(dir, "deploy", "kustomization.yaml"), []byte("resources:\n- configmap.yaml\n"), 0o644))
+	assertNoError(t, os.WriteFile(filepath.Join(dir, "deploy", "configmap.yaml"),
+		[]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: "+name+"\n"), 0o644))
+	execGit(t, dir, "add", ".")
+	execGit(t, dir, "-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "Add "+name)
+}
+
 func testRepository(t *testing.T, c GitConfig) *PeanutRepository {
 	t.Helper()
 	r := NewRepository(c, kustomize.New())
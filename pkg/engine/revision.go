@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+var shaRE = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// resolveRevision resolves a commit SHA, tag or semver constraint to a
+// commit, and returns the commit along with the resolved ref.
+//
+// For a semver constraint, the highest tag that matches the constraint is
+// used, tags that are not semantic versions are ignored.
+func resolveRevision(repo *git.Repository, revision string) (plumbing.Hash, string, error) {
+	if shaRE.MatchString(revision) {
+		h := plumbing.NewHash(revision)
+		if _, err := repo.CommitObject(h); err != nil {
+			return plumbing.ZeroHash, "", fmt.Errorf("failed to find commit %s: %w", revision, err)
+		}
+		return h, revision, nil
+	}
+	ref, err := repo.Tag(revision)
+	if err == nil {
+		return resolveTag(repo, ref)
+	}
+	if !errors.Is(err, git.ErrTagNotFound) {
+		return plumbing.ZeroHash, "", fmt.Errorf("failed to get tag %s: %w", revision, err)
+	}
+	constraint, err := semver.NewConstraint(revision)
+	if err != nil {
+		return plumbing.ZeroHash, "", fmt.Errorf("revision %q is not a commit SHA, tag or semver constraint", revision)
+	}
+	ref, err = highestMatchingTag(repo, constraint)
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+	if ref == nil {
+		return plumbing.ZeroHash, "", fmt.Errorf("no tags match %q", revision)
+	}
+	return resolveTag(repo, ref)
+}
+
+// resolveTag returns the commit for lightweight and annotated tags.
+func resolveTag(repo *git.Repository, ref *plumbing.Reference) (plumbing.Hash, string, error) {
+	h, err := repo.ResolveRevision(plumbing.Revision(ref.Name().String()))
+	if err != nil {
+		return plumbing.ZeroHash, "", fmt.Errorf("failed to resolve tag %s: %w", ref.Name().Short(), err)
+	}
+	return *h, ref.Name().String(), nil
+}
+
+func highestMatchingTag(repo *git.Repository, constraint *semver.Constraints) (*plumbing.Reference, error) {
+	tags, err := repo.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	var (
+		highest    *semver.Version
+		highestRef *plumbing.Reference
+	)
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		v, err := semver.NewVersion(ref.Name().Short())
+		if err != nil {
+			return nil
+		}
+		if constraint.Check(v) && (highest == nil || v.GreaterThan(highest)) {
+			highest, highestRef = v, ref
+		}
+		return nil
+	})
+	if err != nil && err != storer.ErrStop {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	return highestRef, nil
+}
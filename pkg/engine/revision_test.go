@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/kustomize"
+)
+
+func TestResolveRevision(t *testing.T) {
+	dir := makeTaggedGitRepository(t)
+	repo, err := git.PlainOpen(dir)
+	assertNoError(t, err)
+	initial := execGit(t, dir, "rev-list", "-n", "1", "v1.4.0")
+
+	revisionTests := []struct {
+		revision string
+		wantRef  string
+	}{
+		{initial, initial},
+		{"v1.4.0", "refs/tags/v1.4.0"},
+		{"v1.4.x", "refs/tags/v1.4.2"},
+		{"~1.4", "refs/tags/v1.4.2"},
+		{">= 1.4.0", "refs/tags/v1.5.0"},
+		{"production", "refs/tags/production"},
+	}
+
+	for _, tt := range revisionTests {
+		t.Run(tt.revision, func(t *testing.T) {
+			h, ref, err := resolveRevision(repo, tt.revision)
+			assertNoError(t, err)
+
+			if ref != tt.wantRef {
+				t.Errorf("got ref %q, want %q", ref, tt.wantRef)
+			}
+			if want := execGit(t, dir, "rev-list", "-n", "1", tt.wantRef); h.String() != want {
+				t.Errorf("got commit %s, want %s", h, want)
+			}
+		})
+	}
+}
+
+func TestResolveRevisionErrors(t *testing.T) {
+	repo, err := git.PlainOpen(makeTaggedGitRepository(t))
+	assertNoError(t, err)
+
+	revisionTests := []struct {
+		revision string
+		want     string
+	}{
+		{"v2.x", `no tags match "v2.x"`},
+		{"unknown", `revision "unknown" is not a commit SHA, tag or semver constraint`},
+		{strings.Repeat("a", 40), "failed to find commit " + strings.Repeat("a", 40)},
+	}
+
+	for _, tt := range revisionTests {
+		t.Run(tt.revision, func(t *testing.T) {
+			_, _, err := resolveRevision(repo, tt.revision)
+
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloneWithRevision(t *testing.T) {
+	source := makeTaggedGitRepository(t)
+	c := GitConfig{RepoURL: source, Revision: "v1.4.x", Path: "deploy"}
+	r := NewRepository(c, kustomize.New())
+	dir := mkTempDir(t)
+
+	err := r.Clone(dir)
+	assertNoError(t, err)
+
+	assertHead(t, r, execGit(t, source, "rev-list", "-n", "1", "v1.4.2"))
+	if ref := r.Ref(); ref != "refs/tags/v1.4.2" {
+		t.Fatalf("got ref %q, want %q", ref, "refs/tags/v1.4.2")
+	}
+
+	commitFile(t, source, "deploy/new.yaml")
+	execGit(t, source, "tag", "v1.4.3")
+	h, err := r.Sync()
+	assertNoError(t, err)
+
+	if want := execGit(t, source, "rev-list", "-n", "1", "v1.4.3"); h.String() != want {
+		t.Fatalf("Sync() got %s, want %s", h, want)
+	}
+	if ref := r.Ref(); ref != "refs/tags/v1.4.3" {
+		t.Fatalf("got ref %q, want %q", ref, "refs/tags/v1.4.3")
+	}
+}
+
+func TestCloneWithBranchRef(t *testing.T) {
+	source := makeGitRepository(t)
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+
+	err := r.Clone(mkTempDir(t))
+	assertNoError(t, err)
+
+	if ref := r.Ref(); ref != "refs/heads/main" {
+		t.Fatalf("got ref %q, want %q", ref, "refs/heads/main")
+	}
+}
+
+// makeTaggedGitRepository creates a repository with semver tags on several
+// commits.
+func makeTaggedGitRepository(t *testing.T) string {
+	t.Helper()
+	dir := makeGitRepository(t)
+	execGit(t, dir, "tag", "v1.4.0")
+	execGit(t, dir, "tag", "production")
+	for _, v := range []string{"v1.4.1", "v1.4.2", "v1.4.3-rc.1", "v1.5.0", "not-a-version"} {
+		commitFile(t, dir, "deploy/"+v+".yaml")
+		execGit(t, dir, "-c", "user.name=Test", "-c", "user.email=test@example.com", "tag", "-a", "-m", v, v)
+	}
+	return dir
+}
+
+func assertHead(t *testing.T, r *PeanutRepository, want string) {
+	t.Helper()
+	got, err := r.HeadHash()
+	assertNoError(t, err)
+	if got.String() != want {
+		t.Fatalf("incorrect git SHA from HeadHash, got %s, want %s", got, want)
+	}
+}
+
+func execGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %s", strings.Join(args, " "), out)
+	}
+	return strings.TrimSpace(string(out))
+}
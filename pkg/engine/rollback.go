@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+// rollback applies the resources from the last healthy commit after the
+// resources from the bad commit were degraded, and records it as a separate
+// synchronisation.
+func (m *Manager) rollback(app *Application, bad plumbing.Hash, done <-chan struct{}, logger *log.Entry) {
+	healthy, ok := lastHealthySHA(app, bad)
+	if !ok {
+		logger.Warnf("Resources from %s are degraded, but there is no healthy commit to roll back to", bad)
+		return
+	}
+	logger.Warnf("Resources from %s are degraded, rolling back to %s", bad, healthy)
+	app.Metrics.CountRollback()
+	record := recent.Synchronisation{
+		Start:        time.Now(),
+		SHA:          healthy.String(),
+		Ref:          app.Repository.Ref(),
+		RollbackFrom: bad.String(),
+	}
+	targets, err := app.Repository.ParseManifestsAt(healthy)
+	record.Parser = app.Repository.Parser()
+	if err != nil {
+		app.Metrics.CountError()
+		record.Error = fmt.Errorf("failed to parse the manifests from %s: %w", healthy, err)
+		app.record(record)
+		logger.Errorf("Failed to roll back: %s", record.Error)
+		return
+	}
+	err = m.apply(app, healthy, targets, &record, done, logger)
+	app.record(record)
+	if err != nil {
+		app.Metrics.CountError()
+		logger.Errorf("Failed to roll back to %s: %s", healthy, err)
+		return
+	}
+	app.Metrics.Record(record.Results)
+}
+
+// lastHealthySHA returns the SHA of the most recent synchronisation that
+// became healthy, ignoring the bad commit, and false if no synchronisation
+// became healthy.
+func lastHealthySHA(app *Application, bad plumbing.Hash) (plumbing.Hash, bool) {
+	for _, v := range app.Synchronisations.History() {
+		if v.Health == health.HealthStatusHealthy && !v.Failed() && v.SHA != bad.String() {
+			return plumbing.NewHash(v.SHA), true
+		}
+	}
+	return plumbing.ZeroHash, false
+}
+
+// rolledBackFrom returns true if a synchronisation rolled back automatically
+// from the commit, and the commit hasn't been deployed since, commits that
+// were rolled back from are not synchronised again.
+//
+// Manual rollbacks are pinned instead, and the head is synchronised again
+// when the application is resumed.
+func (a *Application) rolledBackFrom(sha plumbing.Hash) bool {
+	for _, v := range a.Synchronisations.History() {
+		if v.RollbackFrom == sha.String() && !v.Pinned {
+			return true
+		}
+		if v.SHA == sha.String() && !v.Rejected {
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/metrics"
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+const healthySHA = "9b2e1ea1e5c1e2d3cc5d4b1a0e7b1f2c3d4e5f60"
+
+func TestSynchroniseRollsBackDegradedCommit(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{makeDeployment("test-ns", "taxi", 1)}
+	repo.commits = map[plumbing.Hash][]*unstructured.Unstructured{
+		plumbing.NewHash(healthySHA): {makeConfigMap("test-ns", "healthy-cfg", "a")},
+	}
+	app := testApplication(repo)
+	app.Config.AutoRollback = true
+	app.Synchronisations.Record(recent.Synchronisation{SHA: healthySHA, Health: health.HealthStatusHealthy})
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: degradedClusterCache()}
+
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	synced := syncer.synced()
+	if l := len(synced); l != 2 {
+		t.Fatalf("got %d syncs, want 2", l)
+	}
+	if rev, name := synced[1].revision, synced[1].resources[0].GetName(); rev != healthySHA || name != "healthy-cfg" {
+		t.Fatalf("rollback synchronised %s from %s, want healthy-cfg from %s", name, rev, healthySHA)
+	}
+	history := app.Synchronisations.History()
+	if history[1].SHA != testSHA || history[1].Health != health.HealthStatusDegraded {
+		t.Fatalf("got %s %s, want the degraded synchronisation of %s", history[1].SHA, history[1].Health, testSHA)
+	}
+	if history[0].SHA != healthySHA || history[0].RollbackFrom != testSHA {
+		t.Fatalf("got %s rolled back from %q, want %s rolled back from %s", history[0].SHA, history[0].RollbackFrom, healthySHA, testSHA)
+	}
+	if r := app.Metrics.(*metrics.MockMetrics).Rollbacks; r != 1 {
+		t.Fatalf("got %d rollbacks, want 1", r)
+	}
+
+	// The degraded commit is not synchronised again.
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	if l := len(syncer.synced()); l != 2 {
+		t.Fatalf("got %d syncs, want 2", l)
+	}
+}
+
+func TestSynchroniseAfterRollbackAndRejection(t *testing.T) {
+	untrustedSHA := "d2a3b1c4e5f60718293a4b5c6d7e8f9012345678"
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{makeDeployment("test-ns", "taxi", 1)}
+	repo.commits = map[plumbing.Hash][]*unstructured.Unstructured{
+		plumbing.NewHash(healthySHA): {makeConfigMap("test-ns", "healthy-cfg", "a")},
+	}
+	app := testApplication(repo)
+	app.Config.AutoRollback = true
+	app.Verifier = fakeVerifier{testSHA: "Test User", healthySHA: "Test User"}
+	app.Synchronisations.Record(recent.Synchronisation{SHA: healthySHA, Health: health.HealthStatusHealthy})
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: degradedClusterCache()}
+	logger := log.WithField("application", app.Name)
+
+	sha := m.synchronise(app, plumbing.NewHash(testSHA), nil, logger)
+	// An untrusted commit is rejected, and the degraded commit is restored.
+	repo.head = plumbing.NewHash(untrustedSHA)
+	repo.syncHead = repo.head
+	sha = m.synchronise(app, sha, nil, logger)
+	repo.syncHead = plumbing.ZeroHash
+	latest, _ := app.Synchronisations.Latest()
+	if !latest.Rejected || latest.SHA != untrustedSHA {
+		t.Fatalf("got rejected %v for %s, want a rejection of %s", latest.Rejected, latest.SHA, untrustedSHA)
+	}
+	m.synchronise(app, sha, nil, logger)
+
+	if l := len(syncer.synced()); l != 2 {
+		t.Fatalf("got %d syncs, want the degraded commit and the rollback", l)
+	}
+}
+
+func TestRolledBackFrom(t *testing.T) {
+	rolledBackTests := []struct {
+		name    string
+		history []recent.Synchronisation
+		want    bool
+	}{
+		{"no history", nil, false},
+		{"rolled back", []recent.Synchronisation{{SHA: testSHA}, {SHA: healthySHA, RollbackFrom: testSHA}}, true},
+		{"rejected after the rollback", []recent.Synchronisation{{SHA: healthySHA, RollbackFrom: testSHA}, {SHA: "d2a3b1c4e5f60718293a4b5c6d7e8f9012345678", Rejected: true}}, true},
+		{"pinned after the rollback", []recent.Synchronisation{{SHA: healthySHA, RollbackFrom: testSHA}, {SHA: healthySHA, Pinned: true}}, true},
+		{"deployed after the rollback", []recent.Synchronisation{{SHA: healthySHA, RollbackFrom: testSHA}, {SHA: testSHA, Pinned: true}}, false},
+		{"manual rollback", []recent.Synchronisation{{SHA: healthySHA, RollbackFrom: testSHA, Pinned: true}}, false},
+		{"rolled back from another commit", []recent.Synchronisation{{SHA: testSHA}, {SHA: healthySHA, RollbackFrom: "d2a3b1c4e5f60718293a4b5c6d7e8f9012345678"}}, false},
+	}
+
+	for _, tt := range rolledBackTests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+			for _, v := range tt.history {
+				app.Synchronisations.Record(v)
+			}
+
+			if got := app.rolledBackFrom(plumbing.NewHash(testSHA)); got != tt.want {
+				t.Fatalf("rolledBackFrom() got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSynchroniseWithoutRollback(t *testing.T) {
+	rollbackTests := []struct {
+		name         string
+		autoRollback bool
+		history      []recent.Synchronisation
+	}{
+		{"disabled", false, []recent.Synchronisation{{SHA: healthySHA, Health: health.HealthStatusHealthy}}},
+		{"no healthy commit", true, []recent.Synchronisation{{SHA: healthySHA, Health: health.HealthStatusProgressing}}},
+		{"no history", true, nil},
+	}
+
+	for _, tt := range rollbackTests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeRepository(plumbing.NewHash(testSHA))
+			repo.resources = []*unstructured.Unstructured{makeDeployment("test-ns", "taxi", 1)}
+			app := testApplication(repo)
+			app.Config.AutoRollback = tt.autoRollback
+			for _, v := range tt.history {
+				app.Synchronisations.Record(v)
+			}
+			syncer := &fakeGitOpsEngine{}
+			m := &Manager{gitOpsEngine: syncer, clusterCache: degradedClusterCache()}
+
+			m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+			if l := len(syncer.synced()); l != 1 {
+				t.Fatalf("got %d syncs, want 1", l)
+			}
+			if r := app.Metrics.(*metrics.MockMetrics).Rollbacks; r != 0 {
+				t.Fatalf("got %d rollbacks, want 0", r)
+			}
+		})
+	}
+}
+
+// degradedClusterCache returns a cluster cache with a Deployment that exceeded
+// its progress deadline.
+func degradedClusterCache() *fakeClusterCache {
+	d := makeDeployment("test-ns", "taxi", 0)
+	conditions := []interface{}{
+		map[string]interface{}{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded"},
+	}
+	if err := unstructured.SetNestedSlice(d.Object, conditions, "status", "conditions"); err != nil {
+		panic(err)
+	}
+	return &fakeClusterCache{
+		live: map[kube.ResourceKey]*unstructured.Unstructured{
+			kube.NewResourceKey("apps", "Deployment", "test-ns", "taxi"): d,
+		},
+	}
+}
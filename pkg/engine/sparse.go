@@ -0,0 +1,269 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"sigs.k8s.io/yaml"
+)
+
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// kustomization is the part of a Kustomization that references local files
+// and directories.
+type kustomization struct {
+	Resources             []string             `json:"resources"`
+	Bases                 []string             `json:"bases"`
+	Components            []string             `json:"components"`
+	Crds                  []string             `json:"crds"`
+	PatchesStrategicMerge []string             `json:"patchesStrategicMerge"`
+	PatchesJSON6902       []kustomizationPatch `json:"patchesJson6902"`
+	Patches               []kustomizationPatch `json:"patches"`
+	ConfigMapGenerator    []kustomizationGen   `json:"configMapGenerator"`
+	SecretGenerator       []kustomizationGen   `json:"secretGenerator"`
+}
+
+type kustomizationPatch struct {
+	Path string `json:"path"`
+}
+
+type kustomizationGen struct {
+	Files []string `json:"files"`
+	Envs  []string `json:"envs"`
+	Env   string   `json:"env"`
+}
+
+// paths returns the local paths that the Kustomization references.
+func (k kustomization) paths() []string {
+	paths := []string{}
+	paths = append(paths, k.Resources...)
+	paths = append(paths, k.Bases...)
+	paths = append(paths, k.Components...)
+	paths = append(paths, k.Crds...)
+	for _, v := range k.PatchesStrategicMerge {
+		// Patches can also be inline.
+		if !strings.Contains(v, "\n") {
+			paths = append(paths, v)
+		}
+	}
+	for _, v := range append(k.PatchesJSON6902, k.Patches...) {
+		if v.Path != "" {
+			paths = append(paths, v.Path)
+		}
+	}
+	for _, v := range append(k.ConfigMapGenerator, k.SecretGenerator...) {
+		for _, f := range v.Files {
+			// Files can be "key=path".
+			if i := strings.Index(f, "="); i >= 0 {
+				f = f[i+1:]
+			}
+			paths = append(paths, f)
+		}
+		paths = append(paths, v.Envs...)
+		if v.Env != "" {
+			paths = append(paths, v.Env)
+		}
+	}
+	return paths
+}
+
+// sparseDirectories returns the directories in the tree that are needed to
+// parse the paths, this is the paths and any directories that are referenced
+// by Kustomizations, remote references are ignored.
+//
+// The returned directories are relative to the root of the tree, and
+// directories within other returned directories are omitted.
+func sparseDirectories(tree *object.Tree, paths ...string) ([]string, error) {
+	dirs := map[string]bool{}
+	pending := []string{}
+	for _, v := range paths {
+		pending = append(pending, cleanTreePath(v))
+	}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		if dirs[current] {
+			continue
+		}
+		dirs[current] = true
+		k, err := readKustomization(tree, current)
+		if err != nil {
+			return nil, err
+		}
+		if k == nil {
+			continue
+		}
+		for _, v := range k.paths() {
+			if isRemote(v) {
+				continue
+			}
+			p := cleanTreePath(path.Join(current, v))
+			if p == ".." || strings.HasPrefix(p, "../") {
+				continue
+			}
+			if isTreeDir(tree, p) {
+				pending = append(pending, p)
+				continue
+			}
+			dirs[path.Dir(p)] = true
+		}
+	}
+	return topLevelDirectories(dirs), nil
+}
+
+func readKustomization(tree *object.Tree, dir string) (*kustomization, error) {
+	for _, name := range kustomizationFiles {
+		f, err := tree.File(path.Join(dir, name))
+		if errors.Is(err, object.ErrFileNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path.Join(dir, name), err)
+		}
+		body, err := f.Contents()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		var k kustomization
+		if err := yaml.Unmarshal([]byte(body), &k); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", f.Name, err)
+		}
+		return &k, nil
+	}
+	return nil, nil
+}
+
+// exportDirectories writes the files in the directories of the tree to the
+// destination, replacing any existing files in the directories.
+func exportDirectories(tree *object.Tree, dirs []string, dest string) error {
+	for _, dir := range dirs {
+		sub := tree
+		if dir != "." {
+			var err error
+			sub, err = tree.Tree(dir)
+			if errors.Is(err, object.ErrDirectoryNotFound) {
+				sub = nil
+			} else if err != nil {
+				return fmt.Errorf("failed to read %s: %w", dir, err)
+			}
+		}
+		target := filepath.Join(dest, filepath.FromSlash(dir))
+		if err := removeContents(target); err != nil {
+			return err
+		}
+		if sub == nil {
+			continue
+		}
+		err := sub.Files().ForEach(func(f *object.File) error {
+			return writeFile(f, filepath.Join(target, filepath.FromSlash(f.Name)))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to export %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// removeContents removes everything in the directory except the Git
+// metadata.
+func removeContents(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, v := range entries {
+		if v.Name() == ".git" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, v.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(f *object.File, filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if f.Mode == filemode.Symlink {
+		target, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(string(target), filename)
+	}
+	perm := os.FileMode(0o644)
+	if f.Mode == filemode.Executable {
+		perm = 0o755
+	}
+	out, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func isTreeDir(tree *object.Tree, p string) bool {
+	if p == "." {
+		return true
+	}
+	_, err := tree.Tree(p)
+	return err == nil
+}
+
+func isRemote(s string) bool {
+	return strings.Contains(s, "://") || strings.HasPrefix(s, "git@") ||
+		strings.HasPrefix(s, "github.com/")
+}
+
+func cleanTreePath(p string) string {
+	return path.Clean(strings.TrimPrefix(p, "/"))
+}
+
+// topLevelDirectories returns the sorted directories, without any that are
+// within another directory.
+func topLevelDirectories(dirs map[string]bool) []string {
+	sorted := []string{}
+	for k := range dirs {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	result := []string{}
+	for _, v := range sorted {
+		if len(result) > 0 && isWithin(v, result) {
+			continue
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+func isWithin(dir string, parents []string) bool {
+	for _, p := range parents {
+		if p == "." || dir == p || strings.HasPrefix(dir, p+"/") {
+			return true
+		}
+	}
+	return false
+}
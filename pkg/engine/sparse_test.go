@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/kustomize"
+)
+
+func TestSparseDirectories(t *testing.T) {
+	repo, err := git.PlainOpen(makeMonorepo(t))
+	assertNoError(t, err)
+	head, err := repo.Head()
+	assertNoError(t, err)
+	commit, err := repo.CommitObject(head.Hash())
+	assertNoError(t, err)
+	tree, err := commit.Tree()
+	assertNoError(t, err)
+
+	dirTests := []struct {
+		path string
+		want []string
+	}{
+		{"deploy/overlays/prod", []string{"config", "deploy/base", "deploy/overlays/prod", "patches"}},
+		{"/deploy/base/", []string{"deploy/base"}},
+		{"deploy/overlays/remote", []string{"deploy/base", "deploy/overlays/remote"}},
+		{"deploy", []string{"deploy"}},
+		{"docs", []string{"docs"}},
+		{".", []string{"."}},
+	}
+
+	for _, tt := range dirTests {
+		t.Run(tt.path, func(t *testing.T) {
+			dirs, err := sparseDirectories(tree, tt.path)
+			assertNoError(t, err)
+
+			if diff := cmp.Diff(tt.want, dirs); diff != "" {
+				t.Fatalf("sparseDirectories(%q):\n%s", tt.path, diff)
+			}
+		})
+	}
+}
+
+func TestSparseDirectoriesWithSeveralPaths(t *testing.T) {
+	repo, err := git.PlainOpen(makeMonorepo(t))
+	assertNoError(t, err)
+	head, err := repo.Head()
+	assertNoError(t, err)
+	commit, err := repo.CommitObject(head.Hash())
+	assertNoError(t, err)
+	tree, err := commit.Tree()
+	assertNoError(t, err)
+
+	dirs, err := sparseDirectories(tree, "docs", "deploy/overlays/dev", "deploy/base/")
+	assertNoError(t, err)
+
+	if diff := cmp.Diff([]string{"deploy/base", "deploy/overlays/dev", "docs"}, dirs); diff != "" {
+		t.Fatalf("sparseDirectories():\n%s", diff)
+	}
+}
+
+func TestCloneWithSparseCheckout(t *testing.T) {
+	source := makeMonorepo(t)
+	c := GitConfig{RepoURL: source, Branch: "main", Path: "deploy/overlays/dev", Sparse: true}
+	r := NewRepository(c, kustomize.New())
+	dir := mkTempDir(t)
+
+	err := r.Clone(dir)
+	assertNoError(t, err)
+
+	assertHead(t, r, execGit(t, source, "rev-parse", "HEAD"))
+	assertFiles(t, dir, map[string]bool{
+		"deploy/base/configmap.yaml":              true,
+		"deploy/overlays/dev/kustomization.yaml":  true,
+		"deploy/overlays/prod/kustomization.yaml": false,
+		"patches/replicas.yaml":                   false,
+		"docs/README.md":                          false,
+	})
+	resources, err := r.ParseManifests()
+	assertNoError(t, err)
+	if l := len(resources); l != 1 {
+		t.Fatalf("got %d resources, want 1", l)
+	}
+
+	commitFile(t, source, "deploy/base/new.yaml")
+	execGit(t, source, "rm", "-q", "deploy/base/unused.yaml")
+	execGit(t, source, "-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "Remove unused file")
+	h, err := r.Sync()
+	assertNoError(t, err)
+
+	if want := execGit(t, source, "rev-parse", "HEAD"); h.String() != want {
+		t.Fatalf("Sync() got %s, want %s", h, want)
+	}
+	assertFiles(t, dir, map[string]bool{
+		"deploy/base/new.yaml":       true,
+		"deploy/base/unused.yaml":    false,
+		"deploy/base/configmap.yaml": true,
+	})
+	if ref := r.Ref(); ref != "refs/heads/main" {
+		t.Fatalf("got ref %q, want %q", ref, "refs/heads/main")
+	}
+}
+
+func TestCloneWithSingleBranch(t *testing.T) {
+	source := makeGitRepository(t)
+	execGit(t, source, "branch", "other")
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy", SingleBranch: true}, kustomize.New())
+	dir := mkTempDir(t)
+
+	err := r.Clone(dir)
+	assertNoError(t, err)
+	execGit(t, source, "branch", "another")
+	commitFile(t, source, "deploy/new.yaml")
+	_, err = r.Sync()
+	assertNoError(t, err)
+
+	assertHead(t, r, execGit(t, source, "rev-parse", "HEAD"))
+	if branches := execGit(t, dir, "branch", "-r"); branches != "origin/main" {
+		t.Fatalf("got remote branches %q, want origin/main", branches)
+	}
+}
+
+func TestCloneWithDepth(t *testing.T) {
+	source := makeGitRepository(t)
+	commitFile(t, source, "deploy/first.yaml")
+	commitFile(t, source, "deploy/second.yaml")
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy", Depth: 1}, kustomize.New())
+	dir := mkTempDir(t)
+
+	err := r.Clone(dir)
+	assertNoError(t, err)
+
+	assertHead(t, r, execGit(t, source, "rev-parse", "HEAD"))
+	if count := execGit(t, dir, "rev-list", "--count", "HEAD"); count != "1" {
+		t.Fatalf("got %s commits, want 1", count)
+	}
+
+	commitFile(t, source, "deploy/third.yaml")
+	_, err = r.Sync()
+	assertNoError(t, err)
+
+	assertHead(t, r, execGit(t, source, "rev-parse", "HEAD"))
+}
+
+func TestOpenOrCloneReusesClone(t *testing.T) {
+	source := makeGitRepository(t)
+	c := GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}
+	dir := filepath.Join(t.TempDir(), "clone")
+	assertNoError(t, NewRepository(c, kustomize.New()).Clone(dir))
+	marker := filepath.Join(dir, ".git", "peanut-test")
+	assertNoError(t, os.WriteFile(marker, []byte("test"), 0o644))
+	commitFile(t, source, "deploy/new.yaml")
+
+	r := NewRepository(c, kustomize.New())
+	err := r.OpenOrClone(dir)
+	assertNoError(t, err)
+
+	assertHead(t, r, execGit(t, source, "rev-parse", "HEAD"))
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("the clone was not reused: %s", err)
+	}
+}
+
+func TestOpenOrCloneReplacesOtherRepository(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "clone")
+	other := GitConfig{RepoURL: makeGitRepository(t), Branch: "main", Path: "deploy"}
+	assertNoError(t, NewRepository(other, kustomize.New()).Clone(dir))
+	source := makeGitRepository(t)
+	commitFile(t, source, "deploy/new.yaml")
+
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+	err := r.OpenOrClone(dir)
+	assertNoError(t, err)
+
+	assertHead(t, r, execGit(t, source, "rev-parse", "HEAD"))
+}
+
+func TestOpenOrCloneWithMissingDirectory(t *testing.T) {
+	source := makeGitRepository(t)
+	dir := filepath.Join(t.TempDir(), "clone")
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy", Sparse: true}, kustomize.New())
+
+	err := r.OpenOrClone(dir)
+	assertNoError(t, err)
+
+	assertHead(t, r, execGit(t, source, "rev-parse", "HEAD"))
+	assertFiles(t, dir, map[string]bool{"deploy/kustomization.yaml": true})
+}
+
+// makeMonorepo creates a repository with Kustomizations that reference
+// directories outside of the path.
+func makeMonorepo(t *testing.T) string {
+	t.Helper()
+	dir := makeGitRepository(t)
+	files := map[string]string{
+		"deploy/base/kustomization.yaml": "resources:\n- configmap.yaml\n",
+		"deploy/base/configmap.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test-cfg\ndata:\n  key: value\n",
+		"deploy/overlays/prod/kustomization.yaml": `resources:
+- ../../base
+patches:
+- path: ../../../patches/replicas.yaml
+configMapGenerator:
+- name: app
+  files:
+  - app.properties=../../../config/app.properties
+`,
+		"deploy/overlays/remote/kustomization.yaml": "resources:\n- ../../base\n- https://github.com/example/example//deploy?ref=v1.0.0\n- ../../../../outside\n",
+		"deploy/overlays/dev/kustomization.yaml":    "resources:\n- ../../base\n",
+		"patches/replicas.yaml":                     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test-cfg\ndata:\n  patched: \"true\"\n",
+		"deploy/base/unused.yaml":                   "# unused\n",
+		"config/app.properties":                     "key=value\n",
+		"docs/README.md":                            "# Documentation\n",
+	}
+	for k, v := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(k))
+		assertNoError(t, os.MkdirAll(filepath.Dir(filename), 0o755))
+		assertNoError(t, os.WriteFile(filename, []byte(v), 0o644))
+	}
+	assertNoError(t, os.Remove(filepath.Join(dir, "deploy", "kustomization.yaml")))
+	execGit(t, dir, "add", "-A")
+	execGit(t, dir, "-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "Add monorepo")
+	return dir
+}
+
+func assertFiles(t *testing.T, dir string, files map[string]bool) {
+	t.Helper()
+	for k, want := range files {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(k)))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists got %v, want %v", k, exists, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultSSHUser is used when the repository URL doesn't specify a user.
+const defaultSSHUser = "git"
+
+// SSHConfig configures authentication with an SSH private key.
+type SSHConfig struct {
+	PrivateKeyFile string
+	// PassphraseFile is optional, and contains the passphrase for an
+	// encrypted private key.
+	PassphraseFile string
+	// KnownHostsFile is optional, if it's not provided, the host keys are
+	// checked against the files in $SSH_KNOWN_HOSTS, or ~/.ssh/known_hosts.
+	KnownHostsFile string
+	// InsecureIgnoreHostKey disables checking the host key of the server.
+	InsecureIgnoreHostKey bool
+}
+
+// publicKeys loads the private key, and configures the checking of the host
+// keys.
+//
+// The key is loaded each time, so that a replaced key is used without
+// restarting.
+func (c SSHConfig) publicKeys(repoURL string) (*gitssh.PublicKeys, error) {
+	passphrase := ""
+	if c.PassphraseFile != "" {
+		b, err := os.ReadFile(c.PassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the SSH private key passphrase: %w", err)
+		}
+		passphrase = strings.TrimRight(string(b), "\r\n")
+	}
+	user := defaultSSHUser
+	if ep, err := transport.NewEndpoint(repoURL); err == nil && ep.User != "" {
+		user = ep.User
+	}
+	auth, err := gitssh.NewPublicKeysFromFile(user, c.PrivateKeyFile, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the SSH private key: %w", err)
+	}
+	if c.InsecureIgnoreHostKey {
+		auth.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return auth, nil
+	}
+	files := []string{}
+	if c.KnownHostsFile != "" {
+		files = append(files, c.KnownHostsFile)
+	}
+	callback, err := gitssh.NewKnownHostsCallback(files...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the SSH known hosts: %w", err)
+	}
+	auth.HostKeyCallback = callback
+	return auth, nil
+}
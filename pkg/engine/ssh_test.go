@@ -0,0 +1,274 @@
+package engine
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/kustomize"
+)
+
+func TestCloneWithSSHKey(t *testing.T) {
+	clientKey, keyFile := writeSSHKey(t, nil)
+	server := startSSHGitServer(t, clientKey)
+	c := GitConfig{
+		RepoURL: server.repoURL,
+		Branch:  "main",
+		Path:    "deploy",
+		SSH: SSHConfig{
+			PrivateKeyFile: keyFile,
+			KnownHostsFile: writeKnownHosts(t, server.addr, server.hostKey.PublicKey()),
+		},
+	}
+	r := NewRepository(c, kustomize.New())
+	dir := mkTempDir(t)
+
+	err := r.Clone(dir)
+	assertNoError(t, err)
+
+	want := execGitHead(t, dir)
+	got, err := r.HeadHash()
+	assertNoError(t, err)
+	if want != got.String() {
+		t.Fatalf("incorrect git SHA from HeadHash, got %#v, want %#v", got.String(), want)
+	}
+	if _, err := r.Sync(); err != nil && !upToDate(err) {
+		t.Fatalf("failed to sync: %s", err)
+	}
+}
+
+func TestCloneWithEncryptedSSHKey(t *testing.T) {
+	clientKey, keyFile := writeSSHKey(t, []byte("test-passphrase"))
+	server := startSSHGitServer(t, clientKey)
+	passphraseFile := filepath.Join(t.TempDir(), "passphrase")
+	assertNoError(t, os.WriteFile(passphraseFile, []byte("test-passphrase\n"), 0o600))
+	c := GitConfig{
+		RepoURL: server.repoURL,
+		Branch:  "main",
+		Path:    "deploy",
+		SSH: SSHConfig{
+			PrivateKeyFile: keyFile,
+			PassphraseFile: passphraseFile,
+			KnownHostsFile: writeKnownHosts(t, server.addr, server.hostKey.PublicKey()),
+		},
+	}
+	r := NewRepository(c, kustomize.New())
+
+	err := r.Clone(mkTempDir(t))
+	assertNoError(t, err)
+}
+
+func TestCloneWithUnknownSSHHostKey(t *testing.T) {
+	clientKey, keyFile := writeSSHKey(t, nil)
+	server := startSSHGitServer(t, clientKey)
+	otherHostKey := newSSHSigner(t)
+	c := GitConfig{
+		RepoURL: server.repoURL,
+		Branch:  "main",
+		Path:    "deploy",
+		SSH: SSHConfig{
+			PrivateKeyFile: keyFile,
+			KnownHostsFile: writeKnownHosts(t, server.addr, otherHostKey.PublicKey()),
+		},
+	}
+	r := NewRepository(c, kustomize.New())
+
+	err := r.Clone(mkTempDir(t))
+
+	if err == nil || !strings.Contains(err.Error(), "knownhosts: key mismatch") {
+		t.Fatalf("got error %v, want a host key error", err)
+	}
+}
+
+func TestCloneWithInsecureIgnoreHostKey(t *testing.T) {
+	clientKey, keyFile := writeSSHKey(t, nil)
+	server := startSSHGitServer(t, clientKey)
+	c := GitConfig{
+		RepoURL: server.repoURL,
+		Branch:  "main",
+		Path:    "deploy",
+		SSH: SSHConfig{
+			PrivateKeyFile:        keyFile,
+			InsecureIgnoreHostKey: true,
+		},
+	}
+	r := NewRepository(c, kustomize.New())
+
+	err := r.Clone(mkTempDir(t))
+	assertNoError(t, err)
+}
+
+func TestCloneWithUnauthorizedSSHKey(t *testing.T) {
+	clientKey, _ := writeSSHKey(t, nil)
+	server := startSSHGitServer(t, clientKey)
+	_, otherKeyFile := writeSSHKey(t, nil)
+	c := GitConfig{
+		RepoURL: server.repoURL,
+		Branch:  "main",
+		Path:    "deploy",
+		SSH: SSHConfig{
+			PrivateKeyFile: otherKeyFile,
+			KnownHostsFile: writeKnownHosts(t, server.addr, server.hostKey.PublicKey()),
+		},
+	}
+	r := NewRepository(c, kustomize.New())
+
+	err := r.Clone(mkTempDir(t))
+
+	if err == nil || !strings.Contains(err.Error(), "unable to authenticate") {
+		t.Fatalf("got error %v, want an authentication error", err)
+	}
+}
+
+type sshGitServer struct {
+	addr    string
+	repoURL string
+	hostKey ssh.Signer
+}
+
+// startSSHGitServer starts an SSH server that serves a Git repository with
+// git-upload-pack to clients with the authorized key.
+func startSSHGitServer(t *testing.T, authorized ssh.PublicKey) *sshGitServer {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("this test needs git")
+	}
+	repoDir := makeGitRepository(t)
+	hostKey := newSSHSigner(t)
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized key")
+		},
+	}
+	cfg.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assertNoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSHConn(conn, cfg)
+		}
+	}()
+	return &sshGitServer{
+		addr:    l.Addr().String(),
+		repoURL: "ssh://git@" + l.Addr().String() + repoDir,
+		hostKey: hostKey,
+	}
+}
+
+func serveSSHConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				// The payload is the length prefixed command e.g.
+				// git-upload-pack '/path/to/repo'
+				command := string(req.Payload[4:])
+				args := strings.SplitN(command, " ", 2)
+				cmd := exec.Command("git", strings.TrimPrefix(args[0], "git-"), strings.Trim(args[1], "'"))
+				cmd.Stdin, cmd.Stdout, cmd.Stderr = channel, channel, channel.Stderr()
+				status := make([]byte, 4)
+				if err := cmd.Run(); err != nil {
+					binary.BigEndian.PutUint32(status, 1)
+				}
+				channel.SendRequest("exit-status", false, status)
+				return
+			}
+		}()
+	}
+}
+
+// makeGitRepository creates a repository with a single commit on the main
+// branch, and returns the path.
+func makeGitRepository(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	assertNoError(t, os.MkdirAll(filepath.Join(dir, "deploy"), 0o755))
+	assertNoError(t, os.WriteFile(filepath.Join(dir, "deploy", "kustomization.yaml"), []byte("resources: []\n"), 0o644))
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"add", "."},
+		{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "Initial commit"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %s", strings.Join(args, " "), out)
+		}
+	}
+	return dir
+}
+
+func newSSHSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assertNoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	assertNoError(t, err)
+	return signer
+}
+
+// writeSSHKey writes a new private key in OpenSSH format, encrypted with the
+// passphrase if provided, and returns the public key and the filename.
+func writeSSHKey(t *testing.T, passphrase []byte) (ssh.PublicKey, string) {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	assertNoError(t, err)
+	var block *pem.Block
+	if passphrase != nil {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", passphrase)
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	}
+	assertNoError(t, err)
+	filename := filepath.Join(t.TempDir(), "id_ed25519")
+	assertNoError(t, os.WriteFile(filename, pem.EncodeToMemory(block), 0o600))
+	sshPub, err := ssh.NewPublicKey(pub)
+	assertNoError(t, err)
+	return sshPub, filename
+}
+
+func writeKnownHosts(t *testing.T, addr string, key ssh.PublicKey) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+	assertNoError(t, os.WriteFile(filename, []byte(line+"\n"), 0o600))
+	return filename
+}
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+// Pinned returns the SHA that the application is held on, or an empty string
+// if the application is synchronised automatically.
+func (a *Application) Pinned() string {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	return a.pinned
+}
+
+// Suspended returns true if the synchronisation is suspended until the
+// application is resumed.
+func (a *Application) Suspended() bool {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	return a.suspended
+}
+
+// Suspend stops synchronising the application until it's resumed, it returns
+// false if the application was already suspended.
+//
+// The application isn't suspended if the suspension can't be saved.
+func (a *Application) Suspend() (bool, error) {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	if a.suspended {
+		return false, nil
+	}
+	a.suspended = true
+	if err := a.saveState(); err != nil {
+		a.suspended = false
+		return false, err
+	}
+	return true, nil
+}
+
+// Resume removes the pin and the suspension, and triggers a synchronisation,
+// it returns false if the application was neither pinned nor suspended.
+//
+// The application isn't resumed if the change can't be saved.
+func (a *Application) Resume() (bool, error) {
+	a.stateMu.Lock()
+	pinned, suspended := a.pinned, a.suspended
+	if pinned == "" && !suspended {
+		a.stateMu.Unlock()
+		return false, nil
+	}
+	a.pinned, a.suspended = "", false
+	if err := a.saveState(); err != nil {
+		a.pinned, a.suspended = pinned, suspended
+		a.stateMu.Unlock()
+		return false, err
+	}
+	a.stateMu.Unlock()
+	a.TriggerSync()
+	return true, nil
+}
+
+// pin holds the application on the SHA, failing to save the pin doesn't
+// prevent it, it's only lost on restart.
+func (a *Application) pin(sha string) {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.pinned = sha
+	if err := a.saveState(); err != nil {
+		log.WithField("application", a.Name).Error(err)
+	}
+}
+
+// restoreState restores the pin and the suspension from the state store.
+func (a *Application) restoreState() error {
+	if a.StateStore == nil {
+		return nil
+	}
+	state, err := a.StateStore.LoadState(a.Name)
+	if err != nil {
+		return err
+	}
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.pinned = state.Pinned
+	a.suspended = state.Suspended
+	return nil
+}
+
+// saveState saves the pin and the suspension to the state store, it must be
+// called with the stateMu held.
+func (a *Application) saveState() error {
+	if a.StateStore == nil {
+		return nil
+	}
+	state := recent.State{Suspended: a.suspended, Pinned: a.pinned}
+	if err := a.StateStore.SaveState(a.Name, state); err != nil {
+		return fmt.Errorf("failed to save the state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/recent"
+)
+
+func TestSuspend(t *testing.T) {
+	store := recent.NewMemoryStore()
+	app := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+	app.Resync = make(chan bool, 1)
+	app.StateStore = store
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	if suspended, err := app.Suspend(); err != nil || !suspended {
+		t.Fatalf("Suspend() got %v, %v, want true", suspended, err)
+	}
+	if suspended, err := app.Suspend(); err != nil || suspended {
+		t.Fatalf("Suspend() of a suspended application got %v, %v, want false", suspended, err)
+	}
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	if l := len(syncer.synced()); l != 0 {
+		t.Fatalf("got %d syncs while suspended, want 0", l)
+	}
+	if state, _ := store.LoadState(app.Name); !state.Suspended {
+		t.Fatal("the suspension was not saved")
+	}
+
+	if resumed, err := app.Resume(); err != nil || !resumed {
+		t.Fatalf("Resume() got %v, %v, want true", resumed, err)
+	}
+	if l := len(app.Resync); l != 1 {
+		t.Fatalf("got %d resyncs, want 1", l)
+	}
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	if l := len(syncer.synced()); l != 1 {
+		t.Fatalf("got %d syncs after resuming, want 1", l)
+	}
+	if state, _ := store.LoadState(app.Name); state != (recent.State{}) {
+		t.Fatalf("got state %#v after resuming, want the zero state", state)
+	}
+}
+
+func TestResumeWhenNotSuspended(t *testing.T) {
+	app := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+	app.Resync = make(chan bool, 1)
+
+	if resumed, err := app.Resume(); err != nil || resumed {
+		t.Fatalf("Resume() got %v, %v, want false", resumed, err)
+	}
+	if l := len(app.Resync); l != 0 {
+		t.Fatalf("got %d resyncs, want 0", l)
+	}
+}
+
+func TestSuspendWhenTheStateCantBeSaved(t *testing.T) {
+	app := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+	app.Resync = make(chan bool, 1)
+	app.StateStore = failingStateStore{}
+
+	suspended, err := app.Suspend()
+
+	if err == nil || err.Error() != "failed to save the state: failed to update ConfigMap" {
+		t.Fatalf("got error %v, want the state to fail to save", err)
+	}
+	if suspended || app.Suspended() {
+		t.Fatal("the application was suspended")
+	}
+}
+
+func TestResumeWhenTheStateCantBeSaved(t *testing.T) {
+	app := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+	app.Resync = make(chan bool, 1)
+	if _, err := app.Suspend(); err != nil {
+		t.Fatal(err)
+	}
+	app.StateStore = failingStateStore{}
+
+	resumed, err := app.Resume()
+
+	if err == nil {
+		t.Fatal("Resume() got no error, want the state to fail to save")
+	}
+	if resumed || !app.Suspended() {
+		t.Fatal("the application was resumed")
+	}
+	if l := len(app.Resync); l != 0 {
+		t.Fatalf("got %d resyncs, want 0", l)
+	}
+}
+
+func TestRunRestoresState(t *testing.T) {
+	stateTests := []struct {
+		name  string
+		state recent.State
+	}{
+		{"pinned", recent.State{Pinned: healthySHA}},
+		{"suspended", recent.State{Suspended: true}},
+	}
+
+	for _, tt := range stateTests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := recent.NewMemoryStore()
+			if err := store.SaveState("test-app", tt.state); err != nil {
+				t.Fatal(err)
+			}
+			app := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+			app.StateStore = store
+			syncer := &fakeGitOpsEngine{}
+
+			runSync(t, syncer, app, func() {
+				app.Resync <- true
+			})
+
+			if l := len(syncer.synced()); l != 0 {
+				t.Fatalf("got %d syncs, want 0", l)
+			}
+			if p, s := app.Pinned(), app.Suspended(); p != tt.state.Pinned || s != tt.state.Suspended {
+				t.Fatalf("got pinned %q and suspended %v, want %#v", p, s, tt.state)
+			}
+		})
+	}
+}
+
+type failingStateStore struct{}
+
+func (failingStateStore) LoadState(string) (recent.State, error) {
+	return recent.State{}, nil
+}
+
+func (failingStateStore) SaveState(string, recent.State) error {
+	return errors.New("failed to update ConfigMap")
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// checkSymlinks returns an error if a symlink in the directory resolves to a
+// file outside the directory, symlinks to files that don't exist can't be
+// read, and are ignored.
+func checkSymlinks(dir string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == git.GitDirName {
+			return filepath.SkipDir
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		name, _ := filepath.Rel(root, path)
+		target, err := filepath.EvalSymlinks(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to resolve the symlink %s: %w", name, err)
+		}
+		if rel, err := filepath.Rel(root, target); err != nil || !filepath.IsLocal(rel) {
+			return fmt.Errorf("the symlink %s is outside the repository", name)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/kustomize"
+)
+
+func TestParseManifestsWithConfinedSymlinks(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret.yaml")
+	assertNoError(t, os.WriteFile(outside, []byte("apiVersion: v1\nkind: Secret\n"), 0o600))
+	symlinkTests := []struct {
+		name    string
+		target  string
+		sparse  bool
+		wantErr string
+	}{
+		{"symlink in the repository", "configmap.yaml", false, ""},
+		{"relative symlink out of the repository", "../../../../../../../../.." + outside, false, "the symlink deploy/linked.yaml is outside the repository"},
+		{"absolute symlink in a sparse checkout", outside, true, "the symlink deploy/linked.yaml is outside the repository"},
+		{"missing file", "missing.yaml", false, ""},
+	}
+
+	for _, tt := range symlinkTests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := makeGitRepository(t)
+			assertNoError(t, os.Symlink(tt.target, filepath.Join(source, "deploy", "linked.yaml")))
+			writeConfigMap(t, source, "test-cfg")
+			r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy", Sparse: tt.sparse, Confined: true}, kustomize.New())
+			assertNoError(t, r.Clone(mkTempDir(t)))
+
+			_, err := r.ParseManifests()
+
+			if tt.wantErr == "" {
+				assertNoError(t, err)
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseManifestsWithSymlinksNotConfined(t *testing.T) {
+	source := makeGitRepository(t)
+	assertNoError(t, os.Symlink("/etc/hostname", filepath.Join(source, "deploy", "linked.yaml")))
+	writeConfigMap(t, source, "test-cfg")
+	r := NewRepository(GitConfig{RepoURL: source, Branch: "main", Path: "deploy"}, kustomize.New())
+	assertNoError(t, r.Clone(mkTempDir(t)))
+
+	_, err := r.ParseManifests()
+
+	assertNoError(t, err)
+}
@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSyncWindowClosed is returned when deploying an application outside its
+// sync windows.
+var ErrSyncWindowClosed = errors.New("the application is outside its sync windows")
+
+// SyncWindowOpen returns true if the resources can be applied at the time,
+// because the application's sync windows are open, or are overridden.
+func (a *Application) SyncWindowOpen(t time.Time) bool {
+	if t.Before(a.SyncWindowOverride()) {
+		return true
+	}
+	return a.Config.SyncWindows.Open(t)
+}
+
+// SyncWindowOverride returns the time that the sync windows are overridden
+// until, or the zero time if they are not overridden.
+func (a *Application) SyncWindowOverride() time.Time {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	return a.overrideUntil
+}
+
+// OverrideSyncWindows allows the resources to be applied outside the sync
+// windows until the time, and triggers a synchronisation, the zero time
+// removes the override.
+func (a *Application) OverrideSyncWindows(until time.Time) {
+	a.stateMu.Lock()
+	a.overrideUntil = until
+	a.stateMu.Unlock()
+	if !until.IsZero() {
+		a.TriggerSync()
+	}
+}
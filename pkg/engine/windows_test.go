@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/window"
+)
+
+func TestSynchroniseOutsideSyncWindows(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{makeConfigMap("test-ns", "test-cfg", "a")}
+	app := testApplication(repo)
+	app.Config.SyncWindows = window.Windows{alwaysDenied(t)}
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	if l := len(syncer.synced()); l != 0 {
+		t.Fatalf("got %d syncs, want 0", l)
+	}
+	if _, ok := app.Synchronisations.Latest(); ok {
+		t.Fatal("synchronisation recorded outside the sync windows")
+	}
+}
+
+func TestSynchroniseOutsideSyncWindowsDetectsDrift(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.resources = []*unstructured.Unstructured{makeConfigMap("test-ns", "test-cfg", "a")}
+	app := testApplication(repo)
+	app.Config.SyncWindows = window.Windows{alwaysDenied(t)}
+	app.Config.DetectDriftOutsideWindows = true
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	if l := len(syncer.synced()); l != 0 {
+		t.Fatalf("got %d syncs, want 0", l)
+	}
+	latest, _ := app.Synchronisations.Latest()
+	if !latest.Monitored || len(latest.Diffs) != 1 {
+		t.Fatalf("got monitored %v with %d diffs, want a monitored synchronisation with 1 diff", latest.Monitored, len(latest.Diffs))
+	}
+}
+
+func TestOverrideSyncWindows(t *testing.T) {
+	app := testApplication(newFakeRepository(plumbing.NewHash(testSHA)))
+	app.Resync = make(chan bool, 1)
+	app.Config.SyncWindows = window.Windows{alwaysDenied(t)}
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	app.OverrideSyncWindows(time.Now().Add(time.Hour))
+	if l := len(app.Resync); l != 1 {
+		t.Fatalf("got %d resyncs, want 1", l)
+	}
+	m.synchronise(app, plumbing.NewHash(testSHA), nil, log.WithField("application", app.Name))
+
+	if l := len(syncer.synced()); l != 1 {
+		t.Fatalf("got %d syncs with the override, want 1", l)
+	}
+
+	app.OverrideSyncWindows(time.Time{})
+	if app.SyncWindowOpen(time.Now()) {
+		t.Fatal("sync windows are open after removing the override")
+	}
+}
+
+func TestDeployOutsideSyncWindows(t *testing.T) {
+	repo := newFakeRepository(plumbing.NewHash(testSHA))
+	repo.commits = map[plumbing.Hash][]*unstructured.Unstructured{
+		plumbing.NewHash(healthySHA): {makeConfigMap("test-ns", "deployed-cfg", "a")},
+	}
+	app := testApplication(repo)
+	app.Config.SyncWindows = window.Windows{alwaysDenied(t)}
+	syncer := &fakeGitOpsEngine{}
+	m := &Manager{gitOpsEngine: syncer, clusterCache: &fakeClusterCache{}}
+
+	_, err := m.Deploy(context.Background(), app, plumbing.NewHash(healthySHA))
+
+	if !errors.Is(err, ErrSyncWindowClosed) {
+		t.Fatalf("got error %v, want %v", err, ErrSyncWindowClosed)
+	}
+	if l := len(syncer.synced()); l != 0 {
+		t.Fatalf("got %d syncs, want 0", l)
+	}
+}
+
+// alwaysDenied returns a deny window that starts every minute.
+func alwaysDenied(t *testing.T) window.Window {
+	t.Helper()
+	w, err := window.New(window.Deny, "* * * * *", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
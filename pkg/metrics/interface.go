@@ -1,6 +1,9 @@
 package metrics
 
-import "github.com/argoproj/gitops-engine/pkg/sync/common"
+import (
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/argoproj/gitops-engine/pkg/sync/common"
+)
 
 // Interface implementations provide metrics for the system.
 type Interface interface {
@@ -8,4 +11,20 @@ type Interface interface {
 	Record([]common.ResourceSyncResult)
 	// CountError tracks errors.
 	CountError()
+	// CountHistoryRewritten tracks rewritten history e.g. force-pushes.
+	CountHistoryRewritten()
+	// CountReclone tracks corrupted clones that were cloned again.
+	CountReclone()
+	// CountRejected tracks commits that were not synchronised because the
+	// signature was not trusted.
+	CountRejected()
+	// CountRollback tracks rollbacks to a previous commit, automatic or
+	// manual.
+	CountRollback()
+	// RecordHealth records the aggregate health of the synchronised
+	// resources, and the number of resources with each health status.
+	RecordHealth(health.HealthStatusCode, map[health.HealthStatusCode]int)
+	// RecordOutOfSync records the number of resources that differ from the
+	// manifests, before they are synchronised.
+	RecordOutOfSync(int)
 }
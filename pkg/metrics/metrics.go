@@ -1,18 +1,46 @@
 package metrics
 
 import (
+	"github.com/argoproj/gitops-engine/pkg/health"
 	"github.com/argoproj/gitops-engine/pkg/sync/common"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	applicationLabel = "application"
+	statusLabel      = "status"
+)
+
+// healthStatuses are the health statuses that are gauged, so that statuses
+// that are no longer current are reset.
+var healthStatuses = []health.HealthStatusCode{
+	health.HealthStatusHealthy,
+	health.HealthStatusSuspended,
+	health.HealthStatusProgressing,
+	health.HealthStatusMissing,
+	health.HealthStatusDegraded,
+	health.HealthStatusUnknown,
+}
+
 // PrometheusMetrics is a wrapper around Prometheus metrics for counting
 // events in the system.
+//
+// The metrics are labelled with the name of the application they are recorded
+// for.
 type PrometheusMetrics struct {
-	synced       prometheus.Gauge
-	syncFailed   prometheus.Gauge
-	pruned       prometheus.Gauge
-	pruneSkipped prometheus.Gauge
-	errors       prometheus.Counter
+	application  string
+	synced       *prometheus.GaugeVec
+	syncFailed   *prometheus.GaugeVec
+	pruned       *prometheus.GaugeVec
+	pruneSkipped *prometheus.GaugeVec
+	errors       *prometheus.CounterVec
+	rewritten    *prometheus.CounterVec
+	reclones     *prometheus.CounterVec
+	rejected     *prometheus.CounterVec
+	rollbacks    *prometheus.CounterVec
+	health       *prometheus.GaugeVec
+	resources    *prometheus.GaugeVec
+	outOfSync    *prometheus.GaugeVec
 }
 
 // New creates and returns a PrometheusMetrics initialised with prometheus
@@ -23,44 +51,101 @@ func New(ns string, reg prometheus.Registerer) *PrometheusMetrics {
 		reg = prometheus.DefaultRegisterer
 	}
 
-	pm.synced = prometheus.NewGauge(prometheus.GaugeOpts{
+	pm.synced = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: ns,
 		Name:      "synced",
 		Help:      "Number of resources synced",
-	})
+	}, []string{applicationLabel})
 
-	pm.syncFailed = prometheus.NewGauge(prometheus.GaugeOpts{
+	pm.syncFailed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: ns,
 		Name:      "sync_failed",
 		Help:      "Number of resources that failed to sync",
-	})
+	}, []string{applicationLabel})
 
-	pm.pruned = prometheus.NewGauge(prometheus.GaugeOpts{
+	pm.pruned = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: ns,
 		Name:      "pruned",
 		Help:      "Number of resources pruned",
-	})
+	}, []string{applicationLabel})
 
-	pm.pruneSkipped = prometheus.NewGauge(prometheus.GaugeOpts{
+	pm.pruneSkipped = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: ns,
 		Name:      "prune_skipped",
 		Help:      "Number of resources that the pruning skipped",
-	})
+	}, []string{applicationLabel})
 
-	pm.errors = prometheus.NewCounter(prometheus.CounterOpts{
+	pm.errors = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: ns,
 		Name:      "errors",
 		Help:      "Count of errors during synchronisation",
-	})
+	}, []string{applicationLabel})
+
+	pm.rewritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Name:      "history_rewritten",
+		Help:      "Count of synchronisations where the history was rewritten",
+	}, []string{applicationLabel})
+
+	pm.reclones = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Name:      "reclones",
+		Help:      "Count of corrupted clones that were cloned again",
+	}, []string{applicationLabel})
+
+	pm.rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Name:      "rejected_commits",
+		Help:      "Count of synchronisations refused because the commit signature was not trusted",
+	}, []string{applicationLabel})
+
+	pm.rollbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Name:      "rollbacks",
+		Help:      "Count of rollbacks to a previous commit",
+	}, []string{applicationLabel})
+
+	pm.health = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Name:      "health",
+		Help:      "Aggregate health of the synchronised resources, 1 for the current status",
+	}, []string{applicationLabel, statusLabel})
+
+	pm.resources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Name:      "resources_health",
+		Help:      "Number of synchronised resources with each health status",
+	}, []string{applicationLabel, statusLabel})
+
+	pm.outOfSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Name:      "out_of_sync",
+		Help:      "Number of resources that differ from the manifests",
+	}, []string{applicationLabel})
 
 	reg.MustRegister(pm.synced)
 	reg.MustRegister(pm.syncFailed)
 	reg.MustRegister(pm.pruned)
 	reg.MustRegister(pm.pruneSkipped)
 	reg.MustRegister(pm.errors)
+	reg.MustRegister(pm.rewritten)
+	reg.MustRegister(pm.reclones)
+	reg.MustRegister(pm.rejected)
+	reg.MustRegister(pm.rollbacks)
+	reg.MustRegister(pm.health)
+	reg.MustRegister(pm.resources)
+	reg.MustRegister(pm.outOfSync)
 	return pm
 }
 
+// ForApplication returns a PrometheusMetrics that shares the underlying
+// metrics, but records them with the provided application name.
+func (p *PrometheusMetrics) ForApplication(name string) *PrometheusMetrics {
+	m := *p
+	m.application = name
+	return &m
+}
+
 // Record is an implementation of the metrics Interface.
 func (p *PrometheusMetrics) Record(r []common.ResourceSyncResult) {
 	var synced, syncFailed, pruned, pruneSkipped float64
@@ -76,13 +161,54 @@ func (p *PrometheusMetrics) Record(r []common.ResourceSyncResult) {
 			pruneSkipped++
 		}
 	}
-	p.synced.Set(synced)
-	p.syncFailed.Set(syncFailed)
-	p.pruned.Set(pruned)
-	p.pruneSkipped.Set(pruneSkipped)
+	p.synced.WithLabelValues(p.application).Set(synced)
+	p.syncFailed.WithLabelValues(p.application).Set(syncFailed)
+	p.pruned.WithLabelValues(p.application).Set(pruned)
+	p.pruneSkipped.WithLabelValues(p.application).Set(pruneSkipped)
 }
 
 // CountError counts the number of errors during synchronisation.
 func (m *PrometheusMetrics) CountError() {
-	m.errors.Inc()
+	m.errors.WithLabelValues(m.application).Inc()
+}
+
+// CountHistoryRewritten counts the number of times that the history of the
+// synchronised ref was rewritten.
+func (m *PrometheusMetrics) CountHistoryRewritten() {
+	m.rewritten.WithLabelValues(m.application).Inc()
+}
+
+// CountReclone counts the number of times that a corrupted clone was cloned
+// again.
+func (m *PrometheusMetrics) CountReclone() {
+	m.reclones.WithLabelValues(m.application).Inc()
+}
+
+// CountRejected counts the number of synchronisations that were refused
+// because the commit signature was not trusted.
+func (m *PrometheusMetrics) CountRejected() {
+	m.rejected.WithLabelValues(m.application).Inc()
+}
+
+// CountRollback counts the number of rollbacks to a previous commit.
+func (m *PrometheusMetrics) CountRollback() {
+	m.rollbacks.WithLabelValues(m.application).Inc()
+}
+
+// RecordHealth is an implementation of the metrics Interface.
+func (m *PrometheusMetrics) RecordHealth(status health.HealthStatusCode, resources map[health.HealthStatusCode]int) {
+	for _, v := range healthStatuses {
+		current := 0.0
+		if v == status {
+			current = 1
+		}
+		m.health.WithLabelValues(m.application, string(v)).Set(current)
+		m.resources.WithLabelValues(m.application, string(v)).Set(float64(resources[v]))
+	}
+}
+
+// RecordOutOfSync records the number of resources that differ from the
+// manifests.
+func (m *PrometheusMetrics) RecordOutOfSync(n int) {
+	m.outOfSync.WithLabelValues(m.application).Set(float64(n))
 }
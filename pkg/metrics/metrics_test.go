@@ -4,6 +4,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/argoproj/gitops-engine/pkg/health"
 	"github.com/argoproj/gitops-engine/pkg/sync/common"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
@@ -18,18 +19,18 @@ func TestRecordWithSynced(t *testing.T) {
 		// {Status: common.ResultCodePruned},
 		// {Status: common.ResultCodePruneSkipped},
 	}
-	m := New("testing", prometheus.NewRegistry())
+	m := New("testing", prometheus.NewRegistry()).ForApplication("test-app")
 	m.Record(result)
 
 	assertMetricGauged(t, m, result, m.synced, `
 # HELP testing_synced Number of resources synced
 # TYPE testing_synced gauge
-testing_synced 1
+testing_synced{application="test-app"} 1
 `)
 }
 
 func TestRecordWithSyncFailed(t *testing.T) {
-	m := New("testing", prometheus.NewRegistry())
+	m := New("testing", prometheus.NewRegistry()).ForApplication("test-app")
 	result := []common.ResourceSyncResult{
 		{Status: common.ResultCodeSyncFailed},
 	}
@@ -37,12 +38,12 @@ func TestRecordWithSyncFailed(t *testing.T) {
 	assertMetricGauged(t, m, result, m.syncFailed, `
 # HELP testing_sync_failed Number of resources that failed to sync
 # TYPE testing_sync_failed gauge
-testing_sync_failed 1
+testing_sync_failed{application="test-app"} 1
 `)
 }
 
 func TestRecordWithPruned(t *testing.T) {
-	m := New("testing", prometheus.NewRegistry())
+	m := New("testing", prometheus.NewRegistry()).ForApplication("test-app")
 	result := []common.ResourceSyncResult{
 		{Status: common.ResultCodePruned},
 	}
@@ -50,12 +51,12 @@ func TestRecordWithPruned(t *testing.T) {
 	assertMetricGauged(t, m, result, m.pruned, `
 # HELP testing_pruned Number of resources pruned
 # TYPE testing_pruned gauge
-testing_pruned 1
+testing_pruned{application="test-app"} 1
 `)
 }
 
 func TestRecordWithPruneSkipped(t *testing.T) {
-	m := New("testing", prometheus.NewRegistry())
+	m := New("testing", prometheus.NewRegistry()).ForApplication("test-app")
 	result := []common.ResourceSyncResult{
 		{Status: common.ResultCodePruneSkipped},
 	}
@@ -63,29 +64,165 @@ func TestRecordWithPruneSkipped(t *testing.T) {
 	assertMetricGauged(t, m, result, m.pruneSkipped, `
 # HELP testing_prune_skipped Number of resources that the pruning skipped
 # TYPE testing_prune_skipped gauge
-testing_prune_skipped 1
+testing_prune_skipped{application="test-app"} 1
 `)
 }
 
 func TestCountError(t *testing.T) {
-	m := New("testing", prometheus.NewRegistry())
+	m := New("testing", prometheus.NewRegistry()).ForApplication("test-app")
 
 	m.CountError()
 
 	err := testutil.CollectAndCompare(m.errors, strings.NewReader(`
 # HELP testing_errors Count of errors during synchronisation
 # TYPE testing_errors counter
-testing_errors 1
+testing_errors{application="test-app"} 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCountHistoryRewritten(t *testing.T) {
+	m := New("testing", prometheus.NewRegistry()).ForApplication("test-app")
+
+	m.CountHistoryRewritten()
+
+	err := testutil.CollectAndCompare(m.rewritten, strings.NewReader(`
+# HELP testing_history_rewritten Count of synchronisations where the history was rewritten
+# TYPE testing_history_rewritten counter
+testing_history_rewritten{application="test-app"} 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCountReclone(t *testing.T) {
+	m := New("testing", prometheus.NewRegistry()).ForApplication("test-app")
+
+	m.CountReclone()
+
+	err := testutil.CollectAndCompare(m.reclones, strings.NewReader(`
+# HELP testing_reclones Count of corrupted clones that were cloned again
+# TYPE testing_reclones counter
+testing_reclones{application="test-app"} 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCountRejected(t *testing.T) {
+	m := New("testing", prometheus.NewRegistry()).ForApplication("test-app")
+
+	m.CountRejected()
+
+	err := testutil.CollectAndCompare(m.rejected, strings.NewReader(`
+# HELP testing_rejected_commits Count of synchronisations refused because the commit signature was not trusted
+# TYPE testing_rejected_commits counter
+testing_rejected_commits{application="test-app"} 1
 `))
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-func assertMetricGauged(t *testing.T, m *PrometheusMetrics, r []common.ResourceSyncResult, g prometheus.Gauge, output string) {
+func TestCountRollback(t *testing.T) {
+	m := New("testing", prometheus.NewRegistry()).ForApplication("test-app")
+
+	m.CountRollback()
+
+	err := testutil.CollectAndCompare(m.rollbacks, strings.NewReader(`
+# HELP testing_rollbacks Count of rollbacks to a previous commit
+# TYPE testing_rollbacks counter
+testing_rollbacks{application="test-app"} 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordHealth(t *testing.T) {
+	m := New("testing", prometheus.NewRegistry()).ForApplication("test-app")
+
+	m.RecordHealth(health.HealthStatusProgressing, map[health.HealthStatusCode]int{
+		health.HealthStatusHealthy:     2,
+		health.HealthStatusProgressing: 1,
+	})
+	m.RecordHealth(health.HealthStatusHealthy, map[health.HealthStatusCode]int{
+		health.HealthStatusHealthy: 3,
+	})
+
+	err := testutil.CollectAndCompare(m.health, strings.NewReader(`
+# HELP testing_health Aggregate health of the synchronised resources, 1 for the current status
+# TYPE testing_health gauge
+testing_health{application="test-app",status="Degraded"} 0
+testing_health{application="test-app",status="Healthy"} 1
+testing_health{application="test-app",status="Missing"} 0
+testing_health{application="test-app",status="Progressing"} 0
+testing_health{application="test-app",status="Suspended"} 0
+testing_health{application="test-app",status="Unknown"} 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testutil.CollectAndCompare(m.resources, strings.NewReader(`
+# HELP testing_resources_health Number of synchronised resources with each health status
+# TYPE testing_resources_health gauge
+testing_resources_health{application="test-app",status="Degraded"} 0
+testing_resources_health{application="test-app",status="Healthy"} 3
+testing_resources_health{application="test-app",status="Missing"} 0
+testing_resources_health{application="test-app",status="Progressing"} 0
+testing_resources_health{application="test-app",status="Suspended"} 0
+testing_resources_health{application="test-app",status="Unknown"} 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertMetricGauged(t *testing.T, m *PrometheusMetrics, r []common.ResourceSyncResult, g prometheus.Collector, output string) {
 	m.Record(r)
 	err := testutil.CollectAndCompare(g, strings.NewReader(output))
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestRecordOutOfSync(t *testing.T) {
+	m := New("testing", prometheus.NewRegistry()).ForApplication("test-app")
+
+	m.RecordOutOfSync(3)
+
+	err := testutil.CollectAndCompare(m.outOfSync, strings.NewReader(`
+# HELP testing_out_of_sync Number of resources that differ from the manifests
+# TYPE testing_out_of_sync gauge
+testing_out_of_sync{application="test-app"} 3
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordWithMultipleApplications(t *testing.T) {
+	m := New("testing", prometheus.NewRegistry())
+
+	m.ForApplication("app-1").Record([]common.ResourceSyncResult{
+		{Status: common.ResultCodeSynced},
+	})
+	m.ForApplication("app-2").Record([]common.ResourceSyncResult{
+		{Status: common.ResultCodeSynced},
+		{Status: common.ResultCodeSynced},
+	})
+
+	err := testutil.CollectAndCompare(m.synced, strings.NewReader(`
+# HELP testing_synced Number of resources synced
+# TYPE testing_synced gauge
+testing_synced{application="app-1"} 1
+testing_synced{application="app-2"} 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
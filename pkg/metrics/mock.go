@@ -3,6 +3,7 @@ package metrics
 import (
 	"sync"
 
+	"github.com/argoproj/gitops-engine/pkg/health"
 	"github.com/argoproj/gitops-engine/pkg/sync/common"
 )
 
@@ -16,6 +17,16 @@ type MockMetrics struct {
 	Pruned       int64
 	PruneSkipped int64
 	Errors       int64
+	Rewritten    int64
+	Reclones     int64
+	Rejected     int64
+	Rollbacks    int64
+	// Health and ResourceHealth are the most recently recorded health.
+	Health         health.HealthStatusCode
+	ResourceHealth map[health.HealthStatusCode]int
+	// OutOfSync is the most recently recorded number of resources that are
+	// out of sync.
+	OutOfSync int
 
 	mu sync.Mutex
 }
@@ -48,3 +59,40 @@ func (p *MockMetrics) CountError() {
 	defer p.mu.Unlock()
 	p.Errors++
 }
+
+func (p *MockMetrics) CountHistoryRewritten() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Rewritten++
+}
+
+func (p *MockMetrics) CountReclone() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Reclones++
+}
+
+func (p *MockMetrics) CountRejected() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Rejected++
+}
+
+func (p *MockMetrics) CountRollback() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Rollbacks++
+}
+
+func (p *MockMetrics) RecordHealth(status health.HealthStatusCode, resources map[health.HealthStatusCode]int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Health = status
+	p.ResourceHealth = resources
+}
+
+func (p *MockMetrics) RecordOutOfSync(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.OutOfSync = n
+}
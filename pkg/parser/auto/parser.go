@@ -0,0 +1,82 @@
+package auto
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+)
+
+// The names of the parsers that can be detected.
+const (
+	Kustomize = "kustomize"
+	Helm      = "helm"
+	Jsonnet   = "jsonnet"
+	Manifest  = "manifest"
+)
+
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// New creates and returns a new AutoParser that chooses from the provided
+// parsers, by name.
+func New(parsers map[string]parser.ManifestParser) *AutoParser {
+	return &AutoParser{parsers: parsers}
+}
+
+// AutoParser is an implementation of the ManifestParser that inspects the
+// path, and parses it with the appropriate parser.
+type AutoParser struct {
+	parsers map[string]parser.ManifestParser
+}
+
+// Detect is an implementation of the parser.Detector interface.
+//
+// Kustomize is chosen if the path has a Kustomization, Helm if it has a
+// Chart.yaml, Jsonnet if it has .jsonnet files, and otherwise the path is
+// parsed as plain manifests.
+func (a *AutoParser) Detect(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect the parser for %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		return Manifest, nil
+	}
+	for _, v := range kustomizationFiles {
+		if exists(filepath.Join(path, v)) {
+			return Kustomize, nil
+		}
+	}
+	if exists(filepath.Join(path, "Chart.yaml")) {
+		return Helm, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(path, "*.jsonnet"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) > 0 {
+		return Jsonnet, nil
+	}
+	return Manifest, nil
+}
+
+// Parse is an implementation of ManifestParser.
+func (a *AutoParser) Parse(path string) ([]*unstructured.Unstructured, error) {
+	name, err := a.Detect(path)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := a.parsers[name]
+	if !ok {
+		return nil, fmt.Errorf("detected %s in %s, but the %s parser is not available", name, path, name)
+	}
+	return p.Parse(path)
+}
+
+func exists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
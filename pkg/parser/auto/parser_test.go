@@ -0,0 +1,114 @@
+package auto
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+)
+
+var _ parser.Detector = (*AutoParser)(nil)
+
+func TestDetect(t *testing.T) {
+	detectTests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"kustomization.yaml", []string{"kustomization.yaml", "deployment.yaml"}, Kustomize},
+		{"kustomization.yml", []string{"kustomization.yml"}, Kustomize},
+		{"Kustomization", []string{"Kustomization"}, Kustomize},
+		{"chart", []string{"Chart.yaml", "values.yaml"}, Helm},
+		{"kustomized chart", []string{"Chart.yaml", "kustomization.yaml"}, Kustomize},
+		{"jsonnet", []string{"main.jsonnet", "lib.libsonnet"}, Jsonnet},
+		{"manifests", []string{"deployment.yaml", "service.yaml"}, Manifest},
+		{"empty", nil, Manifest},
+	}
+
+	for _, tt := range detectTests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, tt.files...)
+
+			name, err := New(nil).Detect(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if name != tt.want {
+				t.Fatalf("Detect() got %s, want %s", name, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectWithFile(t *testing.T) {
+	dir := writeFiles(t, "deployment.yaml")
+
+	name, err := New(nil).Detect(filepath.Join(dir, "deployment.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != Manifest {
+		t.Fatalf("Detect() got %s, want %s", name, Manifest)
+	}
+}
+
+func TestDetectWithMissingPath(t *testing.T) {
+	_, err := New(nil).Detect("testdata/unknown")
+
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestParse(t *testing.T) {
+	dir := writeFiles(t, "Chart.yaml")
+	helm := &fakeParser{}
+	a := New(map[string]parser.ManifestParser{Helm: helm, Kustomize: &fakeParser{}})
+
+	_, err := a.Parse(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if helm.parsed != dir {
+		t.Fatalf("got parsed %q, want %q", helm.parsed, dir)
+	}
+}
+
+func TestParseWithUnavailableParser(t *testing.T) {
+	dir := writeFiles(t, "main.jsonnet")
+	a := New(map[string]parser.ManifestParser{Kustomize: &fakeParser{}})
+
+	_, err := a.Parse(dir)
+
+	if err == nil || !strings.Contains(err.Error(), "the jsonnet parser is not available") {
+		t.Fatalf("incorrect error: %v", err)
+	}
+}
+
+type fakeParser struct {
+	parsed string
+}
+
+func (f *fakeParser) Parse(path string) ([]*unstructured.Unstructured, error) {
+	f.parsed = path
+	return nil, nil
+}
+
+func writeFiles(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, v := range files {
+		if err := os.WriteFile(filepath.Join(dir, v), []byte{}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
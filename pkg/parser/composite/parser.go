@@ -0,0 +1,77 @@
+package composite
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+)
+
+// Source is a path that is parsed with a parser.
+type Source struct {
+	// Path is relative to the path that is parsed.
+	Path   string
+	Parser parser.ManifestParser
+}
+
+// New creates and returns a new CompositeParser.
+func New(sources []Source) *CompositeParser {
+	return &CompositeParser{sources: sources}
+}
+
+// CompositeParser is an implementation of the ManifestParser that parses
+// several sources, and combines the resources.
+//
+// Each resource can only be defined by one source.
+type CompositeParser struct {
+	sources []Source
+}
+
+// Parse is an implementation of ManifestParser.
+func (c *CompositeParser) Parse(path string) ([]*unstructured.Unstructured, error) {
+	return c.ParseRevision(path, "")
+}
+
+// ParseRevision is an implementation of RevisionParser, the commit is passed
+// to the parsers of the sources that need it.
+func (c *CompositeParser) ParseRevision(path, sha string) ([]*unstructured.Unstructured, error) {
+	res := []*unstructured.Unstructured{}
+	defined := map[kube.ResourceKey]string{}
+	for _, s := range c.sources {
+		parsed, err := parseSource(s, filepath.Join(path, s.Path), sha)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse source %s: %w", s.Path, err)
+		}
+		for _, v := range parsed {
+			key := kube.GetResourceKey(v)
+			if previous, ok := defined[key]; ok {
+				return nil, fmt.Errorf("resource %s is defined in source %s and source %s", describe(key), previous, s.Path)
+			}
+			defined[key] = s.Path
+		}
+		res = append(res, parsed...)
+	}
+	return res, nil
+}
+
+func parseSource(s Source, path, sha string) ([]*unstructured.Unstructured, error) {
+	if rp, ok := s.Parser.(parser.RevisionParser); ok {
+		return rp.ParseRevision(path, sha)
+	}
+	return s.Parser.Parse(path)
+}
+
+// describe formats the key as e.g. apps/Deployment test-ns/test-deployment.
+func describe(k kube.ResourceKey) string {
+	kind, name := k.Kind, k.Name
+	if k.Group != "" {
+		kind = k.Group + "/" + kind
+	}
+	if k.Namespace != "" {
+		name = k.Namespace + "/" + name
+	}
+	return kind + " " + name
+}
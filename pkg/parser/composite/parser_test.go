@@ -0,0 +1,109 @@
+package composite
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/kustomize"
+	"github.com/bigkevmcd/peanut-engine/pkg/parser/manifest"
+)
+
+var _ parser.RevisionParser = (*CompositeParser)(nil)
+
+func TestCompositeParse(t *testing.T) {
+	c := New([]Source{
+		{Path: "parser/manifest/testdata", Parser: manifest.New()},
+		{Path: "testdata", Parser: &fakeParser{resources: []*unstructured.Unstructured{makeConfigMap("test-ns", "test-cfg")}}},
+	})
+
+	res, err := c.Parse("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := []string{}
+	for _, v := range res {
+		kinds = append(kinds, v.GetKind())
+	}
+	if diff := cmp.Diff([]string{"Deployment", "Namespace", "Service", "ConfigMap"}, kinds); diff != "" {
+		t.Fatalf("parsed resources:\n%s", diff)
+	}
+}
+
+func TestCompositeParseWithDuplicateResources(t *testing.T) {
+	c := New([]Source{
+		{Path: "testdata", Parser: kustomize.New()},
+		{Path: "parser/manifest/testdata", Parser: manifest.New()},
+	})
+
+	_, err := c.Parse("../..")
+
+	want := "resource Namespace taxi-dev is defined in source testdata and source parser/manifest/testdata"
+	if err == nil || err.Error() != want {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+}
+
+func TestCompositeParseWithFailure(t *testing.T) {
+	testErr := errors.New("failed to parse")
+	c := New([]Source{
+		{Path: "crds", Parser: &fakeParser{}},
+		{Path: "workloads", Parser: &fakeParser{err: testErr}},
+	})
+
+	_, err := c.Parse("testdata")
+
+	if !errors.Is(err, testErr) || !strings.Contains(err.Error(), "failed to parse source workloads") {
+		t.Fatalf("incorrect error: %v", err)
+	}
+}
+
+func TestCompositeParseRevision(t *testing.T) {
+	revisioned := &fakeRevisionParser{}
+	c := New([]Source{
+		{Path: "crds", Parser: &fakeParser{}},
+		{Path: "generated", Parser: revisioned},
+	})
+
+	_, err := c.ParseRevision("testdata", "8ef5ff2f4e8ac7f5fa0c1b9a3c1d6f5e0b3f1a2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if revisioned.sha != "8ef5ff2f4e8ac7f5fa0c1b9a3c1d6f5e0b3f1a2c" {
+		t.Fatalf("got sha %q", revisioned.sha)
+	}
+}
+
+type fakeRevisionParser struct {
+	fakeParser
+	sha string
+}
+
+func (f *fakeRevisionParser) ParseRevision(path, sha string) ([]*unstructured.Unstructured, error) {
+	f.sha = sha
+	return f.Parse(path)
+}
+
+type fakeParser struct {
+	resources []*unstructured.Unstructured
+	err       error
+}
+
+func (f *fakeParser) Parse(string) ([]*unstructured.Unstructured, error) {
+	return f.resources, f.err
+}
+
+func makeConfigMap(ns, name string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("v1")
+	u.SetKind("ConfigMap")
+	u.SetNamespace(ns)
+	u.SetName(name)
+	return u
+}
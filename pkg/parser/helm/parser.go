@@ -0,0 +1,82 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+)
+
+// DefaultReleaseName is the release name that charts are rendered with if no
+// release name is provided.
+const DefaultReleaseName = "peanut"
+
+// Options configure how charts are rendered.
+type Options struct {
+	// ReleaseName is the name of the release, available to the templates as
+	// .Release.Name.
+	ReleaseName string
+	// Namespace is the namespace of the release, available to the templates
+	// as .Release.Namespace.
+	Namespace string
+	// ValueFiles are relative to the chart, or absolute, and are applied in
+	// order.
+	ValueFiles []string
+	// Values override the value files, in the same format as helm's --set
+	// e.g. image.tag=v1.0.0
+	Values []string
+}
+
+// New creates and returns a new HelmParser.
+func New(opts Options) *HelmParser {
+	if opts.ReleaseName == "" {
+		opts.ReleaseName = DefaultReleaseName
+	}
+	return &HelmParser{opts: opts, command: "helm"}
+}
+
+// HelmParser is an implementation of the ManifestParser that renders Helm
+// charts with "helm template", releases are not stored in the cluster.
+type HelmParser struct {
+	opts    Options
+	command string
+}
+
+// Parse is an implementation of ManifestParser.
+func (h *HelmParser) Parse(path string) ([]*unstructured.Unstructured, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(h.command, h.args(path)...)
+	cmd.Dir = path
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to render the chart in %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	res, err := parser.ParseYAML(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the rendered chart in %s: %w", path, err)
+	}
+	return res, nil
+}
+
+func (h *HelmParser) args(path string) []string {
+	args := []string{"template", h.opts.ReleaseName, ".", "--include-crds"}
+	if h.opts.Namespace != "" {
+		args = append(args, "--namespace", h.opts.Namespace)
+	}
+	for _, v := range h.opts.ValueFiles {
+		if !filepath.IsAbs(v) {
+			v = filepath.Join(path, v)
+		}
+		args = append(args, "--values", v)
+	}
+	for _, v := range h.opts.Values {
+		args = append(args, "--set", v)
+	}
+	return args
+}
@@ -0,0 +1,127 @@
+package helm
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+)
+
+var _ parser.ManifestParser = (*HelmParser)(nil)
+
+func TestHelmParse(t *testing.T) {
+	if _, err := exec.LookPath("helm"); err != nil {
+		t.Skip("this test needs helm")
+	}
+	h := New(Options{
+		ReleaseName: "taxi",
+		Namespace:   "taxi-dev",
+		ValueFiles:  []string{"values-production.yaml"},
+		Values:      []string{"image.tag=v2.0.0"},
+	})
+
+	res, err := h.Parse("testdata/chart")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(res); l != 2 {
+		t.Fatalf("got %d, want 2", l)
+	}
+	d := findByKind(res, "Deployment")
+	if n := d.GetName(); n != "taxi" {
+		t.Errorf("GetName() got %s, want %s", n, "taxi")
+	}
+	if n := d.GetNamespace(); n != "taxi-dev" {
+		t.Errorf("GetNamespace() got %s, want %s", n, "taxi-dev")
+	}
+	replicas, _, _ := unstructured.NestedInt64(d.Object, "spec", "replicas")
+	if replicas != 3 {
+		t.Errorf("got %d replicas, want 3", replicas)
+	}
+	containers, _, _ := unstructured.NestedSlice(d.Object, "spec", "template", "spec", "containers")
+	if image := containers[0].(map[string]interface{})["image"]; image != "bigkevmcd/taxi:v2.0.0" {
+		t.Errorf("got image %s, want %s", image, "bigkevmcd/taxi:v2.0.0")
+	}
+}
+
+func TestHelmParseArguments(t *testing.T) {
+	h := New(Options{
+		Namespace:  "taxi-dev",
+		ValueFiles: []string{"values-production.yaml", "/etc/peanut/values.yaml"},
+		Values:     []string{"image.tag=v2.0.0", "replicas=2"},
+	})
+	h.command = writeFakeHelm(t, `cat <<EOF
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: args
+data:
+  args: "$*"
+EOF`)
+	chart, err := filepath.Abs("testdata/chart")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := h.Parse(chart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, _, _ := unstructured.NestedString(res[0].Object, "data", "args")
+	want := "template peanut . --include-crds --namespace taxi-dev " +
+		"--values " + filepath.Join(chart, "values-production.yaml") + " --values /etc/peanut/values.yaml " +
+		"--set image.tag=v2.0.0 --set replicas=2"
+	if diff := cmp.Diff(want, args); diff != "" {
+		t.Fatalf("helm arguments:\n%s", diff)
+	}
+}
+
+func TestHelmParseWithFailure(t *testing.T) {
+	h := New(Options{})
+	h.command = writeFakeHelm(t, `echo "Error: Chart.yaml file is missing" >&2
+exit 1`)
+
+	_, err := h.Parse("testdata")
+
+	if err == nil || !strings.Contains(err.Error(), "failed to render the chart in testdata: exit status 1: Error: Chart.yaml file is missing") {
+		t.Fatalf("incorrect error: %v", err)
+	}
+}
+
+func TestHelmParseWithInvalidOutput(t *testing.T) {
+	h := New(Options{})
+	h.command = writeFakeHelm(t, `echo "metadata: {name: test}"`)
+
+	_, err := h.Parse("testdata/chart")
+
+	if err == nil || !strings.Contains(err.Error(), `failed to parse the rendered chart in testdata/chart: resource "test" has no kind`) {
+		t.Fatalf("incorrect error: %v", err)
+	}
+}
+
+// writeFakeHelm writes a shell script that is executed in place of helm.
+func writeFakeHelm(t *testing.T, script string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "helm")
+	if err := os.WriteFile(filename, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func findByKind(r []*unstructured.Unstructured, k string) *unstructured.Unstructured {
+	for _, v := range r {
+		if v.GetKind() == k {
+			return v
+		}
+	}
+	return nil
+}
@@ -8,3 +8,18 @@ import (
 type ManifestParser interface {
 	Parse(string) ([]*unstructured.Unstructured, error)
 }
+
+// Detector is implemented by parsers that choose how to parse each path.
+type Detector interface {
+	ManifestParser
+	// Detect returns the name of the parser that parses the path.
+	Detect(string) (string, error)
+}
+
+// RevisionParser is implemented by parsers that need the commit that is
+// parsed.
+type RevisionParser interface {
+	ManifestParser
+	// ParseRevision parses the path, checked out at the commit SHA.
+	ParseRevision(path, sha string) ([]*unstructured.Unstructured, error)
+}
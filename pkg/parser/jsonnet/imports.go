@@ -0,0 +1,147 @@
+package jsonnet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// importPattern matches the import keywords, Jsonnet requires a string
+// literal after the keyword, but comments can come between them.
+var importPattern = regexp.MustCompile(`\b(import|importstr|importbin)\b\s*`)
+
+// jsonnetImport is an import in a Jsonnet file.
+type jsonnetImport struct {
+	keyword string
+	path    string
+}
+
+// checkImports returns an error if the main file, or any file that it imports,
+// imports a file outside the path.
+//
+// Imports are resolved relative to the importing file, and to each of the
+// library paths, and all of these must be within the path. Symlinks are not
+// resolved.
+func (j *JsonnetParser) checkImports(path, main string) error {
+	root, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	bases := []string{}
+	for _, v := range j.opts.LibPaths {
+		lib := filepath.Join(root, v)
+		if !within(root, lib) {
+			return fmt.Errorf("the library path %q is outside %s", v, path)
+		}
+		bases = append(bases, lib)
+	}
+	seen := map[string]bool{}
+	var check func(string) error
+	check = func(file string) error {
+		if seen[file] {
+			return nil
+		}
+		seen[file] = true
+		b, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(root, file)
+		imports, err := parseImports(b)
+		if err != nil {
+			return fmt.Errorf("failed to check the imports of %s in %s: %w", name, path, err)
+		}
+		for _, imp := range imports {
+			if filepath.IsAbs(imp.path) {
+				return fmt.Errorf("%s in %s imports %q, which is outside the path", name, path, imp.path)
+			}
+			for _, base := range append([]string{filepath.Dir(file)}, bases...) {
+				target := filepath.Join(base, imp.path)
+				if !within(root, target) {
+					return fmt.Errorf("%s in %s imports %q, which is outside the path", name, path, imp.path)
+				}
+				if imp.keyword != "import" {
+					continue
+				}
+				if err := check(target); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	file := filepath.Join(root, main)
+	if !within(root, file) {
+		return fmt.Errorf("%s is outside %s", main, path)
+	}
+	return check(file)
+}
+
+// parseImports returns the imports in the Jsonnet source.
+//
+// Only the plain forms of the string literals are supported, import paths
+// with escapes, text blocks, or comments after the keyword are rejected, as
+// these can't be checked without evaluating the Jsonnet.
+func parseImports(src []byte) ([]jsonnetImport, error) {
+	imports := []jsonnetImport{}
+	for _, loc := range importPattern.FindAllSubmatchIndex(src, -1) {
+		keyword := string(src[loc[2]:loc[3]])
+		path, ok, err := importPath(src[loc[1]:])
+		if err != nil {
+			return nil, fmt.Errorf("%s at offset %d: %w", keyword, loc[0], err)
+		}
+		if ok {
+			imports = append(imports, jsonnetImport{keyword: keyword, path: path})
+		}
+	}
+	return imports, nil
+}
+
+// importPath returns the string literal at the start of the source, and false
+// if the source doesn't start with a string literal, e.g. when the keyword is
+// in a string or a comment.
+func importPath(src []byte) (string, bool, error) {
+	if len(src) == 0 {
+		return "", false, nil
+	}
+	switch src[0] {
+	case '"', '\'':
+		end := bytes.IndexByte(src[1:], src[0])
+		if end == -1 {
+			return "", false, errors.New("unterminated string")
+		}
+		s := src[1 : end+1]
+		if bytes.IndexByte(s, '\\') != -1 {
+			return "", false, errors.New("escapes in import paths are not supported")
+		}
+		return string(s), true, nil
+	case '@':
+		if len(src) < 2 || (src[1] != '"' && src[1] != '\'') {
+			return "", false, errors.New("invalid verbatim string")
+		}
+		end := bytes.IndexByte(src[2:], src[1])
+		if end == -1 {
+			return "", false, errors.New("unterminated string")
+		}
+		if len(src) > end+3 && src[end+3] == src[1] {
+			return "", false, errors.New("escapes in import paths are not supported")
+		}
+		return string(src[2 : end+2]), true, nil
+	case '|', '/', '#':
+		return "", false, errors.New("import paths must be plain string literals")
+	}
+	return "", false, nil
+}
+
+// within returns true if the path is the root, or in the root.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && filepath.IsLocal(rel)
+}
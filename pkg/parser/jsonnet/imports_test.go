@@ -0,0 +1,111 @@
+package jsonnet
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseImports(t *testing.T) {
+	src := `local k = import 'k/k.libsonnet';
+local cfg = importstr "config.ini";
+local logo = importbin @'logo.png';
+// Users import the configuration from the library.
+{ description: 'import this', k: k, cfg: cfg, logo: logo }
+`
+	imports, err := parseImports([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []jsonnetImport{
+		{keyword: "import", path: "k/k.libsonnet"},
+		{keyword: "importstr", path: "config.ini"},
+		{keyword: "importbin", path: "logo.png"},
+	}
+	if diff := cmp.Diff(want, imports, cmp.AllowUnexported(jsonnetImport{})); diff != "" {
+		t.Fatalf("imports:\n%s", diff)
+	}
+}
+
+func TestParseImportsErrors(t *testing.T) {
+	importTests := []struct {
+		src     string
+		wantErr string
+	}{
+		{`importstr "\u002fetc/passwd"`, "escapes in import paths are not supported"},
+		{`importstr @"/etc/""passwd"`, "escapes in import paths are not supported"},
+		{"importstr |||\n  /etc/passwd\n|||", "import paths must be plain string literals"},
+		{`importstr /* comment */ "/etc/passwd"`, "import paths must be plain string literals"},
+		{`import "main.jsonnet`, "unterminated string"},
+	}
+
+	for _, tt := range importTests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := parseImports([]byte(tt.src))
+			assertErrorMatch(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestParseConfined(t *testing.T) {
+	confinedTests := []struct {
+		name     string
+		files    map[string]string
+		main     string
+		libPaths []string
+		wantErr  string
+	}{
+		{"local imports", map[string]string{"main.jsonnet": `(import 'lib/k.libsonnet') + { c: importstr 'config.ini' }`, "lib/k.libsonnet": `{}`}, "", nil, ""},
+		{"library imports", map[string]string{"main.jsonnet": `import 'k.libsonnet'`, "vendor/k.libsonnet": `import 'other.libsonnet'`}, "", []string{"vendor"}, ""},
+		{"absolute import", map[string]string{"main.jsonnet": `importstr '/etc/passwd'`}, "", nil, `main.jsonnet in .* imports "/etc/passwd", which is outside the path`},
+		{"nested import outside the path", map[string]string{"main.jsonnet": `import 'lib/k.libsonnet'`, "lib/k.libsonnet": `importstr '../../secret.txt'`}, "", nil, `lib/k.libsonnet in .* imports "../../secret.txt", which is outside the path`},
+		{"import outside a library path", map[string]string{"lib/main.jsonnet": `importstr '../config.ini'`}, "lib/main.jsonnet", []string{"."}, `imports "../config.ini", which is outside the path`},
+		{"library path outside the path", map[string]string{"main.jsonnet": `{}`}, "", []string{"../vendor"}, `the library path "../vendor" is outside`},
+	}
+
+	for _, tt := range confinedTests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir()
+			for name, body := range tt.files {
+				writeFile(t, filepath.Join(path, name), body)
+			}
+			j := New(Options{Main: tt.main, LibPaths: tt.libPaths, Confined: true})
+			j.command = writeFakeJsonnet(t, `echo '{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "test"}}'`)
+
+			_, err := j.Parse(path)
+
+			assertErrorMatch(t, tt.wantErr, err)
+		})
+	}
+}
+
+func writeFile(t *testing.T, filename, body string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertErrorMatch(t *testing.T, s string, e error) {
+	t.Helper()
+	if s == "" && e == nil {
+		return
+	}
+	if s != "" && e == nil {
+		t.Fatalf("wanted error matching %s, got nil", s)
+	}
+	match, err := regexp.MatchString(s, e.Error())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match {
+		t.Fatalf("error did not match, got %s, want %s", e, s)
+	}
+}
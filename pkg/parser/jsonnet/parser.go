@@ -0,0 +1,155 @@
+package jsonnet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+)
+
+// DefaultMain is the file that is evaluated if no file is provided, and the
+// path has more than one .jsonnet file.
+const DefaultMain = "main.jsonnet"
+
+// Options configure how the Jsonnet is evaluated.
+type Options struct {
+	// Main is the file to evaluate, relative to the path.
+	Main string
+	// ExtVars are available to the Jsonnet as std.extVar(name).
+	ExtVars map[string]string
+	// TLAs are passed as arguments to the top-level function.
+	TLAs map[string]string
+	// LibPaths are searched for imports, relative to the path, e.g. the
+	// vendor directory of jsonnet-bundler.
+	LibPaths []string
+	// Confined rejects Jsonnet that imports files outside the path, for
+	// Jsonnet that isn't trusted.
+	Confined bool
+}
+
+// New creates and returns a new JsonnetParser.
+func New(opts Options) *JsonnetParser {
+	return &JsonnetParser{opts: opts, command: "jsonnet"}
+}
+
+// JsonnetParser is an implementation of the ManifestParser that evaluates
+// Jsonnet with the jsonnet command.
+//
+// The output can be a resource, a List, an array of resources, or an object
+// with resources as the values, and these can be nested.
+type JsonnetParser struct {
+	opts    Options
+	command string
+}
+
+// Parse is an implementation of ManifestParser.
+func (j *JsonnetParser) Parse(path string) ([]*unstructured.Unstructured, error) {
+	main, err := j.main(path)
+	if err != nil {
+		return nil, err
+	}
+	if j.opts.Confined {
+		if err := j.checkImports(path, main); err != nil {
+			return nil, err
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(j.command, j.args(main)...)
+	cmd.Dir = path
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to evaluate %s in %s: %w: %s", main, path, err, strings.TrimSpace(stderr.String()))
+	}
+	var v interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &v); err != nil {
+		return nil, fmt.Errorf("failed to parse the output of %s in %s: %w", main, path, err)
+	}
+	res, err := flatten(v, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the output of %s in %s: %w", main, path, err)
+	}
+	return res, nil
+}
+
+// main returns the file to evaluate, if no file is configured, and there's a
+// single .jsonnet file in the path, that's evaluated.
+func (j *JsonnetParser) main(path string) (string, error) {
+	if j.opts.Main != "" {
+		return j.opts.Main, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(path, "*.jsonnet"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 1 {
+		return filepath.Base(matches[0]), nil
+	}
+	return DefaultMain, nil
+}
+
+func (j *JsonnetParser) args(main string) []string {
+	args := []string{}
+	for _, v := range j.opts.LibPaths {
+		args = append(args, "--jpath", v)
+	}
+	for _, k := range sortedKeys(j.opts.ExtVars) {
+		args = append(args, "--ext-str", k+"="+j.opts.ExtVars[k])
+	}
+	for _, k := range sortedKeys(j.opts.TLAs) {
+		args = append(args, "--tla-str", k+"="+j.opts.TLAs[k])
+	}
+	return append(args, main)
+}
+
+// flatten returns the resources in the evaluated Jsonnet, the key is the
+// location in the output, for errors.
+func flatten(v interface{}, key string) ([]*unstructured.Unstructured, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		res := []*unstructured.Unstructured{}
+		for i, item := range v {
+			items, err := flatten(item, fmt.Sprintf("%s[%d]", key, i))
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, items...)
+		}
+		return res, nil
+	case map[string]interface{}:
+		if _, ok := v["kind"]; ok {
+			return parser.Flatten(&unstructured.Unstructured{Object: v})
+		}
+		res := []*unstructured.Unstructured{}
+		for _, k := range sortedKeys(v) {
+			items, err := flatten(v[k], key+"."+k)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, items...)
+		}
+		return res, nil
+	}
+	if key == "" {
+		key = "the output"
+	}
+	return nil, fmt.Errorf("%s is not a resource, array or object", key)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,162 @@
+package jsonnet
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+)
+
+var _ parser.ManifestParser = (*JsonnetParser)(nil)
+
+func TestJsonnetParse(t *testing.T) {
+	if _, err := exec.LookPath("jsonnet"); err != nil {
+		t.Skip("this test needs jsonnet")
+	}
+	j := New(Options{
+		ExtVars:  map[string]string{"environment": "production"},
+		TLAs:     map[string]string{"namespace": "test-ns"},
+		LibPaths: []string{"../vendor"},
+	})
+
+	res, err := j.Parse("testdata/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"test-ns/test-cfg", "test-ns/test-cfg-1", "test-ns/test-cfg-2"}, names(res)); diff != "" {
+		t.Fatalf("parsed resources:\n%s", diff)
+	}
+	env, _, _ := unstructured.NestedString(res[0].Object, "data", "environment")
+	if env != "production" {
+		t.Fatalf("got environment %q, want %q", env, "production")
+	}
+}
+
+func TestJsonnetParseArguments(t *testing.T) {
+	j := New(Options{
+		ExtVars:  map[string]string{"environment": "production", "cluster": "eu-west-1"},
+		TLAs:     map[string]string{"namespace": "test-ns"},
+		LibPaths: []string{"../vendor", "lib"},
+	})
+	j.command = writeFakeJsonnet(t, `printf '{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "args"}, "data": {"args": "%s"}}' "$*"`)
+
+	res, err := j.Parse("testdata/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, _, _ := unstructured.NestedString(res[0].Object, "data", "args")
+	want := "--jpath ../vendor --jpath lib --ext-str cluster=eu-west-1 --ext-str environment=production --tla-str namespace=test-ns main.jsonnet"
+	if diff := cmp.Diff(want, args); diff != "" {
+		t.Fatalf("jsonnet arguments:\n%s", diff)
+	}
+}
+
+func TestJsonnetParseMain(t *testing.T) {
+	mainTests := []struct {
+		name  string
+		main  string
+		files []string
+		want  string
+	}{
+		{"configured", "environments/prod.jsonnet", []string{"main.jsonnet"}, "environments/prod.jsonnet"},
+		{"single file", "", []string{"app.jsonnet", "lib.libsonnet"}, "app.jsonnet"},
+		{"several files", "", []string{"app.jsonnet", "main.jsonnet"}, "main.jsonnet"},
+	}
+
+	for _, tt := range mainTests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, v := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, v), []byte("{}"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			j := New(Options{Main: tt.main})
+
+			main, err := j.main(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if main != tt.want {
+				t.Fatalf("main() got %q, want %q", main, tt.want)
+			}
+		})
+	}
+}
+
+func TestJsonnetParseOutput(t *testing.T) {
+	j := New(Options{})
+	j.command = writeFakeJsonnet(t, `cat <<EOF
+{
+  "b": [{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "test-cfg-2"}}],
+  "a": {
+    "cfg": {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "test-cfg-1"}},
+    "disabled": null
+  },
+  "c": {"apiVersion": "v1", "kind": "List", "items": [{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "test-cfg-3"}}]}
+}
+EOF`)
+
+	res, err := j.Parse("testdata/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"/test-cfg-1", "/test-cfg-2", "/test-cfg-3"}, names(res)); diff != "" {
+		t.Fatalf("parsed resources:\n%s", diff)
+	}
+}
+
+func TestJsonnetParseErrors(t *testing.T) {
+	errorTests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"failure", `echo "RUNTIME ERROR: Undefined external variable: environment" >&2; exit 1`,
+			"failed to evaluate main.jsonnet in testdata/app: exit status 1: RUNTIME ERROR: Undefined external variable: environment"},
+		{"invalid JSON", `echo "{"`, "failed to parse the output of main.jsonnet in testdata/app"},
+		{"not a resource", `echo '{"a": [{"apiVersion": "v1", "kind": "ConfigMap"}, "value"]}'`, "failed to parse the output of main.jsonnet in testdata/app: .a[1] is not a resource, array or object"},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := New(Options{})
+			j.command = writeFakeJsonnet(t, tt.script)
+
+			_, err := j.Parse("testdata/app")
+
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("incorrect error: %v", err)
+			}
+		})
+	}
+}
+
+// writeFakeJsonnet writes a shell script that is executed in place of
+// jsonnet.
+func writeFakeJsonnet(t *testing.T, script string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "jsonnet")
+	if err := os.WriteFile(filename, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func names(res []*unstructured.Unstructured) []string {
+	n := []string{}
+	for _, v := range res {
+		n = append(n, v.GetNamespace()+"/"+v.GetName())
+	}
+	return n
+}
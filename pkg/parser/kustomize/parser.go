@@ -6,6 +6,8 @@ import (
 	"github.com/bigkevmcd/peanut/pkg/kustomize/parser"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/sops"
 )
 
 // New creates and returns a new KustomizeParser.
@@ -13,14 +15,25 @@ func New() *KustomizeParser {
 	return &KustomizeParser{}
 }
 
+// NewWithDecryptor creates and returns a new KustomizeParser that decrypts
+// files encrypted by SOPS.
+func NewWithDecryptor(d *sops.Decryptor) *KustomizeParser {
+	return &KustomizeParser{decryptor: d}
+}
+
 // KustomizeParser is an implementation of the ManifestParser that can parse
 // from Kustomize definitions.
 type KustomizeParser struct {
+	decryptor *sops.Decryptor
 }
 
 // Parse is an implementation of ManifestParser.
 func (k *KustomizeParser) Parse(path string) ([]*unstructured.Unstructured, error) {
-	resMap, err := parser.ParseTreeToResMap(path, filesys.MakeFsOnDisk())
+	fs := filesys.MakeFsOnDisk()
+	if k.decryptor != nil {
+		fs = k.decryptor.FileSystem(fs)
+	}
+	resMap, err := parser.ParseTreeToResMap(path, fs)
 	if err != nil {
 		return nil, err
 	}
@@ -1,10 +1,13 @@
 package kustomize
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+	"github.com/bigkevmcd/peanut-engine/pkg/sops"
 	"github.com/google/go-cmp/cmp"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -59,6 +62,31 @@ func TestKustomizationParseWithFailure(t *testing.T) {
 	}
 }
 
+func TestKustomizationParseWithDecryptor(t *testing.T) {
+	installFakeSops(t)
+	k := NewWithDecryptor(sops.New(sops.Options{}))
+
+	res, err := k.Parse("testdata/encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets := map[string]interface{}{}
+	for _, v := range res {
+		secrets[v.GetName()] = v.Object["stringData"]
+		if data, ok := v.Object["data"]; ok {
+			secrets[v.GetName()] = data
+		}
+	}
+	want := map[string]interface{}{
+		"database":     map[string]interface{}{"password": "c2VjcmV0"},
+		"database-env": map[string]interface{}{"PASSWORD": "YzJWamNtVjA="},
+	}
+	if diff := cmp.Diff(want, secrets); diff != "" {
+		t.Fatalf("decrypted secrets:\n%s", diff)
+	}
+}
+
 func findByKind(r []*unstructured.Unstructured, k string) *unstructured.Unstructured {
 	for _, v := range r {
 		if v.GetKind() == k {
@@ -67,3 +95,17 @@ func findByKind(r []*unstructured.Unstructured, k string) *unstructured.Unstruct
 	}
 	return nil
 }
+
+// installFakeSops puts a script on the PATH that is executed in place of
+// sops, it "decrypts" the values by removing the encryption metadata.
+func installFakeSops(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+sed -e '/^sops/,$d' -e 's/ENC\[AES256_GCM,data:\([^,]*\),[^]]*\]/\1/g' "$2"
+`
+	if err := os.WriteFile(filepath.Join(dir, "sops"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
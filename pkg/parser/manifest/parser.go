@@ -1,8 +1,14 @@
 package manifest
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+
 	"github.com/manifestival/manifestival"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/sops"
 )
 
 // New creates and returns a new ManifestivalParser.
@@ -10,14 +16,29 @@ func New() *ManifestivalParser {
 	return &ManifestivalParser{}
 }
 
+// NewWithDecryptor creates and returns a new ManifestivalParser that decrypts
+// files encrypted by SOPS.
+func NewWithDecryptor(d *sops.Decryptor) *ManifestivalParser {
+	return &ManifestivalParser{decryptor: d}
+}
+
 // ManifestivalParser is an implementation of the ManifestParser that can parse
 // from Kustomize definitions.
 type ManifestivalParser struct {
+	decryptor *sops.Decryptor
 }
 
 // Parse is an implementation of ManifestParser.
 func (k *ManifestivalParser) Parse(path string) ([]*unstructured.Unstructured, error) {
-	m, err := manifestival.ManifestFrom(manifestival.Path(path))
+	var source manifestival.Source = manifestival.Path(path)
+	if k.decryptor != nil {
+		decrypted, err := k.decrypt(path)
+		if err != nil {
+			return nil, err
+		}
+		source = decrypted
+	}
+	m, err := manifestival.ManifestFrom(source)
 	if err != nil {
 		return nil, err
 	}
@@ -28,3 +49,38 @@ func (k *ManifestivalParser) Parse(path string) ([]*unstructured.Unstructured, e
 	}
 	return res, nil
 }
+
+// decrypt reads the file, or the files in the directory, in the same way as
+// manifestival.Path, decrypting the files that are encrypted.
+func (k *ManifestivalParser) decrypt(path string) (manifestival.Slice, error) {
+	files := []string{path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = []string{}
+		for _, v := range entries {
+			if !v.IsDir() {
+				files = append(files, filepath.Join(path, v.Name()))
+			}
+		}
+	}
+	res := manifestival.Slice{}
+	for _, v := range files {
+		b, err := k.decryptor.ReadFile(v)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := manifestival.Reader(bytes.NewReader(b)).Parse()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, parsed...)
+	}
+	return res, nil
+}
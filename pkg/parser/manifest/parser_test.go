@@ -1,9 +1,12 @@
 package manifest
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+	"github.com/bigkevmcd/peanut-engine/pkg/sops"
 	"github.com/google/go-cmp/cmp"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -50,6 +53,27 @@ func TestManifestParseExtractsResources(t *testing.T) {
 	}
 }
 
+func TestManifestParseWithDecryptor(t *testing.T) {
+	installFakeSops(t)
+	k := NewWithDecryptor(sops.New(sops.Options{}))
+
+	res, err := k.Parse("testdata/encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(res); l != 2 {
+		t.Fatalf("got %d, want 2", l)
+	}
+	password, _, _ := unstructured.NestedString(findByKind(res, "Secret").Object, "stringData", "password")
+	if password != "c2VjcmV0" {
+		t.Fatalf("got password %q, want %q", password, "c2VjcmV0")
+	}
+	if _, ok := findByKind(res, "Secret").Object["sops"]; ok {
+		t.Fatal("secret has the sops metadata")
+	}
+}
+
 func findByKind(r []*unstructured.Unstructured, k string) *unstructured.Unstructured {
 	for _, v := range r {
 		if v.GetKind() == k {
@@ -58,3 +82,17 @@ func findByKind(r []*unstructured.Unstructured, k string) *unstructured.Unstruct
 	}
 	return nil
 }
+
+// installFakeSops puts a script on the PATH that is executed in place of
+// sops, it "decrypts" the values by removing the encryption metadata.
+func installFakeSops(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+sed -e '/^sops/,$d' -e 's/ENC\[AES256_GCM,data:\([^,]*\),[^]]*\]/\1/g' "$2"
+`
+	if err := os.WriteFile(filepath.Join(dir, "sops"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
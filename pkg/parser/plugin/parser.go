@@ -0,0 +1,165 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+)
+
+const (
+	// DefaultTimeout is how long the command can run if no timeout is
+	// provided.
+	DefaultTimeout = time.Minute
+	// DefaultMaxOutput is the number of bytes the command can output if no
+	// limit is provided.
+	DefaultMaxOutput = 10 * 1024 * 1024
+)
+
+// waitDelay is how long to wait for the output to be closed after the
+// command is stopped.
+const waitDelay = 5 * time.Second
+
+// The environment variables that describe what is parsed.
+const (
+	AppNameEnv   = "PEANUT_APP_NAME"
+	SHAEnv       = "PEANUT_COMMIT_SHA"
+	RepoURLEnv   = "PEANUT_REPO_URL"
+	PathEnv      = "PEANUT_PATH"
+	NamespaceEnv = "PEANUT_NAMESPACE"
+)
+
+// inheritedEnv are the only variables from the environment of peanut-engine
+// that are passed to the command.
+var inheritedEnv = []string{"PATH", "HOME"}
+
+// Options configure the command and the environment it runs in.
+type Options struct {
+	// Command is the command and its arguments, it's run in the path that is
+	// parsed, and must output YAML on stdout.
+	Command []string
+	// Env is added to the environment of the command.
+	Env map[string]string
+	// Timeout defaults to DefaultTimeout.
+	Timeout time.Duration
+	// MaxOutput is the number of bytes the command can output, this defaults
+	// to DefaultMaxOutput.
+	MaxOutput int64
+
+	// These are provided to the command in the environment.
+	AppName   string
+	RepoURL   string
+	Path      string
+	Namespace string
+}
+
+// New creates and returns a new PluginParser.
+func New(opts Options) *PluginParser {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxOutput == 0 {
+		opts.MaxOutput = DefaultMaxOutput
+	}
+	return &PluginParser{opts: opts}
+}
+
+// PluginParser is an implementation of the ManifestParser that runs a command
+// that generates the resources as multi-document YAML.
+//
+// The command gets PATH and HOME, the configured environment, and the
+// PEANUT_ variables that describe what is parsed, but not the rest of the
+// environment of peanut-engine, which can contain credentials.
+type PluginParser struct {
+	opts Options
+}
+
+// Parse is an implementation of ManifestParser.
+func (p *PluginParser) Parse(path string) ([]*unstructured.Unstructured, error) {
+	return p.ParseRevision(path, "")
+}
+
+// ParseRevision is an implementation of RevisionParser.
+func (p *PluginParser) ParseRevision(path, sha string) ([]*unstructured.Unstructured, error) {
+	if len(p.opts.Command) == 0 {
+		return nil, errors.New("no plugin command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.Timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: p.opts.MaxOutput, exceeded: cancel}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.opts.Command[0], p.opts.Command[1:]...)
+	cmd.Dir = path
+	cmd.Env = p.env(sha)
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	// Processes started by the command can keep the output open after it's
+	// killed.
+	cmd.WaitDelay = waitDelay
+	err := cmd.Run()
+	switch {
+	case stdout.overflowed:
+		return nil, fmt.Errorf("plugin %s in %s output more than %d bytes", p.opts.Command[0], path, p.opts.MaxOutput)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, fmt.Errorf("plugin %s in %s timed out after %s", p.opts.Command[0], path, p.opts.Timeout)
+	case err != nil:
+		return nil, fmt.Errorf("plugin %s failed in %s: %w: %s", p.opts.Command[0], path, err, strings.TrimSpace(stderr.String()))
+	}
+	res, err := parser.ParseYAML(&stdout.buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the output of plugin %s in %s: %w", p.opts.Command[0], path, err)
+	}
+	return res, nil
+}
+
+func (p *PluginParser) env(sha string) []string {
+	env := []string{}
+	for _, k := range inheritedEnv {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	keys := make([]string, 0, len(p.opts.Env))
+	for k := range p.opts.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+p.opts.Env[k])
+	}
+	return append(env,
+		AppNameEnv+"="+p.opts.AppName,
+		SHAEnv+"="+sha,
+		RepoURLEnv+"="+p.opts.RepoURL,
+		PathEnv+"="+p.opts.Path,
+		NamespaceEnv+"="+p.opts.Namespace,
+	)
+}
+
+// limitedBuffer is a buffer that fails writes that would exceed the limit,
+// and calls exceeded, to stop the command.
+type limitedBuffer struct {
+	buf        bytes.Buffer
+	limit      int64
+	overflowed bool
+	exceeded   func()
+}
+
+func (l *limitedBuffer) Write(b []byte) (int, error) {
+	if int64(l.buf.Len()+len(b)) > l.limit {
+		l.overflowed = true
+		l.exceeded()
+		return 0, errors.New("output limit exceeded")
+	}
+	return l.buf.Write(b)
+}
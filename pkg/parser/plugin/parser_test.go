@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/parser"
+)
+
+var _ parser.RevisionParser = (*PluginParser)(nil)
+
+func TestPluginParse(t *testing.T) {
+	p := New(Options{Command: []string{"./generate.sh"}})
+
+	res, err := p.Parse("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"generated-1", "generated-2"}, names(res)); diff != "" {
+		t.Fatalf("parsed resources:\n%s", diff)
+	}
+}
+
+func TestPluginParseEnvironment(t *testing.T) {
+	t.Setenv("PEANUT_TEST_SECRET", "secret")
+	p := New(Options{
+		Command:   []string{"sh", "-c", `env | grep -E '^(PEANUT_|CLUSTER)' | sort | sed 's/^/  /; s/=/: "/; s/$/"/' | (printf 'apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: env\ndata:\n'; cat)`},
+		Env:       map[string]string{"CLUSTER": "eu-west-1"},
+		AppName:   "taxi",
+		RepoURL:   "https://github.com/org/taxi.git",
+		Path:      "deploy",
+		Namespace: "taxi-prod",
+	})
+
+	res, err := p.ParseRevision("testdata", "8ef5ff2f4e8ac7f5fa0c1b9a3c1d6f5e0b3f1a2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, _, _ := unstructured.NestedStringMap(res[0].Object, "data")
+	want := map[string]string{
+		"CLUSTER":           "eu-west-1",
+		"PEANUT_APP_NAME":   "taxi",
+		"PEANUT_COMMIT_SHA": "8ef5ff2f4e8ac7f5fa0c1b9a3c1d6f5e0b3f1a2c",
+		"PEANUT_NAMESPACE":  "taxi-prod",
+		"PEANUT_PATH":       "deploy",
+		"PEANUT_REPO_URL":   "https://github.com/org/taxi.git",
+	}
+	if diff := cmp.Diff(want, env); diff != "" {
+		t.Fatalf("plugin environment:\n%s", diff)
+	}
+}
+
+func TestPluginParseErrors(t *testing.T) {
+	errorTests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"no command", Options{}, "no plugin command"},
+		{"failure", Options{Command: []string{"sh", "-c", "echo 'unknown environment' >&2; exit 1"}},
+			"plugin sh failed in testdata: exit status 1: unknown environment"},
+		{"timeout", Options{Command: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond},
+			"plugin sleep in testdata timed out after 100ms"},
+		{"output limit", Options{Command: []string{"sh", "-c", "while true; do cat configmap.yaml; done"}, MaxOutput: 1024},
+			"plugin sh in testdata output more than 1024 bytes"},
+		{"invalid YAML", Options{Command: []string{"echo", "{"}},
+			"failed to parse the output of plugin echo in testdata"},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.opts).Parse("testdata")
+
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("incorrect error: %v", err)
+			}
+		})
+	}
+}
+
+func names(res []*unstructured.Unstructured) []string {
+	n := []string{}
+	for _, v := range res {
+		n = append(n, v.GetName())
+	}
+	return n
+}
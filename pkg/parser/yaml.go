@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ParseYAML parses a stream of YAML or JSON documents into resources, empty
+// documents are skipped, and the items of List resources are flattened.
+func ParseYAML(r io.Reader) ([]*unstructured.Unstructured, error) {
+	res := []*unstructured.Unstructured{}
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err != nil {
+			if errors.Is(err, io.EOF) {
+				return res, nil
+			}
+			return nil, fmt.Errorf("failed to decode document %d: %w", len(res)+1, err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		flattened, err := Flatten(&unstructured.Unstructured{Object: obj})
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, flattened...)
+	}
+}
+
+// Flatten returns the items of a List resource, including nested Lists, or
+// the resource itself if it's not a List.
+func Flatten(u *unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	if !u.IsList() {
+		if u.GetKind() == "" {
+			return nil, fmt.Errorf("resource %q has no kind", u.GetName())
+		}
+		return []*unstructured.Unstructured{u}, nil
+	}
+	res := []*unstructured.Unstructured{}
+	err := u.EachListItem(func(o runtime.Object) error {
+		items, err := Flatten(o.(*unstructured.Unstructured))
+		if err != nil {
+			return err
+		}
+		res = append(res, items...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to flatten %s: %w", u.GetKind(), err)
+	}
+	return res, nil
+}
@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseYAML(t *testing.T) {
+	res, err := ParseYAML(strings.NewReader(`---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: test-ns
+---
+# an empty document
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: test-cfg
+- apiVersion: v1
+  kind: List
+  items:
+  - apiVersion: v1
+    kind: Secret
+    metadata:
+      name: test-secret
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := []string{}
+	for _, v := range res {
+		kinds = append(kinds, v.GetKind()+"/"+v.GetName())
+	}
+	want := []string{"Namespace/test-ns", "ConfigMap/test-cfg", "Secret/test-secret"}
+	if diff := cmp.Diff(want, kinds); diff != "" {
+		t.Fatalf("parsed resources:\n%s", diff)
+	}
+}
+
+func TestParseYAMLWithJSON(t *testing.T) {
+	res, err := ParseYAML(strings.NewReader(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "test-cfg"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(res); l != 1 {
+		t.Fatalf("got %d resources, want 1", l)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	parseTests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"invalid YAML", "apiVersion: v1\nkind: [ConfigMap\n", "failed to decode document 1"},
+		{"missing kind", "apiVersion: v1\nmetadata:\n  name: test-cfg\n", `resource "test-cfg" has no kind`},
+		{"missing kind in list", "apiVersion: v1\nkind: List\nitems:\n- metadata:\n    name: test-cfg\n", "failed to flatten List"},
+	}
+
+	for _, tt := range parseTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseYAML(strings.NewReader(tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
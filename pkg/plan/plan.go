@@ -0,0 +1,114 @@
+package plan
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/argoproj/gitops-engine/pkg/diff"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Action is the change that a synchronisation would make to a resource.
+type Action string
+
+const (
+	// ActionCreate indicates that the resource doesn't exist in the cluster.
+	ActionCreate Action = "Create"
+	// ActionUpdate indicates that the resource differs from the cluster.
+	ActionUpdate Action = "Update"
+	// ActionPrune indicates that the resource would be removed from the
+	// cluster.
+	ActionPrune Action = "Prune"
+	// ActionPruneSkipped indicates that the resource is no longer in the
+	// manifests, but pruning is disabled.
+	ActionPruneSkipped Action = "PruneSkipped"
+	// ActionNone indicates that the resource matches the cluster.
+	ActionNone Action = "None"
+)
+
+// Plan is the set of changes that a synchronisation would make.
+type Plan struct {
+	SHA       string           `json:"sha"`
+	Resources []ResourceChange `json:"resources"`
+}
+
+// ResourceChange is the change that a synchronisation would make to a single
+// resource.
+type ResourceChange struct {
+	Group     string `json:"group"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Action    Action `json:"action"`
+}
+
+// Compute compares the target resources with the live resources and returns
+// the changes.
+//
+// The targets and live resources are paired by index, a nil target indicates
+// a live resource that is not in the manifests, and a nil live resource
+// indicates a target resource that doesn't exist in the cluster.
+func Compute(sha string, targets, live []*unstructured.Unstructured, prune bool) (*Plan, error) {
+	diffs, err := diff.DiffArray(targets, live)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare resources: %w", err)
+	}
+	p := &Plan{SHA: sha, Resources: []ResourceChange{}}
+	for i := range targets {
+		var action Action
+		obj := targets[i]
+		switch {
+		case targets[i] == nil && live[i] == nil:
+			continue
+		case targets[i] == nil:
+			obj = live[i]
+			action = ActionPruneSkipped
+			if prune {
+				action = ActionPrune
+			}
+		case live[i] == nil:
+			action = ActionCreate
+		case diffs.Diffs[i].Modified:
+			action = ActionUpdate
+		default:
+			action = ActionNone
+		}
+		key := kube.GetResourceKey(obj)
+		p.Resources = append(p.Resources, ResourceChange{
+			Group:     key.Group,
+			Kind:      key.Kind,
+			Namespace: key.Namespace,
+			Name:      key.Name,
+			Action:    action,
+		})
+	}
+	return p, nil
+}
+
+// Count returns the number of resources with the provided action.
+func (p *Plan) Count(a Action) int {
+	n := 0
+	for _, v := range p.Resources {
+		if v.Action == a {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteTable writes a human-readable table of the changes.
+func (p *Plan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tGROUP\tKIND\tNAMESPACE\tNAME")
+	for _, v := range p.Resources {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", v.Action, v.Group, v.Kind, v.Namespace, v.Name)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nPlan for %s: %d to create, %d to update, %d to prune, %d unchanged.\n",
+		p.SHA, p.Count(ActionCreate), p.Count(ActionUpdate), p.Count(ActionPrune), p.Count(ActionNone))
+	return err
+}
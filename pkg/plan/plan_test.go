@@ -0,0 +1,94 @@
+package plan
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestCompute(t *testing.T) {
+	targets := []*unstructured.Unstructured{
+		makeConfigMap("created", "a"),
+		makeConfigMap("updated", "b"),
+		makeConfigMap("unchanged", "c"),
+		nil,
+	}
+	live := []*unstructured.Unstructured{
+		nil,
+		makeConfigMap("updated", "a"),
+		makeConfigMap("unchanged", "c"),
+		makeConfigMap("pruned", "d"),
+	}
+
+	p, err := Compute("7f193461f0b44fc5e397a63f2ddba8d9453e7a3f", targets, live, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Plan{
+		SHA: "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+		Resources: []ResourceChange{
+			{Kind: "ConfigMap", Namespace: "test-ns", Name: "created", Action: ActionCreate},
+			{Kind: "ConfigMap", Namespace: "test-ns", Name: "updated", Action: ActionUpdate},
+			{Kind: "ConfigMap", Namespace: "test-ns", Name: "unchanged", Action: ActionNone},
+			{Kind: "ConfigMap", Namespace: "test-ns", Name: "pruned", Action: ActionPrune},
+		},
+	}
+	if diff := cmp.Diff(want, p); diff != "" {
+		t.Fatalf("plan failed:\n%s", diff)
+	}
+}
+
+func TestComputeWithoutPruning(t *testing.T) {
+	p, err := Compute("", []*unstructured.Unstructured{nil}, []*unstructured.Unstructured{makeConfigMap("pruned", "d")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a := p.Resources[0].Action; a != ActionPruneSkipped {
+		t.Fatalf("got action %s, want %s", a, ActionPruneSkipped)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	p := &Plan{
+		SHA: "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+		Resources: []ResourceChange{
+			{Group: "apps", Kind: "Deployment", Namespace: "test-ns", Name: "taxi", Action: ActionCreate},
+			{Kind: "ConfigMap", Namespace: "test-ns", Name: "config", Action: ActionNone},
+		},
+	}
+	var b bytes.Buffer
+
+	if err := p.WriteTable(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `ACTION  GROUP  KIND        NAMESPACE  NAME
+Create  apps   Deployment  test-ns    taxi
+None           ConfigMap   test-ns    config
+
+Plan for 7f193461f0b44fc5e397a63f2ddba8d9453e7a3f: 1 to create, 0 to update, 0 to prune, 1 unchanged.
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Fatalf("table output:\n%s", diff)
+	}
+}
+
+func makeConfigMap(name, value string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "test-ns",
+			},
+			"data": map[string]interface{}{
+				"value": value,
+			},
+		},
+	}
+}
@@ -0,0 +1,210 @@
+package recent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/diff"
+)
+
+const (
+	configMapPrefix      = "peanut-history-"
+	configMapHistoryKey  = "history.json"
+	configMapStateKey    = "state.json"
+	applicationLabel     = "peanut.bigkevmcd.com/application"
+	managedByLabel       = "app.kubernetes.io/managed-by"
+	managedByLabelValue  = "peanut-engine"
+	maxConfigMapNameSize = 253
+	configMapHashSize    = 8
+	// maxConfigMapHistorySize leaves space in the 1MiB ConfigMap for the
+	// state and the metadata.
+	maxConfigMapHistorySize = 1000 * 1024
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// ConfigMapStore is a Store that keeps the history of each application in a
+// ConfigMap in the cluster.
+//
+// ConfigMaps are limited in size to 1MiB, which limits the depth of history
+// that can be stored, the oldest synchronisations are not saved if the history
+// doesn't fit.
+type ConfigMapStore struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+// NewConfigMapStore creates and returns a new ConfigMapStore that stores
+// ConfigMaps in the provided namespace.
+func NewConfigMapStore(client kubernetes.Interface, namespace string) *ConfigMapStore {
+	return &ConfigMapStore{client: client, namespace: namespace}
+}
+
+// Load implements the Store interface.
+func (c *ConfigMapStore) Load(application string) ([]Synchronisation, error) {
+	cm, err := c.client.CoreV1().ConfigMaps(c.namespace).Get(context.Background(), configMapName(application), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return []Synchronisation{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history for %s: %w", application, err)
+	}
+	// The ConfigMap is created without the history if the state is saved
+	// first.
+	data := cm.Data[configMapHistoryKey]
+	if data == "" {
+		return []Synchronisation{}, nil
+	}
+	var stored []storedSynchronisation
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse history for %s: %w", application, err)
+	}
+	return fromStored(stored), nil
+}
+
+// Save implements the Store interface.
+func (c *ConfigMapStore) Save(application string, history []Synchronisation) error {
+	b, err := marshalHistory(history, maxConfigMapHistorySize)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history for %s: %w", application, err)
+	}
+	if err := c.update(application, configMapHistoryKey, b); err != nil {
+		return fmt.Errorf("failed to save history for %s: %w", application, err)
+	}
+	return nil
+}
+
+// LoadState implements the StateStore interface.
+//
+// The state is kept in the same ConfigMap as the history.
+func (c *ConfigMapStore) LoadState(application string) (State, error) {
+	var state State
+	cm, err := c.client.CoreV1().ConfigMaps(c.namespace).Get(context.Background(), configMapName(application), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to get state for %s: %w", application, err)
+	}
+	data, ok := cm.Data[configMapStateKey]
+	if !ok {
+		return state, nil
+	}
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		return state, fmt.Errorf("failed to parse state for %s: %w", application, err)
+	}
+	return state, nil
+}
+
+// SaveState implements the StateStore interface.
+func (c *ConfigMapStore) SaveState(application string, state State) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state for %s: %w", application, err)
+	}
+	if err := c.update(application, configMapStateKey, b); err != nil {
+		return fmt.Errorf("failed to save state for %s: %w", application, err)
+	}
+	return nil
+}
+
+// marshalHistory marshals the most recent synchronisations that fit in the
+// size.
+//
+// If the most recent synchronisation doesn't fit on its own, it's saved
+// without the content of its diffs.
+func marshalHistory(history []Synchronisation, size int) ([]byte, error) {
+	for {
+		b, err := json.Marshal(toStored(history))
+		if err != nil || len(b) <= size || len(history) == 0 {
+			return b, err
+		}
+		if len(history) > 1 {
+			history = history[1:]
+			continue
+		}
+		latest := history[0]
+		if !hasDiffContent(latest) {
+			return b, nil
+		}
+		diffs := make([]diff.ResourceDiff, len(latest.Diffs))
+		for i, v := range latest.Diffs {
+			diffs[i] = diff.ResourceDiff{Group: v.Group, Kind: v.Kind, Namespace: v.Namespace, Name: v.Name}
+		}
+		latest.Diffs = diffs
+		history = []Synchronisation{latest}
+	}
+}
+
+func hasDiffContent(s Synchronisation) bool {
+	for _, v := range s.Diffs {
+		if v.Diff != "" || v.Patch != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// update sets the key in the application's ConfigMap, creating the ConfigMap
+// if necessary.
+//
+// The history and the state are saved to the same ConfigMap, so if it was
+// changed, or created, since it was read, it's read again, and the key is set
+// in the new version.
+func (c *ConfigMapStore) update(application, key string, b []byte) error {
+	configMaps := c.client.CoreV1().ConfigMaps(c.namespace)
+	retriable := func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}
+	return retry.OnError(retry.DefaultRetry, retriable, func() error {
+		cm, err := configMaps.Get(context.Background(), configMapName(application), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        configMapName(application),
+					Namespace:   c.namespace,
+					Labels:      map[string]string{managedByLabel: managedByLabelValue},
+					Annotations: map[string]string{applicationLabel: application},
+				},
+				Data: map[string]string{key: string(b)},
+			}
+			_, err := configMaps.Create(context.Background(), cm, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[key] = string(b)
+		_, err = configMaps.Update(context.Background(), cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// configMapName returns a valid ConfigMap name for the application's history.
+//
+// Names are lowercased, and invalid characters replaced, so a hash of the
+// application name is appended to keep the names of different applications
+// distinct.
+func configMapName(application string) string {
+	sum := sha256.Sum256([]byte(application))
+	suffix := "-" + hex.EncodeToString(sum[:])[:configMapHashSize]
+	name := configMapPrefix + strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(application), "-"), ".-")
+	if len(name) > maxConfigMapNameSize-len(suffix) {
+		name = strings.TrimRight(name[:maxConfigMapNameSize-len(suffix)], ".-")
+	}
+	return name + suffix
+}
@@ -0,0 +1,178 @@
+package recent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/diff"
+)
+
+func TestConfigMapStore(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := NewConfigMapStore(client, "peanut-system")
+
+	testStore(t, store)
+
+	cm, err := client.CoreV1().ConfigMaps("peanut-system").Get(context.Background(), configMapName("test-app"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := cm.Annotations[applicationLabel]; v != "test-app" {
+		t.Fatalf("got application annotation %q, want test-app", v)
+	}
+}
+
+func TestConfigMapStoreWithConflicts(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := NewConfigMapStore(client, "peanut-system")
+	b, err := marshalHistory([]Synchronisation{{SHA: "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f"}}, maxConfigMapHistorySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gvr := corev1.SchemeGroupVersion.WithResource("configmaps")
+	gr := schema.GroupResource{Resource: "configmaps"}
+	// The history is saved while the state is saved, so the ConfigMap exists
+	// when it's created, and has changed when it's updated.
+	conflicted := map[string]bool{}
+	client.PrependReactor("*", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		verb := action.GetVerb()
+		if (verb != "create" && verb != "update") || conflicted[verb] {
+			return false, nil, nil
+		}
+		conflicted[verb] = true
+		if verb == "create" {
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: configMapName("test-app"), Namespace: "peanut-system"},
+				Data:       map[string]string{configMapHistoryKey: string(b)},
+			}
+			if err := client.Tracker().Create(gvr, cm, "peanut-system"); err != nil {
+				t.Fatal(err)
+			}
+			return true, nil, apierrors.NewAlreadyExists(gr, configMapName("test-app"))
+		}
+		return true, nil, apierrors.NewConflict(gr, configMapName("test-app"), errors.New("the object has been modified"))
+	})
+
+	if err := store.SaveState("test-app", State{Suspended: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := store.Load("test-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(history); l != 1 {
+		t.Fatalf("got %d synchronisations, want 1", l)
+	}
+	state, err := store.LoadState("test-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Suspended {
+		t.Fatal("the suspension was not saved")
+	}
+	if !conflicted["update"] {
+		t.Fatal("the ConfigMap was not updated after the conflict")
+	}
+}
+
+func TestConfigMapStoreLoadWithOnlyState(t *testing.T) {
+	store := NewConfigMapStore(fake.NewSimpleClientset(), "peanut-system")
+	if err := store.SaveState("test-app", State{Suspended: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := store.Load("test-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(history); l != 0 {
+		t.Fatalf("got %d synchronisations, want 0", l)
+	}
+}
+
+func TestMarshalHistoryDropsOldSynchronisations(t *testing.T) {
+	history := []Synchronisation{}
+	for i := 1; i <= 5; i++ {
+		history = append(history, Synchronisation{ID: int64(i), Diffs: []diff.ResourceDiff{{Name: "test-cfg", Diff: strings.Repeat("a", 400)}}})
+	}
+
+	b, err := marshalHistory(history, 1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(b); l > 1200 {
+		t.Fatalf("got history of %d bytes, want no more than 1200", l)
+	}
+	var stored []storedSynchronisation
+	if err := json.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if l := len(stored); l != 2 || stored[1].ID != 5 {
+		t.Fatalf("got %d synchronisations, want the 2 most recent", l)
+	}
+}
+
+func TestMarshalHistoryDropsLargeDiffs(t *testing.T) {
+	history := []Synchronisation{
+		{ID: 1, Diffs: []diff.ResourceDiff{{Name: "test-cfg", Diff: strings.Repeat("a", 2000)}}},
+	}
+
+	b, err := marshalHistory(history, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored []storedSynchronisation
+	if err := json.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if l := len(stored); l != 1 {
+		t.Fatalf("got %d synchronisations, want 1", l)
+	}
+	if d := stored[0].Diffs[0]; d.Name != "test-cfg" || d.Diff != "" {
+		t.Fatalf("got diff %#v, want the resource without the diff", d)
+	}
+}
+
+func TestConfigMapName(t *testing.T) {
+	nameTests := []struct {
+		application string
+		want        string
+	}{
+		{"test-app", "peanut-history-test-app-b58b0cb4"},
+		{"test-ns.Test_App", "peanut-history-test-ns.test-app-ae06fbde"},
+		{"App_A", "peanut-history-app-a-77841dab"},
+		{"app-a", "peanut-history-app-a-f2524ca2"},
+	}
+
+	for _, tt := range nameTests {
+		if got := configMapName(tt.application); got != tt.want {
+			t.Errorf("configMapName(%q) got %q, want %q", tt.application, got, tt.want)
+		}
+	}
+}
+
+func TestConfigMapNameWithLongName(t *testing.T) {
+	name := configMapName(strings.Repeat("a", 300))
+
+	if l := len(name); l != maxConfigMapNameSize {
+		t.Fatalf("got name of length %d, want %d", l, maxConfigMapNameSize)
+	}
+	if name == configMapName(strings.Repeat("a", 301)) {
+		t.Fatal("long names that differ got the same ConfigMap name")
+	}
+}
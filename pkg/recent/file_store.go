@@ -0,0 +1,112 @@
+package recent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// FileStore is a Store that keeps the history of each application in a JSON
+// file in a directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates and returns a new FileStore, creating the directory if
+// necessary.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory %s: %w", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Load implements the Store interface.
+func (f *FileStore) Load(application string) ([]Synchronisation, error) {
+	b, err := os.ReadFile(f.filename(application))
+	if errors.Is(err, os.ErrNotExist) {
+		return []Synchronisation{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history for %s: %w", application, err)
+	}
+	var stored []storedSynchronisation
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse history for %s: %w", application, err)
+	}
+	return fromStored(stored), nil
+}
+
+// Save implements the Store interface.
+//
+// The history is written to a temporary file, and then renamed, so that a
+// failure doesn't leave a partially written file.
+func (f *FileStore) Save(application string, history []Synchronisation) error {
+	b, err := json.Marshal(toStored(history))
+	if err != nil {
+		return fmt.Errorf("failed to marshal history for %s: %w", application, err)
+	}
+	if err := f.write(f.filename(application), b); err != nil {
+		return fmt.Errorf("failed to save history for %s: %w", application, err)
+	}
+	return nil
+}
+
+// LoadState implements the StateStore interface.
+func (f *FileStore) LoadState(application string) (State, error) {
+	var state State
+	b, err := os.ReadFile(f.stateFilename(application))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read state for %s: %w", application, err)
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return state, fmt.Errorf("failed to parse state for %s: %w", application, err)
+	}
+	return state, nil
+}
+
+// SaveState implements the StateStore interface.
+func (f *FileStore) SaveState(application string, state State) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state for %s: %w", application, err)
+	}
+	if err := f.write(f.stateFilename(application), b); err != nil {
+		return fmt.Errorf("failed to save state for %s: %w", application, err)
+	}
+	return nil
+}
+
+// write replaces the content of the file through a temporary file.
+func (f *FileStore) write(filename string, b []byte) error {
+	tmp, err := os.CreateTemp(f.dir, ".history-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+func (f *FileStore) filename(application string) string {
+	return filepath.Join(f.dir, url.PathEscape(application)+".json")
+}
+
+// stateFilename returns the file for the application's state, which has a
+// different extension, so that it can't be the history of another
+// application.
+func (f *FileStore) stateFilename(application string) string {
+	return filepath.Join(f.dir, url.PathEscape(application)+".state")
+}
@@ -0,0 +1,32 @@
+package recent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "history"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+}
+
+func TestFileStoreWithCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "test-app.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Load("test-app")
+	if err == nil {
+		t.Fatal("expected an error loading a corrupt file")
+	}
+}
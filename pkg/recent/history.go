@@ -0,0 +1,89 @@
+package recent
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultHistoryLimit = 20
+
+// historyQuery filters and paginates the synchronisation history.
+type historyQuery struct {
+	sha    string
+	failed *bool
+	since  time.Time
+	until  time.Time
+	before int64
+	limit  int
+}
+
+// parseHistoryQuery parses the query parameters for the history.
+//
+//	sha    - synchronisations of SHAs with this prefix
+//	failed - "true" for failed synchronisations, "false" for successful ones
+//	since  - synchronisations that started at or after this RFC3339 time
+//	until  - synchronisations that started before this RFC3339 time
+//	before - synchronisations with an ID less than this, for pagination
+//	limit  - the maximum number of synchronisations to return
+func parseHistoryQuery(v url.Values) (historyQuery, error) {
+	q := historyQuery{sha: strings.ToLower(v.Get("sha")), limit: defaultHistoryLimit}
+	var err error
+	if s := v.Get("failed"); s != "" {
+		failed, parseErr := strconv.ParseBool(s)
+		if parseErr != nil {
+			return q, fmt.Errorf("invalid failed parameter %q", s)
+		}
+		q.failed = &failed
+	}
+	if q.since, err = parseTime(v, "since"); err != nil {
+		return q, err
+	}
+	if q.until, err = parseTime(v, "until"); err != nil {
+		return q, err
+	}
+	if s := v.Get("before"); s != "" {
+		if q.before, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return q, fmt.Errorf("invalid before parameter %q", s)
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.limit, err = strconv.Atoi(s); err != nil || q.limit < 1 {
+			return q, fmt.Errorf("invalid limit parameter %q", s)
+		}
+	}
+	return q, nil
+}
+
+func parseTime(v url.Values, name string) (time.Time, error) {
+	s := v.Get(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s parameter %q, must be RFC3339", name, s)
+	}
+	return t, nil
+}
+
+func (q historyQuery) matches(s Synchronisation) bool {
+	if q.before != 0 && s.ID >= q.before {
+		return false
+	}
+	if !strings.HasPrefix(s.SHA, q.sha) {
+		return false
+	}
+	if q.failed != nil && s.Failed() != *q.failed {
+		return false
+	}
+	if !q.since.IsZero() && s.Start.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && !s.Start.Before(q.until) {
+		return false
+	}
+	return true
+}
@@ -4,57 +4,265 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/argoproj/gitops-engine/pkg/health"
 	"github.com/argoproj/gitops-engine/pkg/sync/common"
 	"github.com/julienschmidt/httprouter"
 )
 
-// TODO: Currently there's only support for the most recent synchronisation.
-// Need to work out to safely provide access to the others.
-
 // RecentRouter is an HTTP API for accessing recent synchronisations.
 type RecentRouter struct {
 	*httprouter.Router
 	recent *RecentSynchronisations
+
+	mu           sync.RWMutex
+	applications map[string]*RecentSynchronisations
 }
 
 // GetLatest returns the most recent synchronisation log.
 func (a *RecentRouter) GetLatest(w http.ResponseWriter, r *http.Request) {
-	err := json.NewEncoder(w).Encode(makeSynchronisationResponse(a.recent.Latest()))
+	a.writeLatest(w, a.recent)
+}
+
+// GetApplications returns the names of the registered applications.
+func (a *RecentRouter) GetApplications(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	names := []string{}
+	for k := range a.applications {
+		names = append(names, k)
+	}
+	a.mu.RUnlock()
+	sort.Strings(names)
+
+	err := json.NewEncoder(w).Encode(responseApplications{Applications: names})
 	if err != nil {
-		log.Printf("ERROR: failed to marshal recent entries: %s", err)
+		log.Printf("ERROR: failed to marshal applications: %s", err)
 	}
 }
 
+// GetApplicationLatest returns the most recent synchronisation log for a
+// named application.
+func (a *RecentRouter) GetApplicationLatest(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	a.writeLatest(w, a.application(params.ByName("name")))
+}
+
+// GetHistory returns the recorded synchronisations, most recent first.
+//
+// See parseHistoryQuery for the supported query parameters.
+func (a *RecentRouter) GetHistory(w http.ResponseWriter, r *http.Request) {
+	a.writeHistory(w, r, a.recent)
+}
+
+// GetHistoryItem returns an individual synchronisation.
+func (a *RecentRouter) GetHistoryItem(w http.ResponseWriter, r *http.Request) {
+	a.writeHistoryItem(w, r, a.recent)
+}
+
+// GetApplicationHistory returns the recorded synchronisations for a named
+// application, most recent first.
+func (a *RecentRouter) GetApplicationHistory(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	a.writeHistory(w, r, a.application(params.ByName("name")))
+}
+
+// GetApplicationHistoryItem returns an individual synchronisation for a named
+// application.
+func (a *RecentRouter) GetApplicationHistoryItem(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	a.writeHistoryItem(w, r, a.application(params.ByName("name")))
+}
+
+// AddApplication registers the synchronisations for a named application.
+func (a *RecentRouter) AddApplication(name string, r *RecentSynchronisations) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.applications[name] = r
+}
+
+// RemoveApplication removes the synchronisations for a named application.
+func (a *RecentRouter) RemoveApplication(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.applications, name)
+}
+
 // NewRouter creates and returns a new RecentRouter.
+//
+// The provided synchronisations are served from /latest.
 func NewRouter(r *RecentSynchronisations) *RecentRouter {
-	api := &RecentRouter{Router: httprouter.New(), recent: r}
+	api := &RecentRouter{Router: httprouter.New(), recent: r, applications: map[string]*RecentSynchronisations{}}
 	api.HandlerFunc(http.MethodGet, "/latest", api.GetLatest)
+	api.HandlerFunc(http.MethodGet, "/history", api.GetHistory)
+	api.HandlerFunc(http.MethodGet, "/history/:id", api.GetHistoryItem)
+	api.HandlerFunc(http.MethodGet, "/applications", api.GetApplications)
+	api.HandlerFunc(http.MethodGet, "/applications/:name/latest", api.GetApplicationLatest)
+	api.HandlerFunc(http.MethodGet, "/applications/:name/history", api.GetApplicationHistory)
+	api.HandlerFunc(http.MethodGet, "/applications/:name/history/:id", api.GetApplicationHistoryItem)
 	return api
 }
 
+func (a *RecentRouter) application(name string) *RecentSynchronisations {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.applications[name]
+}
+
+func (a *RecentRouter) writeLatest(w http.ResponseWriter, recent *RecentSynchronisations) {
+	if recent == nil {
+		http.Error(w, "application not found", http.StatusNotFound)
+		return
+	}
+	latest, ok := recent.Latest()
+	if !ok {
+		http.Error(w, "no synchronisations recorded", http.StatusNotFound)
+		return
+	}
+	err := json.NewEncoder(w).Encode(makeSynchronisationResponse(latest))
+	if err != nil {
+		log.Printf("ERROR: failed to marshal recent entries: %s", err)
+	}
+}
+
+func (a *RecentRouter) writeHistory(w http.ResponseWriter, r *http.Request, recent *RecentSynchronisations) {
+	if recent == nil {
+		http.Error(w, "application not found", http.StatusNotFound)
+		return
+	}
+	q, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res := responseHistory{Synchronisations: []responseSync{}}
+	for _, v := range recent.History() {
+		if !q.matches(v) {
+			continue
+		}
+		if len(res.Synchronisations) == q.limit {
+			res.Next = res.Synchronisations[q.limit-1].ID
+			break
+		}
+		res.Synchronisations = append(res.Synchronisations, makeSynchronisationResponse(v))
+	}
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		log.Printf("ERROR: failed to marshal history: %s", err)
+	}
+}
+
+func (a *RecentRouter) writeHistoryItem(w http.ResponseWriter, r *http.Request, recent *RecentSynchronisations) {
+	if recent == nil {
+		http.Error(w, "application not found", http.StatusNotFound)
+		return
+	}
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid synchronisation id", http.StatusBadRequest)
+		return
+	}
+	s, ok := recent.Get(id)
+	if !ok {
+		http.Error(w, "synchronisation not found", http.StatusNotFound)
+		return
+	}
+	err = json.NewEncoder(w).Encode(makeSynchronisationResponse(s))
+	if err != nil {
+		log.Printf("ERROR: failed to marshal synchronisation: %s", err)
+	}
+}
+
 func makeSynchronisationResponse(s Synchronisation) responseSync {
 	r := responseSync{
-		Start:   s.Start.Format(time.RFC3339),
-		End:     s.End.Format(time.RFC3339),
-		SHA:     s.SHA,
-		Error:   s.Error.Error(),
-		Results: []responseSyncItem{},
+		ID:               s.ID,
+		Start:            s.Start.Format(time.RFC3339),
+		End:              s.End.Format(time.RFC3339),
+		SHA:              s.SHA,
+		Ref:              s.Ref,
+		HistoryRewritten: s.HistoryRewritten,
+		PreviousSHA:      s.PreviousSHA,
+		Recloned:         s.Recloned,
+		Rejected:         s.Rejected,
+		SignedBy:         s.SignedBy,
+		Parser:           s.Parser,
+		RollbackFrom:     s.RollbackFrom,
+		Pinned:           s.Pinned,
+		Monitored:        s.Monitored,
+		Health:           s.Health,
+		Results:          []responseSyncItem{},
+		OutOfSync:        []responseResource{},
+		ResourceHealth:   s.ResourceHealth,
+	}
+	if s.Error != nil {
+		r.Error = s.Error.Error()
 	}
 	for _, v := range s.Results {
 		r.Results = append(r.Results, makeSyncItem(v))
 	}
+	for _, v := range s.Diffs {
+		r.OutOfSync = append(r.OutOfSync, responseResource{Group: v.Group, Kind: v.Kind, Namespace: v.Namespace, Name: v.Name})
+	}
 
 	return r
 }
 
+type responseApplications struct {
+	Applications []string `json:"applications"`
+}
+
+type responseHistory struct {
+	Synchronisations []responseSync `json:"synchronisations"`
+	// Next is the value for the "before" parameter to get the next page, it's
+	// omitted on the last page.
+	Next int64 `json:"next,omitempty"`
+}
+
 type responseSync struct {
-	Start   string             `json:"startTime"`
-	End     string             `json:"endTime"`
-	SHA     string             `json:"sha"`
+	ID    int64  `json:"id"`
+	Start string `json:"startTime"`
+	End   string `json:"endTime"`
+	SHA   string `json:"sha"`
+	Ref   string `json:"ref,omitempty"`
+	// HistoryRewritten is true if the ref was rewritten since the
+	// PreviousSHA was synchronised.
+	HistoryRewritten bool   `json:"historyRewritten,omitempty"`
+	PreviousSHA      string `json:"previousSHA,omitempty"`
+	Recloned         bool   `json:"recloned,omitempty"`
+	// Rejected is true if the commit\'s signature was not trusted.
+	Rejected bool   `json:"rejected,omitempty"`
+	SignedBy string `json:"signedBy,omitempty"`
+	// Parser is the parser that was detected for the manifests.
+	Parser  string             `json:"parser,omitempty"`
 	Error   string             `json:"error"`
 	Results []responseSyncItem `json:"results"`
+	// OutOfSync are the resources that differed from the manifests before
+	// synchronising.
+	OutOfSync []responseResource `json:"outOfSync"`
+	// Health is the health of the resources after synchronising, these are
+	// omitted if the health was not assessed.
+	Health         health.HealthStatusCode `json:"health,omitempty"`
+	ResourceHealth []ResourceHealth        `json:"resourceHealth,omitempty"`
+	// RollbackFrom is the SHA that degraded the resources, if this
+	// synchronisation rolled back.
+	RollbackFrom string `json:"rollbackFrom,omitempty"`
+	// Pinned is true if the application is held on the SHA until it's
+	// resumed.
+	Pinned bool `json:"pinned,omitempty"`
+	// Monitored is true if the resources were only compared with the
+	// cluster, and OutOfSync is the drift from the manifests.
+	Monitored bool `json:"monitored,omitempty"`
+}
+
+type responseResource struct {
+	Group     string `json:"group"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
 }
 
 type responseSyncItem struct {
@@ -12,10 +12,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/argoproj/gitops-engine/pkg/health"
 	"github.com/argoproj/gitops-engine/pkg/sync/common"
 	"github.com/argoproj/gitops-engine/pkg/utils/kube"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/google/go-cmp/cmp"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/diff"
 )
 
 func TestGetLatest(t *testing.T) {
@@ -43,6 +46,7 @@ func TestGetLatest(t *testing.T) {
 	}
 
 	assertJSONResponse(t, res, map[string]interface{}{
+		"id":        float64(1),
 		"startTime": "2020-06-24T22:00:00Z",
 		"endTime":   "2020-06-24T22:01:00Z",
 		"sha":       sha,
@@ -57,9 +61,328 @@ func TestGetLatest(t *testing.T) {
 				"status":    "SyncFailed",
 			},
 		},
+		"outOfSync": []interface{}{},
+	})
+}
+
+func TestGetLatestWithOutOfSyncResources(t *testing.T) {
+	ts, s := makeServer(t)
+	start, end := time.Date(2020, time.June, 24, 22, 0, 0, 0, time.UTC), time.Date(2020, time.June, 24, 22, 1, 0, 0, time.UTC)
+	sha := "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f"
+	s.Record(Synchronisation{
+		Start: start, End: end, SHA: sha,
+		Diffs: []diff.ResourceDiff{
+			{Kind: "ConfigMap", Namespace: "test", Name: "test-cfg", Diff: "--- live\n+++ desired\n"},
+		},
+	})
+
+	req := makeClientRequest(t, fmt.Sprintf("%s/latest", ts.URL))
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertJSONResponse(t, res, map[string]interface{}{
+		"id":        float64(1),
+		"startTime": "2020-06-24T22:00:00Z",
+		"endTime":   "2020-06-24T22:01:00Z",
+		"sha":       sha,
+		"error":     "",
+		"results":   []interface{}{},
+		"outOfSync": []interface{}{
+			map[string]interface{}{
+				"group":     "",
+				"kind":      "ConfigMap",
+				"namespace": "test",
+				"name":      "test-cfg",
+			},
+		},
+	})
+}
+
+func TestGetLatestWithHealth(t *testing.T) {
+	ts, s := makeServer(t)
+	start, end := time.Date(2020, time.June, 24, 22, 0, 0, 0, time.UTC), time.Date(2020, time.June, 24, 22, 1, 0, 0, time.UTC)
+	sha := "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f"
+	s.Record(Synchronisation{
+		Start: start, End: end, SHA: sha,
+		Health: health.HealthStatusProgressing,
+		ResourceHealth: []ResourceHealth{
+			{Group: "apps", Kind: "Deployment", Namespace: "test", Name: "taxi", Status: health.HealthStatusProgressing, Message: "Waiting for rollout to finish"},
+		},
+	})
+
+	req := makeClientRequest(t, fmt.Sprintf("%s/latest", ts.URL))
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertJSONResponse(t, res, map[string]interface{}{
+		"id":        float64(1),
+		"startTime": "2020-06-24T22:00:00Z",
+		"endTime":   "2020-06-24T22:01:00Z",
+		"sha":       sha,
+		"error":     "",
+		"results":   []interface{}{},
+		"outOfSync": []interface{}{},
+		"health":    "Progressing",
+		"resourceHealth": []interface{}{
+			map[string]interface{}{
+				"group":     "apps",
+				"kind":      "Deployment",
+				"namespace": "test",
+				"name":      "taxi",
+				"status":    "Progressing",
+				"message":   "Waiting for rollout to finish",
+			},
+		},
+	})
+}
+
+func TestGetLatestWithNoSynchronisations(t *testing.T) {
+	ts, _ := makeServer(t)
+
+	req := makeClientRequest(t, fmt.Sprintf("%s/latest", ts.URL))
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertHTTPError(t, res, http.StatusNotFound, "no synchronisations recorded")
+}
+
+func TestGetApplications(t *testing.T) {
+	ts, _ := makeServer(t)
+	router := ts.Config.Handler.(*RecentRouter)
+	router.AddApplication("app-2", NewRecentSynchronisations(ring.New(1)))
+	router.AddApplication("app-1", NewRecentSynchronisations(ring.New(1)))
+
+	req := makeClientRequest(t, fmt.Sprintf("%s/applications", ts.URL))
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertJSONResponse(t, res, map[string]interface{}{
+		"applications": []interface{}{"app-1", "app-2"},
+	})
+}
+
+func TestGetApplicationLatest(t *testing.T) {
+	ts, _ := makeServer(t)
+	router := ts.Config.Handler.(*RecentRouter)
+	syncs := NewRecentSynchronisations(ring.New(1))
+	router.AddApplication("test-app", syncs)
+	start, end := time.Date(2020, time.June, 24, 22, 0, 0, 0, time.UTC), time.Date(2020, time.June, 24, 22, 1, 0, 0, time.UTC)
+	sha := "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f"
+	syncs.Add(start, end, plumbing.NewHash(sha), nil, []common.ResourceSyncResult{})
+
+	req := makeClientRequest(t, fmt.Sprintf("%s/applications/test-app/latest", ts.URL))
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertJSONResponse(t, res, map[string]interface{}{
+		"id":        float64(1),
+		"startTime": "2020-06-24T22:00:00Z",
+		"endTime":   "2020-06-24T22:01:00Z",
+		"sha":       sha,
+		"error":     "",
+		"results":   []interface{}{},
+		"outOfSync": []interface{}{},
+	})
+}
+
+func TestGetApplicationLatestWithUnknownApplication(t *testing.T) {
+	ts, _ := makeServer(t)
+	router := ts.Config.Handler.(*RecentRouter)
+	router.AddApplication("unknown", NewRecentSynchronisations(ring.New(1)))
+	router.RemoveApplication("unknown")
+
+	req := makeClientRequest(t, fmt.Sprintf("%s/applications/unknown/latest", ts.URL))
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertHTTPError(t, res, http.StatusNotFound, "application not found")
+}
+
+func TestGetHistory(t *testing.T) {
+	ts, s := makeServer(t)
+	recordHistory(s)
+
+	res, err := ts.Client().Do(makeClientRequest(t, fmt.Sprintf("%s/history?limit=2", ts.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, next := readHistory(t, res)
+	if diff := cmp.Diff([]int64{3, 2}, ids); diff != "" {
+		t.Fatalf("history failed:\n%s", diff)
+	}
+	if next != 2 {
+		t.Fatalf("got next %d, want 2", next)
+	}
+
+	res, err = ts.Client().Do(makeClientRequest(t, fmt.Sprintf("%s/history?limit=2&before=%d", ts.URL, next)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, next = readHistory(t, res)
+	if diff := cmp.Diff([]int64{1}, ids); diff != "" {
+		t.Fatalf("history failed:\n%s", diff)
+	}
+	if next != 0 {
+		t.Fatalf("got next %d on the last page, want 0", next)
+	}
+}
+
+func TestGetHistoryWithFilters(t *testing.T) {
+	filterTests := []struct {
+		query string
+		want  []int64
+	}{
+		{"", []int64{3, 2, 1}},
+		{"sha=7f19", []int64{2, 1}},
+		{"failed=true", []int64{2}},
+		{"failed=false", []int64{3, 1}},
+		{"since=2020-06-24T22:05:00Z", []int64{3, 2}},
+		{"until=2020-06-24T22:10:00Z", []int64{2, 1}},
+		{"sha=7f19&failed=false", []int64{1}},
+	}
+
+	for _, tt := range filterTests {
+		t.Run(tt.query, func(t *testing.T) {
+			ts, s := makeServer(t)
+			recordHistory(s)
+
+			res, err := ts.Client().Do(makeClientRequest(t, fmt.Sprintf("%s/history?%s", ts.URL, tt.query)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids, _ := readHistory(t, res)
+			if diff := cmp.Diff(tt.want, ids); diff != "" {
+				t.Fatalf("history failed:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetHistoryWithInvalidQuery(t *testing.T) {
+	invalidTests := []struct {
+		query   string
+		wantErr string
+	}{
+		{"failed=maybe", `invalid failed parameter "maybe"`},
+		{"since=yesterday", `invalid since parameter "yesterday", must be RFC3339`},
+		{"before=last", `invalid before parameter "last"`},
+		{"limit=0", `invalid limit parameter "0"`},
+	}
+
+	for _, tt := range invalidTests {
+		t.Run(tt.query, func(t *testing.T) {
+			ts, _ := makeServer(t)
+
+			res, err := ts.Client().Do(makeClientRequest(t, fmt.Sprintf("%s/history?%s", ts.URL, tt.query)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertHTTPError(t, res, http.StatusBadRequest, tt.wantErr)
+		})
+	}
+}
+
+func TestGetHistoryItem(t *testing.T) {
+	ts, s := makeServer(t)
+	recordHistory(s)
+
+	res, err := ts.Client().Do(makeClientRequest(t, fmt.Sprintf("%s/history/2", ts.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertJSONResponse(t, res, map[string]interface{}{
+		"id":        float64(2),
+		"startTime": "2020-06-24T22:05:00Z",
+		"endTime":   "2020-06-24T22:06:00Z",
+		"sha":       "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+		"error":     "failed to apply",
+		"results":   []interface{}{},
+		"outOfSync": []interface{}{},
 	})
 }
 
+func TestGetHistoryItemWithUnknownID(t *testing.T) {
+	ts, s := makeServer(t)
+	recordHistory(s)
+
+	res, err := ts.Client().Do(makeClientRequest(t, fmt.Sprintf("%s/history/20", ts.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertHTTPError(t, res, http.StatusNotFound, "synchronisation not found")
+}
+
+func TestGetApplicationHistory(t *testing.T) {
+	ts, _ := makeServer(t)
+	router := ts.Config.Handler.(*RecentRouter)
+	syncs := NewRecentSynchronisations(ring.New(5))
+	router.AddApplication("test-app", syncs)
+	recordHistory(syncs)
+
+	res, err := ts.Client().Do(makeClientRequest(t, fmt.Sprintf("%s/applications/test-app/history?failed=true", ts.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, _ := readHistory(t, res)
+	if diff := cmp.Diff([]int64{2}, ids); diff != "" {
+		t.Fatalf("history failed:\n%s", diff)
+	}
+
+	res, err = ts.Client().Do(makeClientRequest(t, fmt.Sprintf("%s/applications/unknown/history/1", ts.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHTTPError(t, res, http.StatusNotFound, "application not found")
+}
+
+// recordHistory records three synchronisations, five minutes apart, the
+// second of which failed.
+func recordHistory(s *RecentSynchronisations) {
+	start := time.Date(2020, time.June, 24, 22, 0, 0, 0, time.UTC)
+	for i, v := range []struct {
+		sha string
+		err error
+	}{
+		{"7f193461f0b44fc5e397a63f2ddba8d9453e7a3f", nil},
+		{"7f193461f0b44fc5e397a63f2ddba8d9453e7a3f", errors.New("failed to apply")},
+		{"c3a1b7e4b27d9f1d8b3e28c3a2b5a6c8f2e9d0a1", nil},
+	} {
+		t := start.Add(time.Duration(i) * time.Minute * 5)
+		s.Record(Synchronisation{Start: t, End: t.Add(time.Minute), SHA: v.sha, Error: v.err})
+	}
+}
+
+func readHistory(t *testing.T, res *http.Response) ([]int64, int64) {
+	t.Helper()
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("didn't get a successful response: %v", res.StatusCode)
+	}
+	var history responseHistory
+	if err := json.NewDecoder(res.Body).Decode(&history); err != nil {
+		t.Fatal(err)
+	}
+	ids := []int64{}
+	for _, v := range history.Synchronisations {
+		ids = append(ids, v.ID)
+	}
+	return ids, history.Next
+}
+
 func makeClientRequest(t *testing.T, path string) *http.Request {
 	r, err := http.NewRequest("GET", path, nil)
 	if err != nil {
@@ -101,3 +424,18 @@ func assertJSONResponse(t *testing.T, res *http.Response, want map[string]interf
 		t.Fatalf("JSON response failed:\n%s", diff)
 	}
 }
+
+func assertHTTPError(t *testing.T, res *http.Response, status int, want string) {
+	t.Helper()
+	defer res.Body.Close()
+	if res.StatusCode != status {
+		t.Fatalf("status code got %v, want %v", res.StatusCode, status)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := strings.TrimSpace(string(b)); msg != want {
+		t.Fatalf("error message got %q, want %q", msg, want)
+	}
+}
@@ -0,0 +1,182 @@
+package recent
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/argoproj/gitops-engine/pkg/sync/common"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/diff"
+)
+
+// Store persists the synchronisation history and the state of applications.
+type Store interface {
+	StateStore
+
+	// Load returns the stored synchronisations for the named application,
+	// oldest first.
+	//
+	// If nothing has been stored for the application, this returns an empty
+	// slice.
+	Load(application string) ([]Synchronisation, error)
+
+	// Save replaces the stored synchronisations for the named application, the
+	// synchronisations are provided oldest first.
+	Save(application string, history []Synchronisation) error
+}
+
+// StateStore persists the state of applications that is controlled through
+// the API.
+type StateStore interface {
+	// LoadState returns the stored state for the named application, or the
+	// zero State if nothing has been stored.
+	LoadState(application string) (State, error)
+
+	// SaveState replaces the stored state for the named application.
+	SaveState(application string, state State) error
+}
+
+// State is the state of an application that is retained across restarts.
+type State struct {
+	// Suspended is true if the synchronisation is suspended until it's
+	// resumed.
+	Suspended bool `json:"suspended,omitempty"`
+	// Pinned is the SHA that the application is held on until it's resumed.
+	Pinned string `json:"pinned,omitempty"`
+}
+
+// MemoryStore is a Store that keeps the history in memory, the history is
+// lost when the process exits.
+type MemoryStore struct {
+	mu      sync.Mutex
+	history map[string][]Synchronisation
+	states  map[string]State
+}
+
+// NewMemoryStore creates and returns a new MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{history: map[string][]Synchronisation{}, states: map[string]State{}}
+}
+
+// Load implements the Store interface.
+func (m *MemoryStore) Load(application string) ([]Synchronisation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Synchronisation{}, m.history[application]...), nil
+}
+
+// Save implements the Store interface.
+func (m *MemoryStore) Save(application string, history []Synchronisation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.history[application] = append([]Synchronisation{}, history...)
+	return nil
+}
+
+// LoadState implements the StateStore interface.
+func (m *MemoryStore) LoadState(application string) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.states[application], nil
+}
+
+// SaveState implements the StateStore interface.
+func (m *MemoryStore) SaveState(application string, state State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[application] = state
+	return nil
+}
+
+// storedSynchronisation is the serialised form of a Synchronisation.
+//
+// Errors can't be serialised, so only the message is stored.
+type storedSynchronisation struct {
+	ID    int64     `json:"id"`
+	Start time.Time `json:"startTime"`
+	End   time.Time `json:"endTime"`
+	SHA   string    `json:"sha"`
+	Ref   string    `json:"ref,omitempty"`
+	// HistoryRewritten and PreviousSHA are recorded when the Ref was
+	// rewritten.
+	HistoryRewritten bool                        `json:"historyRewritten,omitempty"`
+	PreviousSHA      string                      `json:"previousSHA,omitempty"`
+	Recloned         bool                        `json:"recloned,omitempty"`
+	Rejected         bool                        `json:"rejected,omitempty"`
+	SignedBy         string                      `json:"signedBy,omitempty"`
+	Parser           string                      `json:"parser,omitempty"`
+	RollbackFrom     string                      `json:"rollbackFrom,omitempty"`
+	Pinned           bool                        `json:"pinned,omitempty"`
+	Monitored        bool                        `json:"monitored,omitempty"`
+	Error            string                      `json:"error,omitempty"`
+	Results          []common.ResourceSyncResult `json:"results,omitempty"`
+	Diffs            []diff.ResourceDiff         `json:"diffs,omitempty"`
+	Health           health.HealthStatusCode     `json:"health,omitempty"`
+	ResourceHealth   []ResourceHealth            `json:"resourceHealth,omitempty"`
+}
+
+func toStored(history []Synchronisation) []storedSynchronisation {
+	stored := []storedSynchronisation{}
+	for _, v := range history {
+		s := storedSynchronisation{
+			ID:      v.ID,
+			Start:   v.Start,
+			End:     v.End,
+			SHA:     v.SHA,
+			Ref:     v.Ref,
+			Results: v.Results,
+			Diffs:   v.Diffs,
+
+			HistoryRewritten: v.HistoryRewritten,
+			PreviousSHA:      v.PreviousSHA,
+			Recloned:         v.Recloned,
+			Rejected:         v.Rejected,
+			SignedBy:         v.SignedBy,
+			Parser:           v.Parser,
+			RollbackFrom:     v.RollbackFrom,
+			Pinned:           v.Pinned,
+			Monitored:        v.Monitored,
+			Health:           v.Health,
+			ResourceHealth:   v.ResourceHealth,
+		}
+		if v.Error != nil {
+			s.Error = v.Error.Error()
+		}
+		stored = append(stored, s)
+	}
+	return stored
+}
+
+func fromStored(stored []storedSynchronisation) []Synchronisation {
+	history := []Synchronisation{}
+	for _, v := range stored {
+		s := Synchronisation{
+			ID:      v.ID,
+			Start:   v.Start,
+			End:     v.End,
+			SHA:     v.SHA,
+			Ref:     v.Ref,
+			Results: v.Results,
+			Diffs:   v.Diffs,
+
+			HistoryRewritten: v.HistoryRewritten,
+			PreviousSHA:      v.PreviousSHA,
+			Recloned:         v.Recloned,
+			Rejected:         v.Rejected,
+			SignedBy:         v.SignedBy,
+			Parser:           v.Parser,
+			RollbackFrom:     v.RollbackFrom,
+			Pinned:           v.Pinned,
+			Monitored:        v.Monitored,
+			Health:           v.Health,
+			ResourceHealth:   v.ResourceHealth,
+		}
+		if v.Error != "" {
+			s.Error = errors.New(v.Error)
+		}
+		history = append(history, s)
+	}
+	return history
+}
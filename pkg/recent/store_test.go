@@ -0,0 +1,214 @@
+package recent
+
+import (
+	"container/ring"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/argoproj/gitops-engine/pkg/sync/common"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/diff"
+)
+
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*FileStore)(nil)
+	_ Store = (*ConfigMapStore)(nil)
+)
+
+func TestLoadRecentSynchronisations(t *testing.T) {
+	store := NewMemoryStore()
+	syncs, err := LoadRecentSynchronisations("test-app", ring.New(5), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		syncs.Record(Synchronisation{SHA: fmt.Sprintf("sha-%d", i)})
+	}
+
+	loaded, err := LoadRecentSynchronisations("test-app", ring.New(5), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(syncs.History(), loaded.History()); diff != "" {
+		t.Fatalf("loaded history:\n%s", diff)
+	}
+	if s := loaded.Record(Synchronisation{}); s.ID != 4 {
+		t.Fatalf("got ID %d after loading, want 4", s.ID)
+	}
+}
+
+func TestLoadRecentSynchronisationsWithSmallerRing(t *testing.T) {
+	store := NewMemoryStore()
+	syncs, err := LoadRecentSynchronisations("test-app", ring.New(5), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		syncs.Record(Synchronisation{SHA: fmt.Sprintf("sha-%d", i)})
+	}
+
+	loaded, err := LoadRecentSynchronisations("test-app", ring.New(2), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"5:sha-4", "4:sha-3"}
+	if diff := cmp.Diff(want, historyKeys(loaded.History())); diff != "" {
+		t.Fatalf("loaded history:\n%s", diff)
+	}
+}
+
+func TestLoadRecentSynchronisationsWithFailingStore(t *testing.T) {
+	_, err := LoadRecentSynchronisations("test-app", ring.New(5), failingStore{})
+
+	if err == nil || err.Error() != "failed to load" {
+		t.Fatalf("got error %v, want failed to load", err)
+	}
+}
+
+// testStore checks that a store round-trips the history of multiple
+// applications.
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+	empty, err := store.Load("test-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(empty); l != 0 {
+		t.Fatalf("got %d synchronisations before saving, want 0", l)
+	}
+
+	start := time.Date(2020, time.June, 24, 22, 0, 0, 0, time.UTC)
+	history := []Synchronisation{
+		{
+			ID: 1, Start: start, End: start.Add(time.Minute),
+			SHA:              "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f",
+			Ref:              "refs/tags/v1.4.2",
+			HistoryRewritten: true,
+			Rejected:         true,
+			SignedBy:         "SHA256:q4UuAVXqMkbb9PvBbvFNbtOLwpKL7BkC0tTSZIvgJ2o",
+			Parser:           "kustomize",
+			PreviousSHA:      "9b2e1ea1e5c1e2d3cc5d4b1a0e7b1f2c3d4e5f60",
+			Error:            errors.New("failed to apply"),
+			Results: []common.ResourceSyncResult{
+				{
+					ResourceKey: kube.NewResourceKey("", "ConfigMap", "test-ns", "test-cfg"),
+					Status:      common.ResultCodeSyncFailed,
+					Message:     "failed to apply",
+				},
+			},
+			Diffs: []diff.ResourceDiff{
+				{Kind: "ConfigMap", Namespace: "test-ns", Name: "test-cfg", Diff: "--- live\n+++ desired\n"},
+			},
+			Health: health.HealthStatusDegraded,
+			ResourceHealth: []ResourceHealth{
+				{Group: "apps", Kind: "Deployment", Namespace: "test-ns", Name: "taxi", Status: health.HealthStatusDegraded, Message: "Deployment exceeded its progress deadline"},
+			},
+			RollbackFrom: "c3a1b7e4b27d9f1d8b3e28c3a2b5a6c8f2e9d0a1",
+			Pinned:       true,
+			Monitored:    true,
+		},
+		{ID: 2, Start: start.Add(time.Minute * 5), End: start.Add(time.Minute * 6), SHA: "c3a1b7e4b27d9f1d8b3e28c3a2b5a6c8f2e9d0a1"},
+	}
+	if err := store.Save("test-app", history); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("other-app", history[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Load("test-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(history, loaded, cmp.Comparer(compareErrorMessages)); diff != "" {
+		t.Fatalf("loaded history:\n%s", diff)
+	}
+	other, err := store.Load("other-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(other); l != 1 {
+		t.Fatalf("got %d synchronisations for other-app, want 1", l)
+	}
+
+	testStateStore(t, store)
+	// Saving the state doesn't replace the history.
+	loaded, err = store.Load("test-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(loaded); l != 2 {
+		t.Fatalf("got %d synchronisations after saving the state, want 2", l)
+	}
+}
+
+// testStateStore checks that a store round-trips the state of multiple
+// applications.
+func testStateStore(t *testing.T, store StateStore) {
+	t.Helper()
+	empty, err := store.LoadState("test-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty != (State{}) {
+		t.Fatalf("got state %#v before saving, want the zero state", empty)
+	}
+
+	state := State{Suspended: true, Pinned: "7f193461f0b44fc5e397a63f2ddba8d9453e7a3f"}
+	if err := store.SaveState("test-app", state); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveState("new-app", State{Suspended: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.LoadState("test-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != state {
+		t.Fatalf("got state %#v, want %#v", loaded, state)
+	}
+	other, err := store.LoadState("other-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other != (State{}) {
+		t.Fatalf("got state %#v for other-app, want the zero state", other)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func compareErrorMessages(x, y error) bool {
+	if x == nil || y == nil {
+		return x == y
+	}
+	return x.Error() == y.Error()
+}
+
+type failingStore struct{}
+
+func (failingStore) Load(string) ([]Synchronisation, error) {
+	return nil, errors.New("failed to load")
+}
+
+func (failingStore) Save(string, []Synchronisation) error {
+	return errors.New("failed to save")
+}
+
+func (failingStore) LoadState(string) (State, error) {
+	return State{}, errors.New("failed to load")
+}
+
+func (failingStore) SaveState(string, State) error {
+	return errors.New("failed to save")
+}
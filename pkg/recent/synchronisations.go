@@ -1,12 +1,18 @@
 package recent
 
 import (
+	"log"
+	"sync"
 	"time"
 
 	"container/ring"
 
+	"github.com/argoproj/gitops-engine/pkg/health"
 	"github.com/argoproj/gitops-engine/pkg/sync/common"
+	"github.com/argoproj/gitops-engine/pkg/utils/kube"
 	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/diff"
 )
 
 // NewRecentSynchronisations creates and returns a ring buffer of
@@ -15,28 +21,206 @@ func NewRecentSynchronisations(r *ring.Ring) *RecentSynchronisations {
 	return &RecentSynchronisations{recent: r}
 }
 
+// LoadRecentSynchronisations creates and returns a ring buffer of
+// synchronisations for the named application, that is populated from, and
+// saves each synchronisation to, the store.
+//
+// If the store has more synchronisations than fit in the ring, only the most
+// recent are kept.
+func LoadRecentSynchronisations(application string, r *ring.Ring, store Store) (*RecentSynchronisations, error) {
+	history, err := store.Load(application)
+	if err != nil {
+		return nil, err
+	}
+	if l := len(history) - r.Len(); l > 0 {
+		history = history[l:]
+	}
+	rs := &RecentSynchronisations{recent: r, application: application, store: store}
+	for _, v := range history {
+		rs.recent.Value = v
+		rs.recent = rs.recent.Next()
+		if v.ID > rs.lastID {
+			rs.lastID = v.ID
+		}
+	}
+	return rs, nil
+}
+
 // RecentSynchronisations represents a ring buffer of recent sync states.
 type RecentSynchronisations struct {
 	// Access to the ring is synchronised by a Mutex internally.
+	mu     sync.RWMutex
 	recent *ring.Ring
+	lastID int64
+
+	// The store is optional, and if provided, the history is saved after
+	// each synchronisation.
+	application string
+	store       Store
+	// saveMu orders the saves, which are made without holding mu, savedID is
+	// the ID of the latest synchronisation in the saved history.
+	saveMu  sync.Mutex
+	savedID int64
 }
 
 // Add records the details of a synchronisation in the ring.
 func (r *RecentSynchronisations) Add(start, end time.Time, sha plumbing.Hash, syncErr error, results []common.ResourceSyncResult) {
-	r.recent.Value = Synchronisation{Start: start, End: end, SHA: sha.String(), Error: syncErr, Results: results}
+	r.Record(Synchronisation{Start: start, End: end, SHA: sha.String(), Error: syncErr, Results: results})
+}
+
+// Record records a synchronisation in the ring, replacing the oldest
+// synchronisation if the ring is full.
+//
+// The synchronisation is assigned the next ID, and is returned with the ID.
+func (r *RecentSynchronisations) Record(s Synchronisation) Synchronisation {
+	r.mu.Lock()
+	r.lastID++
+	s.ID = r.lastID
+	r.recent.Value = s
 	r.recent = r.recent.Next()
+	history := r.history()
+	r.mu.Unlock()
+	if r.store != nil {
+		r.save(s.ID, history)
+	}
+	return s
+}
+
+// save saves the history, unless a more recent history was already saved.
+func (r *RecentSynchronisations) save(id int64, history []Synchronisation) {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+	if id < r.savedID {
+		return
+	}
+	r.savedID = id
+	// The history is most recent first, and stored oldest first.
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	if err := r.store.Save(r.application, history); err != nil {
+		log.Printf("ERROR: failed to save history: %s", err)
+	}
+}
+
+// Latest returns the last recorded synchronisation, and false if nothing has
+// been recorded.
+func (r *RecentSynchronisations) Latest() (Synchronisation, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.recent.Prev().Value.(Synchronisation)
+	return s, ok
 }
 
-// Latest returns the last recorded synchronisation.
-func (r *RecentSynchronisations) Latest() Synchronisation {
-	return r.recent.Prev().Value.(Synchronisation)
+// History returns the recorded synchronisations, most recent first.
+func (r *RecentSynchronisations) History() []Synchronisation {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.history()
+}
+
+func (r *RecentSynchronisations) history() []Synchronisation {
+	history := []Synchronisation{}
+	current := r.recent
+	for i := 0; i < r.recent.Len(); i++ {
+		current = current.Prev()
+		s, ok := current.Value.(Synchronisation)
+		if !ok {
+			break
+		}
+		history = append(history, s)
+	}
+	return history
+}
+
+// Get returns the synchronisation with the provided ID, and false if it's not
+// in the ring.
+func (r *RecentSynchronisations) Get(id int64) (Synchronisation, bool) {
+	for _, v := range r.History() {
+		if v.ID == id {
+			return v, true
+		}
+	}
+	return Synchronisation{}, false
 }
 
 // Synchronisation represents a sync run from the gitops engine.
 type Synchronisation struct {
-	Start   time.Time                   `json:"startTime"`
-	End     time.Time                   `json:"endTime"`
-	SHA     string                      `json:"sha"`
+	// ID identifies the synchronisation, IDs increase with each recorded
+	// synchronisation.
+	ID    int64     `json:"id"`
+	Start time.Time `json:"startTime"`
+	End   time.Time `json:"endTime"`
+	SHA   string    `json:"sha"`
+	// Ref is the branch, tag or commit SHA that the SHA was resolved from.
+	Ref string `json:"ref"`
+	// HistoryRewritten is true if the Ref was rewritten e.g. by a force-push,
+	// and PreviousSHA is no longer an ancestor of the SHA.
+	HistoryRewritten bool   `json:"historyRewritten"`
+	PreviousSHA      string `json:"previousSHA"`
+	// Recloned is true if the local clone was corrupted, and the repository
+	// was cloned again.
+	Recloned bool `json:"recloned"`
+	// Rejected is true if the commit was not synchronised because its
+	// signature was not trusted, SignedBy is the trusted signer of
+	// synchronised commits, if signatures are verified.
+	Rejected bool   `json:"rejected"`
+	SignedBy string `json:"signedBy"`
+	// Parser is the parser that was detected for the manifests, if the
+	// parser is detected automatically.
+	Parser  string                      `json:"parser"`
 	Error   error                       `json:"err"`
 	Results []common.ResourceSyncResult `json:"results"`
+	// Diffs are the differences between the manifests and the cluster for
+	// the resources that were out of sync before synchronising.
+	Diffs []diff.ResourceDiff `json:"diffs"`
+	// Health is the aggregate health of the synchronised resources, it's
+	// empty if the health was not assessed, and ResourceHealth is the health
+	// of each resource that has a health check.
+	Health         health.HealthStatusCode `json:"health"`
+	ResourceHealth []ResourceHealth        `json:"resourceHealth"`
+	// RollbackFrom is the SHA that degraded the resources, if this
+	// synchronisation rolled back to the SHA that was last healthy.
+	RollbackFrom string `json:"rollbackFrom"`
+	// Pinned is true if the SHA was deployed manually, and the application
+	// is held on it until it's resumed.
+	Pinned bool `json:"pinned"`
+	// Monitored is true if the resources were compared with the cluster, but
+	// were not applied.
+	Monitored bool `json:"monitored"`
+}
+
+// ResourceHealth is the health of a synchronised resource.
+type ResourceHealth struct {
+	Group     string                  `json:"group"`
+	Kind      string                  `json:"kind"`
+	Namespace string                  `json:"namespace"`
+	Name      string                  `json:"name"`
+	Status    health.HealthStatusCode `json:"status"`
+	Message   string                  `json:"message,omitempty"`
+}
+
+// Failed returns true if the synchronisation failed, or any resource failed to
+// synchronise.
+func (s Synchronisation) Failed() bool {
+	if s.Error != nil {
+		return true
+	}
+	for _, v := range s.Results {
+		if v.Status == common.ResultCodeSyncFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the recorded difference for the identified resource, and false
+// if the resource was not out of sync.
+func (s Synchronisation) Diff(key kube.ResourceKey) (diff.ResourceDiff, bool) {
+	for _, v := range s.Diffs {
+		if kube.NewResourceKey(v.Group, v.Kind, v.Namespace, v.Name) == key {
+			return v, true
+		}
+	}
+	return diff.ResourceDiff{}, false
 }
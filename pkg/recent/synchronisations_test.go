@@ -3,6 +3,8 @@ package recent
 import (
 	"container/ring"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,8 +14,6 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
-// TODO: What sort of access should we give to the ring?
-
 func TestLatest(t *testing.T) {
 	syncs := NewRecentSynchronisations(ring.New(5))
 	start, end := time.Now(), time.Now()
@@ -23,13 +23,110 @@ func TestLatest(t *testing.T) {
 	syncs.Add(start, end, plumbing.NewHash(sha), syncErr, []common.ResourceSyncResult{})
 
 	want := Synchronisation{
+		ID:      1,
 		Start:   start,
 		End:     end,
 		SHA:     sha,
 		Error:   syncErr,
 		Results: []common.ResourceSyncResult{},
 	}
-	if diff := cmp.Diff(want, syncs.Latest(), cmpopts.EquateErrors()); diff != "" {
+	latest, ok := syncs.Latest()
+	if !ok {
+		t.Fatal("Latest() did not return a synchronisation")
+	}
+	if diff := cmp.Diff(want, latest, cmpopts.EquateErrors()); diff != "" {
 		t.Fatalf("latest sync failed:\n%s", diff)
 	}
 }
+
+func TestLatestWithNoSynchronisations(t *testing.T) {
+	syncs := NewRecentSynchronisations(ring.New(5))
+
+	if _, ok := syncs.Latest(); ok {
+		t.Fatal("Latest() returned a synchronisation")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	syncs := NewRecentSynchronisations(ring.New(3))
+	for i := 0; i < 5; i++ {
+		syncs.Record(Synchronisation{SHA: fmt.Sprintf("sha-%d", i)})
+	}
+
+	want := []string{"5:sha-4", "4:sha-3", "3:sha-2"}
+	if diff := cmp.Diff(want, historyKeys(syncs.History())); diff != "" {
+		t.Fatalf("History() failed:\n%s", diff)
+	}
+}
+
+func TestHistoryWithPartiallyFilledRing(t *testing.T) {
+	syncs := NewRecentSynchronisations(ring.New(5))
+	syncs.Record(Synchronisation{SHA: "sha-0"})
+	syncs.Record(Synchronisation{SHA: "sha-1"})
+
+	want := []string{"2:sha-1", "1:sha-0"}
+	if diff := cmp.Diff(want, historyKeys(syncs.History())); diff != "" {
+		t.Fatalf("History() failed:\n%s", diff)
+	}
+}
+
+func TestGet(t *testing.T) {
+	syncs := NewRecentSynchronisations(ring.New(2))
+	for i := 0; i < 3; i++ {
+		syncs.Record(Synchronisation{SHA: fmt.Sprintf("sha-%d", i)})
+	}
+
+	if s, ok := syncs.Get(2); !ok || s.SHA != "sha-1" {
+		t.Fatalf("Get(2) got %v, %v", s, ok)
+	}
+	if _, ok := syncs.Get(1); ok {
+		t.Fatal("Get(1) returned a synchronisation that was replaced")
+	}
+}
+
+func TestRecordConcurrently(t *testing.T) {
+	syncs := NewRecentSynchronisations(ring.New(5))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			syncs.Record(Synchronisation{})
+			syncs.History()
+			syncs.Latest()
+		}()
+	}
+	wg.Wait()
+
+	if latest, _ := syncs.Latest(); latest.ID != 10 {
+		t.Fatalf("got latest ID %d, want 10", latest.ID)
+	}
+}
+
+func TestFailed(t *testing.T) {
+	failedTests := []struct {
+		name string
+		s    Synchronisation
+		want bool
+	}{
+		{"successful", Synchronisation{Results: []common.ResourceSyncResult{{Status: common.ResultCodeSynced}}}, false},
+		{"error", Synchronisation{Error: errors.New("failed")}, true},
+		{"failed resource", Synchronisation{Results: []common.ResourceSyncResult{{Status: common.ResultCodeSyncFailed}}}, true},
+	}
+
+	for _, tt := range failedTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.Failed(); got != tt.want {
+				t.Fatalf("Failed() got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func historyKeys(history []Synchronisation) []string {
+	keys := []string{}
+	for _, v := range history {
+		keys = append(keys, fmt.Sprintf("%d:%s", v.ID, v.SHA))
+	}
+	return keys
+}
@@ -0,0 +1,255 @@
+// Package signature verifies the signatures of Git commits against trusted
+// OpenPGP keys and SSH keys.
+package signature
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrUnsigned is returned when verifying a commit that has no signature.
+var ErrUnsigned = errors.New("commit is not signed")
+
+const (
+	sshSignatureMagic     = "SSHSIG"
+	sshSignatureVersion   = 1
+	sshSignatureNamespace = "git"
+	sshSignatureType      = "SSH SIGNATURE"
+)
+
+// UntrustedError is returned when a commit's signature is invalid, or was not
+// made by a trusted key.
+type UntrustedError struct {
+	SHA string
+	Err error
+}
+
+func (e *UntrustedError) Error() string {
+	return fmt.Sprintf("commit %s does not have a trusted signature: %s", e.SHA, e.Err)
+}
+
+func (e *UntrustedError) Unwrap() error {
+	return e.Err
+}
+
+// Verifier verifies that commits are signed by trusted keys.
+type Verifier struct {
+	keyring        openpgp.EntityList
+	allowedSigners []ssh.PublicKey
+}
+
+// New creates and returns a Verifier that trusts the OpenPGP public keys in
+// the armored keyring file, and the SSH public keys in the allowed signers
+// file, either can be empty.
+//
+// The allowed signers file can be in the authorized_keys format, or in the
+// allowed_signers format used by Git.
+func New(keyringFile, allowedSignersFile string) (*Verifier, error) {
+	if keyringFile == "" && allowedSignersFile == "" {
+		return nil, errors.New("no keyring or allowed signers to verify signatures with")
+	}
+	v := &Verifier{}
+	if keyringFile != "" {
+		f, err := os.Open(keyringFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open keyring: %w", err)
+		}
+		defer f.Close()
+		v.keyring, err = openpgp.ReadArmoredKeyRing(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse keyring %s: %w", keyringFile, err)
+		}
+	}
+	if allowedSignersFile != "" {
+		b, err := os.ReadFile(allowedSignersFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read allowed signers: %w", err)
+		}
+		v.allowedSigners, err = parseAllowedSigners(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse allowed signers %s: %w", allowedSignersFile, err)
+		}
+	}
+	return v, nil
+}
+
+// Verify checks the signature of the commit, and returns a description of the
+// key that signed it.
+//
+// If the commit is unsigned, this returns ErrUnsigned, and if the signature
+// can't be verified with a trusted key, an *UntrustedError.
+func (v *Verifier) Verify(c *object.Commit) (string, error) {
+	if c.PGPSignature == "" {
+		return "", fmt.Errorf("commit %s: %w", c.Hash, ErrUnsigned)
+	}
+	encoded := &plumbing.MemoryObject{}
+	if err := c.EncodeWithoutSignature(encoded); err != nil {
+		return "", err
+	}
+	r, err := encoded.Reader()
+	if err != nil {
+		return "", err
+	}
+	signer, err := v.verify(r, c.PGPSignature)
+	if err != nil {
+		return "", &UntrustedError{SHA: c.Hash.String(), Err: err}
+	}
+	return signer, nil
+}
+
+func (v *Verifier) verify(message io.Reader, signature string) (string, error) {
+	if strings.HasPrefix(signature, "-----BEGIN "+sshSignatureType+"-----") {
+		return v.verifySSH(message, signature)
+	}
+	if len(v.keyring) == 0 {
+		return "", errors.New("no OpenPGP keys are trusted")
+	}
+	entity, err := openpgp.CheckArmoredDetachedSignature(v.keyring, message, strings.NewReader(signature), nil)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint))
+	for name := range entity.Identities {
+		return fmt.Sprintf("%s (%s)", name, fingerprint), nil
+	}
+	return fingerprint, nil
+}
+
+// sshSignature is the SSHSIG signature format, after the magic preamble.
+//
+// See https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+type sshSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is the data that is signed, after the magic preamble.
+type sshSignedData struct {
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func (v *Verifier) verifySSH(message io.Reader, signature string) (string, error) {
+	if len(v.allowedSigners) == 0 {
+		return "", errors.New("no SSH keys are trusted")
+	}
+	block, _ := pem.Decode([]byte(signature))
+	if block == nil || block.Type != sshSignatureType {
+		return "", errors.New("invalid SSH signature")
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(sshSignatureMagic)) {
+		return "", errors.New("invalid SSH signature preamble")
+	}
+	var sig sshSignature
+	if err := ssh.Unmarshal(block.Bytes[len(sshSignatureMagic):], &sig); err != nil {
+		return "", fmt.Errorf("failed to parse SSH signature: %w", err)
+	}
+	if sig.Version != sshSignatureVersion {
+		return "", fmt.Errorf("unsupported SSH signature version %d", sig.Version)
+	}
+	if sig.Namespace != sshSignatureNamespace {
+		return "", fmt.Errorf("SSH signature has namespace %q, want %q", sig.Namespace, sshSignatureNamespace)
+	}
+	pub, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse SSH signature public key: %w", err)
+	}
+	if !v.isAllowedSigner(pub) {
+		return "", fmt.Errorf("SSH key %s is not an allowed signer", ssh.FingerprintSHA256(pub))
+	}
+	hash, err := hashMessage(sig.HashAlgorithm, message)
+	if err != nil {
+		return "", err
+	}
+	var s ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &s); err != nil {
+		return "", fmt.Errorf("failed to parse SSH signature blob: %w", err)
+	}
+	signed := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignedData{
+		Namespace:     sig.Namespace,
+		Reserved:      sig.Reserved,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          hash,
+	})...)
+	if err := pub.Verify(signed, &s); err != nil {
+		return "", fmt.Errorf("invalid SSH signature: %w", err)
+	}
+	return ssh.FingerprintSHA256(pub), nil
+}
+
+func (v *Verifier) isAllowedSigner(pub ssh.PublicKey) bool {
+	for _, k := range v.allowedSigners {
+		if bytes.Equal(k.Marshal(), pub.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashMessage(algorithm string, message io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+	switch algorithm {
+	case "sha256":
+		h := sha256.Sum256(b)
+		return h[:], nil
+	case "sha512":
+		h := sha512.Sum512(b)
+		return h[:], nil
+	}
+	return nil, fmt.Errorf("unsupported SSH signature hash algorithm %q", algorithm)
+}
+
+// parseAllowedSigners parses public keys in either the authorized_keys format,
+// or the allowed_signers format, where each key is preceded by the principals
+// and options.
+func parseAllowedSigners(b []byte) ([]ssh.PublicKey, error) {
+	keys := []ssh.PublicKey{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pub, err := parseAllowedSigner(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		keys = append(keys, pub)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no keys found")
+	}
+	return keys, nil
+}
+
+func parseAllowedSigner(line string) (ssh.PublicKey, error) {
+	fields := strings.Fields(line)
+	for i := range fields {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[i:], " ")))
+		if err == nil {
+			return pub, nil
+		}
+	}
+	return nil, errors.New("no public key found")
+}
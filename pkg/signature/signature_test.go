@@ -0,0 +1,238 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestVerifyWithPGPSignature(t *testing.T) {
+	entity := newEntity(t)
+	v, err := New(writeKeyring(t, entity), "")
+	assertNoError(t, err)
+	c := signPGP(t, entity, newCommit("Signed commit"))
+
+	signer, err := v.Verify(c)
+	assertNoError(t, err)
+
+	if !strings.HasPrefix(signer, "Test User <test@example.com> (") {
+		t.Fatalf("got signer %q", signer)
+	}
+}
+
+func TestVerifyWithUntrustedPGPSignature(t *testing.T) {
+	v, err := New(writeKeyring(t, newEntity(t)), "")
+	assertNoError(t, err)
+	c := signPGP(t, newEntity(t), newCommit("Signed commit"))
+
+	_, err = v.Verify(c)
+
+	var untrusted *UntrustedError
+	if !errors.As(err, &untrusted) {
+		t.Fatalf("got error %v, want an UntrustedError", err)
+	}
+}
+
+func TestVerifyWithModifiedCommit(t *testing.T) {
+	entity := newEntity(t)
+	v, err := New(writeKeyring(t, entity), "")
+	assertNoError(t, err)
+	c := signPGP(t, entity, newCommit("Signed commit"))
+	c.Message = "Modified commit"
+
+	_, err = v.Verify(c)
+
+	assertErrorMatch(t, "does not have a trusted signature", err)
+}
+
+func TestVerifyWithUnsignedCommit(t *testing.T) {
+	v, err := New(writeKeyring(t, newEntity(t)), "")
+	assertNoError(t, err)
+
+	_, err = v.Verify(newCommit("Unsigned commit"))
+
+	if !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("got error %v, want %v", err, ErrUnsigned)
+	}
+}
+
+func TestVerifyWithSSHSignature(t *testing.T) {
+	keyFile, pub := writeSSHKey(t)
+	allowed := writeFile(t, "allowed_signers", `user@example.com namespaces="git" `+string(ssh.MarshalAuthorizedKey(pub)))
+	v, err := New("", allowed)
+	assertNoError(t, err)
+
+	signer, err := v.Verify(signSSH(t, keyFile))
+	assertNoError(t, err)
+
+	if want := ssh.FingerprintSHA256(pub); signer != want {
+		t.Fatalf("got signer %q, want %q", signer, want)
+	}
+}
+
+func TestVerifyWithUntrustedSSHSignature(t *testing.T) {
+	keyFile, _ := writeSSHKey(t)
+	_, other := writeSSHKey(t)
+	v, err := New("", writeFile(t, "allowed_signers", string(ssh.MarshalAuthorizedKey(other))))
+	assertNoError(t, err)
+
+	_, err = v.Verify(signSSH(t, keyFile))
+
+	assertErrorMatch(t, "SSH key SHA256:.* is not an allowed signer", err)
+}
+
+func TestVerifyWithSSHSignatureAndNoAllowedSigners(t *testing.T) {
+	keyFile, _ := writeSSHKey(t)
+	v, err := New(writeKeyring(t, newEntity(t)), "")
+	assertNoError(t, err)
+
+	_, err = v.Verify(signSSH(t, keyFile))
+
+	assertErrorMatch(t, "no SSH keys are trusted", err)
+}
+
+func TestNewErrors(t *testing.T) {
+	newTests := []struct {
+		name           string
+		keyring        string
+		allowedSigners string
+		want           string
+	}{
+		{"no keys", "", "", "no keyring or allowed signers"},
+		{"missing keyring", "/doesnotexist/keyring.asc", "", "failed to open keyring"},
+		{"invalid keyring", writeFile(t, "keyring.asc", "not a keyring"), "", "failed to parse keyring"},
+		{"missing allowed signers", "", "/doesnotexist/allowed_signers", "failed to read allowed signers"},
+		{"invalid allowed signers", "", writeFile(t, "allowed_signers", "# comment\nuser@example.com invalid\n"), "line 2: no public key found"},
+		{"empty allowed signers", "", writeFile(t, "allowed_signers", "# comment\n"), "no keys found"},
+	}
+
+	for _, tt := range newTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.keyring, tt.allowedSigners)
+			assertErrorMatch(t, tt.want, err)
+		})
+	}
+}
+
+func newCommit(message string) *object.Commit {
+	sig := object.Signature{Name: "Test", Email: "test@example.com", When: time.Date(2020, time.June, 24, 22, 0, 0, 0, time.UTC)}
+	return &object.Commit{
+		Author:    sig,
+		Committer: sig,
+		Message:   message,
+		TreeHash:  plumbing.NewHash("4b825dc642cb6eb9a060e54bf8d69288fbee4904"),
+	}
+}
+
+func newEntity(t *testing.T) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Test User", "", "test@example.com", nil)
+	assertNoError(t, err)
+	return entity
+}
+
+func writeKeyring(t *testing.T, entity *openpgp.Entity) string {
+	t.Helper()
+	var b bytes.Buffer
+	w, err := armor.Encode(&b, openpgp.PublicKeyType, nil)
+	assertNoError(t, err)
+	assertNoError(t, entity.Serialize(w))
+	assertNoError(t, w.Close())
+	return writeFile(t, "keyring.asc", b.String())
+}
+
+func signPGP(t *testing.T, entity *openpgp.Entity, c *object.Commit) *object.Commit {
+	t.Helper()
+	encoded := &plumbing.MemoryObject{}
+	assertNoError(t, c.EncodeWithoutSignature(encoded))
+	r, err := encoded.Reader()
+	assertNoError(t, err)
+	var sig bytes.Buffer
+	assertNoError(t, openpgp.ArmoredDetachSign(&sig, entity, r, nil))
+	c.PGPSignature = sig.String()
+	return c
+}
+
+func writeSSHKey(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	assertNoError(t, err)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	assertNoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	assertNoError(t, err)
+	return writeFile(t, "id_ed25519", string(pem.EncodeToMemory(block))), sshPub
+}
+
+// signSSH creates a commit signed with the SSH key by git, and returns it.
+func signSSH(t *testing.T, keyFile string) *object.Commit {
+	t.Helper()
+	for _, name := range []string{"git", "ssh-keygen"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("this test needs %s", name)
+		}
+	}
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"-c", "user.name=Test", "-c", "user.email=test@example.com", "-c", "gpg.format=ssh", "-c", "user.signingkey=" + keyFile,
+			"commit", "-q", "-S", "--allow-empty", "-m", "Signed commit"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %s", strings.Join(args, " "), out)
+		}
+	}
+	r, err := git.PlainOpen(dir)
+	assertNoError(t, err)
+	head, err := r.Head()
+	assertNoError(t, err)
+	c, err := r.CommitObject(head.Hash())
+	assertNoError(t, err)
+	return c
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	assertNoError(t, os.WriteFile(filename, []byte(content), 0o600))
+	return filename
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertErrorMatch(t *testing.T, s string, e error) {
+	t.Helper()
+	if e == nil {
+		t.Fatalf("wanted error matching %s, got nil", s)
+	}
+	match, err := regexp.MatchString(s, e.Error())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match {
+		t.Fatalf("error did not match, got %s, want %s", e, s)
+	}
+}
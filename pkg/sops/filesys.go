@@ -0,0 +1,25 @@
+package sops
+
+import (
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// FileSystem returns a Kustomize FileSystem that decrypts the files that
+// were encrypted by SOPS when they are read, e.g. the resources and the files
+// for secretGenerators.
+func (d *Decryptor) FileSystem(fs filesys.FileSystem) filesys.FileSystem {
+	return decryptingFileSystem{FileSystem: fs, decryptor: d}
+}
+
+type decryptingFileSystem struct {
+	filesys.FileSystem
+	decryptor *Decryptor
+}
+
+func (f decryptingFileSystem) ReadFile(path string) ([]byte, error) {
+	b, err := f.FileSystem.ReadFile(path)
+	if err != nil || !IsEncrypted(path, b) {
+		return b, err
+	}
+	return f.decryptor.Decrypt(path)
+}
@@ -0,0 +1,112 @@
+package sops
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// The environment variables that configure the keys that sops decrypts with.
+const (
+	ageKeyFileEnv = "SOPS_AGE_KEY_FILE"
+	gnupgHomeEnv  = "GNUPGHOME"
+)
+
+// inheritedEnv are the only variables from the environment of peanut-engine
+// that are passed to sops.
+var inheritedEnv = []string{"PATH", "HOME"}
+
+// Options configure the keys that files are decrypted with.
+type Options struct {
+	// AgeKeyFile is a file of age identities.
+	AgeKeyFile string
+	// GnuPGHome is a GnuPG home directory with the PGP private keys.
+	GnuPGHome string
+}
+
+// New creates and returns a new Decryptor.
+func New(opts Options) *Decryptor {
+	return &Decryptor{opts: opts, command: "sops"}
+}
+
+// Decryptor decrypts files encrypted by SOPS, with the sops command.
+//
+// The decrypted content is only kept in memory, it's never written to disk.
+type Decryptor struct {
+	opts    Options
+	command string
+}
+
+// IsEncrypted returns true if the content of the file was encrypted by SOPS,
+// YAML, JSON and dotenv files (with the .env extension) are detected.
+func IsEncrypted(filename string, b []byte) bool {
+	if !bytes.Contains(b, []byte("sops")) {
+		return false
+	}
+	if filepath.Ext(filename) == ".env" {
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "sops_mac=") {
+				return true
+			}
+		}
+		return false
+	}
+	var doc map[string]interface{}
+	if err := k8syaml.NewYAMLOrJSONDecoder(bytes.NewReader(b), 4096).Decode(&doc); err != nil {
+		return false
+	}
+	metadata, ok := doc["sops"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = metadata["mac"]
+	return ok
+}
+
+// Decrypt decrypts the file, and returns the decrypted content.
+func (d *Decryptor) Decrypt(filename string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(d.command, "--decrypt", filename)
+	cmd.Env = d.env()
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w: %s", filename, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// ReadFile reads the file, and decrypts it if it was encrypted by SOPS.
+func (d *Decryptor) ReadFile(filename string) ([]byte, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(filename, b) {
+		return b, nil
+	}
+	return d.Decrypt(filename)
+}
+
+func (d *Decryptor) env() []string {
+	env := []string{}
+	for _, k := range inheritedEnv {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	if d.opts.AgeKeyFile != "" {
+		env = append(env, ageKeyFileEnv+"="+d.opts.AgeKeyFile)
+	}
+	if d.opts.GnuPGHome != "" {
+		env = append(env, gnupgHomeEnv+"="+d.opts.GnuPGHome)
+	}
+	return env
+}
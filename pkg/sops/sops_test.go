@@ -0,0 +1,197 @@
+package sops
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/yaml"
+)
+
+func TestIsEncrypted(t *testing.T) {
+	encryptedTests := []struct {
+		filename string
+		want     bool
+	}{
+		{"testdata/secret.yaml", true},
+		{"testdata/database.env", true},
+		{"testdata/configmap.yaml", false},
+	}
+
+	for _, tt := range encryptedTests {
+		t.Run(tt.filename, func(t *testing.T) {
+			b, err := os.ReadFile(tt.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := IsEncrypted(tt.filename, b); got != tt.want {
+				t.Fatalf("IsEncrypted() got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsEncryptedWithJSON(t *testing.T) {
+	b := []byte(`{"data": "ENC[AES256_GCM,data:c2VjcmV0,type:str]", "sops": {"mac": "ENC[AES256_GCM,data:bWFj,type:str]"}}`)
+
+	if !IsEncrypted("secret.json", b) {
+		t.Fatal("JSON was not detected as encrypted")
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	d := New(Options{AgeKeyFile: "/etc/peanut/age.txt"})
+	d.command = writeFakeSops(t)
+
+	b, err := d.Decrypt("testdata/secret.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "apiVersion: v1\nkind: Secret\nmetadata:\n    name: database\ntype: Opaque\nstringData:\n    password: c2VjcmV0\nSOPS_AGE_KEY_FILE=/etc/peanut/age.txt\n"
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("Decrypt() failed:\n%s", diff)
+	}
+}
+
+func TestDecryptEnvironment(t *testing.T) {
+	t.Setenv("PEANUT_TEST_SECRET", "secret")
+	d := New(Options{GnuPGHome: "/etc/peanut/gnupg"})
+	d.command = writeScript(t, `env | grep -E '^(GNUPGHOME|PEANUT_|SOPS_)' | sort`)
+
+	b, err := d.Decrypt("testdata/secret.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff("GNUPGHOME=/etc/peanut/gnupg\n", string(b)); diff != "" {
+		t.Fatalf("sops environment:\n%s", diff)
+	}
+}
+
+func TestDecryptWithSops(t *testing.T) {
+	for _, v := range []string{"sops", "gpg"} {
+		if _, err := exec.LookPath(v); err != nil {
+			t.Skipf("this test needs %s", v)
+		}
+	}
+	home := gnupgHome(t)
+	runCommand(t, home, "gpg", "--batch", "--passphrase", "", "--quick-gen-key", "peanut@example.com", "default", "default", "never")
+	fingerprint := ""
+	for _, line := range strings.Split(runCommand(t, home, "gpg", "--list-secret-keys", "--with-colons"), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" && fingerprint == "" {
+			fingerprint = fields[9]
+		}
+	}
+	if fingerprint == "" {
+		t.Fatal("no fingerprint for the generated key")
+	}
+	secret := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: test-secret\nstringData:\n  password: secret\n"
+	filename := filepath.Join(t.TempDir(), "secret.yaml")
+	if err := os.WriteFile(filename, []byte(secret), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	runCommand(t, home, "sops", "--encrypt", "--in-place", "--pgp", fingerprint, filename)
+	d := New(Options{GnuPGHome: home})
+
+	b, err := d.Decrypt(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got, want map[string]interface{}
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte(secret), &want); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("Decrypt() failed:\n%s", diff)
+	}
+}
+
+func TestDecryptWithFailure(t *testing.T) {
+	d := New(Options{})
+	d.command = writeFakeSops(t)
+
+	_, err := d.Decrypt("testdata/secret.yaml")
+
+	if err == nil || !strings.Contains(err.Error(), "failed to decrypt testdata/secret.yaml: exit status 1: failed to get the data key") {
+		t.Fatalf("incorrect error: %v", err)
+	}
+}
+
+func TestFileSystem(t *testing.T) {
+	d := New(Options{AgeKeyFile: "/etc/peanut/age.txt"})
+	d.command = writeFakeSops(t)
+	fs := d.FileSystem(filesys.MakeFsOnDisk())
+
+	decrypted, err := fs.ReadFile("testdata/database.env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := fs.ReadFile("testdata/configmap.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff("PASSWORD=c2VjcmV0\nSOPS_AGE_KEY_FILE=/etc/peanut/age.txt\n", string(decrypted)); diff != "" {
+		t.Fatalf("ReadFile() failed:\n%s", diff)
+	}
+	if !strings.Contains(string(plain), "tool: sops") {
+		t.Fatalf("ReadFile() got %q", plain)
+	}
+}
+
+// writeFakeSops writes a script that is executed in place of sops, it
+// "decrypts" the values by removing the encryption metadata, and appends the
+// age key file, to show that it was provided.
+func writeFakeSops(t *testing.T) string {
+	return writeScript(t, `[ "$1" = "--decrypt" ] || exit 2
+[ -n "$SOPS_AGE_KEY_FILE" ] || { echo "failed to get the data key" >&2; exit 1; }
+sed -e '/^sops/,$d' -e 's/ENC\[AES256_GCM,data:\([^,]*\),[^]]*\]/\1/g' "$2"
+echo "SOPS_AGE_KEY_FILE=$SOPS_AGE_KEY_FILE"`)
+}
+
+func writeScript(t *testing.T, script string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "sops")
+	if err := os.WriteFile(filename, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// gnupgHome creates a GnuPG home directory, with a short path, as the agent's
+// socket path is limited in length.
+func gnupgHome(t *testing.T) string {
+	t.Helper()
+	home, err := os.MkdirTemp("", "gnupg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd := exec.Command("gpgconf", "--kill", "gpg-agent")
+		cmd.Env = append(os.Environ(), gnupgHomeEnv+"="+home)
+		_ = cmd.Run()
+		os.RemoveAll(home)
+	})
+	return home
+}
+
+func runCommand(t *testing.T, home, name string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), gnupgHomeEnv+"="+home)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s failed: %s: %s", name, err, out)
+	}
+	return string(out)
+}
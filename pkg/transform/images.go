@@ -0,0 +1,119 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Image overrides the images of containers with the name.
+type Image struct {
+	// Name is the image without the tag or digest e.g. nginx or
+	// registry.example.com/team/app.
+	Name string `json:"name"`
+	// NewName replaces the name, keeping the tag or digest, unless they are
+	// overridden too.
+	NewName string `json:"newName,omitempty"`
+	// NewTag replaces the tag, and removes any digest.
+	NewTag string `json:"newTag,omitempty"`
+	// Digest replaces the tag with the digest e.g. sha256:...
+	Digest string `json:"digest,omitempty"`
+}
+
+// ParseImage parses an override of the form name=reference, e.g.
+// nginx=registry.example.com/nginx:1.25, the name, tag and digest of the
+// image are replaced with those in the reference.
+func ParseImage(s string) (Image, error) {
+	name, ref, ok := strings.Cut(s, "=")
+	if !ok || name == "" || ref == "" {
+		return Image{}, fmt.Errorf("invalid image %q, must be name=reference", s)
+	}
+	newName, tag, digest := splitImage(ref)
+	return Image{Name: name, NewName: newName, NewTag: tag, Digest: digest}, nil
+}
+
+// Images returns a Transformer that overrides the images of the containers,
+// init containers and ephemeral containers in each resource, wherever they
+// are in the resource, e.g. in the pod template of a Deployment, or the job
+// template of a CronJob.
+func Images(images []Image) Transformer {
+	return TransformerFunc(func(u *unstructured.Unstructured) error {
+		walkContainers(u.Object, func(c map[string]interface{}) {
+			image, ok := c["image"].(string)
+			if !ok {
+				return
+			}
+			c["image"] = overrideImage(image, images)
+		})
+		return nil
+	})
+}
+
+func overrideImage(image string, images []Image) string {
+	name, tag, digest := splitImage(image)
+	for _, v := range images {
+		if v.Name != name {
+			continue
+		}
+		if v.NewName != "" {
+			name = v.NewName
+		}
+		if v.NewTag != "" {
+			tag, digest = v.NewTag, ""
+		}
+		if v.Digest != "" {
+			tag, digest = "", v.Digest
+		}
+		break
+	}
+	switch {
+	case digest != "":
+		return name + "@" + digest
+	case tag != "":
+		return name + ":" + tag
+	}
+	return name
+}
+
+// splitImage splits an image reference into the name, tag and digest, the
+// tag is after the last ":" that is not part of a registry host and port.
+func splitImage(image string) (string, string, string) {
+	name, digest, _ := strings.Cut(image, "@")
+	tag := ""
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	return name, tag, digest
+}
+
+var containerFields = []string{"containers", "initContainers", "ephemeralContainers"}
+
+// walkContainers calls the function with each container in the object.
+func walkContainers(obj map[string]interface{}, f func(map[string]interface{})) {
+	for k, v := range obj {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			walkContainers(v, f)
+		case []interface{}:
+			isContainers := contains(containerFields, k)
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					if isContainers {
+						f(m)
+					}
+					walkContainers(m, f)
+				}
+			}
+		}
+	}
+}
+
+func contains(s []string, v string) bool {
+	for _, item := range s {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
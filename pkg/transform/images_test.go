@@ -0,0 +1,69 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestImages(t *testing.T) {
+	u := makeDeployment()
+
+	err := Images([]Image{
+		{Name: "bigkevmcd/taxi", NewTag: "v0.0.4"},
+		{Name: "nginx", NewName: "registry.example.com/nginx", Digest: "sha256:abc123"},
+		{Name: "registry.example.com:5000/taxi/migrate", NewName: "registry.example.com:5000/taxi/migrations"},
+	}).Transform(u)
+	assertNoError(t, err)
+
+	want := []string{
+		"registry.example.com:5000/taxi/migrations:v1",
+		"bigkevmcd/taxi:v0.0.4",
+		"registry.example.com/nginx@sha256:abc123",
+	}
+	if diff := cmp.Diff(want, images(t, u.Object)); diff != "" {
+		t.Fatalf("images:\n%s", diff)
+	}
+}
+
+func TestParseImage(t *testing.T) {
+	parseTests := []struct {
+		s    string
+		want Image
+	}{
+		{"nginx=nginx:1.25", Image{Name: "nginx", NewName: "nginx", NewTag: "1.25"}},
+		{"nginx=registry.example.com:5000/nginx", Image{Name: "nginx", NewName: "registry.example.com:5000/nginx"}},
+		{"nginx=nginx@sha256:abc123", Image{Name: "nginx", NewName: "nginx", Digest: "sha256:abc123"}},
+	}
+
+	for _, tt := range parseTests {
+		t.Run(tt.s, func(t *testing.T) {
+			i, err := ParseImage(tt.s)
+			assertNoError(t, err)
+
+			if diff := cmp.Diff(tt.want, i); diff != "" {
+				t.Fatalf("ParseImage() failed:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseImageWithInvalidImage(t *testing.T) {
+	_, err := ParseImage("nginx:1.25")
+
+	if err == nil || err.Error() != `invalid image "nginx:1.25", must be name=reference` {
+		t.Fatalf("incorrect error: %v", err)
+	}
+}
+
+func images(t *testing.T, obj map[string]interface{}) []string {
+	t.Helper()
+	res := []string{}
+	for _, field := range []string{"initContainers", "containers"} {
+		containers := obj["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})[field].([]interface{})
+		for _, v := range containers {
+			res = append(res, v.(map[string]interface{})["image"].(string))
+		}
+	}
+	return res
+}
@@ -0,0 +1,63 @@
+package transform
+
+import (
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Target selects the resources that a patch is applied to, empty fields
+// match all resources.
+type Target struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// Matches returns true if the resource is selected by the target.
+func (t Target) Matches(u *unstructured.Unstructured) bool {
+	gvk := u.GroupVersionKind()
+	for _, v := range [][2]string{
+		{t.Group, gvk.Group},
+		{t.Version, gvk.Version},
+		{t.Kind, gvk.Kind},
+		{t.Namespace, u.GetNamespace()},
+		{t.Name, u.GetName()},
+	} {
+		if v[0] != "" && v[0] != v[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// Patch returns a Transformer that applies the JSON patch (RFC 6902) to the
+// resources that the target selects, the patch can be JSON or YAML.
+func Patch(target Target, patch string) (Transformer, error) {
+	b, err := yaml.YAMLToJSON([]byte(patch))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the patch: %w", err)
+	}
+	p, err := jsonpatch.DecodePatch(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the patch: %w", err)
+	}
+	return TransformerFunc(func(u *unstructured.Unstructured) error {
+		if !target.Matches(u) {
+			return nil
+		}
+		b, err := u.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		patched, err := p.Apply(b)
+		if err != nil {
+			return fmt.Errorf("failed to apply the patch: %w", err)
+		}
+		return u.UnmarshalJSON(patched)
+	}), nil
+}
@@ -0,0 +1,71 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPatch(t *testing.T) {
+	p, err := Patch(Target{Kind: "Deployment", Name: "taxi"}, `
+- op: replace
+  path: /spec/replicas
+  value: 3
+`)
+	assertNoError(t, err)
+	u := makeDeployment()
+
+	assertNoError(t, p.Transform(u))
+
+	replicas, _, _ := unstructured.NestedInt64(u.Object, "spec", "replicas")
+	if replicas != 3 {
+		t.Fatalf("got %d replicas, want 3", replicas)
+	}
+}
+
+func TestPatchWithUnselectedResource(t *testing.T) {
+	p, err := Patch(Target{Group: "apps", Kind: "Deployment", Name: "other"}, `[{"op": "remove", "path": "/spec"}]`)
+	assertNoError(t, err)
+	u := makeDeployment()
+
+	assertNoError(t, p.Transform(u))
+
+	if _, ok := u.Object["spec"]; !ok {
+		t.Fatal("unselected resource was patched")
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	if _, err := Patch(Target{}, `{"op": "remove"}`); err == nil || !strings.Contains(err.Error(), "failed to parse the patch") {
+		t.Fatalf("incorrect error: %v", err)
+	}
+
+	p, err := Patch(Target{}, `[{"op": "remove", "path": "/spec/unknown"}]`)
+	assertNoError(t, err)
+
+	err = p.Transform(makeDeployment())
+	if err == nil || !strings.Contains(err.Error(), "failed to apply the patch") {
+		t.Fatalf("incorrect error: %v", err)
+	}
+}
+
+func TestTargetMatches(t *testing.T) {
+	targetTests := []struct {
+		target Target
+		want   bool
+	}{
+		{Target{}, true},
+		{Target{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "default", Name: "taxi"}, true},
+		{Target{Group: "", Kind: "Deployment"}, true},
+		{Target{Version: "v1beta1"}, false},
+		{Target{Kind: "Service"}, false},
+		{Target{Namespace: "other"}, false},
+	}
+
+	for _, tt := range targetTests {
+		if got := tt.target.Matches(makeDeployment()); got != tt.want {
+			t.Errorf("%#v Matches() got %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package transform
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Transformer modifies a parsed resource before it's synchronised.
+type Transformer interface {
+	Transform(*unstructured.Unstructured) error
+}
+
+// TransformerFunc is an adapter to allow functions to be used as
+// Transformers.
+type TransformerFunc func(*unstructured.Unstructured) error
+
+// Transform is an implementation of Transformer.
+func (f TransformerFunc) Transform(u *unstructured.Unstructured) error {
+	return f(u)
+}
+
+// Labels returns a Transformer that adds the labels to each resource,
+// replacing existing labels with the same keys.
+//
+// Only the labels of the resource are changed, not the labels of pod
+// templates or selectors, as selectors can't be changed once created.
+func Labels(labels map[string]string) Transformer {
+	return TransformerFunc(func(u *unstructured.Unstructured) error {
+		u.SetLabels(merge(u.GetLabels(), labels))
+		return nil
+	})
+}
+
+// Annotations returns a Transformer that adds the annotations to each
+// resource, replacing existing annotations with the same keys.
+func Annotations(annotations map[string]string) Transformer {
+	return TransformerFunc(func(u *unstructured.Unstructured) error {
+		u.SetAnnotations(merge(u.GetAnnotations(), annotations))
+		return nil
+	})
+}
+
+// Namespace returns a Transformer that sets the namespace of each namespaced
+// resource, replacing any namespace in the manifests.
+//
+// Resources of the well-known cluster-scoped kinds are not changed.
+func Namespace(ns string) Transformer {
+	return TransformerFunc(func(u *unstructured.Unstructured) error {
+		if !clusterScoped[u.GroupVersionKind().GroupKind()] {
+			u.SetNamespace(ns)
+		}
+		return nil
+	})
+}
+
+// StripFields returns a Transformer that removes the fields from each
+// resource, the fields are JSON pointers e.g. /spec/replicas, and fields that
+// don't exist are ignored.
+func StripFields(fields []string) Transformer {
+	paths := make([][]string, len(fields))
+	for i, v := range fields {
+		paths[i] = splitPointer(v)
+	}
+	return TransformerFunc(func(u *unstructured.Unstructured) error {
+		for _, v := range paths {
+			unstructured.RemoveNestedField(u.Object, v...)
+		}
+		return nil
+	})
+}
+
+func merge(current, values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return current
+	}
+	if current == nil {
+		current = map[string]string{}
+	}
+	for k, v := range values {
+		current[k] = v
+	}
+	return current
+}
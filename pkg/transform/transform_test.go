@@ -0,0 +1,114 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestLabels(t *testing.T) {
+	u := makeDeployment()
+	u.SetLabels(map[string]string{"app": "taxi", "tier": "backend"})
+
+	assertNoError(t, Labels(map[string]string{"tier": "frontend", "cluster": "eu-west-1"}).Transform(u))
+
+	want := map[string]string{"app": "taxi", "tier": "frontend", "cluster": "eu-west-1"}
+	if diff := cmp.Diff(want, u.GetLabels()); diff != "" {
+		t.Fatalf("labels:\n%s", diff)
+	}
+	selector, _, _ := unstructured.NestedStringMap(u.Object, "spec", "selector", "matchLabels")
+	if diff := cmp.Diff(map[string]string{"app": "taxi"}, selector); diff != "" {
+		t.Fatalf("selector changed:\n%s", diff)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	u := makeDeployment()
+
+	assertNoError(t, Annotations(map[string]string{"team": "taxi"}).Transform(u))
+
+	if diff := cmp.Diff(map[string]string{"team": "taxi"}, u.GetAnnotations()); diff != "" {
+		t.Fatalf("annotations:\n%s", diff)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	namespaceTests := []struct {
+		apiVersion string
+		kind       string
+		want       string
+	}{
+		{"apps/v1", "Deployment", "test-ns"},
+		{"v1", "ConfigMap", "test-ns"},
+		{"v1", "Namespace", ""},
+		{"rbac.authorization.k8s.io/v1", "ClusterRole", ""},
+		{"rbac.authorization.k8s.io/v1", "Role", "test-ns"},
+		{"apiextensions.k8s.io/v1", "CustomResourceDefinition", ""},
+	}
+
+	for _, tt := range namespaceTests {
+		t.Run(tt.kind, func(t *testing.T) {
+			u := &unstructured.Unstructured{}
+			u.SetAPIVersion(tt.apiVersion)
+			u.SetKind(tt.kind)
+
+			assertNoError(t, Namespace("test-ns").Transform(u))
+
+			if ns := u.GetNamespace(); ns != tt.want {
+				t.Fatalf("got namespace %q, want %q", ns, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripFields(t *testing.T) {
+	u := makeDeployment()
+	u.SetAnnotations(map[string]string{"example.com/owner": "taxi", "team": "taxi"})
+
+	assertNoError(t, StripFields([]string{"/spec/replicas", "/metadata/annotations/example.com~1owner", "/status/unknown"}).Transform(u))
+
+	if _, ok, _ := unstructured.NestedFieldNoCopy(u.Object, "spec", "replicas"); ok {
+		t.Fatal("replicas were not removed")
+	}
+	if diff := cmp.Diff(map[string]string{"team": "taxi"}, u.GetAnnotations()); diff != "" {
+		t.Fatalf("annotations:\n%s", diff)
+	}
+}
+
+func makeDeployment() *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":      "taxi",
+				"namespace": "default",
+			},
+			"spec": map[string]interface{}{
+				"replicas": int64(1),
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{"app": "taxi"},
+				},
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"initContainers": []interface{}{
+							map[string]interface{}{"name": "migrate", "image": "registry.example.com:5000/taxi/migrate:v1"},
+						},
+						"containers": []interface{}{
+							map[string]interface{}{"name": "taxi", "image": "bigkevmcd/taxi:v0.0.3"},
+							map[string]interface{}{"name": "proxy", "image": "nginx"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// bitbucket parses Bitbucket Cloud and Bitbucket Server webhooks, which are
+// signed with HMAC-SHA256.
+//
+// Bitbucket doesn't report the files that were changed by a push.
+type bitbucket struct{}
+
+func (bitbucket) validate(h http.Header, body, secret []byte) error {
+	return validateHMAC(h.Get("X-Hub-Signature"), "sha256=", body, secret)
+}
+
+func (bitbucket) parse(h http.Header, body []byte) (*PushEvent, error) {
+	switch h.Get("X-Event-Key") {
+	case "repo:push":
+		return parseBitbucketCloudPush(body)
+	case "repo:refs_changed":
+		return parseBitbucketServerPush(body)
+	}
+	return nil, nil
+}
+
+func parseBitbucketCloudPush(body []byte) (*PushEvent, error) {
+	var p struct {
+		Repository struct {
+			Links struct {
+				HTML struct {
+					Href string `json:"href"`
+				} `json:"html"`
+			} `json:"links"`
+		} `json:"repository"`
+		Push struct {
+			Changes []struct {
+				// New is null when a branch is deleted.
+				New *struct {
+					Type string `json:"type"`
+					Name string `json:"name"`
+				} `json:"new"`
+			} `json:"changes"`
+		} `json:"push"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse push event: %w", err)
+	}
+	e := &PushEvent{RepoURLs: []string{p.Repository.Links.HTML.Href}}
+	for _, v := range p.Push.Changes {
+		if v.New == nil {
+			continue
+		}
+		switch v.New.Type {
+		case "branch":
+			e.Branches = append(e.Branches, v.New.Name)
+		case "tag":
+			e.Tags = append(e.Tags, v.New.Name)
+		}
+	}
+	return e, nil
+}
+
+func parseBitbucketServerPush(body []byte) (*PushEvent, error) {
+	var p struct {
+		Repository struct {
+			Links struct {
+				Clone []struct {
+					Href string `json:"href"`
+				} `json:"clone"`
+			} `json:"links"`
+		} `json:"repository"`
+		Changes []struct {
+			Ref struct {
+				DisplayID string `json:"displayId"`
+				Type      string `json:"type"`
+			} `json:"ref"`
+			Type string `json:"type"`
+		} `json:"changes"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse push event: %w", err)
+	}
+	e := &PushEvent{}
+	for _, v := range p.Repository.Links.Clone {
+		e.RepoURLs = append(e.RepoURLs, v.Href)
+	}
+	for _, v := range p.Changes {
+		if v.Type == "DELETE" {
+			continue
+		}
+		switch v.Ref.Type {
+		case "BRANCH":
+			e.Branches = append(e.Branches, v.Ref.DisplayID)
+		case "TAG":
+			e.Tags = append(e.Tags, v.Ref.DisplayID)
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,20 @@
+package webhook
+
+import (
+	"net/http"
+)
+
+// gitea parses Gitea webhooks, which are signed with HMAC-SHA256, and have the
+// same format as GitHub push events.
+type gitea struct{}
+
+func (gitea) validate(h http.Header, body, secret []byte) error {
+	return validateHMAC(h.Get("X-Gitea-Signature"), "", body, secret)
+}
+
+func (gitea) parse(h http.Header, body []byte) (*PushEvent, error) {
+	if h.Get("X-Gitea-Event") != "push" {
+		return nil, nil
+	}
+	return parseGitHubPush(body)
+}
@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	branchRefPrefix = "refs/heads/"
+	tagRefPrefix    = "refs/tags/"
+)
+
+// github parses GitHub webhooks, which are signed with HMAC-SHA256.
+type github struct{}
+
+func (github) validate(h http.Header, body, secret []byte) error {
+	return validateHMAC(h.Get("X-Hub-Signature-256"), "sha256=", body, secret)
+}
+
+func (github) parse(h http.Header, body []byte) (*PushEvent, error) {
+	if h.Get("X-GitHub-Event") != "push" {
+		return nil, nil
+	}
+	return parseGitHubPush(body)
+}
+
+// githubPush is the format of push events from GitHub, and Gitea.
+type githubPush struct {
+	Ref        string `json:"ref"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+	Commits []commit `json:"commits"`
+}
+
+func parseGitHubPush(body []byte) (*PushEvent, error) {
+	var p githubPush
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse push event: %w", err)
+	}
+	// Deleting a branch or tag can't be synchronised.
+	if p.Deleted {
+		return nil, nil
+	}
+	e := &PushEvent{RepoURLs: []string{p.Repository.CloneURL, p.Repository.SSHURL, p.Repository.HTMLURL}}
+	if !setRef(e, p.Ref) {
+		return nil, nil
+	}
+	if len(e.Branches) > 0 {
+		e.Paths = changedPaths(p.Commits)
+	}
+	return e, nil
+}
+
+// setRef adds the pushed branch or tag to the event, and returns false if the
+// ref is neither.
+func setRef(e *PushEvent, ref string) bool {
+	switch {
+	case strings.HasPrefix(ref, branchRefPrefix):
+		e.Branches = append(e.Branches, strings.TrimPrefix(ref, branchRefPrefix))
+	case strings.HasPrefix(ref, tagRefPrefix):
+		e.Tags = append(e.Tags, strings.TrimPrefix(ref, tagRefPrefix))
+	default:
+		return false
+	}
+	return true
+}
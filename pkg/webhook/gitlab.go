@@ -0,0 +1,50 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// zeroSHA is the "after" SHA for a push that deletes a branch.
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// gitlab parses GitLab webhooks, which provide the secret as a token.
+type gitlab struct{}
+
+func (gitlab) validate(h http.Header, body, secret []byte) error {
+	return validateToken(h.Get("X-Gitlab-Token"), secret)
+}
+
+func (gitlab) parse(h http.Header, body []byte) (*PushEvent, error) {
+	if e := h.Get("X-Gitlab-Event"); e != "Push Hook" && e != "Tag Push Hook" {
+		return nil, nil
+	}
+	var p struct {
+		Ref     string `json:"ref"`
+		After   string `json:"after"`
+		Project struct {
+			HTTPURL string `json:"git_http_url"`
+			SSHURL  string `json:"git_ssh_url"`
+			WebURL  string `json:"web_url"`
+		} `json:"project"`
+		Commits           []commit `json:"commits"`
+		TotalCommitsCount int      `json:"total_commits_count"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse push event: %w", err)
+	}
+	if p.After == zeroSHA {
+		return nil, nil
+	}
+	e := &PushEvent{RepoURLs: []string{p.Project.HTTPURL, p.Project.SSHURL, p.Project.WebURL}}
+	if !setRef(e, p.Ref) {
+		return nil, nil
+	}
+	// GitLab only includes the first 20 commits, so the changed files are
+	// unknown for larger pushes.
+	if len(e.Branches) > 0 && p.TotalCommitsCount <= len(p.Commits) {
+		e.Paths = changedPaths(p.Commits)
+	}
+	return e, nil
+}
@@ -0,0 +1,47 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var (
+	errMissingSignature = errors.New("missing signature")
+	errInvalidSignature = errors.New("invalid signature")
+)
+
+// validateHMAC checks that the signature is the prefixed, hex encoded
+// HMAC-SHA256 of the body.
+func validateHMAC(signature, prefix string, body, secret []byte) error {
+	if signature == "" {
+		return errMissingSignature
+	}
+	if !strings.HasPrefix(signature, prefix) {
+		return errInvalidSignature
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return errInvalidSignature
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// validateToken checks that the token matches the secret.
+func validateToken(token string, secret []byte) error {
+	if token == "" {
+		return errMissingSignature
+	}
+	if subtle.ConstantTimeCompare([]byte(token), secret) != 1 {
+		return errInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bigkevmcd/peanut-engine/pkg/engine"
+)
+
+// maxPayloadSize is the largest payload that is accepted, GitHub caps payloads
+// at 25MB.
+const maxPayloadSize = 25 * 1024 * 1024
+
+// PushEvent is a push to a Git repository.
+type PushEvent struct {
+	// RepoURLs are the URLs that identify the repository, e.g. the clone
+	// URLs.
+	RepoURLs []string
+	// Branches are the names of the branches that were pushed to.
+	Branches []string
+	// Tags are the names of the tags that were pushed.
+	Tags []string
+	// Paths are the files that were changed by the push, this is nil if the
+	// provider doesn't report the changed files.
+	Paths []string
+}
+
+// provider parses and validates the webhooks from a Git hosting service.
+type provider interface {
+	// validate checks that the request was signed with the secret.
+	validate(h http.Header, body, secret []byte) error
+	// parse returns the push from the request, or nil if the request is not
+	// a push, e.g. a ping.
+	parse(h http.Header, body []byte) (*PushEvent, error)
+}
+
+var providers = map[string]provider{
+	"github":    github{},
+	"gitlab":    gitlab{},
+	"gitea":     gitea{},
+	"bitbucket": bitbucket{},
+}
+
+// Applications provides access to the running applications.
+//
+// This is implemented by engine.Manager.
+type Applications interface {
+	Applications() []*engine.Application
+}
+
+// Handler receives push webhooks, and triggers the synchronisation of the
+// applications that are affected by the push.
+type Handler struct {
+	*httprouter.Router
+	applications Applications
+	secret       []byte
+}
+
+// NewHandler creates and returns a new Handler.
+//
+// Webhooks must be signed with the secret, or for GitLab, provide it as the
+// token.
+func NewHandler(apps Applications, secret []byte) *Handler {
+	h := &Handler{Router: httprouter.New(), applications: apps, secret: secret}
+	h.HandlerFunc(http.MethodPost, "/api/v1/webhooks/:provider", h.Receive)
+	return h
+}
+
+// Receive validates and parses a webhook from the provider in the path, and
+// triggers the synchronisation of the applications that synchronise the
+// pushed branch, if the push changed files in the application's path.
+func (h *Handler) Receive(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+	p, ok := providers[name]
+	if !ok {
+		http.Error(w, "unknown provider", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+	if len(h.secret) == 0 {
+		http.Error(w, "webhook secret not configured", http.StatusForbidden)
+		return
+	}
+	if err := p.validate(r.Header, body, h.secret); err != nil {
+		log.Warnf("Rejected %s webhook: %s", name, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	event, err := p.parse(r.Header, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := responseWebhook{Triggered: []string{}}
+	if event != nil {
+		for _, app := range h.applications.Applications() {
+			if !matches(app.Git, event) {
+				continue
+			}
+			log.WithField("application", app.Name).Infof("Synchronisation triggered by %s webhook", name)
+			app.TriggerSync()
+			res.Triggered = append(res.Triggered, app.Name)
+		}
+		sort.Strings(res.Triggered)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("ERROR: failed to marshal webhook response: %s", err)
+	}
+}
+
+type responseWebhook struct {
+	Triggered []string `json:"triggered"`
+}
+
+// matches returns true if the push is to the configured repository and
+// branch, and changed files in one of the configured paths.
+//
+// Applications that deploy a revision match all pushes to the repository, as
+// pushing a tag may change the revision.
+func matches(cfg engine.GitConfig, e *PushEvent) bool {
+	if cfg.Revision != "" {
+		return matchesRepository(cfg.RepoURL, e.RepoURLs)
+	}
+	if !matchesRepository(cfg.RepoURL, e.RepoURLs) || !contains(e.Branches, cfg.Branch) {
+		return false
+	}
+	for _, v := range cfg.Paths() {
+		if matchesPath(v, e.Paths) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesRepository(repoURL string, urls []string) bool {
+	want := normaliseURL(repoURL)
+	for _, v := range urls {
+		if normaliseURL(v) == want {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPath(path string, paths []string) bool {
+	if paths == nil {
+		return true
+	}
+	path = strings.Trim(path, "/")
+	if path == "" || path == "." {
+		return true
+	}
+	for _, v := range paths {
+		if v == path || strings.HasPrefix(v, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// normaliseURL returns the host and path of a repository URL, so that HTTP and
+// SSH URLs for the same repository can be compared.
+func normaliseURL(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	var host, path string
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		host, path = u.Hostname(), u.Path
+	} else if i := strings.Index(s, ":"); i > 0 {
+		// SCP-like SSH URLs e.g. git@github.com:example/example.git
+		host, path = s[strings.Index(s, "@")+1:i], s[i+1:]
+	} else {
+		return s
+	}
+	return host + "/" + strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// changedPaths returns the files that were added, modified or removed by
+// commits.
+func changedPaths(commits []commit) []string {
+	paths := []string{}
+	for _, v := range commits {
+		paths = append(paths, v.Added...)
+		paths = append(paths, v.Modified...)
+		paths = append(paths, v.Removed...)
+	}
+	return paths
+}
+
+// commit is the format of commits in GitHub, GitLab and Gitea push events.
+type commit struct {
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
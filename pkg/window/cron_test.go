@@ -0,0 +1,72 @@
+package window
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleMatches(t *testing.T) {
+	// 2020-06-24 was a Wednesday.
+	wednesday := time.Date(2020, time.June, 24, 9, 30, 0, 0, time.UTC)
+	matchTests := []struct {
+		schedule string
+		t        time.Time
+		want     bool
+	}{
+		{"* * * * *", wednesday, true},
+		{"30 9 * * *", wednesday, true},
+		{"31 9 * * *", wednesday, false},
+		{"*/15 9-17 * * *", wednesday, true},
+		{"*/20 * * * *", wednesday, false},
+		{"10/20 * * * *", wednesday, true},
+		{"0,30 9 * * mon-fri", wednesday, true},
+		{"30 9 * * sat,sun", wednesday, false},
+		{"30 9 * * 0", wednesday.AddDate(0, 0, 4), true},
+		{"30 9 * * 7", wednesday.AddDate(0, 0, 4), true},
+		{"30 9 24 jun *", wednesday, true},
+		{"30 9 * JUL *", wednesday, false},
+		// If both days are restricted, either can match.
+		{"30 9 1 * 3", wednesday, true},
+		{"30 9 24 * 1", wednesday, true},
+		{"30 9 1 * 1", wednesday, false},
+		{"30 9 1 * *", wednesday, false},
+	}
+
+	for _, tt := range matchTests {
+		t.Run(tt.schedule, func(t *testing.T) {
+			s, err := ParseSchedule(tt.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := s.Matches(tt.t); got != tt.want {
+				t.Fatalf("Matches(%s) got %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	errorTests := []struct {
+		schedule string
+		want     string
+	}{
+		{"* * * *", `invalid schedule "* * * *", must have 5 fields`},
+		{"60 * * * *", `invalid schedule "60 * * * *": invalid minute "60", must be 0-59`},
+		{"* 9-x * * *", `invalid schedule "* 9-x * * *": invalid hour "x", must be 0-23`},
+		{"* * 0 * *", `invalid schedule "* * 0 * *": invalid day of month "0", must be 1-31`},
+		{"* * * 13 *", `invalid schedule "* * * 13 *": invalid month "13", must be 1-12`},
+		{"* * * * fri-mon", `invalid schedule "* * * * fri-mon": invalid range in day of week "fri-mon"`},
+		{"*/0 * * * *", `invalid schedule "*/0 * * * *": invalid step in minute "*/0"`},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.schedule, func(t *testing.T) {
+			_, err := ParseSchedule(tt.schedule)
+
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
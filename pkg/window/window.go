@@ -0,0 +1,98 @@
+// Package window restricts when resources are applied to the cluster, with
+// windows that start on a cron schedule, and last for a duration.
+package window
+
+import (
+	"fmt"
+	"time"
+
+	// The time zone database is embedded, as the image has no zoneinfo.
+	_ "time/tzdata"
+)
+
+// Kind is whether a window allows or denies applying resources.
+type Kind string
+
+const (
+	// Allow windows are the only times that resources are applied, if an
+	// application has allow windows.
+	Allow Kind = "allow"
+	// Deny windows are times that resources are not applied, these take
+	// precedence over allow windows.
+	Deny Kind = "deny"
+)
+
+// Window is a period that starts on a schedule, and lasts for a duration.
+type Window struct {
+	Kind     Kind
+	Schedule string
+	Duration time.Duration
+	// Location is the time zone that the schedule is in.
+	Location *time.Location
+
+	schedule *Schedule
+}
+
+// New parses the schedule and time zone, and returns a Window, the time zone
+// is an IANA name e.g. Europe/London, and defaults to UTC.
+func New(kind Kind, schedule string, duration time.Duration, timeZone string) (Window, error) {
+	if kind != Allow && kind != Deny {
+		return Window{}, fmt.Errorf("invalid window kind %q, must be %s or %s", kind, Allow, Deny)
+	}
+	if duration <= 0 {
+		return Window{}, fmt.Errorf("invalid window duration %s", duration)
+	}
+	s, err := ParseSchedule(schedule)
+	if err != nil {
+		return Window{}, err
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid window time zone %q: %w", timeZone, err)
+	}
+	return Window{Kind: kind, Schedule: schedule, Duration: duration, Location: loc, schedule: s}, nil
+}
+
+// Active returns true if the window started less than the duration before
+// the time.
+func (w Window) Active(t time.Time) bool {
+	t = t.In(w.Location)
+	for start := t.Truncate(time.Minute); t.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+		if w.schedule.Matches(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// Windows are the windows of an application.
+type Windows []Window
+
+// Open returns true if resources can be applied at the time, no deny window
+// is active, and if there are allow windows, an allow window is active.
+func (ws Windows) Open(t time.Time) bool {
+	allowed, hasAllow := false, false
+	for _, w := range ws {
+		switch w.Kind {
+		case Deny:
+			if w.Active(t) {
+				return false
+			}
+		case Allow:
+			hasAllow = true
+			allowed = allowed || w.Active(t)
+		}
+	}
+	return allowed || !hasAllow
+}
+
+// Active returns the windows that are active at the time.
+func (ws Windows) Active(t time.Time) Windows {
+	active := Windows{}
+	for _, w := range ws {
+		if w.Active(t) {
+			active = append(active, w)
+		}
+	}
+	return active
+}
@@ -0,0 +1,106 @@
+package window
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowActive(t *testing.T) {
+	// Business hours in London, 09:00-17:00 on weekdays.
+	w, err := New(Allow, "0 9 * * mon-fri", 8*time.Hour, "Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeTests := []struct {
+		t    time.Time
+		want bool
+	}{
+		// London is UTC+1 in June.
+		{time.Date(2020, time.June, 24, 7, 59, 0, 0, time.UTC), false},
+		{time.Date(2020, time.June, 24, 8, 0, 0, 0, time.UTC), true},
+		{time.Date(2020, time.June, 24, 15, 59, 59, 0, time.UTC), true},
+		{time.Date(2020, time.June, 24, 16, 0, 0, 0, time.UTC), false},
+		{time.Date(2020, time.June, 27, 10, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, tt := range activeTests {
+		if got := w.Active(tt.t); got != tt.want {
+			t.Errorf("Active(%s) got %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestWindowsOpen(t *testing.T) {
+	businessHours := mustNew(t, Allow, "0 9 * * mon-fri", 8*time.Hour)
+	fridayAfternoon := mustNew(t, Deny, "0 12 * * fri", 12*time.Hour)
+	// 2020-06-24 was a Wednesday.
+	wednesday := time.Date(2020, time.June, 24, 13, 0, 0, 0, time.UTC)
+	friday := wednesday.AddDate(0, 0, 2)
+	openTests := []struct {
+		name    string
+		windows Windows
+		t       time.Time
+		want    bool
+	}{
+		{"no windows", nil, wednesday, true},
+		{"in an allow window", Windows{businessHours}, wednesday, true},
+		{"outside the allow windows", Windows{businessHours}, wednesday.Add(5 * time.Hour), false},
+		{"outside the deny windows", Windows{fridayAfternoon}, wednesday, true},
+		{"in a deny window", Windows{fridayAfternoon}, friday, false},
+		{"in allow and deny windows", Windows{businessHours, fridayAfternoon}, friday, false},
+	}
+
+	for _, tt := range openTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.windows.Open(tt.t); got != tt.want {
+				t.Fatalf("Open(%s) got %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowsActive(t *testing.T) {
+	businessHours := mustNew(t, Allow, "0 9 * * mon-fri", 8*time.Hour)
+	weekends := mustNew(t, Deny, "0 0 * * sat", 48*time.Hour)
+
+	active := Windows{businessHours, weekends}.Active(time.Date(2020, time.June, 27, 10, 0, 0, 0, time.UTC))
+
+	if len(active) != 1 || active[0].Kind != Deny {
+		t.Fatalf("got active windows %#v, want the deny window", active)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	errorTests := []struct {
+		name     string
+		kind     Kind
+		schedule string
+		duration time.Duration
+		timeZone string
+		want     string
+	}{
+		{"invalid kind", "maybe", "* * * * *", time.Hour, "", `invalid window kind "maybe", must be allow or deny`},
+		{"invalid duration", Allow, "* * * * *", 0, "", "invalid window duration 0s"},
+		{"invalid schedule", Allow, "* * *", time.Hour, "", `invalid schedule "* * *", must have 5 fields`},
+		{"invalid time zone", Deny, "* * * * *", time.Hour, "Europe/Nowhere", `invalid window time zone "Europe/Nowhere": unknown time zone Europe/Nowhere`},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.kind, tt.schedule, tt.duration, tt.timeZone)
+
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("got error %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func mustNew(t *testing.T, kind Kind, schedule string, duration time.Duration) Window {
+	t.Helper()
+	w, err := New(kind, schedule, duration, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}